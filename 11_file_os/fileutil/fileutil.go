@@ -0,0 +1,187 @@
+// Package fileutil writes files atomically and durably.
+//
+// Writing a file in place, as os.Create followed by Write does, leaves it
+// torn if the program or the machine dies halfway: part old content, part
+// new, or empty. The functions here write the new content to a temporary
+// file in the same directory, sync it to disk, rename it over the old file
+// and sync the directory, so that after a crash the file holds either the
+// old content or the new one, never a mix, and a write that returned nil
+// survives a power cut.
+//
+// AppendFile is the exception: it appends in place and syncs, which cannot
+// tear what the file held before, only the data being appended.
+//
+// Symbolic links are followed, so the file a link points to is replaced and
+// the link stays. A file that already exists keeps its permission bits; perm
+// only applies to new files, except with WriteFilePerm. Every failure is
+// returned as an error, and the temporary file is removed.
+package fileutil
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// WriteFile replaces the content of the named file by data, creating the
+// file with perm when it does not exist.
+func WriteFile(path string, data []byte, perm fs.FileMode) error {
+	return Write(path, perm, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// WriteFilePerm is WriteFile for files whose permissions matter, such as
+// those holding secrets: the file gets perm even when it already exists.
+func WriteFilePerm(path string, data []byte, perm fs.FileMode) error {
+	err := write1(path, perm, false, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("fileutil: write %s: %w", path, err)
+	}
+	return nil
+}
+
+// AppendFile adds data at the end of the named file and syncs it to disk,
+// creating the file with perm when it does not exist. A crash can leave part
+// of data at the end of the file, but not change what was there before.
+func AppendFile(path string, data []byte, perm fs.FileMode) error {
+	if err := append1(path, data, perm); err != nil {
+		return fmt.Errorf("fileutil: append %s: %w", path, err)
+	}
+	return nil
+}
+
+func append1(path string, data []byte, perm fs.FileMode) error {
+	_, err := os.Stat(path)
+	created := errors.Is(err, fs.ErrNotExist)
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if created {
+		return SyncDir(filepath.Dir(path))
+	}
+	return nil
+}
+
+// Write replaces the content of the named file by what write writes,
+// creating the file with perm when it does not exist. When write returns an
+// error, the file is left as it was and the error is returned.
+func Write(path string, perm fs.FileMode, write func(w io.Writer) error) error {
+	if err := write1(path, perm, true, write); err != nil {
+		return fmt.Errorf("fileutil: write %s: %w", path, err)
+	}
+	return nil
+}
+
+func write1(path string, perm fs.FileMode, keepPerm bool, write func(w io.Writer) error) error {
+	// Replace the file a link points to, not the link.
+	path, err := resolve(path)
+	if err != nil {
+		return err
+	}
+
+	// Keep the permissions of the file being replaced.
+	if info, err := os.Stat(path); err == nil {
+		if keepPerm {
+			perm = info.Mode().Perm()
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly once renamed
+
+	bw := bufio.NewWriter(tmp)
+	err = write(bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return SyncDir(dir)
+}
+
+// maxLinks is how many symbolic links resolve follows before giving up, as
+// the kernel does.
+const maxLinks = 40
+
+// resolve returns the path of the file path names once symbolic links are
+// followed. The file itself need not exist, even when a link points to it.
+func resolve(path string) (string, error) {
+	for i := 0; i < maxLinks; i++ {
+		info, err := os.Lstat(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			// A new file, possibly in a linked directory.
+			dir, err := filepath.EvalSymlinks(filepath.Dir(path))
+			if err != nil {
+				return "", err
+			}
+			return filepath.Join(dir, filepath.Base(path)), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			return path, nil
+		}
+		target, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		path = target
+	}
+	return "", errors.New("too many links")
+}
+
+// SyncDir flushes the entries of the directory to disk, so that the files
+// created, renamed or removed in it stay that way after a crash.
+func SyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -5,18 +5,23 @@ import (
 	"fmt"
 	"os"
 	"time"
+
+	"github.com/saurabhkk55/Go/11_file_os/fileutil"
 )
 
 func main() {
 	var data string
 
 	// Create a file and get its path
-	createdFile, filePath := createFile()
+	filePath := createFile()
 	fmt.Println("File created:", filePath)
 	fmt.Println("########################################################")
 
 	data = "This is some data that we are writing to a file in Go.\n"
-	writeToFile(createdFile, data)
+	if err := writeToFile(filePath, data); err != nil {
+		fmt.Println("Error writing file:", err)
+		return
+	}
 	fmt.Println("Data written to the file.")
 	fmt.Println("########################################################")
 
@@ -25,7 +30,10 @@ func main() {
 	fmt.Println("########################################################")
 
 	data = "1 2 3 4 5 6 7 8 9 0.\n"
-	writeToFile(createdFile, data)
+	if err := writeToFile(filePath, data); err != nil {
+		fmt.Println("Error writing file:", err)
+		return
+	}
 	fmt.Println("Data written to the file.")
 	fmt.Println("########################################################")
 
@@ -38,11 +46,11 @@ func main() {
 	time.Sleep(duration)
 
 	// Delete the file
-	deleteFile(createdFile, filePath)
+	deleteFile(filePath)
 	fmt.Println("########################################################")
 }
 
-func createFile() (*os.File, string) {
+func createFile() string {
 	// Get the current working directory
 	currentDir, err := os.Getwd()
 	if err != nil {
@@ -65,31 +73,17 @@ func createFile() (*os.File, string) {
 		panic(err)
 	}
 
+	// The writes below go through the path, so the file can be closed now
+	myFile.Close()
+
 	fmt.Println("File is now created in the current working directory and ready for use.")
 
-	return myFile, filePath
+	return filePath
 }
 
-func writeToFile(myEmptyFile *os.File, data string) *os.File {
-	// Create a buffered writer (myWriter) to write to the file efficiently
-	myWriter := bufio.NewWriter(myEmptyFile)
-
-	// Data to be written to the file
-	myData := data
-
-	// Write the data to the file using the buffered writer
-	_, err := myWriter.WriteString(myData)
-	if err != nil {
-		panic(err)
-	}
-
-	// Flush the writer to ensure data is written to the file
-	err = myWriter.Flush()
-	if err != nil {
-		panic(err)
-	}
-
-	return myEmptyFile
+// writeToFile appends data to the file and syncs it to disk, returning any error.
+func writeToFile(my_filePath string, data string) error {
+	return fileutil.AppendFile(my_filePath, []byte(data), 0o644)
 }
 
 func readFile(my_file_Path string) {
@@ -128,10 +122,7 @@ func readFile(my_file_Path string) {
 	}
 }
 
-func deleteFile(myEmptyFile *os.File, my_filePath string) {
-
-	// Close the file before deleting
-	myEmptyFile.Close()
+func deleteFile(my_filePath string) {
 
 	// Delete the file.
 	err := os.Remove(my_filePath)
@@ -2,31 +2,45 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
-
-	"go.mongodb.org/mongo-driver/bson"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/audit"
+	"github.com/saurabhkk55/Go/16_MongoDB/blobstore"
+	"github.com/saurabhkk55/Go/16_MongoDB/cache"
+	"github.com/saurabhkk55/Go/16_MongoDB/expiry"
+	"github.com/saurabhkk55/Go/16_MongoDB/filestore"
+	"github.com/saurabhkk55/Go/16_MongoDB/migrate"
+	"github.com/saurabhkk55/Go/16_MongoDB/mongoconn"
+	"github.com/saurabhkk55/Go/16_MongoDB/outbox"
+	"github.com/saurabhkk55/Go/16_MongoDB/query"
+	"github.com/saurabhkk55/Go/16_MongoDB/search"
+	"github.com/saurabhkk55/Go/16_MongoDB/tenant"
+	"github.com/saurabhkk55/Go/16_MongoDB/userstore"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// connectMongoDB establishes a connection to the default/local MongoDB server.
-func connectMongoDB() (*mongo.Client, error) {
-	// Set MongoDB connection options, specifying the server URI.
-	clientOptions := options.Client().ApplyURI("mongodb://localhost:27017")
-
-	// Connect to the MongoDB server using the specified options.
-	client, err := mongo.Connect(context.TODO(), clientOptions)
+// connectMongoDB connects to the MongoDB server configured by the MONGODB_* environment
+// variables or the MONGODB_CONFIG file, and to the local server when neither is set.
+func connectMongoDB(ctx context.Context) (*mongoconn.Client, error) {
+	// Load the connection settings: URI, pool size, timeouts and app name.
+	cfg, err := mongoconn.Load("")
 	if err != nil {
-		log.Fatal(err)
 		return nil, err
 	}
 
-	// Ping the MongoDB server to ensure a successful connection.
-	err = client.Ping(context.TODO(), nil)
+	// Connect and ping the server, retrying with backoff while it is starting up.
+	client, err := mongoconn.Connect(ctx, cfg, log.Printf)
 	if err != nil {
-		log.Fatal(err)
 		return nil, err
 	}
 
@@ -37,168 +51,808 @@ func connectMongoDB() (*mongo.Client, error) {
 	return client, nil
 }
 
-// createDatabase creates a new database in MongoDB and prints a message once it's created.
-func createDatabase(client *mongo.Client, dbName string) {
-	// Create a new database using the client.Database method.
-	client.Database(dbName)
+// migrateDatabase brings the database and collection up to the latest schema version.
+// It creates the collection, converts legacy documents and adds the validator and
+// indexes the first time, and only applies new migrations on later starts. Legacy
+// documents that could not be converted are moved aside and counted in the log.
+func migrateDatabase(ctx context.Context, runner *migrate.Runner, db *mongo.Database, collectionName string) error {
+	// Apply every migration that has not been applied yet.
+	ran, err := runner.Migrate(ctx, runner.Latest())
+	for _, m := range ran {
+		log.Printf("Applied migration %d: %s", m.Version, m.Description)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Log the schema version the database is now at.
+	log.Printf("Database '%s' is at schema version %d", db.Name(), runner.Latest())
 
-	// Log a message indicating the successful creation of the database.
-	log.Printf("Database '%s' created successfully!", dbName)
+	// Point out the legacy documents left for someone to fix by hand.
+	n, err := migrate.CountQuarantined(ctx, db, collectionName)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Printf("%d legacy documents of '%s' could not be migrated; they are in '%s'",
+			n, collectionName, migrate.QuarantineCollection(collectionName))
+	}
+	return nil
 }
 
-// createCollection creates a new collection inside the specified database and prints a message once it's created.
-func createCollection(client *mongo.Client, dbName, collectionName string) {
-	// Access the specified database.
-	db := client.Database(dbName)
+// newRepository returns the user repository for the specified collection. Every
+// change made through it is recorded in the audit collection of the same database,
+// and users looked up by name are cached until they change. Operations that
+// take longer than timeout fail with userstore.ErrTimeout.
+func newRepository(db *mongo.Database, collectionName, auditCollection string, timeout time.Duration) *cache.Repository {
+	repo := userstore.NewMongoRepository(db.Collection(collectionName))
+	repo.SetTimeout(timeout)
+	audited := audit.NewRepository(repo, audit.NewMongoLog(db.Collection(auditCollection)))
+	return cache.NewRepository(audited, cache.Options{})
+}
 
-	// Create a new collection using the Database.CreateCollection method.
-	err := db.CreateCollection(context.TODO(), collectionName)
-	if err != nil {
-		log.Fatal(err)
+// operationTimeout bounds each MongoDB call made from the menu; main sets it
+// from the connection settings.
+var operationTimeout = userstore.DefaultTimeout
+
+// withTimeout bounds ctx by operationTimeout, for the MongoDB calls made
+// outside the repository, which bounds its own.
+func withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if operationTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, operationTimeout)
+}
+
+// auditContext returns the context for changes made from the menu, which the
+// audit log records as made by the operating system user running the program.
+func auditContext(ctx context.Context) context.Context {
+	return audit.WithActor(ctx, audit.SystemActor())
+}
+
+// printError prints err, or where the change went when it was queued in the
+// outbox because MongoDB could not be reached, or that MongoDB timed out.
+func printError(err error) {
+	var queued *outbox.QueuedError
+	if errors.As(err, &queued) && queued.Duplicate {
+		fmt.Printf("MongoDB is unreachable; the same change is already waiting in the outbox as %s, so it was not queued again.\n", queued.Key)
+		return
+	}
+	if errors.As(err, &queued) {
+		fmt.Printf("MongoDB is unreachable; the change is kept in the outbox as %s and will be retried.\n", queued.Key)
 		return
 	}
+	if errors.Is(err, userstore.ErrTimeout) {
+		fmt.Printf("ERROR:  MongoDB did not answer within %s; try again later.\n", operationTimeout)
+		return
+	}
+	var syntax *query.SyntaxError
+	if errors.As(err, &syntax) {
+		fmt.Println("ERROR: ", err)
+		fmt.Println(syntax.Caret())
+		return
+	}
+	fmt.Println("ERROR: ", err)
+}
 
-	// Log a message indicating the successful creation of the collection.
-	log.Printf("Collection '%s' created successfully in database '%s'!", collectionName, dbName)
+// readLine reads the rest of a line typed at the prompt, skipping the end of
+// the line the previous fmt.Scan left behind. It reads a byte at a time, as
+// fmt.Scan does, so that the two can be mixed.
+func readLine() string {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		if n, err := os.Stdin.Read(b); n == 0 || err != nil {
+			break
+		}
+		if b[0] != '\n' {
+			line = append(line, b[0])
+		} else if strings.TrimSpace(string(line)) != "" {
+			break
+		}
+	}
+	return strings.TrimSpace(string(line))
 }
 
-// insertDocument inserts a document into the specified collection in MongoDB.
-func insertDocument(client *mongo.Client, dbName, collectionName string) {
-	// Access the specified database and collection.
-	collection := client.Database(dbName).Collection(collectionName)
+// readSelection reads a name, or a query such as Age >= 18 AND Name ~ "^Sau",
+// at the prompt. A single word is a name; anything else is parsed as a query.
+func readSelection(prompt string) (name string, where query.Expr, err error) {
+	fmt.Print(prompt)
+	line := readLine()
+	if !strings.ContainsAny(line, " =<>!~()") {
+		return line, nil, nil
+	}
+	where, err = query.Parse(line)
+	return "", where, err
+}
 
-	var user_input string
+// findUsers returns the users outside the trash matching where.
+func findUsers(ctx context.Context, repo userstore.Repository, where query.Expr) ([]userstore.User, error) {
+	var users []userstore.User
+	opts := userstore.ListOptions{Filter: userstore.Filter{Where: where}, Sort: []userstore.SortField{{Field: "name"}}}
+	err := repo.Stream(ctx, opts, func(u *userstore.User) error {
+		users = append(users, *u)
+		return nil
+	})
+	return users, err
+}
 
+// insertDocument inserts a document into the specified collection in MongoDB.
+func insertDocument(ctx context.Context, repo userstore.Repository) {
 	for {
+		var user_input string
 		fmt.Println("press 'Y' or 'y' to insert data, otherwise press any key to exit.")
 		fmt.Scan(&user_input)
 		if user_input == "Y" || user_input == "y" {
-			document := getUserInput()
-			result, err := collection.InsertOne(context.TODO(), document)
+			user, err := getUserInput()
 			if err != nil {
-				log.Fatal(err)
+				// The input ended, or could not be read, midway.
+				printError(err)
 				return
 			}
+			err = repo.Create(auditContext(ctx), &user)
+			if err != nil {
+				// Keep asking: a write MongoDB could not take is in the outbox.
+				printError(err)
+				continue
+			}
 			// Log a message indicating the successful insertion of the document.
-			log.Printf("Document inserted successfully! Document ID: %v", result.InsertedID)
+			log.Printf("Document inserted successfully! Document ID: %v", user.ID.Hex())
+			if user.ExpiresAt != nil {
+				log.Printf("It expires at %s", user.ExpiresAt.Local().Format(time.DateTime))
+			}
 		} else {
 			break
 		}
 	}
 }
 
-// Input from user that needs to be inserted
-func getUserInput() bson.D {
-	var user_name, user_age, user_gen string
+// Input from user that needs to be inserted.
+// Each value is asked for again until it passes validation. An error
+// reading the input, such as its end, is returned at once.
+func getUserInput() (userstore.User, error) {
+	var user userstore.User
 
-	// Get user input for key and value
-	fmt.Print("Enter name: ")
-	fmt.Scan(&user_name)
+	// Get the name; it must be a single non-empty word because fmt.Scan splits on spaces.
+	for {
+		fmt.Print("Enter name: ")
+		if _, err := fmt.Scan(&user.Name); err != nil {
+			return user, err
+		}
+		if len(user.Name) <= userstore.MaxNameLength {
+			break
+		}
+		fmt.Println("ERROR: name is too long")
+	}
 
-	fmt.Print("Enter age: ")
-	fmt.Scan(&user_age)
+	// Get the age as a whole number.
+	for {
+		var user_age string
+		fmt.Print("Enter age: ")
+		if _, err := fmt.Scan(&user_age); err != nil {
+			return user, err
+		}
 
-	fmt.Print("Enter gender: ")
-	fmt.Scan(&user_gen)
+		age, err := userstore.ParseAge(user_age)
+		if err == nil && age >= userstore.MinAge && age <= userstore.MaxAge {
+			user.Age = age
+			break
+		}
+		fmt.Printf("ERROR: age must be a whole number between %d and %d\n", userstore.MinAge, userstore.MaxAge)
+	}
 
-	// Create a BSON document with the user input
-	document := bson.D{
-		primitive.E{Key: "Name", Value: user_name},
-		primitive.E{Key: "Age", Value: user_age},
-		primitive.E{Key: "Gender", Value: user_gen},
+	// Get the gender as M, F or O.
+	for {
+		var user_gen string
+		fmt.Print("Enter gender (M/F/O): ")
+		if _, err := fmt.Scan(&user_gen); err != nil {
+			return user, err
+		}
+
+		gender, err := userstore.ParseGender(user_gen)
+		if err == nil {
+			user.Gender = gender
+			break
+		}
+		fmt.Println("ERROR:", err)
 	}
 
-	return document
+	return user, nil
 }
 
-// fetchDocument retrieves a document from the specified collection based on a field and its value.
-func fetchDocument(client *mongo.Client, dbName, collectionName string) {
-	// Access the specified database and collection.
-	collection := client.Database(dbName).Collection(collectionName)
+// fetchDocument retrieves the document of the user with the entered name, or
+// those of the users matching the entered query. When no user has the name,
+// it lists the users with similar names or attributes instead.
+func fetchDocument(ctx context.Context, repo userstore.Repository, searcher search.Searcher) {
+	user_name, where, err := readSelection(`Enter name, or a query such as Age >= 18 AND Name ~ "^Sau", to get the corresponding documents: `)
+	if err != nil {
+		printError(err)
+		return
+	}
+
+	var users []userstore.User
+	if where != nil {
+		users, err = findUsers(ctx, repo, where)
+	} else {
+		// Look the user up by name, leaving out users in the trash. Repeated
+		// lookups of the same name are answered by the cache.
+		var user *userstore.User
+		user, err = repo.FindByName(ctx, user_name)
+		if errors.Is(err, userstore.ErrNotFound) {
+			suggestUsers(ctx, searcher, user_name)
+			return
+		}
+		if user != nil {
+			users = append(users, *user)
+		}
+	}
+	if err != nil {
+		printError(err)
+		return
+	}
+	if len(users) == 0 {
+		fmt.Println("No user matches", where)
+		return
+	}
+
+	// Print the retrieved documents, one field per line.
+	for i := range users {
+		if i > 0 {
+			fmt.Println()
+		}
+		for key, value := range userstore.Project(&users[i], nil) {
+			fmt.Printf("%s: %v\n", key, value)
+		}
+	}
+}
 
+// suggestUsers prints the users best matching a name that was not found,
+// ignoring case and accents and tolerating typos.
+func suggestUsers(ctx context.Context, searcher search.Searcher, name string) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	results, err := searcher.Search(ctx, search.Query{Text: name, Mode: search.Fuzzy, Limit: 5})
+	if err != nil {
+		printError(err)
+		return
+	}
+	if len(results) == 0 {
+		fmt.Println("ERROR: no user is named", name)
+		return
+	}
+	fmt.Println("No user is named", name+". Did you mean:")
+	for _, r := range results {
+		fmt.Printf("  %s (%s)\n", r.User.Name, r.User.ID.Hex())
+	}
+}
+
+// updateDocument updates the document of the user with the entered name.
+func updateDocument(ctx context.Context, repo userstore.Repository) {
 	var user_name string
-	fmt.Print("Enter name to get its correspondng document: ")
+	fmt.Print("Enter name to update its corresponding document: ")
 	fmt.Scan(&user_name)
 
-	// Define a filter based on the field and its value.
-	filter := bson.D{{Key: "Name", Value: user_name}}
+	// Look the user up first so a wrong name is reported before asking for more input.
+	user, err := repo.FindByName(ctx, user_name)
+	if err != nil {
+		printError(err)
+		return
+	}
 
-	// Find the document using the Collection.FindOne method with the specified filter.
-	var result bson.M
-	err := collection.FindOne(context.TODO(), filter).Decode(&result)
+	fmt.Println("1. Set a field")
+	fmt.Println("2. Remove an attribute")
+	fmt.Println("3. Increment age")
+	fmt.Println("4. Replace the whole document")
+	fmt.Print("Choose an option: ")
+
+	var choice string
+	fmt.Scan(&choice)
+
+	var result *userstore.UpdateResult
+	switch choice {
+	case "1":
+		var field, value string
+		fmt.Print("Enter field (name, age, gender or attributes.<key>): ")
+		fmt.Scan(&field)
+		fmt.Print("Enter value: ")
+		fmt.Scan(&value)
+
+		var update userstore.Update
+		update, err = setFieldUpdate(field, value)
+		if err == nil {
+			result, err = repo.Update(auditContext(ctx), user.ID, update, userstore.AnyVersion)
+		}
+	case "2":
+		var key string
+		fmt.Print("Enter attribute to remove: ")
+		fmt.Scan(&key)
+
+		update := userstore.Update{Unset: []string{"attributes." + key}}
+		result, err = repo.Update(auditContext(ctx), user.ID, update, userstore.AnyVersion)
+	case "3":
+		var amount string
+		fmt.Print("Enter amount to add to the age: ")
+		fmt.Scan(&amount)
+
+		var n int
+		n, err = strconv.Atoi(amount)
+		if err == nil {
+			update := userstore.Update{Inc: map[string]int{"age": n}}
+			result, err = repo.Update(auditContext(ctx), user.ID, update, userstore.AnyVersion)
+		}
+	case "4":
+		var replacement userstore.User
+		replacement, err = getUserInput()
+		if err == nil {
+			replacement.ID = user.ID
+			result, err = repo.Replace(auditContext(ctx), &replacement, userstore.AnyVersion)
+		}
+	default:
+		fmt.Println("ERROR: unknown option", choice)
+		return
+	}
 
-	var k int
 	if err != nil {
-		fmt.Println("ERROR: ", err)
-		k = 0
-		// log.Fatal(err)
-		// return
+		printError(err)
+		return
+	}
+
+	// Print how many documents matched and how many actually changed.
+	fmt.Printf("Matched %v and modified %v document(s).\n", result.Matched, result.Modified)
+}
+
+// setFieldUpdate builds an update that sets a single field typed at the prompt.
+func setFieldUpdate(field, value string) (userstore.Update, error) {
+	var update userstore.Update
+
+	switch {
+	case field == "name":
+		update.Set.Name = &value
+	case field == "age":
+		age, err := userstore.ParseAge(value)
+		if err != nil {
+			return update, err
+		}
+		update.Set.Age = &age
+	case field == "gender":
+		gender, err := userstore.ParseGender(value)
+		if err != nil {
+			return update, err
+		}
+		update.Set.Gender = &gender
+	case strings.HasPrefix(field, "attributes."):
+		update.Set.Attributes = map[string]string{strings.TrimPrefix(field, "attributes."): value}
+	default:
+		return update, fmt.Errorf("unknown field %q", field)
+	}
+
+	return update, nil
+}
+
+// upsertDocument replaces the document of the user with the entered name, or inserts it if there is none.
+func upsertDocument(ctx context.Context, repo userstore.Repository) {
+	user, err := getUserInput()
+	if err != nil {
+		printError(err)
+		return
+	}
+	result, err := repo.Upsert(auditContext(ctx), &user)
+	if err != nil {
+		printError(err)
+		return
+	}
+
+	if result.Upserted() {
+		fmt.Printf("Inserted a new document with ID: %v\n", user.ID.Hex())
 	} else {
-		if k == 0 {
-			for key, value := range result {
-				fmt.Printf("%s: %v\n", key, value)
+		fmt.Printf("Matched %v and modified %v document(s).\n", result.Matched, result.Modified)
+	}
+}
+
+// deleteDocument deletes the user whose name is typed in, or the users
+// matching the query typed in, from the specified collection. The files
+// attached to the users are deleted with them when they are deleted
+// permanently, and kept while they are in the trash.
+func deleteDocument(ctx context.Context, repo userstore.Repository, blobs blobstore.Store) {
+	value, where, err := readSelection(`Enter name, or a query such as Age >= 18 AND Name ~ "^Sau", to delete the corresponding documents: `)
+	if err != nil {
+		printError(err)
+		return
+	}
+
+	// Find the users with that name or matching the query.
+	var users []userstore.User
+	if where != nil {
+		users, err = findUsers(ctx, repo, where)
+	} else {
+		var user *userstore.User
+		user, err = repo.FindByName(ctx, value)
+		if user != nil {
+			users = append(users, *user)
+		}
+	}
+	if err != nil && !errors.Is(err, userstore.ErrNotFound) {
+		printError(err)
+		return
+	}
+	if len(users) == 0 {
+		fmt.Println("Deleted 0 document(s) with the specified filter.")
+		return
+	}
+	if where != nil {
+		for _, u := range users {
+			fmt.Printf("  %s (%s)\n", u.Name, u.ID.Hex())
+		}
+	}
+
+	// Move the users to the trash unless a permanent delete is asked for.
+	var user_input string
+	fmt.Printf("press 'P' or 'p' to delete the %d user(s) permanently, 'N' or 'n' to keep them, otherwise press any key to move them to the trash: ", len(users))
+	fmt.Scan(&user_input)
+	if user_input == "N" || user_input == "n" {
+		fmt.Println("Deleted 0 document(s) with the specified filter.")
+		return
+	}
+	deleted := 0
+	for _, u := range users {
+		if user_input == "P" || user_input == "p" {
+			// The repository deletes the files too; count them to tell.
+			attached, _ := listBlobs(ctx, blobs, u.ID)
+			_, err = repo.HardDelete(auditContext(ctx), u.ID, userstore.AnyVersion)
+			if err == nil && len(attached) > 0 {
+				fmt.Printf("Deleted %d file(s) attached to %s.\n", len(attached), u.Name)
 			}
+		} else {
+			err = repo.Delete(auditContext(ctx), u.ID, userstore.AnyVersion)
+		}
+		if err != nil {
+			printError(err)
+			continue
 		}
+		deleted++
 	}
 
-	// Print the retrieved document.
-	// fmt.Println(result)
+	// Print the number of documents deleted.
+	fmt.Printf("Deleted %d document(s) with the specified filter.\n", deleted)
 }
 
-// deleteDocument deletes a document from the specified collection based on a field and its value.
-func deleteDocument(client *mongo.Client, dbName, collectionName string) {
-	// Access the specified database and collection.
-	collection := client.Database(dbName).Collection(collectionName)
+// listBlobs returns the files attached to the user with the given ID.
+func listBlobs(ctx context.Context, blobs blobstore.Store, userID primitive.ObjectID) ([]blobstore.Blob, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	return blobs.List(ctx, userID)
+}
 
-	var fieldName, value string
+// attachFile stores the file whose path is typed in as a blob of the user
+// whose name is typed in.
+func attachFile(ctx context.Context, repo userstore.Repository, blobs blobstore.Store) {
+	var user_name string
+	fmt.Print("Enter name to attach a file to its user: ")
+	fmt.Scan(&user_name)
+	user, err := repo.FindByName(ctx, user_name)
+	if err != nil {
+		printError(err)
+		return
+	}
 
-	fieldName = "Name"
+	fmt.Print("Enter the path of the file: ")
+	path := readLine()
+	f, err := os.Open(path)
+	if err != nil {
+		printError(err)
+		return
+	}
+	defer f.Close()
 
-	fmt.Print("Enter name to delete its corresponding document: ")
-	fmt.Scan(&value)
+	// The file is streamed to GridFS in chunks, whatever its size.
+	blob, err := blobs.Put(ctx, user.ID, filepath.Base(path), "", f)
+	if err != nil {
+		printError(err)
+		return
+	}
+	fmt.Printf("Attached %s (%s, %d bytes) with ID: %s\n", blob.Filename, blob.ContentType, blob.Size, blob.ID.Hex())
+}
+
+// downloadFile lists the files attached to the user whose name is typed in
+// and saves the one chosen.
+func downloadFile(ctx context.Context, repo userstore.Repository, blobs blobstore.Store) {
+	var user_name string
+	fmt.Print("Enter name to list the files attached to its user: ")
+	fmt.Scan(&user_name)
+	user, err := repo.FindByName(ctx, user_name)
+	if err != nil {
+		printError(err)
+		return
+	}
 
-	// Define a filter based on the field and its value.
-	filter := bson.D{{Key: fieldName, Value: value}}
+	list, err := listBlobs(ctx, blobs, user.ID)
+	if err != nil {
+		printError(err)
+		return
+	}
+	if len(list) == 0 {
+		fmt.Println("No file is attached to", user.Name)
+		return
+	}
+	for i, b := range list {
+		fmt.Printf("%d. %s  %s, %d bytes, %s\n", i+1, b.Filename, b.ContentType, b.Size, b.Uploaded.Local().Format(time.DateTime))
+	}
 
-	// Delete the document using the Collection.DeleteOne method with the specified filter.
-	result, err := collection.DeleteOne(context.TODO(), filter)
+	var choice string
+	fmt.Print("Choose a file to save, or '-' to go back: ")
+	fmt.Scan(&choice)
+	i, err := strconv.Atoi(choice)
+	if err != nil || i < 1 || i > len(list) {
+		return
+	}
+	blob, content, err := blobs.Open(ctx, list[i-1].ID)
 	if err != nil {
-		log.Fatal(err)
+		printError(err)
 		return
 	}
+	defer content.Close()
 
-	// Print the number of documents deleted.
-	fmt.Printf("Deleted %v document(s) with the specified filter.\n", result.DeletedCount)
+	fmt.Printf("Enter the path to save it to (default %s): ", blob.Filename)
+	path := readLine()
+	if path == "" {
+		path = blob.Filename
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		printError(err)
+		return
+	}
+	n, err := io.Copy(f, content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		printError(err)
+		return
+	}
+	fmt.Printf("Saved %d bytes to %s\n", n, path)
+}
+
+// restoreDocument takes the user whose name is typed in out of the trash.
+func restoreDocument(ctx context.Context, repo userstore.Repository) {
+	var user_name string
+	fmt.Print("Enter name to restore its corresponding document: ")
+	fmt.Scan(&user_name)
+
+	// Look the user up in the trash; names stay unique there too.
+	opts := userstore.ListOptions{
+		Filter: userstore.Filter{Name: user_name, Deleted: userstore.OnlyDeleted},
+		Limit:  1,
+	}
+	page, err := repo.List(ctx, opts)
+	if err != nil {
+		printError(err)
+		return
+	}
+	if len(page.Users) == 0 {
+		fmt.Println("ERROR: no deleted user is named", user_name)
+		return
+	}
+
+	user, err := repo.Restore(auditContext(ctx), page.Users[0].ID, userstore.AnyVersion)
+	if err != nil {
+		printError(err)
+		return
+	}
+	fmt.Printf("Restored %s (%s)\n", user.Name, user.ID.Hex())
+}
+
+// showOutbox lists the changes waiting in the outbox and drops the one
+// whose key is typed in.
+func showOutbox(box *outbox.Outbox) {
+	entries, err := box.List()
+	if err != nil {
+		printError(err)
+		return
+	}
+	if len(entries) == 0 {
+		fmt.Println("The outbox is empty.")
+		return
+	}
+	for _, e := range entries {
+		state := "pending"
+		if e.Failed {
+			state = "failed"
+		}
+		fmt.Printf("%s  %s  %s, %d attempt(s): %s\n", e.Key, e.Queued.Local().Format(time.DateTime), state, e.Attempts, e.LastError)
+	}
+
+	var key string
+	fmt.Print("Enter a key to drop that change, or '-' to keep them all: ")
+	fmt.Scan(&key)
+	if key == "-" {
+		return
+	}
+	n, err := box.Drop(key)
+	if err != nil {
+		printError(err)
+		return
+	}
+	fmt.Printf("Dropped %d change(s).\n", n)
 }
 
 func main() {
+	// Work on the users of a provisioned tenant with -tenant ID.
+	tenantID := flag.String("tenant", "", "work on the users of this tenant instead of the shared collection")
+	// Keep the changes MongoDB cannot take in the outbox under -outbox DIR.
+	outboxDir := flag.String("outbox", "outbox", "directory of the outbox of changes waiting for MongoDB")
+	// Make throwaway users expire with -ttl, such as -ttl 24h; MongoDB removes them once expired.
+	ttl := flag.Duration("ttl", 0, "expire the inserted and upserted users this long after; 0 keeps them")
+	flag.Parse()
+
+	// Every MongoDB call of the session runs under ctx. The repository bounds
+	// each of its operations by the operationTimeout of the connection
+	// settings, and the other calls are bounded by withTimeout.
+	ctx := context.Background()
+
 	// Attempt to connect to MongoDB.
-	client, err := connectMongoDB()
+	conn, err := connectMongoDB(ctx)
 	if err != nil {
 		// If connection fails, log the error and exit the program.
 		log.Fatal(err)
 		return
 	}
 	// Defer closing the MongoDB connection until the end of the program.
-	defer client.Disconnect(context.TODO())
+	defer conn.Close(ctx)
+	client := conn.Client
+	operationTimeout = conn.Config().OperationTimeout
 
-	// Specify the name of the database you want to create.
+	// Specify the name of the database and collection to work with.
 	dbName := "db_san"
-	// Create a new database.
-	createDatabase(client, dbName)
-
-	// Specify the name of the collection you want to create.
 	collectionName := "col_san"
+	auditCollection := audit.DefaultCollection
+	bucket := blobstore.DefaultBucket
+	db := client.Database(dbName)
+	runner, err := migrate.NewRunner(db, userstore.Migrations(collectionName))
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
 
-	// Create a new collection inside the database.
-	createCollection(client, dbName, collectionName)
+	// A tenant has a database of its own, or collections of db_san prefixed
+	// with its ID; the other tenants' users cannot be reached from here.
+	if *tenantID != "" {
+		router := tenant.NewRouter(client, dbName, collectionName, auditCollection)
+		t, err := router.Lookup(ctx, *tenantID)
+		if err != nil {
+			log.Fatal(err)
+			return
+		}
+		db = router.Locate(t).DB
+		*outboxDir = filepath.Join(*outboxDir, t.ID)
+		collectionName, auditCollection = router.Collections(t)
+		bucket = router.Locate(t).Name(bucket)
+		if runner, err = router.Migrator(t); err != nil {
+			log.Fatal(err)
+			return
+		}
+	}
 
-	// Insert document
-	insertDocument(client, dbName, collectionName)
+	// Create the collection or upgrade its schema, whichever is needed.
+	if err := migrateDatabase(ctx, runner, db, collectionName); err != nil {
+		log.Fatal(err)
+		return
+	}
 
-	// Fetch and print the document based on the specified field and value.
-	fetchDocument(client, dbName, collectionName)
+	// Index the audit log so that a user's history can be looked up quickly.
+	auditLog := audit.NewMongoLog(db.Collection(auditCollection))
+	if err := auditLog.EnsureIndexes(ctx); err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	// Open the outbox, which keeps the changes made while MongoDB is unreachable.
+	outboxDB, err := filestore.Open(*outboxDir)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	defer outboxDB.Close()
+	outboxColl, err := outboxDB.Collection(outbox.CollectionName)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	box := outbox.New(outboxColl)
+
+	// Keep the files attached to users in a GridFS bucket next to them.
+	blobs, err := blobstore.NewGridFSStore(db, bucket)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	if err := blobs.EnsureIndexes(ctx); err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	// Use one repository for the whole session, so that its cache is shared.
+	// The files of the users deleted permanently go with them, and changes
+	// that cannot reach MongoDB are queued in the outbox instead.
+	cached := newRepository(db, collectionName, auditCollection, operationTimeout)
+	cascade := blobstore.NewRepository(cached, blobs, log.Printf)
+	var repo userstore.Repository = outbox.NewRepository(cascade, box)
+	if *ttl > 0 {
+		repo = expiry.NewRepository(repo, *ttl)
+	}
+
+	// Replay the queued changes in the background, every few seconds while
+	// MongoDB is reachable and less and less often while it is not. Delete
+	// the files left by the users MongoDB removed once they expired.
+	relayCtx, stopRelay := context.WithCancel(ctx)
+	defer stopRelay()
+	go outbox.RunRelay(relayCtx, box, cascade, 5*time.Second, log.Printf)
+	go cascade.RunOrphanSweeper(relayCtx, time.Hour)
+	searcher := search.NewMongoSearcher(db.Collection(collectionName))
 
-	// Delete the document based on the specified field and value.
-	deleteDocument(client, dbName, collectionName)
+	// Show the menu until the user chooses to exit.
+	for {
+		fmt.Println()
+		fmt.Println("1. Insert document")
+		fmt.Println("2. Fetch document")
+		fmt.Println("3. Update document")
+		fmt.Println("4. Upsert document")
+		fmt.Println("5. Delete document")
+		fmt.Println("6. Restore deleted document")
+		fmt.Println("7. Show queued changes")
+		fmt.Println("8. Attach a file")
+		fmt.Println("9. Download an attached file")
+		fmt.Println("0. Exit")
+		fmt.Print("Choose an option: ")
+
+		// The end of the input, or a failure to read it, exits as "0" does
+		// instead of showing the menu again forever.
+		var choice string
+		if _, err := fmt.Scan(&choice); err != nil {
+			if !errors.Is(err, io.EOF) {
+				printError(err)
+			}
+			fmt.Println()
+			choice = "0"
+		}
+
+		switch choice {
+		case "1":
+			// Insert document
+			insertDocument(ctx, repo)
+		case "2":
+			// Fetch and print the document based on the specified field and value.
+			fetchDocument(ctx, repo, searcher)
+		case "3":
+			// Update the document of a user.
+			updateDocument(ctx, repo)
+		case "4":
+			// Replace or insert the document of a user.
+			upsertDocument(ctx, repo)
+		case "5":
+			// Delete the document based on the specified field and value.
+			deleteDocument(ctx, repo, blobs)
+		case "6":
+			// Take a deleted document out of the trash.
+			restoreDocument(ctx, repo)
+		case "7":
+			// List the changes waiting for MongoDB, and drop one if asked.
+			showOutbox(box)
+		case "8":
+			// Store a file, such as a photo or a PDF, with a user.
+			attachFile(ctx, repo, blobs)
+		case "9":
+			// Save one of the files attached to a user.
+			downloadFile(ctx, repo, blobs)
+		case "0":
+			if n, err := box.Len(); err == nil && n > 0 {
+				log.Printf("%d change(s) stay in the outbox until the next start", n)
+			}
+			stats := cached.Stats()
+			log.Printf("Cache: %d hits, %d misses, %d evictions", stats.Hits, stats.Misses, stats.Evictions)
+			return
+		default:
+			fmt.Println("ERROR: unknown option", choice)
+		}
+	}
 }
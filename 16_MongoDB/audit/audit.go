@@ -0,0 +1,115 @@
+// Package audit records who changed which user, when, and how.
+//
+// Wrap a userstore.Repository with NewRepository and every create, update,
+// replace, upsert, delete, restore and purge made through it is written to a Log as an Entry
+// holding the actor, the time, the operation, the filter it used and snapshots
+// of the user before and after the change. The entries of one user replay its
+// whole history; StateAt reconstructs the user as it was at any moment.
+//
+// Entries go either to a MongoDB collection (MongoLog) or to a local
+// append-only JSON Lines file (FileLog).
+package audit
+
+import (
+	"context"
+	"os"
+	"os/user"
+	"time"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/userstore"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Op is the kind of change an entry records.
+type Op string
+
+// The recorded operations.
+const (
+	OpCreate  Op = "create"
+	OpUpdate  Op = "update"
+	OpReplace Op = "replace"
+	OpUpsert  Op = "upsert"
+	OpDelete  Op = "delete"
+	OpRestore Op = "restore"
+
+	// OpPurge records a permanent removal, by HardDelete, Purge or Expire.
+	OpPurge Op = "purge"
+)
+
+// UnknownActor is recorded when the context carries no actor.
+const UnknownActor = "unknown"
+
+// Entry is one recorded change.
+type Entry struct {
+	Time   time.Time          `bson:"time" json:"time"`
+	Actor  string             `bson:"actor" json:"actor"`
+	Op     Op                 `bson:"op" json:"op"`
+	UserID primitive.ObjectID `bson:"userId" json:"userId"`
+
+	// Filter is how the operation selected the user, such as {"_id": ...} or {"Name": ...}.
+	// It is empty for creates.
+	Filter map[string]any `bson:"filter,omitempty" json:"filter,omitempty"`
+
+	// Before is nil for creates, restores and upserts that inserted.
+	// After is nil for purges; after a delete it is the user in the trash.
+	Before *userstore.User `bson:"before,omitempty" json:"before,omitempty"`
+	After  *userstore.User `bson:"after,omitempty" json:"after,omitempty"`
+}
+
+// Log stores audit entries.
+type Log interface {
+	// Write appends an entry.
+	Write(ctx context.Context, e Entry) error
+
+	// History returns the entries of one user, oldest first.
+	History(ctx context.Context, userID primitive.ObjectID) ([]Entry, error)
+}
+
+// StateAt reconstructs a user from its history as it was at time t.
+// A user that was in the trash at t is returned with DeletedAt set. StateAt
+// returns nil when the user did not exist at t, either because it had not
+// been created yet or because it had been purged.
+func StateAt(history []Entry, t time.Time) *userstore.User {
+	var state *userstore.User
+	for _, e := range history {
+		if e.Time.After(t) {
+			break
+		}
+		state = e.After
+	}
+	return state
+}
+
+// actorKey is the context key of the actor.
+type actorKey struct{}
+
+// WithActor returns a context whose changes are recorded as made by actor.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom returns the actor stored by WithActor, or UnknownActor.
+func ActorFrom(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return UnknownActor
+}
+
+// SystemActor returns the name of the operating system user running the
+// program, for tools that have no other notion of who is making a change.
+func SystemActor() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return UnknownActor
+}
+
+var (
+	_ userstore.Repository = (*Repository)(nil)
+	_ Log                  = (*MongoLog)(nil)
+	_ Log                  = (*FileLog)(nil)
+)
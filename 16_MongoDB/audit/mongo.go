@@ -0,0 +1,58 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultCollection is the collection MongoLog is usually given.
+const DefaultCollection = "audit"
+
+// MongoLog stores entries in a MongoDB collection.
+type MongoLog struct {
+	coll *mongo.Collection
+}
+
+// NewMongoLog returns a Log backed by coll.
+func NewMongoLog(coll *mongo.Collection) *MongoLog {
+	return &MongoLog{coll: coll}
+}
+
+// EnsureIndexes creates the index History relies on. It is safe to call on every start.
+func (l *MongoLog) EnsureIndexes(ctx context.Context) error {
+	_, err := l.coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "userId", Value: 1}, {Key: "time", Value: 1}},
+		Options: options.Index().SetName("userId_time"),
+	})
+	if err != nil {
+		return fmt.Errorf("audit: create index: %w", err)
+	}
+	return nil
+}
+
+// Write inserts the entry.
+func (l *MongoLog) Write(ctx context.Context, e Entry) error {
+	_, err := l.coll.InsertOne(ctx, e)
+	return err
+}
+
+// History returns the entries of one user, oldest first.
+func (l *MongoLog) History(ctx context.Context, userID primitive.ObjectID) ([]Entry, error) {
+	// _id breaks ties between entries written in the same millisecond.
+	opts := options.Find().SetSort(bson.D{{Key: "time", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := l.coll.Find(ctx, bson.D{{Key: "userId", Value: userID}}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("audit: history: %w", err)
+	}
+
+	entries := []Entry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, fmt.Errorf("audit: history: %w", err)
+	}
+	return entries, nil
+}
@@ -0,0 +1,276 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/userstore"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Repository is a userstore.Repository that records its changes in a Log.
+// Reads go straight to the wrapped repository.
+//
+// The before snapshot is read just before the change and the after snapshot
+// just after it, so a concurrent writer that slips in between can make an
+// entry show more than its own change, and Purge may record a user that was
+// restored while it ran. Once a change has been made it is reported as made:
+// failing to record it is passed to the logf set with SetLogf, by default
+// log.Printf, instead of being returned.
+type Repository struct {
+	userstore.Repository
+	log  Log
+	logf func(format string, args ...any)
+}
+
+// NewRepository returns a Repository that records the changes made to repo in log.
+func NewRepository(repo userstore.Repository, log Log) *Repository {
+	return &Repository{Repository: repo, log: log, logf: logPrintf}
+}
+
+// logPrintf is the default logf. log.Printf cannot be used directly, as the
+// Log parameter of NewRepository shadows the package.
+func logPrintf(format string, args ...any) { log.Printf(format, args...) }
+
+// SetLogf sets the function told about the changes that could not be recorded.
+func (r *Repository) SetLogf(logf func(format string, args ...any)) {
+	r.logf = logf
+}
+
+// Create stores u and records its creation.
+func (r *Repository) Create(ctx context.Context, u *userstore.User) error {
+	if err := r.Repository.Create(ctx, u); err != nil {
+		return err
+	}
+	r.record(ctx, OpCreate, u.ID, nil, nil, snapshot(u))
+	return nil
+}
+
+// CreateMany stores users and records the creation of every user that was stored.
+func (r *Repository) CreateMany(ctx context.Context, users []*userstore.User) (map[int]error, error) {
+	failed, err := r.Repository.CreateMany(ctx, users)
+	if err != nil {
+		return failed, err
+	}
+	for i, u := range users {
+		if _, ok := failed[i]; ok {
+			continue
+		}
+		r.record(ctx, OpCreate, u.ID, nil, nil, snapshot(u))
+	}
+	return failed, nil
+}
+
+// Update applies update and records the change when the user was modified.
+func (r *Repository) Update(ctx context.Context, id primitive.ObjectID, update userstore.Update, expect userstore.Expect) (*userstore.UpdateResult, error) {
+	before, err := r.current(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := r.Repository.Update(ctx, id, update, expect)
+	if err != nil || result.Modified == 0 {
+		return result, err
+	}
+	r.recordAfter(ctx, OpUpdate, id, idFilter(id), before)
+	return result, nil
+}
+
+// Replace replaces the stored user and records the change when it was modified.
+func (r *Repository) Replace(ctx context.Context, u *userstore.User, expect userstore.Expect) (*userstore.UpdateResult, error) {
+	before, err := r.current(ctx, u.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := r.Repository.Replace(ctx, u, expect)
+	if err != nil || result.Modified == 0 {
+		return result, err
+	}
+	r.recordAfter(ctx, OpReplace, u.ID, idFilter(u.ID), before)
+	return result, nil
+}
+
+// Upsert replaces or inserts the user with u's name and records the change.
+func (r *Repository) Upsert(ctx context.Context, u *userstore.User) (*userstore.UpdateResult, error) {
+	before, err := r.Repository.FindByName(ctx, u.Name)
+	if errors.Is(err, userstore.ErrNotFound) {
+		before, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := r.Repository.Upsert(ctx, u)
+	if err != nil || (result.Modified == 0 && !result.Upserted()) {
+		return result, err
+	}
+	filter := map[string]any{"Name": u.Name}
+	r.recordAfter(ctx, OpUpsert, u.ID, filter, before)
+	return result, nil
+}
+
+// Delete moves the user to the trash and records its state before and after.
+func (r *Repository) Delete(ctx context.Context, id primitive.ObjectID, expect userstore.Expect) error {
+	before, err := r.current(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if err := r.Repository.Delete(ctx, id, expect); err != nil {
+		return err
+	}
+	// The trashed user is found by name, which is unknown when the user only
+	// appeared after it was read; the entry then shows neither state.
+	var after *userstore.User
+	if before != nil {
+		if after, err = r.trashed(ctx, before.Name); err != nil {
+			r.logf("audit: %s of %s not recorded: read after: %v", OpDelete, id.Hex(), err)
+			return nil
+		}
+	}
+	r.record(ctx, OpDelete, id, idFilter(id), before, after)
+	return nil
+}
+
+// Restore takes the user out of the trash and records its restored state.
+func (r *Repository) Restore(ctx context.Context, id primitive.ObjectID, expect userstore.Expect) (*userstore.User, error) {
+	u, err := r.Repository.Restore(ctx, id, expect)
+	if err != nil {
+		return nil, err
+	}
+	r.record(ctx, OpRestore, id, idFilter(id), nil, snapshot(u))
+	return u, nil
+}
+
+// HardDelete permanently removes the user and records its last state.
+func (r *Repository) HardDelete(ctx context.Context, id primitive.ObjectID, expect userstore.Expect) (*userstore.User, error) {
+	u, err := r.Repository.HardDelete(ctx, id, expect)
+	if err != nil {
+		return nil, err
+	}
+	r.record(ctx, OpPurge, id, idFilter(id), snapshot(u), nil)
+	return u, nil
+}
+
+// Purge permanently removes the users deleted before deletedBefore and records
+// the last state of each of them.
+func (r *Repository) Purge(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	// Collect the users first, since they cannot be read once purged.
+	var purged []*userstore.User
+	opts := userstore.ListOptions{Filter: userstore.Filter{Deleted: userstore.OnlyDeleted}}
+	err := r.Repository.Stream(ctx, opts, func(u *userstore.User) error {
+		if u.DeletedAt.Before(deletedBefore) {
+			purged = append(purged, snapshot(u))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := r.Repository.Purge(ctx, deletedBefore)
+	if err != nil {
+		return 0, err
+	}
+	filter := map[string]any{"DeletedAt": map[string]any{"$lt": deletedBefore}}
+	for _, u := range purged {
+		r.record(ctx, OpPurge, u.ID, filter, u, nil)
+	}
+	return n, nil
+}
+
+// Expire permanently removes the users that expired by now and records the
+// last state of each of them.
+func (r *Repository) Expire(ctx context.Context, now time.Time) (int64, error) {
+	var expired []*userstore.User
+	opts := userstore.ListOptions{Filter: userstore.Filter{Deleted: userstore.IncludeDeleted, Where: userstore.ExpiredBy(now)}}
+	err := r.Repository.Stream(ctx, opts, func(u *userstore.User) error {
+		expired = append(expired, snapshot(u))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := r.Repository.Expire(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+	filter := map[string]any{"ExpiresAt": map[string]any{"$lte": now}}
+	for _, u := range expired {
+		r.record(ctx, OpPurge, u.ID, filter, u, nil)
+	}
+	return n, nil
+}
+
+// trashed returns the user in the trash with the given name. Names stay
+// unique in the trash, so there is at most one.
+func (r *Repository) trashed(ctx context.Context, name string) (*userstore.User, error) {
+	opts := userstore.ListOptions{
+		Filter: userstore.Filter{Name: name, Deleted: userstore.OnlyDeleted},
+		Limit:  1,
+	}
+	page, err := r.Repository.List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(page.Users) == 0 {
+		return nil, userstore.ErrNotFound
+	}
+	return &page.Users[0], nil
+}
+
+// History returns the recorded changes of one user, oldest first.
+func (r *Repository) History(ctx context.Context, id primitive.ObjectID) ([]Entry, error) {
+	return r.log.History(ctx, id)
+}
+
+// current returns the stored user, or nil when there is none. The wrapped
+// repository reports the missing user itself when the change is attempted.
+func (r *Repository) current(ctx context.Context, id primitive.ObjectID) (*userstore.User, error) {
+	u, err := r.Repository.Get(ctx, id)
+	if errors.Is(err, userstore.ErrNotFound) {
+		return nil, nil
+	}
+	return u, err
+}
+
+// recordAfter reads the user back and records the change.
+func (r *Repository) recordAfter(ctx context.Context, op Op, id primitive.ObjectID, filter map[string]any, before *userstore.User) {
+	after, err := r.Repository.Get(ctx, id)
+	if err != nil {
+		r.logf("audit: %s of %s not recorded: read after: %v", op, id.Hex(), err)
+		return
+	}
+	r.record(ctx, op, id, filter, before, after)
+}
+
+// record writes an entry for the change, or logs why it could not.
+func (r *Repository) record(ctx context.Context, op Op, id primitive.ObjectID, filter map[string]any, before, after *userstore.User) {
+	e := Entry{
+		Time:   time.Now().UTC().Truncate(time.Millisecond),
+		Actor:  ActorFrom(ctx),
+		Op:     op,
+		UserID: id,
+		Filter: filter,
+		Before: before,
+		After:  after,
+	}
+	if err := r.log.Write(ctx, e); err != nil {
+		r.logf("audit: %s of %s not recorded: %v", op, id.Hex(), err)
+	}
+}
+
+// idFilter is the filter of operations that select a user by ID.
+func idFilter(id primitive.ObjectID) map[string]any {
+	return map[string]any{"_id": id}
+}
+
+// snapshot copies u so that later changes by the caller do not alter the entry.
+func snapshot(u *userstore.User) *userstore.User {
+	c := u.Clone()
+	return &c
+}
@@ -0,0 +1,240 @@
+// Package backup copies a MongoDB database to a single archive file and back.
+//
+// An archive is a zip file. Each collection is stored, Deflate-compressed, as
+// collections/<name>.bson: its documents as raw BSON, one after the other,
+// the same layout mongodump uses. metadata.json comes last and records the
+// source database, the time of the backup and, for every collection, the
+// number of documents, the SHA-256 of its entry, its options (such as the
+// validator) and its index specifications.
+//
+// Backup reads every collection in one snapshot session, so the archive holds
+// the database as it was at a single cluster time, recorded in the metadata,
+// however long the copy takes. Snapshot reads need a replica set or sharded
+// cluster and must finish within the server's snapshot history window
+// (minSnapshotHistoryWindowInSeconds, five minutes by default). A standalone
+// server can only be copied one collection after the other, with NoSnapshot.
+//
+// Restore checks every checksum before it writes anything, so a damaged
+// archive leaves the target database untouched.
+package backup
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Format is the archive format version written by Backup.
+const Format = 1
+
+// metadataName is the name of the metadata entry in the archive.
+const metadataName = "metadata.json"
+
+// Metadata describes the content of an archive.
+type Metadata struct {
+	Format    int       `json:"format"`
+	Database  string    `json:"database"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	// SnapshotTime is the cluster time every collection was read at. It is
+	// nil for a backup made with NoSnapshot, whose collections were each read
+	// at their own time.
+	SnapshotTime *primitive.Timestamp `json:"snapshotTime,omitempty"`
+
+	Collections []Collection `json:"collections"`
+}
+
+// Collection describes one collection in an archive.
+type Collection struct {
+	Name      string `json:"name"`
+	Documents int64  `json:"documents"`
+	Bytes     int64  `json:"bytes"`
+	SHA256    string `json:"sha256"`
+
+	// Options and Indexes are MongoDB Extended JSON, as listCollections and
+	// listIndexes return them.
+	Options json.RawMessage   `json:"options,omitempty"`
+	Indexes []json.RawMessage `json:"indexes,omitempty"`
+}
+
+// Find returns the collection with the given name.
+func (m *Metadata) Find(name string) (*Collection, bool) {
+	for i := range m.Collections {
+		if m.Collections[i].Name == name {
+			return &m.Collections[i], true
+		}
+	}
+	return nil, false
+}
+
+// Options controls which collections Backup and Restore handle.
+type Options struct {
+	// Collections limits the operation to these collections. Empty means all.
+	Collections []string
+
+	// NoSnapshot makes Backup read the collections one after the other
+	// without a snapshot, for a standalone server. Changes made meanwhile can
+	// then be missed, or captured in one collection and not in another.
+	NoSnapshot bool
+}
+
+// selected reports whether the collection is part of the operation.
+func (o Options) selected(name string) bool {
+	if len(o.Collections) == 0 {
+		return true
+	}
+	for _, c := range o.Collections {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Backup streams the collections of db to w as an archive and returns its metadata.
+// Views and system collections are left out.
+func Backup(ctx context.Context, db *mongo.Database, w io.Writer, opts Options) (*Metadata, error) {
+	specs, err := db.ListCollectionSpecifications(ctx, bson.D{{Key: "type", Value: "collection"}})
+	if err != nil {
+		return nil, fmt.Errorf("backup: list collections: %w", err)
+	}
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Name < specs[j].Name })
+
+	meta := &Metadata{
+		Format:    Format,
+		Database:  db.Name(),
+		CreatedAt: time.Now().UTC().Truncate(time.Millisecond),
+	}
+	zw := zip.NewWriter(w)
+
+	// The documents are read in the session, and everything else, which
+	// snapshot reads do not cover, with ctx.
+	readCtx := ctx
+	var sess mongo.Session
+	if !opts.NoSnapshot {
+		sess, err = db.Client().StartSession(options.Session().SetSnapshot(true))
+		if err != nil {
+			return nil, fmt.Errorf("backup: start snapshot session: %w", err)
+		}
+		defer sess.EndSession(context.Background())
+		readCtx = mongo.NewSessionContext(ctx, sess)
+	}
+
+	for _, spec := range specs {
+		if strings.HasPrefix(spec.Name, "system.") || !opts.selected(spec.Name) {
+			continue
+		}
+		info, err := backupCollection(ctx, readCtx, db.Collection(spec.Name), zw)
+		if err != nil {
+			return nil, err
+		}
+		if sess != nil && meta.SnapshotTime == nil {
+			meta.SnapshotTime = snapshotTime(sess)
+		}
+		if spec.Options != nil {
+			if info.Options, err = bson.MarshalExtJSON(spec.Options, true, false); err != nil {
+				return nil, fmt.Errorf("backup: %s: options: %w", spec.Name, err)
+			}
+		}
+		meta.Collections = append(meta.Collections, *info)
+	}
+	for _, name := range opts.Collections {
+		if _, ok := meta.Find(name); !ok {
+			return nil, fmt.Errorf("backup: collection %s does not exist in %s", name, db.Name())
+		}
+	}
+
+	// The metadata goes last because the checksums are only known now.
+	entry, err := zw.Create(metadataName)
+	if err != nil {
+		return nil, fmt.Errorf("backup: %w", err)
+	}
+	enc := json.NewEncoder(entry)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(meta); err != nil {
+		return nil, fmt.Errorf("backup: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("backup: %w", err)
+	}
+	return meta, nil
+}
+
+// snapshotTime returns the cluster time a snapshot session reads at, which
+// the server picks on the first read. The driver only exposes it through its
+// internal session.
+func snapshotTime(sess mongo.Session) *primitive.Timestamp {
+	if xs, ok := sess.(mongo.XSession); ok {
+		if t := xs.ClientSession().SnapshotTime; t != nil {
+			return t
+		}
+	}
+	return sess.OperationTime()
+}
+
+// backupCollection writes the documents of coll, read with readCtx, to a new
+// archive entry and returns what it wrote, with the collection's indexes.
+func backupCollection(ctx, readCtx context.Context, coll *mongo.Collection, zw *zip.Writer) (*Collection, error) {
+	info := &Collection{Name: coll.Name()}
+
+	indexes, err := coll.Indexes().List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("backup: %s: list indexes: %w", info.Name, err)
+	}
+	for indexes.Next(ctx) {
+		ext, err := bson.MarshalExtJSON(indexes.Current, true, false)
+		if err != nil {
+			indexes.Close(ctx)
+			return nil, fmt.Errorf("backup: %s: index: %w", info.Name, err)
+		}
+		info.Indexes = append(info.Indexes, ext)
+	}
+	indexes.Close(ctx)
+	if err := indexes.Err(); err != nil {
+		return nil, fmt.Errorf("backup: %s: list indexes: %w", info.Name, err)
+	}
+
+	entry, err := zw.Create(entryName(info.Name))
+	if err != nil {
+		return nil, fmt.Errorf("backup: %w", err)
+	}
+	hash := sha256.New()
+	out := io.MultiWriter(entry, hash)
+
+	// Documents are copied as the server returns them, without decoding.
+	docs, err := coll.Find(readCtx, bson.D{})
+	if err != nil {
+		return nil, fmt.Errorf("backup: %s: %w", info.Name, err)
+	}
+	defer docs.Close(readCtx)
+	for docs.Next(readCtx) {
+		if _, err := out.Write(docs.Current); err != nil {
+			return nil, fmt.Errorf("backup: %s: %w", info.Name, err)
+		}
+		info.Documents++
+		info.Bytes += int64(len(docs.Current))
+	}
+	if err := docs.Err(); err != nil {
+		return nil, fmt.Errorf("backup: %s: %w", info.Name, err)
+	}
+
+	info.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return info, nil
+}
+
+// entryName returns the name of a collection's entry in the archive.
+func entryName(collection string) string {
+	return "collections/" + collection + ".bson"
+}
@@ -0,0 +1,327 @@
+package backup
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrChecksum is wrapped by the errors Verify returns for damaged collections.
+var ErrChecksum = errors.New("checksum mismatch")
+
+// ChecksumError reports a collection whose entry does not match the metadata.
+type ChecksumError struct {
+	Collection string
+	Reason     string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("backup: %s: %s", e.Collection, e.Reason)
+}
+
+// Unwrap lets errors.Is match ErrChecksum.
+func (e *ChecksumError) Unwrap() error {
+	return ErrChecksum
+}
+
+// batchSize is the number of documents Restore writes per bulk write.
+const batchSize = 500
+
+// Archive is an archive opened for reading.
+type Archive struct {
+	zr   *zip.Reader
+	meta *Metadata
+}
+
+// Open reads the metadata of the archive in r, which holds size bytes.
+func Open(r io.ReaderAt, size int64) (*Archive, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("backup: %w", err)
+	}
+	a := &Archive{zr: zr}
+
+	f, err := zr.Open(metadataName)
+	if err != nil {
+		return nil, fmt.Errorf("backup: archive has no %s: %w", metadataName, err)
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(&a.meta); err != nil {
+		return nil, fmt.Errorf("backup: %s: %w", metadataName, err)
+	}
+	if a.meta.Format != Format {
+		return nil, fmt.Errorf("backup: unsupported archive format %d", a.meta.Format)
+	}
+	return a, nil
+}
+
+// Metadata returns the metadata of the archive.
+func (a *Archive) Metadata() *Metadata {
+	return a.meta
+}
+
+// Verify reads every collection selected by opts and checks its checksum and
+// document count against the metadata.
+func (a *Archive) Verify(opts Options) error {
+	for _, name := range opts.Collections {
+		if _, ok := a.meta.Find(name); !ok {
+			return fmt.Errorf("backup: collection %s is not in the archive", name)
+		}
+	}
+	for _, c := range a.meta.Collections {
+		if !opts.selected(c.Name) {
+			continue
+		}
+		if err := a.verify(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verify checks one collection.
+func (a *Archive) verify(c Collection) error {
+	f, err := a.zr.Open(entryName(c.Name))
+	if err != nil {
+		return &ChecksumError{Collection: c.Name, Reason: "entry is missing"}
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	var n int64
+	err = readDocuments(io.TeeReader(f, hash), func(bson.Raw) error {
+		n++
+		return nil
+	})
+	if err != nil {
+		// zip's own CRC check also surfaces here.
+		return &ChecksumError{Collection: c.Name, Reason: err.Error()}
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != c.SHA256 {
+		return &ChecksumError{Collection: c.Name, Reason: fmt.Sprintf("SHA-256 is %s, want %s", sum, c.SHA256)}
+	}
+	if n != c.Documents {
+		return &ChecksumError{Collection: c.Name, Reason: fmt.Sprintf("holds %d documents, want %d", n, c.Documents)}
+	}
+	return nil
+}
+
+// Mode decides what Restore does with a document whose _id already exists.
+type Mode int
+
+// The restore modes.
+const (
+	Skip      Mode = iota // keep the existing document
+	Overwrite             // replace it by the one from the archive
+)
+
+// ParseMode parses "skip" or "overwrite".
+func ParseMode(s string) (Mode, error) {
+	switch s {
+	case "skip":
+		return Skip, nil
+	case "overwrite":
+		return Overwrite, nil
+	}
+	return 0, fmt.Errorf("backup: unknown restore mode %q, want skip or overwrite", s)
+}
+
+// RestoreOptions controls Restore.
+type RestoreOptions struct {
+	Options
+	Mode Mode
+}
+
+// Result counts what Restore did to one collection.
+type Result struct {
+	Collection string `json:"collection"`
+	Inserted   int64  `json:"inserted"`
+	Replaced   int64  `json:"replaced"`
+	Skipped    int64  `json:"skipped"`
+}
+
+// Restore verifies the archive, then writes its collections into db, which
+// need not have the name of the database that was backed up. Missing
+// collections are created with their original options and indexes first, so
+// validators and unique indexes apply to the restored documents.
+//
+// In Skip mode a document that collides with an existing one, by _id or by a
+// unique index, is skipped. In Overwrite mode it replaces the document with
+// the same _id; a collision on another unique index is an error.
+func Restore(ctx context.Context, db *mongo.Database, a *Archive, opts RestoreOptions) ([]Result, error) {
+	if err := a.Verify(opts.Options); err != nil {
+		return nil, err
+	}
+
+	var results []Result
+	for _, c := range a.meta.Collections {
+		if !opts.selected(c.Name) {
+			continue
+		}
+		if err := prepareCollection(ctx, db, c); err != nil {
+			return results, err
+		}
+		result, err := a.restoreCollection(ctx, db.Collection(c.Name), opts.Mode)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, *result)
+	}
+	return results, nil
+}
+
+// prepareCollection creates the collection with its options when it does not
+// exist, then creates its indexes. Existing indexes with the same definition
+// are left alone.
+func prepareCollection(ctx context.Context, db *mongo.Database, c Collection) error {
+	names, err := db.ListCollectionNames(ctx, bson.D{{Key: "name", Value: c.Name}})
+	if err != nil {
+		return fmt.Errorf("backup: %s: %w", c.Name, err)
+	}
+	if len(names) == 0 {
+		create := bson.D{{Key: "create", Value: c.Name}}
+		if len(c.Options) > 0 {
+			var opts bson.D
+			if err := bson.UnmarshalExtJSON(c.Options, true, &opts); err != nil {
+				return fmt.Errorf("backup: %s: options: %w", c.Name, err)
+			}
+			create = append(create, opts...)
+		}
+		if err := db.RunCommand(ctx, create).Err(); err != nil {
+			return fmt.Errorf("backup: %s: create collection: %w", c.Name, err)
+		}
+	}
+
+	var specs bson.A
+	for _, ext := range c.Indexes {
+		var spec bson.D
+		if err := bson.UnmarshalExtJSON(ext, true, &spec); err != nil {
+			return fmt.Errorf("backup: %s: index: %w", c.Name, err)
+		}
+
+		// The _id index always exists; v and ns belong to the source server.
+		clean := bson.D{}
+		for _, e := range spec {
+			if e.Key != "v" && e.Key != "ns" {
+				clean = append(clean, e)
+			}
+		}
+		if name, _ := clean.Map()["name"].(string); name != "_id_" {
+			specs = append(specs, clean)
+		}
+	}
+	if len(specs) == 0 {
+		return nil
+	}
+	err = db.RunCommand(ctx, bson.D{{Key: "createIndexes", Value: c.Name}, {Key: "indexes", Value: specs}}).Err()
+	if err != nil {
+		return fmt.Errorf("backup: %s: create indexes: %w", c.Name, err)
+	}
+	return nil
+}
+
+// restoreCollection writes the documents of one collection in batches.
+func (a *Archive) restoreCollection(ctx context.Context, coll *mongo.Collection, mode Mode) (*Result, error) {
+	result := &Result{Collection: coll.Name()}
+
+	f, err := a.zr.Open(entryName(coll.Name()))
+	if err != nil {
+		return nil, fmt.Errorf("backup: %w", err)
+	}
+	defer f.Close()
+
+	var batch []mongo.WriteModel
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := writeBatch(ctx, coll, batch, mode, result)
+		batch = batch[:0]
+		return err
+	}
+
+	err = readDocuments(f, func(doc bson.Raw) error {
+		if mode == Overwrite {
+			batch = append(batch, mongo.NewReplaceOneModel().
+				SetFilter(bson.D{{Key: "_id", Value: doc.Lookup("_id")}}).
+				SetReplacement(doc).
+				SetUpsert(true))
+		} else {
+			batch = append(batch, mongo.NewInsertOneModel().SetDocument(doc))
+		}
+		if len(batch) < batchSize {
+			return nil
+		}
+		return flush()
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		return result, fmt.Errorf("backup: %s: %w", coll.Name(), err)
+	}
+	return result, nil
+}
+
+// writeBatch sends one unordered bulk write and adds its outcome to result.
+// In Skip mode duplicate key errors count as skipped documents.
+func writeBatch(ctx context.Context, coll *mongo.Collection, batch []mongo.WriteModel, mode Mode, result *Result) error {
+	res, err := coll.BulkWrite(ctx, batch, options.BulkWrite().SetOrdered(false))
+	if res != nil {
+		result.Inserted += res.InsertedCount + res.UpsertedCount
+		result.Replaced += res.ModifiedCount
+		// A replacement identical to the stored document matches without modifying.
+		result.Skipped += res.MatchedCount - res.ModifiedCount
+	}
+
+	var bulkErr mongo.BulkWriteException
+	if mode == Skip && errors.As(err, &bulkErr) && bulkErr.WriteConcernError == nil {
+		for _, we := range bulkErr.WriteErrors {
+			if !mongo.IsDuplicateKeyError(we) {
+				return err
+			}
+			result.Skipped++
+		}
+		return nil
+	}
+	return err
+}
+
+// readDocuments calls fn for each BSON document in r until r is exhausted.
+func readDocuments(r io.Reader, fn func(bson.Raw) error) error {
+	var size [4]byte
+	for {
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("truncated document: %w", err)
+		}
+
+		n := binary.LittleEndian.Uint32(size[:])
+		if n < 5 || n > 16*1024*1024 {
+			return fmt.Errorf("invalid document length %d", n)
+		}
+		doc := make(bson.Raw, n)
+		copy(doc, size[:])
+		if _, err := io.ReadFull(r, doc[4:]); err != nil {
+			return fmt.Errorf("truncated document: %w", err)
+		}
+		if err := doc.Validate(); err != nil {
+			return err
+		}
+		if err := fn(doc); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// Package blobstore keeps files attached to users, such as profile photos
+// and PDFs, which would not fit in the user documents themselves.
+//
+// A Store streams blobs in and out without holding them in memory. Every
+// blob records the ID of the user it belongs to, so the blobs of a user can
+// be listed, and deleted along with the user by Repository. GridFSStore keeps
+// them in a MongoDB GridFS bucket next to the users; DirStore keeps them in a
+// local directory, for tests and for the file store.
+package blobstore
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrNotFound is returned for a blob that does not exist.
+var ErrNotFound = errors.New("blobstore: blob not found")
+
+// sniffLen is the number of bytes looked at to detect a content type.
+const sniffLen = 512
+
+// Blob describes a stored file.
+type Blob struct {
+	ID          primitive.ObjectID `json:"id"`
+	UserID      primitive.ObjectID `json:"userId"`
+	Filename    string             `json:"filename"`
+	ContentType string             `json:"contentType"`
+	Size        int64              `json:"size"`
+	Uploaded    time.Time          `json:"uploaded"`
+}
+
+// Store keeps the blobs of users.
+type Store interface {
+	// Put streams r into a new blob of the user, named filename. An empty
+	// contentType is detected from the first bytes of r.
+	Put(ctx context.Context, userID primitive.ObjectID, filename, contentType string, r io.Reader) (*Blob, error)
+
+	// Open returns a blob and a reader of its content, which the caller must close.
+	Open(ctx context.Context, id primitive.ObjectID) (*Blob, io.ReadCloser, error)
+
+	// List returns the blobs of the user, oldest first.
+	List(ctx context.Context, userID primitive.ObjectID) ([]Blob, error)
+
+	// Delete removes a blob.
+	Delete(ctx context.Context, id primitive.ObjectID) error
+
+	// DeleteUser removes every blob of the user and returns how many there were.
+	DeleteUser(ctx context.Context, userID primitive.ObjectID) (int64, error)
+
+	// Owners returns the IDs of the users that have blobs, in no particular order.
+	Owners(ctx context.Context) ([]primitive.ObjectID, error)
+}
+
+var (
+	_ Store = (*GridFSStore)(nil)
+	_ Store = (*DirStore)(nil)
+)
+
+// sniff returns contentType, or the type detected from the first bytes of r
+// when it is empty, along with a reader that still yields all of r.
+func sniff(r io.Reader, contentType string) (string, io.Reader) {
+	if contentType != "" {
+		return contentType, r
+	}
+	br := bufio.NewReaderSize(r, sniffLen)
+	head, _ := br.Peek(sniffLen) // a short read is a small file; errors resurface on copy
+	return http.DetectContentType(head), br
+}
+
+// ctxReader stops reading once ctx is done, so that an upload or download
+// of a large blob can be cancelled halfway.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// readCloser pairs a reader with the Close of what it reads from.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
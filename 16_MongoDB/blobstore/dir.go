@@ -0,0 +1,195 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/saurabhkk55/Go/11_file_os/fileutil"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DirStore is a Store keeping each blob in a directory as two files:
+// <id>.blob with the content and <id>.json describing it. The description is
+// written last, so a blob whose upload was interrupted is never listed.
+type DirStore struct {
+	dir string
+}
+
+// NewDirStore returns a DirStore over dir, creating the directory when needed.
+func NewDirStore(dir string) (*DirStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("blobstore: %w", err)
+	}
+	return &DirStore{dir: dir}, nil
+}
+
+func (s *DirStore) path(id primitive.ObjectID, ext string) string {
+	return filepath.Join(s.dir, id.Hex()+ext)
+}
+
+// Put copies r into a new blob of the user, synced to disk.
+func (s *DirStore) Put(ctx context.Context, userID primitive.ObjectID, filename, contentType string, r io.Reader) (*Blob, error) {
+	contentType, r = sniff(r, contentType)
+	b := &Blob{
+		ID:          primitive.NewObjectID(),
+		UserID:      userID,
+		Filename:    filename,
+		ContentType: contentType,
+		Uploaded:    time.Now().UTC(),
+	}
+
+	n, err := s.writeFile(s.path(b.ID, ".blob"), ctxReader{ctx, r})
+	if err != nil {
+		return nil, fmt.Errorf("blobstore: upload: %w", err)
+	}
+	b.Size = n
+
+	data, err := json.Marshal(b)
+	if err != nil {
+		return nil, fmt.Errorf("blobstore: upload: %w", err)
+	}
+	if _, err := s.writeFile(s.path(b.ID, ".json"), bytes.NewReader(data)); err != nil {
+		os.Remove(s.path(b.ID, ".blob"))
+		return nil, fmt.Errorf("blobstore: upload: %w", err)
+	}
+	return b, nil
+}
+
+// writeFile copies r to path atomically and durably, so that path is either
+// absent or complete.
+func (s *DirStore) writeFile(path string, r io.Reader) (int64, error) {
+	var n int64
+	err := fileutil.Write(path, 0o600, func(w io.Writer) error {
+		var err error
+		n, err = io.Copy(w, r)
+		return err
+	})
+	return n, err
+}
+
+// read returns the description of a blob.
+func (s *DirStore) read(id primitive.ObjectID) (*Blob, error) {
+	data, err := os.ReadFile(s.path(id, ".json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("blobstore: %w", err)
+	}
+	var b Blob
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("blobstore: %s: %w", id.Hex(), err)
+	}
+	return &b, nil
+}
+
+// Open returns a blob and its file.
+func (s *DirStore) Open(ctx context.Context, id primitive.ObjectID) (*Blob, io.ReadCloser, error) {
+	b, err := s.read(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(s.path(id, ".blob"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("blobstore: open: %w", err)
+	}
+	return b, readCloser{ctxReader{ctx, f}, f}, nil
+}
+
+// List returns the blobs of the user, oldest first. It reads the description
+// of every blob in the directory.
+func (s *DirStore) List(ctx context.Context, userID primitive.ObjectID) ([]Blob, error) {
+	var blobs []Blob
+	err := s.each(ctx, func(b *Blob) {
+		if b.UserID == userID {
+			blobs = append(blobs, *b)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(blobs, func(i, j int) bool {
+		if c := blobs[i].Uploaded.Compare(blobs[j].Uploaded); c != 0 {
+			return c < 0
+		}
+		return blobs[i].ID.Hex() < blobs[j].ID.Hex()
+	})
+	return blobs, nil
+}
+
+// Owners returns the IDs of the users that have blobs. It reads the
+// description of every blob in the directory.
+func (s *DirStore) Owners(ctx context.Context) ([]primitive.ObjectID, error) {
+	seen := map[primitive.ObjectID]bool{}
+	var owners []primitive.ObjectID
+	err := s.each(ctx, func(b *Blob) {
+		if !seen[b.UserID] {
+			seen[b.UserID] = true
+			owners = append(owners, b.UserID)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return owners, nil
+}
+
+// each calls fn with the description of every blob in the directory.
+func (s *DirStore) each(ctx context.Context, fn func(*Blob)) error {
+	names, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return fmt.Errorf("blobstore: list: %w", err)
+	}
+
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		id, err := primitive.ObjectIDFromHex(strings.TrimSuffix(filepath.Base(name), ".json"))
+		if err != nil {
+			continue // not ours
+		}
+		b, err := s.read(id)
+		if errors.Is(err, ErrNotFound) {
+			continue // deleted since the glob
+		}
+		if err != nil {
+			return err
+		}
+		fn(b)
+	}
+	return nil
+}
+
+// Delete removes a blob, its description first.
+func (s *DirStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	err := os.Remove(s.path(id, ".json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("blobstore: delete: %w", err)
+	}
+	if err := os.Remove(s.path(id, ".blob")); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("blobstore: delete: %w", err)
+	}
+	return nil
+}
+
+// DeleteUser removes every blob of the user.
+func (s *DirStore) DeleteUser(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	return deleteAll(ctx, s, userID)
+}
@@ -0,0 +1,211 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultBucket is the name of the GridFS bucket holding the blobs, whose
+// documents live in the collections <bucket>.files and <bucket>.chunks.
+const DefaultBucket = "user_blobs"
+
+// metadata is what GridFSStore keeps in the metadata field of a file.
+type metadata struct {
+	UserID      primitive.ObjectID `bson:"userId"`
+	ContentType string             `bson:"contentType"`
+}
+
+// fileDoc is a document of the files collection of a bucket.
+type fileDoc struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	Length     int64              `bson:"length"`
+	UploadDate time.Time          `bson:"uploadDate"`
+	Filename   string             `bson:"filename"`
+	Metadata   metadata           `bson:"metadata"`
+}
+
+func (f *fileDoc) blob() *Blob {
+	return &Blob{
+		ID:          f.ID,
+		UserID:      f.Metadata.UserID,
+		Filename:    f.Filename,
+		ContentType: f.Metadata.ContentType,
+		Size:        f.Length,
+		Uploaded:    f.UploadDate.UTC(),
+	}
+}
+
+// GridFSStore is a Store keeping the blobs in a GridFS bucket, split into
+// chunks well under the BSON document limit.
+//
+// The driver's GridFS streams take deadlines rather than contexts, so the
+// deadline of ctx applies to the whole upload or download, and a cancelled
+// ctx stops it at the next chunk.
+type GridFSStore struct {
+	bucket *gridfs.Bucket
+	files  *mongo.Collection
+}
+
+// NewGridFSStore returns a GridFSStore over the bucket of db with the given name.
+func NewGridFSStore(db *mongo.Database, bucketName string) (*GridFSStore, error) {
+	bucket, err := gridfs.NewBucket(db, options.GridFSBucket().SetName(bucketName))
+	if err != nil {
+		return nil, fmt.Errorf("blobstore: %w", err)
+	}
+	return &GridFSStore{bucket: bucket, files: bucket.GetFilesCollection()}, nil
+}
+
+// EnsureIndexes creates the index List and DeleteUser rely on. It is safe to
+// call on every start. The driver creates the indexes of GridFS itself on the
+// first upload.
+func (s *GridFSStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.files.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "metadata.userId", Value: 1}, {Key: "uploadDate", Value: 1}},
+		Options: options.Index().SetName("metadata_userId_uploadDate"),
+	})
+	if err != nil {
+		return fmt.Errorf("blobstore: create index: %w", err)
+	}
+	return nil
+}
+
+// Put streams r into a new blob of the user.
+func (s *GridFSStore) Put(ctx context.Context, userID primitive.ObjectID, filename, contentType string, r io.Reader) (*Blob, error) {
+	contentType, r = sniff(r, contentType)
+	meta := metadata{UserID: userID, ContentType: contentType}
+	up, err := s.bucket.OpenUploadStream(filename, options.GridFSUpload().SetMetadata(meta))
+	if err != nil {
+		return nil, fmt.Errorf("blobstore: upload: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		up.SetWriteDeadline(deadline)
+	}
+
+	n, err := io.Copy(up, ctxReader{ctx, r})
+	if err != nil {
+		// Abort removes the chunks written so far.
+		up.Abort()
+		return nil, fmt.Errorf("blobstore: upload: %w", err)
+	}
+	if err := up.Close(); err != nil {
+		return nil, fmt.Errorf("blobstore: upload: %w", err)
+	}
+
+	return &Blob{
+		ID:          up.FileID.(primitive.ObjectID),
+		UserID:      userID,
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        n,
+		Uploaded:    time.Now().UTC(),
+	}, nil
+}
+
+// Open returns a blob and a reader streaming its chunks.
+func (s *GridFSStore) Open(ctx context.Context, id primitive.ObjectID) (*Blob, io.ReadCloser, error) {
+	var f fileDoc
+	err := s.files.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&f)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("blobstore: open: %w", err)
+	}
+
+	down, err := s.bucket.OpenDownloadStream(id)
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("blobstore: open: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		down.SetReadDeadline(deadline)
+	}
+	return f.blob(), readCloser{ctxReader{ctx, down}, down}, nil
+}
+
+// List returns the blobs of the user, oldest first.
+func (s *GridFSStore) List(ctx context.Context, userID primitive.ObjectID) ([]Blob, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "uploadDate", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := s.files.Find(ctx, bson.D{{Key: "metadata.userId", Value: userID}}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("blobstore: list: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var blobs []Blob
+	for cursor.Next(ctx) {
+		var f fileDoc
+		if err := cursor.Decode(&f); err != nil {
+			return nil, fmt.Errorf("blobstore: list: %w", err)
+		}
+		blobs = append(blobs, *f.blob())
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("blobstore: list: %w", err)
+	}
+	return blobs, nil
+}
+
+// Delete removes a blob and its chunks.
+func (s *GridFSStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	err := s.bucket.DeleteContext(ctx, id)
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("blobstore: delete: %w", err)
+	}
+	return nil
+}
+
+// DeleteUser removes every blob of the user.
+func (s *GridFSStore) DeleteUser(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	return deleteAll(ctx, s, userID)
+}
+
+// Owners returns the IDs of the users that have blobs.
+func (s *GridFSStore) Owners(ctx context.Context) ([]primitive.ObjectID, error) {
+	values, err := s.files.Distinct(ctx, "metadata.userId", bson.D{})
+	if err != nil {
+		return nil, fmt.Errorf("blobstore: owners: %w", err)
+	}
+	owners := make([]primitive.ObjectID, 0, len(values))
+	for _, v := range values {
+		if id, ok := v.(primitive.ObjectID); ok {
+			owners = append(owners, id)
+		}
+	}
+	return owners, nil
+}
+
+// deleteAll removes the blobs of the user one at a time. A blob deleted by
+// someone else in the meantime is not counted.
+func deleteAll(ctx context.Context, s Store, userID primitive.ObjectID) (int64, error) {
+	blobs, err := s.List(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	for _, b := range blobs {
+		err := s.Delete(ctx, b.ID)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
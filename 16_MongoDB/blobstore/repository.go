@@ -0,0 +1,197 @@
+package blobstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/userstore"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Repository is a userstore.Repository that deletes the blobs of the users
+// it removes for good, by HardDelete, Purge or Expire. A user in the trash
+// keeps its blobs, in case it is restored.
+//
+// The user is removed first, so a failure leaves blobs without a user rather
+// than a user without its blobs. Such failures are passed to logf instead of
+// failing the removal, and the blobs are left to SweepOrphans, which also
+// deletes those of the users that MongoDB removed with the TTL index.
+type Repository struct {
+	userstore.Repository
+	blobs Store
+	logf  func(format string, args ...any)
+}
+
+var _ userstore.Repository = (*Repository)(nil)
+
+// NewRepository returns a Repository that deletes from blobs the blobs of
+// the users removed from repo, passing the failures to logf.
+func NewRepository(repo userstore.Repository, blobs Store, logf func(format string, args ...any)) *Repository {
+	return &Repository{Repository: repo, blobs: blobs, logf: logf}
+}
+
+// HardDelete permanently removes the user and its blobs.
+func (r *Repository) HardDelete(ctx context.Context, id primitive.ObjectID, expect userstore.Expect) (*userstore.User, error) {
+	u, err := r.Repository.HardDelete(ctx, id, expect)
+	if err != nil {
+		return nil, err
+	}
+	r.deleteBlobs(ctx, []primitive.ObjectID{id})
+	return u, nil
+}
+
+// Purge permanently removes the users deleted before deletedBefore and their blobs.
+func (r *Repository) Purge(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	var purged []primitive.ObjectID
+	opts := userstore.ListOptions{Filter: userstore.Filter{Deleted: userstore.OnlyDeleted}}
+	err := r.Repository.Stream(ctx, opts, func(u *userstore.User) error {
+		if u.DeletedAt.Before(deletedBefore) {
+			purged = append(purged, u.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := r.Repository.Purge(ctx, deletedBefore)
+	if err != nil {
+		return 0, err
+	}
+	r.deleteGone(ctx, purged)
+	return n, nil
+}
+
+// Expire permanently removes the users that expired by now and their blobs.
+func (r *Repository) Expire(ctx context.Context, now time.Time) (int64, error) {
+	var expired []primitive.ObjectID
+	opts := userstore.ListOptions{Filter: userstore.Filter{Deleted: userstore.IncludeDeleted, Where: userstore.ExpiredBy(now)}}
+	err := r.Repository.Stream(ctx, opts, func(u *userstore.User) error {
+		expired = append(expired, u.ID)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := r.Repository.Expire(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+	r.deleteGone(ctx, expired)
+	return n, nil
+}
+
+// SweepOrphans deletes the blobs of the users that no longer exist, in the
+// trash or out of it, and returns how many blobs it deleted.
+func (r *Repository) SweepOrphans(ctx context.Context) (int64, error) {
+	owners, err := r.blobs.Owners(ctx)
+	if err != nil {
+		return 0, err
+	}
+	gone, err := r.gone(ctx, owners)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, id := range gone {
+		n, err := r.blobs.DeleteUser(ctx, id)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// RunOrphanSweeper calls SweepOrphans once straight away and then every
+// interval until ctx is done. Failures are passed to logf and retried on
+// the next run.
+func (r *Repository) RunOrphanSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := r.SweepOrphans(ctx)
+		switch {
+		case err != nil && ctx.Err() == nil:
+			r.logf("blobstore: sweep orphans: %v", err)
+		case n > 0:
+			r.logf("blobstore: deleted %d blob(s) of removed users", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// deleteGone deletes the blobs of the users among ids that no longer exist,
+// leaving those of the users restored in the meantime.
+func (r *Repository) deleteGone(ctx context.Context, ids []primitive.ObjectID) {
+	if len(ids) == 0 {
+		return
+	}
+	gone, err := r.gone(ctx, ids)
+	if err != nil {
+		r.logf("blobstore: blobs of %d removed user(s) not deleted: %v", len(ids), err)
+		return
+	}
+	r.deleteBlobs(ctx, gone)
+}
+
+// deleteBlobs deletes the blobs of the users with the given IDs.
+func (r *Repository) deleteBlobs(ctx context.Context, ids []primitive.ObjectID) {
+	for _, id := range ids {
+		if _, err := r.blobs.DeleteUser(ctx, id); err != nil {
+			r.logf("blobstore: blobs of removed user %s not deleted: %v", id.Hex(), err)
+		}
+	}
+}
+
+// gone returns the IDs among ids of the users that do not exist.
+func (r *Repository) gone(ctx context.Context, ids []primitive.ObjectID) ([]primitive.ObjectID, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	exists := map[primitive.ObjectID]bool{}
+	opts := userstore.ListOptions{Filter: userstore.Filter{Deleted: userstore.IncludeDeleted, Where: idIn(ids)}}
+	err := r.Repository.Stream(ctx, opts, func(u *userstore.User) error {
+		exists[u.ID] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var gone []primitive.ObjectID
+	for _, id := range ids {
+		if !exists[id] {
+			gone = append(gone, id)
+		}
+	}
+	return gone, nil
+}
+
+// idIn is the condition selecting the users with one of the given IDs.
+type idIn []primitive.ObjectID
+
+func (c idIn) MongoFilter() bson.D {
+	return bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: []primitive.ObjectID(c)}}}}
+}
+
+func (c idIn) Match(u *userstore.User) bool {
+	for _, id := range c {
+		if u.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (c idIn) Compared() map[string]bool {
+	return map[string]bool{"id": false}
+}
@@ -0,0 +1,127 @@
+package blobstore
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/userstore"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestRepositoryDeletesBlobs(t *testing.T) {
+	later := time.Now().Add(time.Hour)
+
+	// Each op returns the count the method it calls returns.
+	tests := []struct {
+		name string
+		op   func(ctx context.Context, r *Repository, alice, bob *userstore.User) (int64, error)
+
+		wantN     int64
+		wantBlobs map[string]int // by owner: alice, bob or orphan
+	}{
+		{
+			name: "hard delete",
+			op: func(ctx context.Context, r *Repository, alice, _ *userstore.User) (int64, error) {
+				_, err := r.HardDelete(ctx, alice.ID, userstore.AnyVersion)
+				return 0, err
+			},
+			wantBlobs: map[string]int{"alice": 0, "bob": 1, "orphan": 1},
+		},
+		{
+			name: "delete keeps the blobs for a restore",
+			op: func(ctx context.Context, r *Repository, alice, _ *userstore.User) (int64, error) {
+				return 0, r.Delete(ctx, alice.ID, userstore.AnyVersion)
+			},
+			wantBlobs: map[string]int{"alice": 2, "bob": 1, "orphan": 1},
+		},
+		{
+			name: "purge",
+			op: func(ctx context.Context, r *Repository, alice, bob *userstore.User) (int64, error) {
+				if err := r.Delete(ctx, alice.ID, userstore.AnyVersion); err != nil {
+					return 0, err
+				}
+				return r.Purge(ctx, later)
+			},
+			wantN:     1,
+			wantBlobs: map[string]int{"alice": 0, "bob": 1, "orphan": 1},
+		},
+		{
+			name: "purge of nothing",
+			op: func(ctx context.Context, r *Repository, _, _ *userstore.User) (int64, error) {
+				return r.Purge(ctx, later)
+			},
+			wantBlobs: map[string]int{"alice": 2, "bob": 1, "orphan": 1},
+		},
+		{
+			name: "expire",
+			op: func(ctx context.Context, r *Repository, _, _ *userstore.User) (int64, error) {
+				return r.Expire(ctx, later.Add(time.Hour))
+			},
+			wantN:     1,
+			wantBlobs: map[string]int{"alice": 2, "bob": 0, "orphan": 1},
+		},
+		{
+			name: "sweep orphans",
+			op: func(ctx context.Context, r *Repository, alice, _ *userstore.User) (int64, error) {
+				// A user in the trash still owns its blobs.
+				if err := r.Delete(ctx, alice.ID, userstore.AnyVersion); err != nil {
+					return 0, err
+				}
+				return r.SweepOrphans(ctx)
+			},
+			wantN:     1,
+			wantBlobs: map[string]int{"alice": 2, "bob": 1, "orphan": 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mem := userstore.NewMemoryRepository()
+			alice := &userstore.User{Name: "alice", Age: 30, Gender: "F"}
+			bob := &userstore.User{Name: "bob", Age: 40, Gender: "M", ExpiresAt: &later}
+			for _, u := range []*userstore.User{alice, bob} {
+				if err := mem.Create(ctx, u); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			blobs, err := NewDirStore(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			owners := map[string]primitive.ObjectID{"alice": alice.ID, "bob": bob.ID, "orphan": primitive.NewObjectID()}
+			for owner, n := range map[string]int{"alice": 2, "bob": 1, "orphan": 1} {
+				for i := 0; i < n; i++ {
+					if _, err := blobs.Put(ctx, owners[owner], "a.txt", "", strings.NewReader("hello")); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			var logged []string
+			logf := func(format string, args ...any) { logged = append(logged, format) }
+			n, err := tt.op(ctx, NewRepository(mem, blobs, logf), alice, bob)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != tt.wantN {
+				t.Errorf("n = %d, want %d", n, tt.wantN)
+			}
+			if len(logged) > 0 {
+				t.Errorf("logged %q", logged)
+			}
+			for owner, want := range tt.wantBlobs {
+				list, err := blobs.List(ctx, owners[owner])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(list) != want {
+					t.Errorf("%s has %d blobs, want %d", owner, len(list), want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package bulk
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/userstore"
+)
+
+// defaultColumns are the CSV columns written when ExportOptions.Columns is empty.
+var defaultColumns = []string{"id", "name", "age", "gender", "createdAt", "updatedAt"}
+
+// ExportOptions controls Export.
+type ExportOptions struct {
+	Format Format
+
+	// Query selects and orders the exported users. Pagination is ignored.
+	Query userstore.ListOptions
+
+	// Columns lists the CSV columns, which may include "attributes.<key>".
+	// JSON Lines output always holds whole users, restricted by Query.Fields.
+	Columns []string
+}
+
+// Export streams the users selected by opts.Query to w and returns how many it wrote.
+func Export(ctx context.Context, repo userstore.Repository, w io.Writer, opts ExportOptions) (int, error) {
+	bw := bufio.NewWriter(w)
+	n := 0
+
+	var write func(u *userstore.User) error
+	var finish func() error
+
+	switch opts.Format {
+	case CSV:
+		columns := opts.Columns
+		if len(columns) == 0 {
+			columns = defaultColumns
+		}
+		for _, col := range columns {
+			if col != "id" && col != "createdAt" && col != "updatedAt" && !isTargetField(col) {
+				return 0, fmt.Errorf("bulk: unknown column %q", col)
+			}
+		}
+
+		cw := csv.NewWriter(bw)
+		if err := cw.Write(columns); err != nil {
+			return 0, err
+		}
+		write = func(u *userstore.User) error {
+			row := make([]string, len(columns))
+			for i, col := range columns {
+				row[i] = columnValue(u, col)
+			}
+			return cw.Write(row)
+		}
+		finish = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	case JSONLines:
+		enc := json.NewEncoder(bw)
+		write = func(u *userstore.User) error {
+			return enc.Encode(userstore.Project(u, opts.Query.Fields))
+		}
+		finish = func() error { return nil }
+	default:
+		return 0, fmt.Errorf("bulk: unknown format %q", opts.Format)
+	}
+
+	err := repo.Stream(ctx, opts.Query, func(u *userstore.User) error {
+		n++
+		return write(u)
+	})
+	if err != nil {
+		return n, err
+	}
+	if err := finish(); err != nil {
+		return n, err
+	}
+	return n, bw.Flush()
+}
+
+// columnValue renders one CSV cell.
+func columnValue(u *userstore.User, col string) string {
+	switch col {
+	case "id":
+		return u.ID.Hex()
+	case "name":
+		return u.Name
+	case "age":
+		return strconv.Itoa(int(u.Age))
+	case "gender":
+		return string(u.Gender)
+	case "createdAt":
+		return u.CreatedAt.Format(time.RFC3339Nano)
+	case "updatedAt":
+		return u.UpdatedAt.Format(time.RFC3339Nano)
+	default:
+		return u.Attributes[strings.TrimPrefix(col, "attributes.")]
+	}
+}
@@ -0,0 +1,416 @@
+// Package bulk imports users from CSV and JSON Lines files and exports them back.
+//
+// Imports validate every row, write the valid ones in batches through
+// Repository.CreateMany and report the rows that were rejected. Exports
+// stream a filtered query without loading the whole result into memory.
+package bulk
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/userstore"
+)
+
+// Format is a bulk file format.
+type Format string
+
+// The supported formats.
+const (
+	CSV       Format = "csv"
+	JSONLines Format = "jsonl"
+)
+
+// DefaultBatchSize is the number of users written per InsertMany when none is configured.
+const DefaultBatchSize = 500
+
+// defaultPreviewLimit is the number of users a dry run keeps in Report.Preview.
+const defaultPreviewLimit = 10
+
+// ParseFormat parses "csv" or "jsonl".
+func ParseFormat(s string) (Format, error) {
+	switch Format(strings.ToLower(s)) {
+	case CSV:
+		return CSV, nil
+	case JSONLines, "jsonlines", "ndjson":
+		return JSONLines, nil
+	}
+	return "", fmt.Errorf("bulk: unknown format %q, want csv or jsonl", s)
+}
+
+// ImportOptions controls Import.
+type ImportOptions struct {
+	Format Format
+
+	// Mapping maps source columns (CSV) or keys (JSON Lines) onto user fields:
+	// "name", "age", "gender" or "attributes.<key>". Columns that are not mapped
+	// are used as-is when they already name a field and ignored otherwise.
+	Mapping map[string]string
+
+	// BatchSize is the number of users written per batch. Zero means DefaultBatchSize.
+	BatchSize int
+
+	// DryRun validates everything and predicts conflicts without writing.
+	DryRun bool
+
+	// PreviewLimit is the number of users a dry run returns in Report.Preview.
+	PreviewLimit int
+}
+
+// Rejection is a row that was not imported.
+type Rejection struct {
+	Line   int    `json:"line"`
+	Reason string `json:"reason"`
+	Record string `json:"record"`
+}
+
+// Report summarizes an import.
+type Report struct {
+	DryRun   bool        `json:"dryRun"`
+	Read     int         `json:"read"`
+	Valid    int         `json:"valid"`
+	Inserted int         `json:"inserted"`
+	Rejected []Rejection `json:"rejected"`
+
+	// Preview holds the first users a dry run would insert.
+	Preview []userstore.User `json:"preview,omitempty"`
+}
+
+// ParseMapping parses a column mapping such as "Full Name=name,Years=age,Town=attributes.city".
+func ParseMapping(s string) (map[string]string, error) {
+	mapping := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		source, target, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("bulk: mapping %q is not source=field", pair)
+		}
+		source, target = strings.TrimSpace(source), strings.TrimSpace(target)
+		if !isTargetField(target) {
+			return nil, fmt.Errorf("bulk: mapping %q targets unknown field %q", pair, target)
+		}
+		mapping[source] = target
+	}
+	return mapping, nil
+}
+
+// isTargetField reports whether an imported value can be written to field.
+func isTargetField(field string) bool {
+	switch field {
+	case "name", "age", "gender":
+		return true
+	}
+	key, ok := strings.CutPrefix(field, "attributes.")
+	return ok && key != ""
+}
+
+// record is one row of input, keyed by its source column names.
+// err is set instead of values when the row could not be parsed.
+type record struct {
+	line   int
+	raw    string
+	values map[string]string
+	err    error
+}
+
+// importer holds the state of a single Import call.
+type importer struct {
+	repo   userstore.Repository
+	opts   ImportOptions
+	report *Report
+
+	// names holds the names seen so far, to reject duplicates inside the file.
+	names map[string]int
+
+	batch      []*userstore.User
+	batchLines []record
+}
+
+// Import reads users from r and stores them in repo.
+// Rows that cannot be parsed or validated are listed in the report instead of
+// stopping the import; only I/O and database failures return an error.
+func Import(ctx context.Context, repo userstore.Repository, r io.Reader, opts ImportOptions) (*Report, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	if opts.PreviewLimit <= 0 {
+		opts.PreviewLimit = defaultPreviewLimit
+	}
+	for _, target := range opts.Mapping {
+		if !isTargetField(target) {
+			return nil, fmt.Errorf("bulk: mapping targets unknown field %q", target)
+		}
+	}
+
+	imp := &importer{
+		repo:   repo,
+		opts:   opts,
+		report: &Report{DryRun: opts.DryRun, Rejected: []Rejection{}},
+		names:  map[string]int{},
+	}
+
+	var err error
+	switch opts.Format {
+	case CSV:
+		err = readCSV(r, func(rec record) error { return imp.add(ctx, rec) })
+	case JSONLines:
+		err = readJSONLines(r, func(rec record) error { return imp.add(ctx, rec) })
+	default:
+		err = fmt.Errorf("bulk: unknown format %q", opts.Format)
+	}
+	if err == nil {
+		err = imp.flush(ctx)
+	}
+
+	// Batches report their failures out of order, so list rejections by line.
+	sort.SliceStable(imp.report.Rejected, func(i, j int) bool {
+		return imp.report.Rejected[i].Line < imp.report.Rejected[j].Line
+	})
+	return imp.report, err
+}
+
+// add validates one record and queues it for the next batch.
+func (imp *importer) add(ctx context.Context, rec record) error {
+	imp.report.Read++
+	if rec.err != nil {
+		imp.reject(rec, rec.err)
+		return nil
+	}
+
+	u, err := imp.toUser(rec)
+	if err == nil {
+		err = u.Validate()
+	}
+	if err == nil {
+		if line, ok := imp.names[u.Name]; ok {
+			err = fmt.Errorf("name %q already used on line %d", u.Name, line)
+		}
+	}
+	if err != nil {
+		imp.reject(rec, err)
+		return nil
+	}
+	imp.names[u.Name] = rec.line
+
+	if imp.opts.DryRun {
+		return imp.preview(ctx, rec, u)
+	}
+
+	imp.batch = append(imp.batch, u)
+	imp.batchLines = append(imp.batchLines, rec)
+	if len(imp.batch) >= imp.opts.BatchSize {
+		return imp.flush(ctx)
+	}
+	return nil
+}
+
+// preview records what a dry run would insert, predicting name conflicts with stored users.
+func (imp *importer) preview(ctx context.Context, rec record, u *userstore.User) error {
+	_, err := imp.repo.FindByName(ctx, u.Name)
+	switch {
+	case err == nil:
+		imp.reject(rec, fmt.Errorf("%w: name %q exists", userstore.ErrDuplicate, u.Name))
+		return nil
+	case !errors.Is(err, userstore.ErrNotFound):
+		return err
+	}
+
+	imp.report.Valid++
+	if len(imp.report.Preview) < imp.opts.PreviewLimit {
+		imp.report.Preview = append(imp.report.Preview, *u)
+	}
+	return nil
+}
+
+// flush writes the queued batch and records the users the repository refused.
+func (imp *importer) flush(ctx context.Context) error {
+	if len(imp.batch) == 0 {
+		return nil
+	}
+
+	failed, err := imp.repo.CreateMany(ctx, imp.batch)
+	if err != nil {
+		return err
+	}
+
+	imp.report.Valid += len(imp.batch)
+	imp.report.Inserted += len(imp.batch) - len(failed)
+	for i, err := range failed {
+		imp.report.Valid--
+		imp.reject(imp.batchLines[i], err)
+	}
+
+	imp.batch, imp.batchLines = imp.batch[:0], imp.batchLines[:0]
+	return nil
+}
+
+// reject adds a record to the rejected rows.
+func (imp *importer) reject(rec record, err error) {
+	imp.report.Rejected = append(imp.report.Rejected, Rejection{
+		Line:   rec.line,
+		Reason: err.Error(),
+		Record: rec.raw,
+	})
+}
+
+// toUser maps a record onto a user using the configured column mapping.
+func (imp *importer) toUser(rec record) (*userstore.User, error) {
+	u := &userstore.User{}
+
+	for source, value := range rec.values {
+		target, ok := imp.opts.Mapping[source]
+		if !ok {
+			target = source
+			if !isTargetField(target) {
+				continue
+			}
+		}
+
+		var err error
+		switch target {
+		case "name":
+			u.Name = strings.TrimSpace(value)
+		case "age":
+			u.Age, err = userstore.ParseAge(value)
+		case "gender":
+			u.Gender, err = userstore.ParseGender(value)
+		default:
+			// An empty cell means the attribute is absent.
+			if value == "" {
+				continue
+			}
+			if u.Attributes == nil {
+				u.Attributes = map[string]string{}
+			}
+			u.Attributes[strings.TrimPrefix(target, "attributes.")] = value
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+// readCSV calls fn for every row of a CSV file whose first row is the header.
+func readCSV(r io.Reader, fn func(record) error) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("bulk: read CSV header: %w", err)
+	}
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+	}
+
+	for {
+		row, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		// A malformed row is rejected on its own; the reader carries on after it.
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			if err := fn(record{line: parseErr.StartLine, err: parseErr.Err}); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("bulk: read CSV: %w", err)
+		}
+
+		line, _ := cr.FieldPos(0)
+		rec := record{line: line, raw: csvLine(row)}
+		if len(row) != len(header) {
+			rec.err = fmt.Errorf("has %d columns, header has %d", len(row), len(header))
+		} else {
+			rec.values = make(map[string]string, len(row))
+			for i, col := range header {
+				rec.values[col] = row[i]
+			}
+		}
+
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+}
+
+// readJSONLines calls fn for every non-blank line of a JSON Lines file.
+// A nested "attributes" object is flattened into "attributes.<key>" values.
+func readJSONLines(r io.Reader, fn func(record) error) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" {
+			continue
+		}
+		rec := record{line: line, raw: text, values: map[string]string{}}
+
+		dec := json.NewDecoder(strings.NewReader(text))
+		dec.UseNumber()
+
+		var obj map[string]any
+		if err := dec.Decode(&obj); err != nil {
+			rec.err = err
+			if err := fn(rec); err != nil {
+				return err
+			}
+			continue
+		}
+
+		for k, v := range obj {
+			if attrs, ok := v.(map[string]any); ok && k == "attributes" {
+				for ak, av := range attrs {
+					rec.values["attributes."+ak] = fmt.Sprint(av)
+				}
+				continue
+			}
+			if v != nil {
+				rec.values[k] = fmt.Sprint(v)
+			}
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// csvLine renders a parsed row back into a CSV line for the rejection report.
+func csvLine(row []string) string {
+	var sb strings.Builder
+	w := csv.NewWriter(&sb)
+	w.Write(row)
+	w.Flush()
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+// WriteRejections writes rejected rows as CSV with line, reason and record columns.
+func WriteRejections(w io.Writer, rejected []Rejection) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"line", "reason", "record"})
+	for _, r := range rejected {
+		cw.Write([]string{fmt.Sprint(r.Line), r.Reason, r.Record})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,176 @@
+package bulk
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/userstore"
+)
+
+func TestImport(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		opts  ImportOptions
+
+		wantRead, wantValid, wantInserted int
+		wantRejected                      []int // lines
+		wantStored                        int   // users in the repository afterwards, with the one stored up front
+	}{
+		{
+			name:     "csv",
+			input:    "name,age,gender\nann,30,F\nben,40,male\n",
+			opts:     ImportOptions{Format: CSV},
+			wantRead: 2, wantValid: 2, wantInserted: 2, wantRejected: []int{}, wantStored: 3,
+		},
+		{
+			name: "csv rejections",
+			input: "name,age,gender\n" +
+				"ann,30,F\n" + // 2
+				"ben,abc,M\n" + // 3: age is not a number
+				"cat,20,X\n" + // 4: unknown gender
+				"ann,31,F\n" + // 5: name already used on line 2
+				"existing,20,F\n" + // 6: name already stored, refused by CreateMany
+				"dan,20\n" + // 7: too few columns
+				"e\"ve,20,F\n" + // 8: bare quote
+				"fay,151,F\n" + // 9: age out of range
+				",20,F\n", // 10: no name
+			opts:     ImportOptions{Format: CSV, BatchSize: 2},
+			wantRead: 9, wantValid: 1, wantInserted: 1, wantRejected: []int{3, 4, 5, 6, 7, 8, 9, 10}, wantStored: 2,
+		},
+		{
+			name:     "csv mapping",
+			input:    "Full Name,Years,Sex,Town,Notes\nann,30,F,Pune,ignored\nben,40,M,,\n",
+			opts:     ImportOptions{Format: CSV, Mapping: map[string]string{"Full Name": "name", "Years": "age", "Sex": "gender", "Town": "attributes.city"}},
+			wantRead: 2, wantValid: 2, wantInserted: 2, wantRejected: []int{}, wantStored: 3,
+		},
+		{
+			name:         "csv without rows",
+			input:        "",
+			opts:         ImportOptions{Format: CSV},
+			wantRejected: []int{}, wantStored: 1,
+		},
+		{
+			name: "json lines",
+			input: `{"name":"ann","age":30,"gender":"F","attributes":{"city":"Pune"}}` + "\n" +
+				"\n" +
+				`{"name":"ben","age":-1,"gender":"M"}` + "\n" +
+				`{"name":"cat",` + "\n" +
+				`{"name":"dan","age":2.5,"gender":"M"}` + "\n",
+			opts:     ImportOptions{Format: JSONLines},
+			wantRead: 4, wantValid: 1, wantInserted: 1, wantRejected: []int{3, 4, 5}, wantStored: 2,
+		},
+		{
+			name:     "dry run",
+			input:    "name,age,gender\nann,30,F\nexisting,20,F\nben,x,M\n",
+			opts:     ImportOptions{Format: CSV, DryRun: true},
+			wantRead: 3, wantValid: 1, wantInserted: 0, wantRejected: []int{3, 4}, wantStored: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			repo := userstore.NewMemoryRepository()
+			if err := repo.Create(ctx, &userstore.User{Name: "existing", Age: 50, Gender: "O"}); err != nil {
+				t.Fatal(err)
+			}
+
+			report, err := Import(ctx, repo, strings.NewReader(tt.input), tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Read != tt.wantRead || report.Valid != tt.wantValid || report.Inserted != tt.wantInserted {
+				t.Errorf("read %d, valid %d, inserted %d; want %d, %d, %d",
+					report.Read, report.Valid, report.Inserted, tt.wantRead, tt.wantValid, tt.wantInserted)
+			}
+			lines := []int{}
+			for _, r := range report.Rejected {
+				lines = append(lines, r.Line)
+			}
+			if !reflect.DeepEqual(lines, tt.wantRejected) {
+				t.Errorf("rejected lines %v, want %v; %+v", lines, tt.wantRejected, report.Rejected)
+			}
+			if tt.opts.DryRun && len(report.Preview) != tt.wantValid {
+				t.Errorf("preview holds %d users, want %d", len(report.Preview), tt.wantValid)
+			}
+
+			page, err := repo.List(ctx, userstore.ListOptions{Limit: 100})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if page.Total != int64(tt.wantStored) {
+				t.Errorf("%d users stored, want %d", page.Total, tt.wantStored)
+			}
+		})
+	}
+}
+
+func TestImportMapsAttributes(t *testing.T) {
+	ctx := context.Background()
+	repo := userstore.NewMemoryRepository()
+	input := "Full Name,Years,Sex,Town\nann,30,female,Pune\nben,40,M,\n"
+	opts := ImportOptions{Format: CSV, Mapping: map[string]string{"Full Name": "name", "Years": "age", "Sex": "gender", "Town": "attributes.city"}}
+	if _, err := Import(ctx, repo, strings.NewReader(input), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		want  userstore.User
+		attrs map[string]string
+	}{
+		{"ann", userstore.User{Name: "ann", Age: 30, Gender: "F"}, map[string]string{"city": "Pune"}},
+		// An empty cell leaves the attribute out.
+		{"ben", userstore.User{Name: "ben", Age: 40, Gender: "M"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := repo.FindByName(ctx, tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.Age != tt.want.Age || u.Gender != tt.want.Gender {
+				t.Errorf("age %d, gender %s; want %d, %s", u.Age, u.Gender, tt.want.Age, tt.want.Gender)
+			}
+			if len(u.Attributes) != len(tt.attrs) || (tt.attrs != nil && !reflect.DeepEqual(u.Attributes, tt.attrs)) {
+				t.Errorf("attributes %v, want %v", u.Attributes, tt.attrs)
+			}
+		})
+	}
+}
+
+func TestParseMapping(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    map[string]string
+		wantErr bool
+	}{
+		{in: "Full Name=name, Years=age,Town=attributes.city", want: map[string]string{"Full Name": "name", "Years": "age", "Town": "attributes.city"}},
+		{in: "", want: map[string]string{}},
+		{in: "a=name,,", want: map[string]string{"a": "name"}},
+		{in: "Years", wantErr: true},
+		{in: "Years=birthday", wantErr: true},
+		{in: "Town=attributes.", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseMapping(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
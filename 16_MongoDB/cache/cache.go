@@ -0,0 +1,213 @@
+// Package cache keeps recently read users in memory in front of a
+// userstore.Repository.
+//
+// Wrap a repository with NewRepository and Get and FindByName are answered
+// from a bounded cache while their entries are fresh. Lookups that find no user
+// are cached too, for a shorter time, so repeated lookups of a missing name do
+// not reach the database either. When the cache is full the least recently
+// used entry is evicted.
+//
+// Every change made through the Repository invalidates the entries of the
+// users it touches. Changes made by other processes are only seen once the
+// entries expire, so the TTL bounds how stale a read can be.
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/userstore"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Defaults used for the zero values of Options.
+const (
+	DefaultSize        = 1000
+	DefaultTTL         = time.Minute
+	DefaultNegativeTTL = 10 * time.Second
+)
+
+// Options configures a cache.
+type Options struct {
+	// Size is the maximum number of entries. Users looked up by ID and by
+	// name take one entry each.
+	Size int
+
+	// TTL is how long a found user is served from the cache.
+	TTL time.Duration
+
+	// NegativeTTL is how long a lookup that found no user is remembered.
+	NegativeTTL time.Duration
+}
+
+// withDefaults fills in the zero fields of o.
+func (o Options) withDefaults() Options {
+	if o.Size <= 0 {
+		o.Size = DefaultSize
+	}
+	if o.TTL <= 0 {
+		o.TTL = DefaultTTL
+	}
+	if o.NegativeTTL <= 0 {
+		o.NegativeTTL = DefaultNegativeTTL
+	}
+	return o
+}
+
+// Stats counts what a cache has done since it was created.
+type Stats struct {
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Evictions int64 `json:"evictions"`
+
+	// Expired counts the misses due to an entry that had outlived its TTL.
+	Expired int64 `json:"expired"`
+
+	// Invalidations counts the entries dropped because of a change.
+	Invalidations int64 `json:"invalidations"`
+
+	// Entries is the number of entries currently held.
+	Entries int `json:"entries"`
+}
+
+// HitRatio returns the fraction of lookups answered from the cache.
+func (s Stats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// key identifies a lookup: by ID when name is empty, otherwise by name.
+// Names are never empty, as users with an empty name do not validate.
+type key struct {
+	id   primitive.ObjectID
+	name string
+}
+
+// entry is a cached lookup. A nil user records that there was none.
+type entry struct {
+	key     key
+	user    *userstore.User
+	expires time.Time
+}
+
+// lru is a map of entries in least recently used order, safe for concurrent use.
+type lru struct {
+	opts Options
+	now  func() time.Time
+
+	mu      sync.Mutex
+	order   *list.List // front is the most recently used
+	entries map[key]*list.Element
+
+	// names maps the ID of each user cached by name to that name, so that a
+	// change made by ID also drops the lookup by the user's old name.
+	names map[primitive.ObjectID]string
+
+	// gen changes on every invalidation. A lookup only stores its result when
+	// gen has not changed since it started, so a read that raced with a change
+	// cannot put the old user back.
+	gen   uint64
+	stats Stats
+}
+
+func newLRU(opts Options) *lru {
+	return &lru{
+		opts:    opts.withDefaults(),
+		now:     time.Now,
+		order:   list.New(),
+		entries: map[key]*list.Element{},
+		names:   map[primitive.ObjectID]string{},
+	}
+}
+
+// get returns the fresh entry for k. ok is false on a miss; gen is then the
+// generation to pass to put along with the loaded result.
+func (c *lru) get(k key) (u *userstore.User, ok bool, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, found := c.entries[k]; found {
+		e := el.Value.(*entry)
+		if c.now().Before(e.expires) {
+			c.order.MoveToFront(el)
+			c.stats.Hits++
+			return e.user, true, c.gen
+		}
+		c.remove(el)
+		c.stats.Expired++
+	}
+	c.stats.Misses++
+	return nil, false, c.gen
+}
+
+// put stores the result of a lookup that started at generation gen. A nil u
+// records a lookup that found nothing.
+func (c *lru) put(k key, u *userstore.User, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen {
+		return
+	}
+	if el, found := c.entries[k]; found {
+		c.remove(el)
+	}
+
+	ttl := c.opts.TTL
+	if u == nil {
+		ttl = c.opts.NegativeTTL
+	}
+	c.entries[k] = c.order.PushFront(&entry{key: k, user: u, expires: c.now().Add(ttl)})
+	if k.name != "" && u != nil {
+		c.names[u.ID] = k.name
+	}
+	for c.order.Len() > c.opts.Size {
+		c.remove(c.order.Back())
+		c.stats.Evictions++
+	}
+}
+
+// invalidate drops the lookups of the user with the given ID, by ID and by the
+// name it was cached under, and the lookups by the given names.
+func (c *lru) invalidate(id primitive.ObjectID, names ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	keys := []key{{id: id}}
+	if name, ok := c.names[id]; ok {
+		keys = append(keys, key{name: name})
+	}
+	for _, name := range names {
+		keys = append(keys, key{name: name})
+	}
+	for _, k := range keys {
+		if el, found := c.entries[k]; found {
+			c.remove(el)
+			c.stats.Invalidations++
+		}
+	}
+}
+
+// snapshot returns the current statistics.
+func (c *lru) snapshot() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := c.stats
+	s.Entries = c.order.Len()
+	return s
+}
+
+// remove drops el. The caller must hold c.mu.
+func (c *lru) remove(el *list.Element) {
+	e := el.Value.(*entry)
+	c.order.Remove(el)
+	delete(c.entries, e.key)
+	if e.key.name != "" && e.user != nil && c.names[e.user.ID] == e.key.name {
+		delete(c.names, e.user.ID)
+	}
+}
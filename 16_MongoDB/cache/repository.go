@@ -0,0 +1,161 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/userstore"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Repository is a userstore.Repository that caches the users returned by Get
+// and FindByName. List, Stream and Count go straight to the wrapped repository.
+//
+// Callers get their own copy of a cached user, so changing it does not change
+// the cache.
+type Repository struct {
+	userstore.Repository
+	c *lru
+}
+
+var _ userstore.Repository = (*Repository)(nil)
+
+// NewRepository returns a Repository that caches the lookups made in repo.
+func NewRepository(repo userstore.Repository, opts Options) *Repository {
+	return &Repository{Repository: repo, c: newLRU(opts)}
+}
+
+// Stats returns the statistics of the cache.
+func (r *Repository) Stats() Stats {
+	return r.c.snapshot()
+}
+
+// Get returns the user with the given ID.
+func (r *Repository) Get(ctx context.Context, id primitive.ObjectID) (*userstore.User, error) {
+	return r.lookup(key{id: id}, func() (*userstore.User, error) {
+		return r.Repository.Get(ctx, id)
+	})
+}
+
+// FindByName returns the user with the given name.
+func (r *Repository) FindByName(ctx context.Context, name string) (*userstore.User, error) {
+	return r.lookup(key{name: name}, func() (*userstore.User, error) {
+		return r.Repository.FindByName(ctx, name)
+	})
+}
+
+// lookup answers from the cache, or calls load and caches what it found.
+// ErrNotFound is cached as a miss; other errors are not cached.
+func (r *Repository) lookup(k key, load func() (*userstore.User, error)) (*userstore.User, error) {
+	u, ok, gen := r.c.get(k)
+	if ok {
+		if u == nil {
+			return nil, userstore.ErrNotFound
+		}
+		c := u.Clone()
+		return &c, nil
+	}
+
+	u, err := load()
+	switch {
+	case err == nil:
+		c := u.Clone()
+		r.c.put(k, &c, gen)
+	case errors.Is(err, userstore.ErrNotFound):
+		r.c.put(k, nil, gen)
+	}
+	return u, err
+}
+
+// Create stores u and forgets the lookups that found no user by its ID or name.
+func (r *Repository) Create(ctx context.Context, u *userstore.User) error {
+	err := r.Repository.Create(ctx, u)
+	r.c.invalidate(u.ID, u.Name)
+	return err
+}
+
+// CreateMany stores users and forgets the lookups of each of them.
+func (r *Repository) CreateMany(ctx context.Context, users []*userstore.User) (map[int]error, error) {
+	failed, err := r.Repository.CreateMany(ctx, users)
+	for _, u := range users {
+		r.c.invalidate(u.ID, u.Name)
+	}
+	return failed, err
+}
+
+// Update applies upd and forgets the user, by ID, by its old name and by its new one.
+func (r *Repository) Update(ctx context.Context, id primitive.ObjectID, upd userstore.Update, expect userstore.Expect) (*userstore.UpdateResult, error) {
+	result, err := r.Repository.Update(ctx, id, upd, expect)
+	if upd.Set.Name != nil {
+		r.c.invalidate(id, *upd.Set.Name)
+	} else {
+		r.c.invalidate(id)
+	}
+	return result, err
+}
+
+// Replace replaces the stored user and forgets it.
+func (r *Repository) Replace(ctx context.Context, u *userstore.User, expect userstore.Expect) (*userstore.UpdateResult, error) {
+	result, err := r.Repository.Replace(ctx, u, expect)
+	r.c.invalidate(u.ID, u.Name)
+	return result, err
+}
+
+// Upsert replaces or inserts the user with u's name and forgets it.
+func (r *Repository) Upsert(ctx context.Context, u *userstore.User) (*userstore.UpdateResult, error) {
+	result, err := r.Repository.Upsert(ctx, u)
+	r.c.invalidate(u.ID, u.Name)
+	return result, err
+}
+
+// Delete moves the user to the trash and forgets it.
+func (r *Repository) Delete(ctx context.Context, id primitive.ObjectID, expect userstore.Expect) error {
+	err := r.Repository.Delete(ctx, id, expect)
+	r.c.invalidate(id)
+	return err
+}
+
+// Restore takes the user out of the trash and forgets the lookups that missed it.
+func (r *Repository) Restore(ctx context.Context, id primitive.ObjectID, expect userstore.Expect) (*userstore.User, error) {
+	u, err := r.Repository.Restore(ctx, id, expect)
+	if err != nil {
+		r.c.invalidate(id)
+		return nil, err
+	}
+	r.c.invalidate(id, u.Name)
+	return u, nil
+}
+
+// HardDelete permanently removes the user and forgets it.
+func (r *Repository) HardDelete(ctx context.Context, id primitive.ObjectID, expect userstore.Expect) (*userstore.User, error) {
+	u, err := r.Repository.HardDelete(ctx, id, expect)
+	r.c.invalidate(id)
+	return u, err
+}
+
+// Purge permanently removes the users deleted before deletedBefore. Users in
+// the trash are never cached, so no entry has to be dropped.
+func (r *Repository) Purge(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	return r.Repository.Purge(ctx, deletedBefore)
+}
+
+// Expire permanently removes the users that expired by now and forgets those
+// outside the trash, the only ones that can be cached.
+func (r *Repository) Expire(ctx context.Context, now time.Time) (int64, error) {
+	var expired []primitive.ObjectID
+	opts := userstore.ListOptions{Filter: userstore.Filter{Where: userstore.ExpiredBy(now)}}
+	err := r.Repository.Stream(ctx, opts, func(u *userstore.User) error {
+		expired = append(expired, u.ID)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := r.Repository.Expire(ctx, now)
+	for _, id := range expired {
+		r.c.invalidate(id)
+	}
+	return n, err
+}
@@ -0,0 +1,384 @@
+// Command userapi serves the user collection as a JSON REST API.
+//
+// By default it talks to the same db_san/col_san collection as 1_connection.go.
+// Pass -store memory to run it without a MongoDB server, or -store file to keep
+// the users in the embedded store under -data-dir instead. Connection settings
+// come from -config, a JSON file, and the MONGODB_* environment variables
+// described in package mongoconn; -uri overrides both.
+//
+// Changes are recorded in the audit log: the -audit-collection of the database
+// for the mongo store, or -audit-file for either store. The actor of a change is
+// the X-Actor request header, or the client address when it is missing.
+//
+// DELETE moves users to the trash. Users that have been there for longer than
+// -retention are deleted permanently by a purger running every -purge-interval.
+//
+// With -cache-size N, up to N lookups by ID or name are answered from memory
+// for -cache-ttl, and lookups that found nothing for -cache-negative-ttl.
+// Changes made through this process invalidate the cache at once; changes made
+// by others show once the entries expire. The hit, miss and eviction counts
+// are published as "userCache" on /debug/vars.
+//
+// Users expire at their expiresAt, and with -ttl the users created or
+// upserted without one expire that long after. MongoDB removes them with a TTL
+// index, and what its TTL monitor removed from every collection of the server
+// is published as "mongoTTL" on /debug/vars. The memory and file stores are
+// swept every -sweep-interval, through the audit log and the cache, and
+// publish what they removed as "userExpiry".
+//
+// The files attached to users with userctl blobs are deleted with the users
+// removed for good: by DELETE ?hard=true, by the purge of the trash or on
+// expiry. The mongo store also looks for the files of the users its TTL index
+// removed every -blob-sweep-interval.
+//
+// Every MongoDB operation is bounded by the operationTimeout of the
+// connection settings, and by the request: a client that goes away cancels
+// the operation it was waiting for. An operation that times out is answered
+// with 504 Gateway Timeout.
+//
+// With -keyring, the mongo store encrypts the Age and Gender fields named in
+// the keyring at rest; see package fieldcrypt. Create and rotate keyrings with
+// userctl keys.
+//
+// With -tenants, every request must name its tenant in the X-Tenant header
+// and only reaches the users of that tenant. Each tenant has its own
+// collections, audit log and cache; provision tenants with userctl tenants.
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/audit"
+	"github.com/saurabhkk55/Go/16_MongoDB/blobstore"
+	"github.com/saurabhkk55/Go/16_MongoDB/cache"
+	"github.com/saurabhkk55/Go/16_MongoDB/expiry"
+	"github.com/saurabhkk55/Go/16_MongoDB/fieldcrypt"
+	"github.com/saurabhkk55/Go/16_MongoDB/filestore"
+	"github.com/saurabhkk55/Go/16_MongoDB/httpapi"
+	"github.com/saurabhkk55/Go/16_MongoDB/mongoconn"
+	"github.com/saurabhkk55/Go/16_MongoDB/tenant"
+	"github.com/saurabhkk55/Go/16_MongoDB/userstore"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to listen on")
+	store := flag.String("store", "mongo", "storage backend: mongo, memory or file")
+	dataDir := flag.String("data-dir", "data", "directory of the file store; each -db is a subdirectory")
+	configPath := flag.String("config", "", "MongoDB config file (default $MONGODB_CONFIG)")
+	uri := flag.String("uri", "", "MongoDB connection URI (overrides the config)")
+	dbName := flag.String("db", "db_san", "database name")
+	collectionName := flag.String("collection", "col_san", "collection name")
+	auditCollection := flag.String("audit-collection", audit.DefaultCollection, "audit log collection of the mongo store; empty disables it")
+	auditFile := flag.String("audit-file", "", "append the audit log to this file instead")
+	retention := flag.Duration("retention", userstore.DefaultRetention, "how long deleted users stay in the trash")
+	purgeInterval := flag.Duration("purge-interval", time.Hour, "how often to purge the trash; 0 disables purging")
+	cacheSize := flag.Int("cache-size", 0, "number of user lookups to cache; 0 disables the cache")
+	cacheTTL := flag.Duration("cache-ttl", cache.DefaultTTL, "how long a cached user is served")
+	cacheNegativeTTL := flag.Duration("cache-negative-ttl", cache.DefaultNegativeTTL, "how long a lookup that found no user is cached")
+	ttl := flag.Duration("ttl", 0, "expire the users created or upserted without an expiresAt this long after; 0 keeps them")
+	sweepInterval := flag.Duration("sweep-interval", expiry.DefaultInterval, "how often the memory and file stores remove expired users; 0 disables it")
+	blobSweepInterval := flag.Duration("blob-sweep-interval", time.Hour, "how often the mongo store deletes the files of users removed on expiry; 0 disables it")
+	keyringPath := flag.String("keyring", "", "keyring file of the encrypted fields (mongo store only)")
+	tenants := flag.Bool("tenants", false, "serve the tenant named by the X-Tenant header of each request (mongo store only)")
+	flag.Parse()
+
+	// Stop serving when the process is interrupted.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if *keyringPath != "" && *store != "mongo" {
+		log.Fatal("-keyring only applies to the mongo store")
+	}
+
+	if *tenants && (*store != "mongo" || *auditFile != "") {
+		log.Fatal("-tenants needs the mongo store and the audit collections of the tenants")
+	}
+	cacheOpts := cache.Options{Size: *cacheSize, TTL: *cacheTTL, NegativeTTL: *cacheNegativeTTL}
+
+	var (
+		repo       userstore.Repository
+		ready      func(context.Context) error
+		auditLog   audit.Log
+		tenantRepo *tenant.Repository // set with -tenants only
+		sweep      bool               // set for the stores without a TTL index
+		blobs      blobstore.Store    // the files of the users; nil for the memory store
+	)
+	switch *store {
+	case "memory":
+		mem := userstore.NewMemoryRepository()
+		repo, sweep = mem, true
+	case "file":
+		db, err := filestore.Open(filepath.Join(*dataDir, *dbName))
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer db.Close()
+
+		coll, err := db.Collection(*collectionName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fileRepo, err := userstore.NewFileRepository(coll)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if blobs, err = blobstore.NewDirStore(filepath.Join(db.Dir(), "blobs")); err != nil {
+			log.Fatal(err)
+		}
+		repo, sweep = fileRepo, true
+	case "mongo":
+		cfg, err := mongoconn.Load(*configPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *uri != "" {
+			cfg.URI = *uri
+		}
+
+		client, err := mongoconn.Connect(ctx, cfg, log.Printf)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer client.Close(context.Background())
+
+		db := client.Database(*dbName)
+		ready = client.Check
+		expvar.Publish("mongoTTL", expvar.Func(func() any {
+			statsCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+			stats, err := expiry.ServerStats(statsCtx, db)
+			if err != nil {
+				return map[string]string{"error": err.Error()}
+			}
+			return stats
+		}))
+
+		var enc *fieldcrypt.Encrypter
+		if *keyringPath != "" {
+			ring, err := fieldcrypt.LoadKeyring(*keyringPath)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if enc, err = fieldcrypt.NewEncrypter(ring); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		if *tenants {
+			// Each tenant gets its own audit log and cache; the indexes of
+			// its audit log were made when it was provisioned.
+			router := tenant.NewRouter(client.Client, *dbName, *collectionName, *auditCollection)
+			var caches, cascades sync.Map
+			tenantRepo = tenant.NewRepository(router, func(t *tenant.Tenant, loc tenant.Location) (userstore.Repository, error) {
+				users, auditName := router.Collections(t)
+				if *auditCollection == "" {
+					auditName = ""
+				}
+				r, mongoLog := mongoRepository(loc.DB, users, auditName, enc, cfg.OperationTimeout)
+				if mongoLog != nil {
+					r = audit.NewRepository(r, mongoLog)
+				}
+				if *cacheSize > 0 {
+					cached := cache.NewRepository(r, cacheOpts)
+					caches.Store(t.ID, cached)
+					r = cached
+				}
+				tenantBlobs, err := blobstore.NewGridFSStore(loc.DB, loc.Name(blobstore.DefaultBucket))
+				if err != nil {
+					return nil, err
+				}
+				cascade := blobstore.NewRepository(r, tenantBlobs, log.Printf)
+				cascades.Store(t.ID, cascade)
+				return cascade, nil
+			})
+			if *blobSweepInterval > 0 {
+				go sweepTenantBlobs(ctx, &cascades, *blobSweepInterval)
+			}
+			expvar.Publish("userCache", expvar.Func(func() any {
+				stats := map[string]cache.Stats{}
+				caches.Range(func(id, cached any) bool {
+					stats[id.(string)] = cached.(*cache.Repository).Stats()
+					return true
+				})
+				return stats
+			}))
+			repo = tenantRepo
+			break
+		}
+
+		gridFS, err := blobstore.NewGridFSStore(db, blobstore.DefaultBucket)
+		if err == nil {
+			err = gridFS.EnsureIndexes(ctx)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		blobs = gridFS
+
+		var mongoLog *audit.MongoLog
+		repo, mongoLog = mongoRepository(db, *collectionName, *auditCollection, enc, cfg.OperationTimeout)
+		if mongoLog != nil {
+			if err := mongoLog.EnsureIndexes(ctx); err != nil {
+				log.Fatal(err)
+			}
+			auditLog = mongoLog
+		}
+	default:
+		log.Fatalf("unknown store %q", *store)
+	}
+
+	if *auditFile != "" {
+		fileLog, err := audit.OpenFileLog(*auditFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer fileLog.Close()
+		auditLog = fileLog
+	}
+	if auditLog != nil {
+		repo = audit.NewRepository(repo, auditLog)
+	}
+	if *cacheSize > 0 && tenantRepo == nil {
+		cached := cache.NewRepository(repo, cacheOpts)
+		expvar.Publish("userCache", expvar.Func(func() any { return cached.Stats() }))
+		repo = cached
+	}
+
+	if blobs != nil {
+		// Users removed for good take their files with them.
+		cascade := blobstore.NewRepository(repo, blobs, log.Printf)
+		if *store == "mongo" && *blobSweepInterval > 0 {
+			go cascade.RunOrphanSweeper(ctx, *blobSweepInterval)
+		}
+		repo = cascade
+	}
+	if *ttl > 0 {
+		repo = expiry.NewRepository(repo, *ttl)
+	}
+	if sweep && *sweepInterval > 0 {
+		sweeper := expiry.NewSweeper(repo)
+		expvar.Publish("userExpiry", expvar.Func(func() any { return sweeper.Stats() }))
+		go sweeper.Run(ctx, *sweepInterval, log.Printf)
+	}
+
+	// Permanently delete users that have been in the trash for longer than the retention.
+	if *purgeInterval > 0 {
+		var purger userstore.Purger = repo
+		if tenantRepo != nil {
+			purger = tenantRepo.AllTenants()
+		}
+		go userstore.RunPurger(ctx, purger, *retention, *purgeInterval, log.Printf)
+	}
+
+	handler := httpapi.NewHandler(repo)
+	handler.SetReadinessCheck(ready)
+
+	mux := http.NewServeMux()
+	var root http.Handler = handler
+	if tenantRepo != nil {
+		root = withTenant(root)
+	}
+	mux.Handle("/", withActor(root))
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	server := &http.Server{
+		Addr:    *addr,
+		Handler: mux,
+	}
+
+	// Shut the server down gracefully once the context is cancelled.
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("Serving users on %s using the %s store", *addr, *store)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+}
+
+// withTenant makes the X-Tenant header the tenant of a request. Requests
+// without one are refused by the tenant repository.
+func withTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := r.Header.Get("X-Tenant"); id != "" {
+			r = r.WithContext(tenant.WithID(r.Context(), id))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sweepTenantBlobs deletes the files left by the users MongoDB removed on
+// expiry, for every tenant opened so far, every interval until ctx is done.
+func sweepTenantBlobs(ctx context.Context, cascades *sync.Map, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		cascades.Range(func(id, cascade any) bool {
+			n, err := cascade.(*blobstore.Repository).SweepOrphans(ctx)
+			switch {
+			case err != nil && ctx.Err() == nil:
+				log.Printf("blobstore: sweep orphans of tenant %s: %v", id, err)
+			case n > 0:
+				log.Printf("blobstore: deleted %d blob(s) of removed users of tenant %s", n, id)
+			}
+			return ctx.Err() == nil
+		})
+	}
+}
+
+// mongoRepository returns the repository of the users in the named collection
+// of db, encrypted with enc unless it is nil, whose operations time out after
+// timeout, and the audit log in the auditName collection unless that is empty.
+func mongoRepository(db *mongo.Database, users, auditName string, enc *fieldcrypt.Encrypter, timeout time.Duration) (userstore.Repository, *audit.MongoLog) {
+	var (
+		repo      = userstore.NewMongoRepository(db.Collection(users))
+		auditColl = db.Collection(auditName)
+	)
+	if enc != nil {
+		repo = enc.NewMongoRepository(db, users)
+		// Audit entries hold whole users, so they are encrypted too.
+		auditColl = enc.Collection(db, auditName)
+	}
+	repo.SetTimeout(timeout)
+	if auditName == "" {
+		return repo, nil
+	}
+	return repo, audit.NewMongoLog(auditColl)
+}
+
+// withActor records the X-Actor header, or the client address, as the actor
+// of the changes a request makes.
+func withActor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor := r.Header.Get("X-Actor")
+		if actor == "" {
+			actor = r.RemoteAddr
+			if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+				actor = host
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(audit.WithActor(r.Context(), actor)))
+	})
+}
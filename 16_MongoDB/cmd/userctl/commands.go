@@ -0,0 +1,1303 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/audit"
+	"github.com/saurabhkk55/Go/16_MongoDB/backup"
+	"github.com/saurabhkk55/Go/16_MongoDB/bulk"
+	"github.com/saurabhkk55/Go/16_MongoDB/expiry"
+	"github.com/saurabhkk55/Go/16_MongoDB/fieldcrypt"
+	"github.com/saurabhkk55/Go/16_MongoDB/filestore"
+	"github.com/saurabhkk55/Go/16_MongoDB/migrate"
+	"github.com/saurabhkk55/Go/16_MongoDB/outbox"
+	"github.com/saurabhkk55/Go/16_MongoDB/query"
+	"github.com/saurabhkk55/Go/16_MongoDB/report"
+	"github.com/saurabhkk55/Go/16_MongoDB/search"
+	"github.com/saurabhkk55/Go/16_MongoDB/seed"
+	"github.com/saurabhkk55/Go/16_MongoDB/tenant"
+	"github.com/saurabhkk55/Go/16_MongoDB/userstore"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// runInsert inserts every JSON user read from -data or stdin and prints each stored user.
+func runInsert(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("insert")
+	data := fs.String("data", "", "JSON user to insert instead of reading stdin")
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(inputReader(a, *data))
+	dec.DisallowUnknownFields()
+
+	// Read a stream of JSON objects so JSON Lines and pretty-printed input both work.
+	enc := json.NewEncoder(a.stdout)
+	for {
+		var u userstore.User
+		err := dec.Decode(&u)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("decode input: %w", err)
+		}
+
+		if err := a.repo.Create(ctx, &u); err != nil {
+			return err
+		}
+		if err := enc.Encode(u); err != nil {
+			return err
+		}
+	}
+}
+
+// runGet prints the user with the given id or -name.
+func runGet(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("get")
+	name := fs.String("name", "", "look the user up by name instead of id")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	u, err := lookup(ctx, a, fs, *name)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(a.stdout).Encode(u)
+}
+
+// runList prints users as JSON Lines, or the whole page as one object with -page.
+func runList(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("list")
+	limit := fs.Int("limit", 0, "maximum number of users to print (0 means all)")
+	offset := fs.Int("offset", 0, "number of users to skip")
+	cursor := fs.String("cursor", "", "nextCursor of a previous -page output")
+	sortBy := fs.String("sort", "", `sort fields such as "-age,name"`)
+	fields := fs.String("fields", "", `fields to print such as "name,age"`)
+	asPage := fs.Bool("page", false, "print {users, total, nextCursor} instead of JSON Lines")
+	filter := filterFlags(fs)
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	opts := userstore.ListOptions{Limit: *limit, Offset: *offset, After: *cursor}
+	var err error
+	if opts.Filter, err = filter(); err != nil {
+		return err
+	}
+	if opts.Sort, err = userstore.ParseSort(*sortBy); err != nil {
+		return err
+	}
+	if opts.Fields, err = userstore.ParseFields(*fields); err != nil {
+		return err
+	}
+
+	page, err := a.repo.List(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	users := make([]map[string]any, len(page.Users))
+	for i := range page.Users {
+		users[i] = userstore.Project(&page.Users[i], opts.Fields)
+	}
+
+	enc := json.NewEncoder(a.stdout)
+	if *asPage {
+		return enc.Encode(map[string]any{
+			"users":      users,
+			"total":      page.Total,
+			"nextCursor": page.NextCursor,
+		})
+	}
+	for _, u := range users {
+		if err := enc.Encode(u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runUpdate applies the JSON update read from -data or stdin to the user with the given id.
+// The update is either a plain object of fields or uses $set, $unset and $inc.
+func runUpdate(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("update")
+	data := fs.String("data", "", "JSON update to apply instead of reading stdin")
+	ifVersion := versionFlag(fs)
+	if err := parseFlags(fs, args, 1); err != nil {
+		return err
+	}
+
+	id, err := parseID(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var upd userstore.Update
+	if err := decodeInput(a, *data, &upd); err != nil {
+		return err
+	}
+
+	result, err := a.repo.Update(ctx, id, upd, ifVersion())
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(a.stdout).Encode(result)
+}
+
+// runReplace replaces the user with the given id by the JSON user read from -data or stdin.
+func runReplace(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("replace")
+	data := fs.String("data", "", "JSON user to store instead of reading stdin")
+	ifVersion := versionFlag(fs)
+	if err := parseFlags(fs, args, 1); err != nil {
+		return err
+	}
+
+	id, err := parseID(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var u userstore.User
+	if err := decodeInput(a, *data, &u); err != nil {
+		return err
+	}
+	u.ID = id
+
+	result, err := a.repo.Replace(ctx, &u, ifVersion())
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(a.stdout).Encode(result)
+}
+
+// runUpsert stores every JSON user read from -data or stdin, replacing users with the same name.
+func runUpsert(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("upsert")
+	data := fs.String("data", "", "JSON user to upsert instead of reading stdin")
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(inputReader(a, *data))
+	dec.DisallowUnknownFields()
+
+	enc := json.NewEncoder(a.stdout)
+	for {
+		var u userstore.User
+		err := dec.Decode(&u)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("decode input: %w", err)
+		}
+
+		// The ID of an existing user with the same name always wins.
+		u.ID = primitive.NilObjectID
+		result, err := a.repo.Upsert(ctx, &u)
+		if err != nil {
+			return err
+		}
+		if err := enc.Encode(result); err != nil {
+			return err
+		}
+	}
+}
+
+// runDelete moves the user with the given id or -name to the trash and prints it.
+// With -hard the user is deleted permanently, even when it is already in the trash.
+func runDelete(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("delete")
+	name := fs.String("name", "", "look the user up by name instead of id")
+	hard := fs.Bool("hard", false, "delete permanently instead of moving to the trash")
+	ifVersion := versionFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	expect := ifVersion()
+
+	// A user in the trash can only be found by id, so hard deletes by id skip the lookup.
+	if *hard && *name == "" && fs.NArg() == 1 {
+		id, err := parseID(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		u, err := a.repo.HardDelete(ctx, id, expect)
+		if err != nil {
+			return err
+		}
+		return json.NewEncoder(a.stdout).Encode(u)
+	}
+
+	u, err := lookup(ctx, a, fs, *name)
+	if err != nil {
+		return err
+	}
+	if *hard {
+		_, err = a.repo.HardDelete(ctx, u.ID, expect)
+	} else {
+		err = a.repo.Delete(ctx, u.ID, expect)
+	}
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(a.stdout).Encode(u)
+}
+
+// runRestore takes the user with the given id out of the trash and prints it.
+func runRestore(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("restore")
+	ifVersion := versionFlag(fs)
+	if err := parseFlags(fs, args, 1); err != nil {
+		return err
+	}
+	id, err := parseID(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	u, err := a.repo.Restore(ctx, id, ifVersion())
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(a.stdout).Encode(u)
+}
+
+// runPurge permanently deletes the users that have been in the trash for longer
+// than -older-than and prints how many were deleted.
+func runPurge(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("purge")
+	olderThan := fs.Duration("older-than", userstore.DefaultRetention, "purge users deleted longer ago than this")
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	n, err := a.repo.Purge(ctx, time.Now().Add(-*olderThan))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(a.stdout, n)
+	return err
+}
+
+// runExpire permanently deletes the users whose expiresAt has passed and
+// prints how many, or prints what the TTL monitor of the server removed.
+func runExpire(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("expire")
+	stats := fs.Bool("stats", false, "print the TTL statistics of the server instead (mongo store only)")
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	if *stats {
+		if a.db == nil {
+			return errors.New("-stats only applies to the mongo store")
+		}
+		s, err := expiry.ServerStats(ctx, a.db)
+		if err != nil {
+			return err
+		}
+		return json.NewEncoder(a.stdout).Encode(s)
+	}
+
+	n, err := a.repo.Expire(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(a.stdout, n)
+	return err
+}
+
+// runCount prints the number of users.
+func runCount(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("count")
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	n, err := a.repo.Count(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(a.stdout, n)
+	return err
+}
+
+// runImport imports users from a CSV or JSON Lines file, or stdin, and prints a JSON report.
+func runImport(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("import")
+	format := fs.String("format", "csv", "input format: csv or jsonl")
+	mapping := fs.String("map", "", `column mapping such as "Full Name=name,Town=attributes.city"`)
+	batch := fs.Int("batch", bulk.DefaultBatchSize, "users written per batch")
+	dryRun := fs.Bool("dry-run", false, "validate and preview without writing")
+	rejects := fs.String("rejects", "", "also write rejected rows as CSV to this file")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: userctl import [flags] [file]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil || fs.NArg() > 1 {
+		return errUsage
+	}
+
+	opts := bulk.ImportOptions{BatchSize: *batch, DryRun: *dryRun}
+	var err error
+	if opts.Format, err = bulk.ParseFormat(*format); err != nil {
+		return err
+	}
+	if opts.Mapping, err = bulk.ParseMapping(*mapping); err != nil {
+		return err
+	}
+
+	in := a.stdin
+	if fs.NArg() == 1 {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	report, err := bulk.Import(ctx, a.repo, in, opts)
+	if report != nil {
+		if encErr := json.NewEncoder(a.stdout).Encode(report); encErr != nil && err == nil {
+			err = encErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if *rejects != "" {
+		f, err := os.Create(*rejects)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return bulk.WriteRejections(f, report.Rejected)
+	}
+	return nil
+}
+
+// runExport writes the users matching the filter flags to stdout as CSV or JSON Lines.
+func runExport(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("export")
+	format := fs.String("format", "jsonl", "output format: csv or jsonl")
+	sortBy := fs.String("sort", "", `sort fields such as "-age,name"`)
+	fields := fs.String("fields", "", `JSON Lines fields such as "name,age"`)
+	columns := fs.String("columns", "", `CSV columns such as "name,age,attributes.city"`)
+	filter := filterFlags(fs)
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	opts := bulk.ExportOptions{}
+	var err error
+	if opts.Format, err = bulk.ParseFormat(*format); err != nil {
+		return err
+	}
+	if opts.Query.Filter, err = filter(); err != nil {
+		return err
+	}
+	if opts.Query.Sort, err = userstore.ParseSort(*sortBy); err != nil {
+		return err
+	}
+	if opts.Query.Fields, err = userstore.ParseFields(*fields); err != nil {
+		return err
+	}
+	if *columns != "" {
+		opts.Columns = strings.Split(*columns, ",")
+	}
+
+	_, err = bulk.Export(ctx, a.repo, a.stdout, opts)
+	return err
+}
+
+// runSeed inserts synthetic users generated from -seed, or prints them with
+// -dry-run, and prints a JSON report of the throughput and batch latencies.
+func runSeed(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("seed")
+	count := fs.Int("count", 1000, "number of users to generate")
+	seedValue := fs.Int64("seed", 1, "seed of the generator; the same seed generates the same users")
+	ages := fs.String("ages", seed.DefaultAges, `age distribution: "uniform:MIN,MAX" or "normal:MEAN,STDDEV"`)
+	genders := fs.String("genders", seed.DefaultGenders, "relative weight of each gender")
+	batch := fs.Int("batch", seed.DefaultBatchSize, "users written per batch")
+	workers := fs.Int("workers", seed.DefaultWorkers, "batches written at the same time")
+	rate := fs.Float64("rate", 0, "target users written per second (0: as fast as possible)")
+	dryRun := fs.Bool("dry-run", false, "print the users as JSON Lines instead of inserting them")
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+	if *count < 0 || *batch < 1 || *workers < 1 || *rate < 0 {
+		return errUsage
+	}
+
+	opts := seed.Options{Seed: *seedValue}
+	var err error
+	if opts.Ages, err = seed.ParseAges(*ages); err != nil {
+		return err
+	}
+	if opts.Genders, err = seed.ParseGenders(*genders); err != nil {
+		return err
+	}
+	gen, err := seed.NewGenerator(opts)
+	if err != nil {
+		return err
+	}
+
+	if *dryRun {
+		enc := json.NewEncoder(a.stdout)
+		for i := 0; i < *count; i++ {
+			if err := enc.Encode(gen.Next()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	loadOpts := seed.LoadOptions{Count: *count, BatchSize: *batch, Workers: *workers, Rate: *rate}
+	report, err := seed.Load(ctx, a.repo, gen, loadOpts)
+	if encErr := json.NewEncoder(a.stdout).Encode(report); encErr != nil && err == nil {
+		err = encErr
+	}
+	return err
+}
+
+// runMigrate applies or rolls back schema migrations, or prints their status with -status.
+func runMigrate(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("migrate")
+	to := fs.Int("to", -1, "target version; 0 rolls everything back (default: latest)")
+	status := fs.Bool("status", false, "print the status of every migration instead of migrating")
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+	if a.migrator == nil {
+		return errors.New("migrations only apply to the mongo store")
+	}
+
+	enc := json.NewEncoder(a.stdout)
+	if *status {
+		statuses, err := a.migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			if err := enc.Encode(s); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	target := *to
+	if target < 0 {
+		target = a.migrator.Latest()
+	}
+	current, err := a.migrator.Current(ctx)
+	if err != nil {
+		return err
+	}
+
+	ran, err := a.migrator.Migrate(ctx, target)
+	direction := "up"
+	if target < current {
+		direction = "down"
+	}
+	for _, m := range ran {
+		enc.Encode(map[string]any{"version": m.Version, "description": m.Description, "direction": direction})
+	}
+	if err != nil {
+		return err
+	}
+
+	// Documents a migration could not convert are moved aside, not lost.
+	n, err := migrate.CountQuarantined(ctx, a.db, a.collection)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		fmt.Fprintf(os.Stderr, "migrate: %d documents could not be migrated; they are in %s\n",
+			n, migrate.QuarantineCollection(a.collection))
+	}
+	return nil
+}
+
+// runHistory prints the audit entries of the user with the given id, oldest first.
+// With -at it prints the user as it was at that time instead.
+func runHistory(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("history")
+	at := fs.String("at", "", "reconstruct the user as it was at this RFC 3339 time")
+	if err := parseFlags(fs, args, 1); err != nil {
+		return err
+	}
+	id, err := parseID(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var when time.Time
+	if *at != "" {
+		if when, err = time.Parse(time.RFC3339, *at); err != nil {
+			fmt.Fprintf(os.Stderr, "history: invalid -at time %q\n", *at)
+			return errUsage
+		}
+	}
+
+	entries, err := a.audit.History(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(a.stdout)
+	if *at == "" {
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	u := audit.StateAt(entries, when)
+	if u == nil {
+		return fmt.Errorf("%w at %s", userstore.ErrNotFound, when.Format(time.RFC3339))
+	}
+	return enc.Encode(u)
+}
+
+// runReport prints a summary of the users matching the filter flags: the count
+// per value of -by (groups), the count per age bucket (histogram) or age
+// statistics (stats), as a table, CSV or JSON.
+func runReport(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("report")
+	by := fs.String("by", "gender", `groups: field to group by, "gender", "age" or "attributes.<key>"`)
+	bounds := fs.String("buckets", "0,18,30,45,65", "histogram: lower bounds of the age buckets")
+	width := fs.Int("width", 0, "histogram: use buckets of this width instead of -buckets")
+	format := fs.String("format", "table", "output format: table, csv or json")
+	filter := filterFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: userctl report [flags] groups|histogram|stats")
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args, 1); err != nil {
+		return err
+	}
+
+	f, err := report.ParseFormat(*format)
+	if err != nil {
+		return err
+	}
+	query, err := filter()
+	if err != nil {
+		return err
+	}
+
+	var table *report.Table
+	switch fs.Arg(0) {
+	case "groups":
+		groups, err := a.reporter.GroupCount(ctx, *by, query)
+		if err != nil {
+			return err
+		}
+		table = report.GroupTable(*by, groups)
+
+	case "histogram":
+		var buckets report.Buckets
+		if *width > 0 {
+			buckets, err = report.UniformBuckets(*width)
+		} else {
+			buckets, err = report.ParseBuckets(*bounds)
+		}
+		if err != nil {
+			return err
+		}
+		histogram, err := a.reporter.AgeHistogram(ctx, buckets, query)
+		if err != nil {
+			return err
+		}
+		table = report.HistogramTable(histogram)
+
+	case "stats":
+		stats, err := a.reporter.AgeStats(ctx, query)
+		if err != nil {
+			return err
+		}
+		table = report.StatsTable(stats)
+
+	default:
+		fmt.Fprintf(os.Stderr, "report: unknown report %q, want groups, histogram or stats\n", fs.Arg(0))
+		return errUsage
+	}
+	return table.Write(a.stdout, f)
+}
+
+// runSearch prints the users whose name or attribute values match the words
+// of the query, best match first, each with its score.
+func runSearch(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("search")
+	mode := fs.String("mode", "prefix", "how words match: exact, prefix, substring or fuzzy")
+	dist := fs.Int("distance", 0, "fuzzy: largest edit distance (default depends on the word length)")
+	limit := fs.Int("limit", search.DefaultLimit, "maximum number of results")
+	filter := filterFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: userctl search [flags] QUERY")
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args, 1); err != nil {
+		return err
+	}
+
+	m, err := search.ParseMode(*mode)
+	if err != nil {
+		return err
+	}
+	query, err := filter()
+	if err != nil {
+		return err
+	}
+
+	results, err := a.searcher.Search(ctx, search.Query{
+		Text:        fs.Arg(0),
+		Mode:        m,
+		MaxDistance: *dist,
+		Limit:       *limit,
+		Filter:      query,
+	})
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(a.stdout)
+	for _, r := range results {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runKeys manages the keyring of the encrypted fields: init creates one,
+// list prints its keys, rotate adds a key and re-encrypts the users with it,
+// and reencrypt brings the users in line with the keyring.
+func runKeys(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("keys")
+	fields := fs.String("fields", "age,gender", "init: fields to encrypt, each optionally =randomized or =deterministic")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: userctl keys [flags] init FILE | list | rotate | reencrypt")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	action := fs.Arg(0)
+	nargs := 1
+	if action == "init" {
+		nargs = 2
+	}
+	if fs.NArg() != nargs {
+		fs.Usage()
+		return errUsage
+	}
+
+	if action == "init" {
+		path := fs.Arg(1)
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("keyring %s already exists", path)
+		}
+		encrypted, err := fieldcrypt.ParseFields(*fields)
+		if err != nil {
+			return err
+		}
+		ring, err := fieldcrypt.NewKeyring(encrypted)
+		if err != nil {
+			return err
+		}
+		if err := ring.Save(path); err != nil {
+			return err
+		}
+		return printKeys(a, ring)
+	}
+
+	if a.keyring == nil {
+		return errors.New("keys " + action + " needs -keyring")
+	}
+	switch action {
+	case "list":
+		return printKeys(a, a.keyring)
+
+	case "rotate":
+		// Save the new key before any value is encrypted with it.
+		if _, err := a.keyring.Rotate(); err != nil {
+			return err
+		}
+		if err := a.keyring.Save(a.keyringPath); err != nil {
+			return err
+		}
+		enc, err := fieldcrypt.NewEncrypter(a.keyring)
+		if err != nil {
+			return err
+		}
+		progress, err := enc.Reencrypt(ctx, a.db, a.collection)
+		if encErr := json.NewEncoder(a.stdout).Encode(progress); encErr != nil && err == nil {
+			err = encErr
+		}
+		return err
+
+	case "reencrypt":
+		progress, err := a.encrypter.Reencrypt(ctx, a.db, a.collection)
+		if encErr := json.NewEncoder(a.stdout).Encode(progress); encErr != nil && err == nil {
+			err = encErr
+		}
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "keys: unknown action %q, want init, list, rotate or reencrypt\n", action)
+	return errUsage
+}
+
+// printKeys prints the encrypted fields and the keys of ring, without their secrets.
+func printKeys(a *app, ring *fieldcrypt.Keyring) error {
+	enc := json.NewEncoder(a.stdout)
+	if err := enc.Encode(map[string]any{"fields": ring.Fields}); err != nil {
+		return err
+	}
+	for _, key := range ring.Keys {
+		err := enc.Encode(map[string]any{"id": key.ID, "created": key.Created, "active": key.ID == ring.Active})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runTenants lists the tenants of the database, provisions a new one with
+// collections of its own, or decommissions one, dropping its collections.
+func runTenants(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("tenants")
+	mode := fs.String("mode", string(tenant.PerDatabase), "provision: give the tenant its own database, or prefixed collections of -db")
+	yes := fs.Bool("yes", false, "decommission: confirm that the users and audit log of the tenant are dropped")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: userctl tenants [flags] list | provision ID | decommission ID")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	action := fs.Arg(0)
+	nargs := 2
+	if action == "list" {
+		nargs = 1
+	}
+	if fs.NArg() != nargs {
+		fs.Usage()
+		return errUsage
+	}
+	if a.router == nil {
+		return errors.New("tenants only apply to the mongo store")
+	}
+
+	enc := json.NewEncoder(a.stdout)
+	switch action {
+	case "list":
+		tenants, err := a.router.List(ctx)
+		if err != nil {
+			return err
+		}
+		for _, t := range tenants {
+			if err := enc.Encode(t); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "provision":
+		m, err := tenant.ParseMode(*mode)
+		if err != nil {
+			return err
+		}
+		t, err := a.router.Provision(ctx, fs.Arg(1), m)
+		if err != nil {
+			return err
+		}
+		return enc.Encode(t)
+
+	case "decommission":
+		if !*yes {
+			fmt.Fprintln(os.Stderr, "tenants: decommission drops every user of the tenant; pass -yes to confirm")
+			return errUsage
+		}
+		t, err := a.router.Decommission(ctx, fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		return enc.Encode(t)
+	}
+	fmt.Fprintf(os.Stderr, "tenants: unknown action %q, want list, provision or decommission\n", action)
+	return errUsage
+}
+
+// tenantCollections limits the collections of a backup or restore to those
+// of the -tenant when it shares its database with other tenants. No
+// collections means all of them, or, for such a tenant, the collections it
+// owns for which exists reports true, as naming a missing one is an error.
+func (a *app) tenantCollections(collections []string, exists func(name string) bool) ([]string, error) {
+	if a.tenant == nil || a.tenant.Mode != tenant.PerCollection {
+		return collections, nil
+	}
+	if len(collections) == 0 {
+		for _, c := range a.router.Owned(a.tenant) {
+			if exists(c) {
+				collections = append(collections, c)
+			}
+		}
+		if len(collections) == 0 {
+			return nil, fmt.Errorf("tenant %s has no collections", a.tenant.ID)
+		}
+		return collections, nil
+	}
+	prefix := a.router.Locate(a.tenant).Prefix
+	for _, c := range collections {
+		if !strings.HasPrefix(c, prefix) {
+			return nil, fmt.Errorf("collection %s does not belong to tenant %s", c, a.tenant.ID)
+		}
+	}
+	return collections, nil
+}
+
+// runOutbox lists the changes queued in an outbox while MongoDB was
+// unreachable, drops them, or replays them now. The outbox must not be in use
+// by another process.
+func runOutbox(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("outbox")
+	dir := fs.String("dir", "outbox", "directory of the outbox")
+	all := fs.Bool("all", false, "drop: drop every entry")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: userctl outbox [-dir DIR] list | replay | drop KEY... | -all drop")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	action, keys := fs.Arg(0), fs.Args()
+	if len(keys) > 0 {
+		keys = keys[1:]
+	}
+	valid := action != "" && len(keys) == 0
+	if action == "drop" {
+		// Either keys or -all, so that a missing key does not drop everything.
+		valid = *all != (len(keys) > 0)
+	}
+	if !valid {
+		fs.Usage()
+		return errUsage
+	}
+
+	db, err := filestore.Open(*dir)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	coll, err := db.Collection(outbox.CollectionName)
+	if err != nil {
+		return err
+	}
+	box := outbox.New(coll)
+
+	enc := json.NewEncoder(a.stdout)
+	switch action {
+	case "list":
+		entries, err := box.List()
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "drop":
+		n, err := box.Drop(keys...)
+		if err != nil {
+			return err
+		}
+		return enc.Encode(map[string]int64{"dropped": n})
+
+	case "replay":
+		res, err := outbox.Replay(ctx, box, a.repo)
+		if res != nil {
+			if encErr := enc.Encode(res); encErr != nil && err == nil {
+				err = encErr
+			}
+		}
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "outbox: unknown action %q, want list, drop or replay\n", action)
+	return errUsage
+}
+
+// runBlobs stores a file as a blob of a user, writes a blob back out, lists
+// the blobs of a user or deletes one.
+func runBlobs(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("blobs")
+	user := fs.String("user", "", "put, list: id of the user the blobs belong to")
+	contentType := fs.String("type", "", "put: content type (default: detected from the content)")
+	filename := fs.String("name", "", "put: name of the blob (default: the base name of the file)")
+	out := fs.String("o", "-", "get: write the content to this file instead of stdout")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: userctl blobs [flags] put FILE | get ID | list | delete ID | sweep")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	action := fs.Arg(0)
+	nargs := map[string]int{"put": 2, "get": 2, "list": 1, "delete": 2, "sweep": 1}[action]
+	if nargs == 0 || fs.NArg() != nargs || (*user == "") != (action == "get" || action == "delete" || action == "sweep") {
+		fs.Usage()
+		return errUsage
+	}
+
+	enc := json.NewEncoder(a.stdout)
+	switch action {
+	case "put", "list":
+		userID, err := parseID(*user)
+		if err != nil {
+			return err
+		}
+		// A user in the trash keeps its blobs but takes no new ones.
+		if _, err := a.repo.Get(ctx, userID); err != nil {
+			return err
+		}
+		if action == "list" {
+			blobs, err := a.blobs.List(ctx, userID)
+			if err != nil {
+				return err
+			}
+			for _, b := range blobs {
+				if err := enc.Encode(b); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		in, name := a.stdin, *filename
+		if path := fs.Arg(1); path != "-" {
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			in = f
+			if name == "" {
+				name = filepath.Base(path)
+			}
+		}
+		b, err := a.blobs.Put(ctx, userID, name, *contentType, in)
+		if err != nil {
+			return err
+		}
+		return enc.Encode(b)
+
+	case "get":
+		id, err := parseID(fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		b, content, err := a.blobs.Open(ctx, id)
+		if err != nil {
+			return err
+		}
+		defer content.Close()
+
+		w := a.stdout
+		if *out != "-" {
+			f, err := os.Create(*out)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+		if _, err := io.Copy(w, content); err != nil {
+			return err
+		}
+		if *out != "-" {
+			return enc.Encode(b)
+		}
+		return nil
+
+	case "sweep":
+		n, err := a.cascade.SweepOrphans(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(a.stdout, n)
+		return err
+
+	default: // delete
+		id, err := parseID(fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		return a.blobs.Delete(ctx, id)
+	}
+}
+
+// runBackup writes every collection of the database to an archive file, or
+// to stdout when the file is "-", and prints the archive's metadata.
+func runBackup(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("backup")
+	collections := fs.String("collections", "", "comma-separated collections to back up (default all)")
+	noSnapshot := fs.Bool("no-snapshot", false, "copy one collection after the other, for a standalone server")
+	if err := parseFlags(fs, args, 1); err != nil {
+		return err
+	}
+	if a.db == nil {
+		return errors.New("backups only apply to the mongo store")
+	}
+	names, err := a.db.ListCollectionNames(ctx, bson.D{})
+	if err != nil {
+		return err
+	}
+	scoped, err := a.tenantCollections(splitList(*collections), func(name string) bool { return slices.Contains(names, name) })
+	if err != nil {
+		return err
+	}
+	opts := backup.Options{Collections: scoped, NoSnapshot: *noSnapshot}
+
+	path := fs.Arg(0)
+	if path == "-" {
+		_, err := backup.Backup(ctx, a.db, a.stdout, opts)
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	meta, err := backup.Backup(ctx, a.db, f, opts)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// Leave no partial archive behind.
+		os.Remove(path)
+		return err
+	}
+
+	enc := json.NewEncoder(a.stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(meta)
+}
+
+// runRestoreBackup verifies an archive and writes its collections into -target,
+// which defaults to the database that was backed up.
+func runRestoreBackup(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("restore-backup")
+	target := fs.String("target", "", "database to restore into (default: the one in the archive)")
+	mode := fs.String("mode", "skip", "existing documents: skip or overwrite")
+	collections := fs.String("collections", "", "comma-separated collections to restore (default all)")
+	verifyOnly := fs.Bool("verify", false, "only verify the checksums and print the metadata")
+	if err := parseFlags(fs, args, 1); err != nil {
+		return err
+	}
+
+	restoreMode, err := backup.ParseMode(*mode)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	archive, err := backup.Open(f, info.Size())
+	if err != nil {
+		return err
+	}
+	scoped, err := a.tenantCollections(splitList(*collections), func(name string) bool {
+		_, ok := archive.Metadata().Find(name)
+		return ok
+	})
+	if err != nil {
+		return err
+	}
+	opts := backup.RestoreOptions{Options: backup.Options{Collections: scoped}, Mode: restoreMode}
+
+	enc := json.NewEncoder(a.stdout)
+	if *verifyOnly {
+		if err := archive.Verify(opts.Options); err != nil {
+			return err
+		}
+		enc.SetIndent("", "  ")
+		return enc.Encode(archive.Metadata())
+	}
+
+	if a.db == nil {
+		return errors.New("backups only apply to the mongo store")
+	}
+	name := *target
+	switch {
+	case a.tenant != nil && name != "":
+		return errors.New("-target cannot be used with -tenant, which restores into the database of the tenant")
+	case a.tenant != nil:
+		name = a.db.Name()
+	case name == "":
+		name = archive.Metadata().Database
+	}
+
+	results, err := backup.Restore(ctx, a.db.Client().Database(name), archive, opts)
+	for _, r := range results {
+		enc.Encode(r)
+	}
+	return err
+}
+
+// splitList splits a comma-separated flag value, returning nil for "".
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// filterFlags registers the user filter flags on fs. The returned function
+// builds the filter once the flags have been parsed.
+func filterFlags(fs *flag.FlagSet) func() (userstore.Filter, error) {
+	name := fs.String("name", "", "only users with this name")
+	gender := fs.String("gender", "", "only users with this gender")
+	minAge := fs.Int("min-age", -1, "only users at least this old")
+	maxAge := fs.Int("max-age", -1, "only users at most this old")
+	deleted := fs.String("deleted", "exclude", "users in the trash: exclude, only or include")
+	where := fs.String("where", "", `only users matching a query such as 'age >= 18 AND name ~ "^Sau"'`)
+
+	return func() (userstore.Filter, error) {
+		f := userstore.Filter{Name: *name}
+		var err error
+		if f.Deleted, err = userstore.ParseDeletedFilter(*deleted); err != nil {
+			return f, err
+		}
+		if *where != "" {
+			if f.Where, err = query.Parse(*where); err != nil {
+				return f, err
+			}
+		}
+		if *gender != "" {
+			g, err := userstore.ParseGender(*gender)
+			if err != nil {
+				return f, err
+			}
+			f.Gender = g
+		}
+		if *minAge >= 0 {
+			age := userstore.Age(*minAge)
+			f.MinAge = &age
+		}
+		if *maxAge >= 0 {
+			age := userstore.Age(*maxAge)
+			f.MaxAge = &age
+		}
+		return f, nil
+	}
+}
+
+// versionFlag registers -if-version on fs. The returned function reports the
+// version the write must find, once the flags have been parsed.
+func versionFlag(fs *flag.FlagSet) func() userstore.Expect {
+	version := fs.Int64("if-version", -1, "only write while the user is at this version (see get)")
+
+	return func() userstore.Expect {
+		if *version < 0 {
+			return userstore.AnyVersion
+		}
+		return userstore.AtVersion(*version)
+	}
+}
+
+// newFlagSet returns a flag set for a subcommand that reports errors instead of exiting.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("userctl "+name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	return fs
+}
+
+// parseFlags parses args and checks that exactly nargs positional arguments remain.
+func parseFlags(fs *flag.FlagSet, args []string, nargs int) error {
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() != nargs {
+		fmt.Fprintf(os.Stderr, "%s: expected %d argument(s), got %d\n", fs.Name(), nargs, fs.NArg())
+		return errUsage
+	}
+	return nil
+}
+
+// lookup finds the user named by -name or by the single positional id argument.
+func lookup(ctx context.Context, a *app, fs *flag.FlagSet, name string) (*userstore.User, error) {
+	switch {
+	case name != "" && fs.NArg() == 0:
+		return a.repo.FindByName(ctx, name)
+	case name == "" && fs.NArg() == 1:
+		id, err := parseID(fs.Arg(0))
+		if err != nil {
+			return nil, err
+		}
+		return a.repo.Get(ctx, id)
+	default:
+		fmt.Fprintf(os.Stderr, "%s: pass either an id or -name\n", fs.Name())
+		return nil, errUsage
+	}
+}
+
+// parseID parses a hex ObjectID given on the command line.
+func parseID(s string) (primitive.ObjectID, error) {
+	id, err := userstore.ParseID(s)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "userctl: invalid id %q\n", s)
+		return primitive.NilObjectID, errUsage
+	}
+	return id, nil
+}
+
+// decodeInput decodes a single JSON value from -data or stdin into v.
+func decodeInput(a *app, data string, v any) error {
+	dec := json.NewDecoder(inputReader(a, data))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("decode input: %w", err)
+	}
+	return nil
+}
+
+// inputReader returns the -data value when set and stdin otherwise.
+func inputReader(a *app, data string) io.Reader {
+	if data != "" {
+		return strings.NewReader(data)
+	}
+	return a.stdin
+}
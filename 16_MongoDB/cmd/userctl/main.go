@@ -0,0 +1,428 @@
+// Command userctl manages the user collection without interactive prompts.
+//
+// Usage:
+//
+//	userctl [-config FILE] [-uri URI] [-store mongo|file] [-data-dir DIR] [-db NAME] [-collection NAME]
+//	        [-actor NAME] [-audit-collection NAME | -audit-file PATH] [-keyring FILE]
+//	        [-tenant ID] [-ttl DURATION] <command> [flags] [args]
+//
+// Commands:
+//
+//	insert            insert the JSON users read from -data or stdin
+//	get <id>          print one user (or: get -name NAME)
+//	list              print users, one JSON object per line (see list -h)
+//	update <id>       apply the JSON update ($set, $unset, $inc) read from -data or stdin
+//	replace <id>      replace a user by the JSON user read from -data or stdin
+//	upsert            replace the users with the same name, or insert them
+//	delete <id>       move one user to the trash (or: delete -name NAME; -hard to delete permanently)
+//	restore <id>      take a user out of the trash
+//	purge             permanently delete users in the trash for longer than -older-than
+//	expire            permanently delete the users whose expiresAt has passed, or print the server's TTL -stats
+//	count             print the number of users
+//	import [file]     import users from CSV or JSON Lines and print a JSON report
+//	export            write the filtered users to stdout as CSV or JSON Lines
+//	seed              insert -count synthetic users generated from -seed, or print them with -dry-run
+//	migrate           apply schema migrations up to -to (default latest), or print -status
+//	history <id>      print the audit entries of a user, or its state at -at TIME
+//	report <kind>     print user counts per -by value (groups), per age bucket (histogram), or age stats
+//	search <query>    print the users whose name or attributes best match the query (see search -h)
+//	keys <action>     create a keyring (init FILE), list its keys, rotate them or reencrypt the users
+//	tenants <action>  list the tenants, provision [-mode database|collection] ID, or decommission -yes ID
+//	outbox <action>   list, drop or replay the changes 1_connection.go queued in the outbox -dir
+//	blobs <action>    put -user ID FILE, get [-o FILE] ID, list -user ID, delete ID, or sweep: files attached to users
+//	backup <file>     write every collection of -db, as of one point in time, to a compressed,
+//	                  checksummed archive; -no-snapshot for a standalone server
+//	restore-backup <file>
+//	                  verify an archive and restore it into -target (default: its own database)
+//
+// list and export select users with -name, -gender, -min-age and -max-age,
+// or with a query such as -where 'age >= 18 AND gender IN ("M", "F")' (see
+// package query). A query that does not parse exits with 2 and points at the
+// offending position.
+//
+// seed generates users with names from built-in lists, ages drawn from
+// -ages and genders in the -genders mix, the same ones for the same -seed.
+// It writes them in batches from -workers goroutines at up to -rate users a
+// second and prints the throughput and the batch latency percentiles, so it
+// fills development databases and load tests them alike. Loading a seed
+// again counts the users already there as duplicates.
+//
+// Users are printed as JSON, one object per line, so the output can be piped
+// back into insert or processed with tools such as jq.
+//
+// update, replace, delete and restore take -if-version N to only write a user
+// that is still at the version printed by get; otherwise they exit with 4.
+//
+// Each operation on the users gives up after the operationTimeout of the
+// connection settings (see package mongoconn) and exits with 5; interrupting
+// userctl aborts the operation in flight.
+//
+// With -store file the users are kept in the embedded store under -data-dir
+// instead of MongoDB, and migrations and backups do not apply.
+//
+// With -keyring the Age and Gender fields named in the keyring are encrypted
+// at rest (see package fieldcrypt). Encrypted fields cannot be sorted or
+// filtered by range, and reports on them are computed here rather than on
+// the server. After keys rotate, the users are re-encrypted with the new key.
+//
+// With -tenant every command works on the collections of that tenant only:
+// its database, or the collections of -db prefixed with "<tenant>_",
+// depending on how it was provisioned. Backups of a tenant that shares -db
+// only hold its own collections.
+//
+// Users carrying an expiresAt are removed once it has passed: by the TTL
+// index of MongoDB within about a minute, and by expire on either store.
+// With -ttl the users inserted, imported or upserted without one expire that
+// long after.
+//
+// Files such as profile photos are attached to users with blobs, which keeps
+// them in the GridFS bucket user_blobs of the database, or under blobs in the
+// directory of the file store. delete -hard, purge and expire delete the
+// blobs of the users they remove; a user in the trash keeps them in case it
+// is restored. blobs sweep deletes the blobs left by users that MongoDB
+// removed with the TTL index.
+//
+// Every change is recorded as made by -actor (default: the operating system
+// user) in the -audit-collection of the database, or in -audit-file instead.
+// The file store keeps its audit log in <-audit-collection>.jsonl next to the data.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/audit"
+	"github.com/saurabhkk55/Go/16_MongoDB/blobstore"
+	"github.com/saurabhkk55/Go/16_MongoDB/expiry"
+	"github.com/saurabhkk55/Go/16_MongoDB/fieldcrypt"
+	"github.com/saurabhkk55/Go/16_MongoDB/filestore"
+	"github.com/saurabhkk55/Go/16_MongoDB/migrate"
+	"github.com/saurabhkk55/Go/16_MongoDB/mongoconn"
+	"github.com/saurabhkk55/Go/16_MongoDB/query"
+	"github.com/saurabhkk55/Go/16_MongoDB/report"
+	"github.com/saurabhkk55/Go/16_MongoDB/search"
+	"github.com/saurabhkk55/Go/16_MongoDB/tenant"
+	"github.com/saurabhkk55/Go/16_MongoDB/userstore"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Exit codes returned by userctl.
+const (
+	exitOK       = 0
+	exitError    = 1
+	exitUsage    = 2
+	exitNotFound = 3
+	exitConflict = 4
+	exitTimeout  = 5
+)
+
+// errUsage is returned by commands that were called with bad arguments.
+var errUsage = errors.New("usage error")
+
+// command is a single userctl subcommand.
+type command struct {
+	name    string
+	summary string
+	run     func(ctx context.Context, app *app, args []string) error
+}
+
+var commands = []command{
+	{"insert", "insert the JSON users read from -data or stdin", runInsert},
+	{"get", "print one user by id or -name", runGet},
+	{"list", "print every user", runList},
+	{"update", "apply a JSON update ($set, $unset, $inc) to the user with the given id", runUpdate},
+	{"replace", "replace the user with the given id by a JSON user", runReplace},
+	{"upsert", "replace the JSON users with the same name, or insert them", runUpsert},
+	{"delete", "move one user by id or -name to the trash, or delete it permanently with -hard", runDelete},
+	{"restore", "take the user with the given id out of the trash", runRestore},
+	{"purge", "permanently delete users in the trash for longer than -older-than", runPurge},
+	{"expire", "permanently delete the users whose expiresAt has passed, or print the server's TTL -stats", runExpire},
+	{"count", "print the number of users", runCount},
+	{"import", "import users from a CSV or JSON Lines file", runImport},
+	{"export", "export the filtered users as CSV or JSON Lines", runExport},
+	{"seed", "insert -count synthetic users generated from -seed and report the throughput", runSeed},
+	{"migrate", "apply or roll back schema migrations, or print their -status", runMigrate},
+	{"history", "print the audit entries of a user, or its state at -at TIME", runHistory},
+	{"report", "print groups, histogram or stats reports as a table, CSV or JSON", runReport},
+	{"search", "print the users best matching the words of a query, ignoring case and accents", runSearch},
+	{"keys", "create a keyring, list or rotate its keys, or re-encrypt the users with it", runKeys},
+	{"tenants", "list, provision or decommission the tenants of the database", runTenants},
+	{"outbox", "list, drop or replay the changes queued while MongoDB was unreachable", runOutbox},
+	{"blobs", "store, fetch, list or delete the files attached to users", runBlobs},
+	{"backup", "write every collection of the database to an archive file", runBackup},
+	{"restore-backup", "verify an archive file and restore it, skipping or overwriting existing documents", runRestoreBackup},
+}
+
+// warnf reports a problem that does not fail the command on stderr.
+func warnf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "userctl: "+format+"\n", args...)
+}
+
+// app carries what every command needs.
+type app struct {
+	repo       userstore.Repository
+	db         *mongo.Database // nil for the file store
+	collection string
+	migrator   *migrate.Runner // nil for the file store
+	audit      audit.Log
+	reporter   report.Reporter
+	searcher   search.Searcher
+	blobs      blobstore.Store
+	cascade    *blobstore.Repository // deletes the blobs of removed users
+	stdin      io.Reader
+	stdout     io.Writer
+
+	// For the mongo store; tenant is set with -tenant only.
+	router *tenant.Router
+	tenant *tenant.Tenant
+
+	// Set with -keyring only.
+	keyring     *fieldcrypt.Keyring
+	keyringPath string
+	encrypter   *fieldcrypt.Encrypter
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+// run parses the global flags, opens the store and dispatches to a command.
+func run(args []string) int {
+	global := flag.NewFlagSet("userctl", flag.ContinueOnError)
+	configPath := global.String("config", "", "MongoDB config file (default $MONGODB_CONFIG)")
+	uri := global.String("uri", "", "MongoDB connection URI (overrides the config)")
+	store := global.String("store", "mongo", "storage backend: mongo or file")
+	dataDir := global.String("data-dir", "data", "directory of the file store; each -db is a subdirectory")
+	dbName := global.String("db", "db_san", "database name")
+	collectionName := global.String("collection", "col_san", "collection name")
+	actor := global.String("actor", audit.SystemActor(), "name recorded in the audit log")
+	auditCollection := global.String("audit-collection", audit.DefaultCollection, "collection of the audit log")
+	auditFile := global.String("audit-file", "", "append the audit log to this file instead of a collection")
+	keyringPath := global.String("keyring", "", "keyring file of the encrypted fields (mongo store only)")
+	tenantID := global.String("tenant", "", "work on the users of this tenant (mongo store only)")
+	ttl := global.Duration("ttl", 0, "expire the users written without an expiresAt this long after; 0 keeps them")
+	global.Usage = func() { usage(global) }
+
+	if err := global.Parse(args); err != nil {
+		return exitUsage
+	}
+	if global.NArg() == 0 {
+		usage(global)
+		return exitUsage
+	}
+
+	cmd, ok := findCommand(global.Arg(0))
+	if !ok {
+		fmt.Fprintf(os.Stderr, "userctl: unknown command %q\n", global.Arg(0))
+		usage(global)
+		return exitUsage
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ctx = audit.WithActor(ctx, *actor)
+
+	var a *app
+	switch *store {
+	case "mongo":
+		cfg, err := mongoconn.Load(*configPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "userctl:", err)
+			return exitUsage
+		}
+		if *uri != "" {
+			cfg.URI = *uri
+		}
+
+		client, err := mongoconn.Connect(ctx, cfg, warnf)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "userctl:", err)
+			return exitError
+		}
+		defer client.Close(context.Background())
+
+		db := client.Database(*dbName)
+		router := tenant.NewRouter(client.Client, *dbName, *collectionName, *auditCollection)
+		var t *tenant.Tenant
+		if *tenantID != "" {
+			// From here on, every collection is the tenant's own.
+			if t, err = router.Lookup(ctx, *tenantID); err != nil {
+				fmt.Fprintln(os.Stderr, "userctl:", err)
+				if errors.Is(err, tenant.ErrUnknown) {
+					return exitNotFound
+				}
+				return exitError
+			}
+			db = router.Locate(t).DB
+			*collectionName, *auditCollection = router.Collections(t)
+			ctx = tenant.WithID(ctx, t.ID)
+		}
+
+		var migrator *migrate.Runner
+		if t != nil {
+			migrator, err = router.Migrator(t)
+		} else {
+			migrator, err = migrate.NewRunner(db, userstore.Migrations(*collectionName))
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "userctl:", err)
+			return exitError
+		}
+		repo := userstore.NewMongoRepository(db.Collection(*collectionName))
+		repo.SetTimeout(cfg.OperationTimeout)
+		a = &app{
+			router:     router,
+			tenant:     t,
+			repo:       repo,
+			db:         db,
+			collection: *collectionName,
+			migrator:   migrator,
+			audit:      audit.NewMongoLog(db.Collection(*auditCollection)),
+			reporter:   report.NewMongoReporter(db.Collection(*collectionName)),
+			searcher:   search.NewMongoSearcher(db.Collection(*collectionName)),
+		}
+		bucket := blobstore.DefaultBucket
+		if t != nil {
+			bucket = router.Locate(t).Name(bucket)
+		}
+		blobs, err := blobstore.NewGridFSStore(db, bucket)
+		if err == nil {
+			err = blobs.EnsureIndexes(ctx)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "userctl:", err)
+			return exitError
+		}
+		a.blobs = blobs
+
+		if *keyringPath != "" {
+			ring, err := fieldcrypt.LoadKeyring(*keyringPath)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "userctl:", err)
+				return exitError
+			}
+			enc, err := fieldcrypt.NewEncrypter(ring)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "userctl:", err)
+				return exitError
+			}
+			repo := enc.NewMongoRepository(db, *collectionName)
+			repo.SetTimeout(cfg.OperationTimeout)
+			a.repo = repo
+			a.keyring, a.keyringPath, a.encrypter = ring, *keyringPath, enc
+			// Audit entries hold whole users, so they are encrypted too.
+			a.audit = audit.NewMongoLog(enc.Collection(db, *auditCollection))
+			// The server cannot aggregate encrypted values, so reports decrypt them here.
+			a.reporter = report.NewMemoryReporter(repo)
+			a.searcher = search.NewMongoSearcher(enc.Collection(db, *collectionName))
+		}
+
+	case "file":
+		db, err := filestore.Open(filepath.Join(*dataDir, *dbName))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "userctl:", err)
+			return exitError
+		}
+		defer db.Close()
+
+		coll, err := db.Collection(*collectionName)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "userctl:", err)
+			return exitError
+		}
+
+		if *keyringPath != "" || *tenantID != "" {
+			fmt.Fprintln(os.Stderr, "userctl: -keyring and -tenant only apply to the mongo store")
+			return exitUsage
+		}
+
+		// There is no audit collection, so the log defaults to a file next to the data.
+		if *auditFile == "" {
+			*auditFile = filepath.Join(db.Dir(), *auditCollection+".jsonl")
+		}
+		repo, err := userstore.NewFileRepository(coll)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "userctl:", err)
+			return exitError
+		}
+		a = &app{repo: repo, reporter: report.NewMemoryReporter(repo), searcher: search.NewMemorySearcher(repo)}
+		if a.blobs, err = blobstore.NewDirStore(filepath.Join(db.Dir(), "blobs")); err != nil {
+			fmt.Fprintln(os.Stderr, "userctl:", err)
+			return exitError
+		}
+
+	default:
+		fmt.Fprintf(os.Stderr, "userctl: unknown store %q, want mongo or file\n", *store)
+		return exitUsage
+	}
+
+	if *auditFile != "" {
+		fileLog, err := audit.OpenFileLog(*auditFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "userctl:", err)
+			return exitError
+		}
+		defer fileLog.Close()
+		a.audit = fileLog
+	}
+	audited := audit.NewRepository(a.repo, a.audit)
+	audited.SetLogf(warnf)
+	a.cascade = blobstore.NewRepository(audited, a.blobs, warnf)
+	a.repo = a.cascade
+	if *ttl > 0 {
+		a.repo = expiry.NewRepository(a.repo, *ttl)
+	}
+	a.stdin, a.stdout = os.Stdin, os.Stdout
+
+	err := cmd.run(ctx, a, global.Args()[1:])
+	var syntax *query.SyntaxError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, errUsage):
+		return exitUsage
+	case errors.Is(err, userstore.ErrNotFound), errors.Is(err, tenant.ErrUnknown), errors.Is(err, blobstore.ErrNotFound):
+		fmt.Fprintln(os.Stderr, "userctl:", err)
+		return exitNotFound
+	case errors.Is(err, userstore.ErrConflict):
+		fmt.Fprintln(os.Stderr, "userctl:", err)
+		return exitConflict
+	case errors.Is(err, userstore.ErrTimeout):
+		fmt.Fprintln(os.Stderr, "userctl:", err)
+		return exitTimeout
+	case errors.As(err, &syntax):
+		fmt.Fprintln(os.Stderr, "userctl:", err)
+		fmt.Fprintln(os.Stderr, syntax.Caret())
+		return exitUsage
+	default:
+		fmt.Fprintln(os.Stderr, "userctl:", err)
+		return exitError
+	}
+}
+
+// findCommand looks up a command by name.
+func findCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+// usage prints the global flags and the list of commands.
+func usage(global *flag.FlagSet) {
+	fmt.Fprintln(os.Stderr, "Usage: userctl [flags] <command> [command flags] [args]")
+	fmt.Fprintln(os.Stderr, "\nFlags:")
+	global.PrintDefaults()
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-14s %s\n", c.name, c.summary)
+	}
+}
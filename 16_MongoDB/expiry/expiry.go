@@ -0,0 +1,106 @@
+// Package expiry removes users once their ExpiresAt has passed.
+//
+// A user expires at the time in its ExpiresAt field, which can be set when it
+// is written or later with an update, and cleared to keep it. Repository sets
+// it on the users created through it, so that every user of a collection
+// expires a fixed time after it was written.
+//
+// MongoDB removes expired users by itself through the TTL index created by
+// the userstore migrations, within about a minute; ServerStats reports what
+// its TTL monitor has removed from every collection of the server. The
+// in-memory and file stores have no such thing, so a Sweeper calls Expire
+// periodically and counts what it removed. Sweep through the audited and
+// cached repository, not the store under it, so that the removals are
+// recorded and the cache forgets the users.
+package expiry
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/userstore"
+)
+
+// DefaultInterval is how often a Sweeper runs when nothing else is configured,
+// which is about as often as the MongoDB TTL monitor.
+const DefaultInterval = time.Minute
+
+// Expirer permanently removes the users whose ExpiresAt is not after now.
+// Every userstore.Repository implements it.
+type Expirer interface {
+	Expire(ctx context.Context, now time.Time) (int64, error)
+}
+
+var _ Expirer = userstore.Repository(nil)
+
+// Stats counts what a Sweeper has done since it was created.
+type Stats struct {
+	// Expired is the number of users removed.
+	Expired int64 `json:"expired"`
+
+	Sweeps   int64 `json:"sweeps"`
+	Failures int64 `json:"failures"`
+
+	// LastSweep is when the last sweep finished, successful or not.
+	LastSweep time.Time `json:"lastSweep"`
+}
+
+// Sweeper removes expired users from a store without a TTL index.
+// It is safe for concurrent use.
+type Sweeper struct {
+	store Expirer
+
+	mu    sync.Mutex
+	stats Stats
+}
+
+// NewSweeper returns a Sweeper removing the expired users of store.
+func NewSweeper(store Expirer) *Sweeper {
+	return &Sweeper{store: store}
+}
+
+// Sweep removes the users that have expired by now and returns how many.
+func (s *Sweeper) Sweep(ctx context.Context) (int64, error) {
+	n, err := s.store.Expire(ctx, time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Sweeps++
+	s.stats.Expired += n
+	if err != nil {
+		s.stats.Failures++
+	}
+	s.stats.LastSweep = time.Now()
+	return n, err
+}
+
+// Run sweeps once straight away and then every interval until ctx is done.
+// Failures are passed to logf and retried on the next run.
+func (s *Sweeper) Run(ctx context.Context, interval time.Duration, logf func(format string, args ...any)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := s.Sweep(ctx)
+		switch {
+		case err != nil && ctx.Err() == nil:
+			logf("expiry: sweep: %v", err)
+		case n > 0:
+			logf("expiry: removed %d expired user(s)", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Stats returns the statistics of the sweeper.
+func (s *Sweeper) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
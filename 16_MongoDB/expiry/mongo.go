@@ -0,0 +1,33 @@
+package expiry
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// TTLStats is what the TTL monitor of a MongoDB server has done since it
+// started, over every TTL index of the server, not only that of the users.
+type TTLStats struct {
+	// DeletedDocuments is the number of documents removed for having expired.
+	DeletedDocuments int64 `bson:"deletedDocuments" json:"deletedDocuments"`
+
+	// Passes is the number of times the monitor looked for expired documents.
+	Passes int64 `bson:"passes" json:"passes"`
+}
+
+// ServerStats returns the TTL statistics of the server db is on.
+func ServerStats(ctx context.Context, db *mongo.Database) (*TTLStats, error) {
+	cmd := bson.D{{Key: "serverStatus", Value: 1}}
+	var status struct {
+		Metrics struct {
+			TTL TTLStats `bson:"ttl"`
+		} `bson:"metrics"`
+	}
+	if err := db.RunCommand(ctx, cmd).Decode(&status); err != nil {
+		return nil, fmt.Errorf("expiry: server status: %w", err)
+	}
+	return &status.Metrics.TTL, nil
+}
@@ -0,0 +1,59 @@
+package expiry
+
+import (
+	"context"
+	"time"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/userstore"
+)
+
+// Repository is a userstore.Repository that gives the users written by
+// Create, CreateMany and Upsert an ExpiresAt of TTL from now, unless they
+// have one. An upsert replacing a user thus pushes its expiry back.
+// Replace and Update leave the expiry alone.
+type Repository struct {
+	userstore.Repository
+	ttl time.Duration
+}
+
+var _ userstore.Repository = (*Repository)(nil)
+
+// NewRepository returns a Repository that makes the users written through
+// repo expire ttl after they were written.
+func NewRepository(repo userstore.Repository, ttl time.Duration) *Repository {
+	return &Repository{Repository: repo, ttl: ttl}
+}
+
+// TTL returns how long after they were written the users expire.
+func (r *Repository) TTL() time.Duration {
+	return r.ttl
+}
+
+// Create stores u, expiring it after the TTL.
+func (r *Repository) Create(ctx context.Context, u *userstore.User) error {
+	r.stamp(u, time.Now())
+	return r.Repository.Create(ctx, u)
+}
+
+// CreateMany stores users, expiring them after the TTL.
+func (r *Repository) CreateMany(ctx context.Context, users []*userstore.User) (map[int]error, error) {
+	now := time.Now()
+	for _, u := range users {
+		r.stamp(u, now)
+	}
+	return r.Repository.CreateMany(ctx, users)
+}
+
+// Upsert replaces or inserts the user with the name of u, expiring it after the TTL.
+func (r *Repository) Upsert(ctx context.Context, u *userstore.User) (*userstore.UpdateResult, error) {
+	r.stamp(u, time.Now())
+	return r.Repository.Upsert(ctx, u)
+}
+
+// stamp sets the expiry of u, unless it has one.
+func (r *Repository) stamp(u *userstore.User, now time.Time) {
+	if u.ExpiresAt == nil {
+		expiresAt := now.Add(r.ttl).UTC()
+		u.ExpiresAt = &expiresAt
+	}
+}
@@ -0,0 +1,207 @@
+// Package fieldcrypt encrypts the Age and Gender of users at rest with
+// AES-256-GCM.
+//
+// The encryption happens in the BSON codecs of the collection, so a
+// MongoRepository built by NewMongoRepository writes ciphertext and reads
+// plaintext without knowing about keys. Every process writing to the
+// collection must use the same keyring.
+//
+// An encrypted value is stored as BSON binary data of the user-defined
+// subtype 0x80:
+//
+//	version (1) | mode (1) | key ID length (1) | key ID | nonce (12) | AES-GCM ciphertext and tag
+//
+// The plaintext is the BSON value the field would have had. The field name
+// is authenticated with it, and so is the _id of the user for a randomized
+// field, so a value cannot be moved to another field or another user.
+//
+// A randomized field gets a random nonce, so equal values look different. A
+// deterministic field derives the nonce from the key, the field and the
+// value, so equal values encrypted with the same key are equal and can be
+// looked up with an equality filter. That also reveals which users share a
+// value, which is why it is opt-in per field. For the same reason its value
+// cannot be bound to the user: another user's value for the field can be
+// copied over it unnoticed.
+//
+// Values of version 1 authenticate only the field name. They are still
+// read; Reencrypt rewrites them.
+package fieldcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/userstore"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Subtype is the BSON binary subtype of encrypted values.
+const Subtype = bsontype.BinaryUserDefined
+
+// Format bytes of an encrypted value.
+const (
+	formatVersion = 2
+	fieldOnly     = 1 // the version whose values authenticate only the field name
+
+	modeRandomized    = 1
+	modeDeterministic = 2
+)
+
+// ErrUnknownKey is wrapped by the errors for values encrypted with a key that
+// is not in the keyring.
+var ErrUnknownKey = errors.New("fieldcrypt: unknown key")
+
+// Encrypter encrypts and decrypts field values with the keys of a keyring.
+type Encrypter struct {
+	ring  *Keyring
+	keys  map[string]*derivedKey
+	modes map[string]userstore.Encryption
+}
+
+// derivedKey holds the keys derived from one keyring key, so that the key
+// used for the nonces of deterministic values is not the encryption key.
+type derivedKey struct {
+	id       string
+	aead     cipher.AEAD
+	nonceKey []byte
+}
+
+// NewEncrypter returns an Encrypter for the fields and keys of ring.
+func NewEncrypter(ring *Keyring) (*Encrypter, error) {
+	if err := ring.validate(); err != nil {
+		return nil, fmt.Errorf("fieldcrypt: %w", err)
+	}
+
+	e := &Encrypter{ring: ring, keys: map[string]*derivedKey{}, modes: map[string]userstore.Encryption{}}
+	for _, key := range ring.Keys {
+		block, err := aes.NewCipher(derive(key.Secret, "fieldcrypt encryption"))
+		if err != nil {
+			return nil, fmt.Errorf("fieldcrypt: key %s: %w", key.ID, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("fieldcrypt: key %s: %w", key.ID, err)
+		}
+		e.keys[key.ID] = &derivedKey{id: key.ID, aead: aead, nonceKey: derive(key.Secret, "fieldcrypt nonce")}
+	}
+	for field, mode := range ring.Fields {
+		if mode != userstore.Plaintext {
+			e.modes[field] = mode
+		}
+	}
+	return e, nil
+}
+
+// Mode returns how field is encrypted.
+func (e *Encrypter) Mode(field string) userstore.Encryption {
+	return e.modes[field]
+}
+
+// Encrypt encrypts the BSON value of field, given by its type and bytes, with
+// the active key, for the user with the given ID.
+func (e *Encrypter) Encrypt(field string, id primitive.ObjectID, t bsontype.Type, value []byte) ([]byte, error) {
+	mode := e.modes[field]
+	if mode == userstore.Plaintext {
+		return nil, fmt.Errorf("fieldcrypt: field %s is not encrypted", field)
+	}
+	key := e.keys[e.ring.Active]
+	plaintext := append([]byte{byte(t)}, value...)
+
+	out := []byte{formatVersion, modeRandomized, byte(len(key.id))}
+	out = append(out, key.id...)
+
+	nonce := make([]byte, key.aead.NonceSize())
+	if mode == userstore.Deterministic {
+		out[1] = modeDeterministic
+		mac := hmac.New(sha256.New, key.nonceKey)
+		mac.Write([]byte(field))
+		mac.Write([]byte{0})
+		mac.Write(plaintext)
+		copy(nonce, mac.Sum(nil))
+	} else if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("fieldcrypt: %w", err)
+	}
+	out = append(out, nonce...)
+	return key.aead.Seal(out, nonce, plaintext, additional(formatVersion, out[1], field, id)), nil
+}
+
+// Decrypt decrypts a value of field produced by Encrypt for the user with
+// the given ID and returns the BSON type and bytes of the plaintext value.
+func (e *Encrypter) Decrypt(field string, id primitive.ObjectID, data []byte) (bsontype.Type, []byte, error) {
+	h, err := parseHeader(data)
+	if err != nil {
+		return 0, nil, fmt.Errorf("fieldcrypt: %s: %w", field, err)
+	}
+	key, ok := e.keys[h.keyID]
+	if !ok {
+		return 0, nil, fmt.Errorf("%w %q in %s", ErrUnknownKey, h.keyID, field)
+	}
+
+	rest := data[h.size:]
+	if len(rest) < key.aead.NonceSize()+key.aead.Overhead()+1 {
+		return 0, nil, fmt.Errorf("fieldcrypt: %s: value is truncated", field)
+	}
+	nonce, sealed := rest[:key.aead.NonceSize()], rest[key.aead.NonceSize():]
+	plaintext, err := key.aead.Open(nil, nonce, sealed, additional(h.version, h.mode, field, id))
+	if err != nil {
+		return 0, nil, fmt.Errorf("fieldcrypt: %s: %w", field, err)
+	}
+	return bsontype.Type(plaintext[0]), plaintext[1:], nil
+}
+
+// additional returns the data authenticated with a value of field: the field
+// name, followed by the user ID unless the value is deterministic or of the
+// version that did not bind it.
+func additional(version, mode byte, field string, id primitive.ObjectID) []byte {
+	ad := []byte(field)
+	if version == fieldOnly || mode == modeDeterministic {
+		return ad
+	}
+	ad = append(ad, 0)
+	return append(ad, id[:]...)
+}
+
+// current reports whether data was encrypted the way field is encrypted now:
+// in the current format, with the active key and the configured mode.
+func (e *Encrypter) current(field string, data []byte) bool {
+	h, err := parseHeader(data)
+	if err != nil || h.version != formatVersion || h.keyID != e.ring.Active {
+		return false
+	}
+	if e.modes[field] == userstore.Deterministic {
+		return h.mode == modeDeterministic
+	}
+	return h.mode == modeRandomized
+}
+
+// header is the unencrypted start of a value.
+type header struct {
+	version byte
+	mode    byte
+	keyID   string
+	size    int
+}
+
+func parseHeader(data []byte) (*header, error) {
+	if len(data) < 3 || (data[0] != formatVersion && data[0] != fieldOnly) {
+		return nil, errors.New("not an encrypted value of a known format")
+	}
+	n := int(data[2])
+	if len(data) < 3+n {
+		return nil, errors.New("value is truncated")
+	}
+	return &header{version: data[0], mode: data[1], keyID: string(data[3 : 3+n]), size: 3 + n}, nil
+}
+
+// derive derives a purpose-specific key from a keyring secret.
+func derive(secret []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,232 @@
+package fieldcrypt
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/userstore"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/bsonrw"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// fieldTypes maps the encryptable fields to the Go types that hold them. The
+// codecs are registered per type, which is what makes the field known.
+var fieldTypes = map[string]reflect.Type{
+	"age":    reflect.TypeOf(userstore.Age(0)),
+	"gender": reflect.TypeOf(userstore.Gender("")),
+}
+
+// fieldKeys maps the encryptable fields to their keys in user documents.
+var fieldKeys = map[string]string{
+	"age":    "Age",
+	"gender": "Gender",
+}
+
+var userType = reflect.TypeOf(userstore.User{})
+
+// Registry returns the default BSON registry with codecs that encrypt the
+// configured fields of users when they are written and decrypt them when
+// they are read. Plaintext values are still read, and so are encrypted
+// values of fields that are no longer encrypted, so a collection can change
+// gradually; see Reencrypt.
+//
+// Users are encrypted whole, as that is where the _id their values are
+// bound to is known. A deterministic value does not depend on the user, so
+// filter values go through codecs of their own, and an equality filter on a
+// deterministic field matches the values encrypted with the active key. A
+// randomized value cannot be written outside its user.
+func (e *Encrypter) Registry() *bsoncodec.Registry {
+	reg := bson.NewRegistry()
+	for field, typ := range fieldTypes {
+		if e.Mode(field) != userstore.Plaintext {
+			reg.RegisterTypeEncoder(typ, e.encoder(field, typ))
+		}
+		reg.RegisterTypeDecoder(typ, e.decoder(field, typ))
+	}
+	reg.RegisterTypeEncoder(userType, e.userEncoder())
+	reg.RegisterTypeDecoder(userType, e.userDecoder())
+	return reg
+}
+
+// Collection returns the named collection of db with the codecs of Registry.
+func (e *Encrypter) Collection(db *mongo.Database, name string) *mongo.Collection {
+	return db.Collection(name, options.Collection().SetRegistry(e.Registry()))
+}
+
+// NewMongoRepository returns a MongoRepository over the named collection of db
+// that encrypts the configured fields and refuses the queries the server
+// cannot answer on them.
+func (e *Encrypter) NewMongoRepository(db *mongo.Database, name string) *userstore.MongoRepository {
+	repo := userstore.NewMongoRepository(e.Collection(db, name))
+	for field, mode := range e.modes {
+		repo.SetEncryption(field, mode)
+	}
+	return repo
+}
+
+// encoder encodes a value of typ outside a user, as in a filter, as the
+// default registry would and encrypts the result.
+func (e *Encrypter) encoder(field string, typ reflect.Type) bsoncodec.ValueEncoder {
+	plain := bson.NewRegistry()
+	return bsoncodec.ValueEncoderFunc(func(ec bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+		if val.Type() != typ {
+			return bsoncodec.ValueEncoderError{Name: field + "Encoder", Types: []reflect.Type{typ}, Received: val}
+		}
+		if e.Mode(field) != userstore.Deterministic {
+			return fmt.Errorf("fieldcrypt: %s is encrypted for each user and can only be written with its user", field)
+		}
+		t, value, err := bson.MarshalValueWithRegistry(plain, val.Interface())
+		if err != nil {
+			return err
+		}
+		ciphertext, err := e.Encrypt(field, primitive.NilObjectID, t, value)
+		if err != nil {
+			return err
+		}
+		return vw.WriteBinaryWithSubtype(ciphertext, Subtype)
+	})
+}
+
+// decoder decrypts an encrypted value read outside a user and decodes the
+// plaintext with the type's own UnmarshalBSONValue, which also handles
+// plaintext values. A value bound to its user cannot be decrypted this way.
+func (e *Encrypter) decoder(field string, typ reflect.Type) bsoncodec.ValueDecoder {
+	return bsoncodec.ValueDecoderFunc(func(dc bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+		if !val.CanSet() || val.Type() != typ {
+			return bsoncodec.ValueDecoderError{Name: field + "Decoder", Types: []reflect.Type{typ}, Received: val}
+		}
+		t, value, err := bsonrw.Copier{}.CopyValueToBytes(vr)
+		if err != nil {
+			return err
+		}
+
+		if t == bsontype.Binary {
+			if t, value, err = e.decrypt(field, primitive.NilObjectID, bson.RawValue{Type: t, Value: value}); err != nil {
+				return err
+			}
+		}
+
+		ptr := reflect.New(typ)
+		if err := ptr.Interface().(bson.ValueUnmarshaler).UnmarshalBSONValue(t, value); err != nil {
+			return err
+		}
+		val.Set(ptr.Elem())
+		return nil
+	})
+}
+
+// userEncoder encodes a user as the default registry would and encrypts the
+// configured fields of the result for the user's _id.
+func (e *Encrypter) userEncoder() bsoncodec.ValueEncoder {
+	plain := bson.NewRegistry()
+	return bsoncodec.ValueEncoderFunc(func(ec bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+		if val.Type() != userType {
+			return bsoncodec.ValueEncoderError{Name: "UserEncoder", Types: []reflect.Type{userType}, Received: val}
+		}
+		data, err := bson.MarshalWithRegistry(plain, val.Interface())
+		if err != nil {
+			return err
+		}
+		doc, err := e.mapFields(bson.Raw(data), func(field string, id primitive.ObjectID, v bson.RawValue) (any, error) {
+			if e.Mode(field) == userstore.Plaintext {
+				return v, nil
+			}
+			ciphertext, err := e.Encrypt(field, id, v.Type, v.Value)
+			if err != nil {
+				return nil, err
+			}
+			return primitive.Binary{Subtype: Subtype, Data: ciphertext}, nil
+		})
+		if err != nil {
+			return err
+		}
+		if data, err = bson.MarshalWithRegistry(plain, doc); err != nil {
+			return err
+		}
+		return bsonrw.Copier{}.CopyDocumentFromBytes(vw, data)
+	})
+}
+
+// userDecoder decrypts the encrypted fields of a user document with the
+// document's _id and decodes the result as the default registry would.
+func (e *Encrypter) userDecoder() bsoncodec.ValueDecoder {
+	plain := bson.NewRegistry()
+	return bsoncodec.ValueDecoderFunc(func(dc bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+		if !val.CanSet() || val.Type() != userType {
+			return bsoncodec.ValueDecoderError{Name: "UserDecoder", Types: []reflect.Type{userType}, Received: val}
+		}
+		data, err := bsonrw.Copier{}.CopyDocumentToBytes(vr)
+		if err != nil {
+			return err
+		}
+		doc, err := e.mapFields(bson.Raw(data), func(field string, id primitive.ObjectID, v bson.RawValue) (any, error) {
+			if v.Type != bsontype.Binary {
+				return v, nil
+			}
+			t, value, err := e.decrypt(field, id, v)
+			if err != nil {
+				return nil, err
+			}
+			return bson.RawValue{Type: t, Value: value}, nil
+		})
+		if err != nil {
+			return err
+		}
+		if data, err = bson.MarshalWithRegistry(plain, doc); err != nil {
+			return err
+		}
+
+		var u userstore.User
+		if err := bson.UnmarshalWithRegistry(plain, data, &u); err != nil {
+			return err
+		}
+		val.Set(reflect.ValueOf(u))
+		return nil
+	})
+}
+
+// mapFields returns the elements of the user document raw, with the values
+// of the encryptable fields replaced by what fn makes of them.
+func (e *Encrypter) mapFields(raw bson.Raw, fn func(field string, id primitive.ObjectID, v bson.RawValue) (any, error)) (bson.D, error) {
+	elems, err := raw.Elements()
+	if err != nil {
+		return nil, err
+	}
+	id, _ := raw.Lookup("_id").ObjectIDOK()
+
+	doc := make(bson.D, 0, len(elems))
+	for _, elem := range elems {
+		var value any = elem.Value()
+		if field, ok := fieldAt(elem.Key()); ok {
+			if value, err = fn(field, id, elem.Value()); err != nil {
+				return nil, err
+			}
+		}
+		doc = append(doc, bson.E{Key: elem.Key(), Value: value})
+	}
+	return doc, nil
+}
+
+// decrypt decrypts v, a value of field stored for the user with the given ID.
+func (e *Encrypter) decrypt(field string, id primitive.ObjectID, v bson.RawValue) (bsontype.Type, []byte, error) {
+	subtype, data, ok := v.BinaryOK()
+	if !ok || subtype != Subtype {
+		return 0, nil, fmt.Errorf("fieldcrypt: %s holds binary data of subtype %#x, want %#x", field, subtype, Subtype)
+	}
+	return e.Decrypt(field, id, data)
+}
+
+// fieldAt returns the encryptable field stored under key in user documents.
+func fieldAt(key string) (string, bool) {
+	for field, k := range fieldKeys {
+		if k == key {
+			return field, true
+		}
+	}
+	return "", false
+}
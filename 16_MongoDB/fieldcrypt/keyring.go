@@ -0,0 +1,192 @@
+package fieldcrypt
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/saurabhkk55/Go/11_file_os/fileutil"
+	"github.com/saurabhkk55/Go/16_MongoDB/userstore"
+)
+
+// KeySize is the size of a key secret: AES-256.
+const KeySize = 32
+
+// Key is one key of a Keyring.
+type Key struct {
+	ID      string    `json:"id"`
+	Secret  []byte    `json:"secret"` // base64 in the file
+	Created time.Time `json:"created"`
+}
+
+// Keyring holds the keys and the field settings of an encrypted collection.
+// It is stored as a JSON file that only its owner may read:
+//
+//	{
+//	  "fields": {"age": "randomized", "gender": "deterministic"},
+//	  "active": "3f9c01aa",
+//	  "keys": [{"id": "3f9c01aa", "secret": "<32 bytes, base64>", "created": "..."}]
+//	}
+//
+// New values are encrypted with the active key. Older keys are kept so that
+// the values they encrypted can still be read; Reencrypt moves them to the
+// active key.
+type Keyring struct {
+	// Fields maps "age" and "gender" to how they are encrypted. Fields that
+	// are not listed are stored as plaintext.
+	Fields map[string]userstore.Encryption `json:"fields"`
+
+	Active string `json:"active"`
+	Keys   []Key  `json:"keys"`
+}
+
+// NewKeyring returns a keyring with one fresh key that encrypts fields.
+func NewKeyring(fields map[string]userstore.Encryption) (*Keyring, error) {
+	k := &Keyring{Fields: fields}
+	if _, err := k.Rotate(); err != nil {
+		return nil, err
+	}
+	if err := k.validate(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// ParseFields parses a comma-separated list of fields to encrypt, each
+// optionally followed by "=randomized" (the default) or "=deterministic",
+// such as "age,gender=deterministic".
+func ParseFields(s string) (map[string]userstore.Encryption, error) {
+	fields := map[string]userstore.Encryption{}
+	for _, item := range strings.Split(s, ",") {
+		field, mode, _ := strings.Cut(strings.TrimSpace(item), "=")
+		e := userstore.Encryption(mode)
+		if mode == "" {
+			e = userstore.Randomized
+		}
+		if !encryptable(field) {
+			return nil, fmt.Errorf("fieldcrypt: field %q cannot be encrypted, want one of %v", field, userstore.EncryptableFields)
+		}
+		if e != userstore.Randomized && e != userstore.Deterministic {
+			return nil, fmt.Errorf("fieldcrypt: field %s: unknown encryption %q, want randomized or deterministic", field, mode)
+		}
+		fields[field] = e
+	}
+	return fields, nil
+}
+
+// LoadKeyring reads the keyring file at path. The file must not be readable
+// by the group or others.
+func LoadKeyring(path string) (*Keyring, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("fieldcrypt: %w", err)
+	}
+	if info.Mode().Perm()&0o077 != 0 {
+		return nil, fmt.Errorf("fieldcrypt: keyring %s is accessible by others (mode %v); chmod 600 it", path, info.Mode().Perm())
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("fieldcrypt: %w", err)
+	}
+	var k Keyring
+	if err := json.Unmarshal(data, &k); err != nil {
+		return nil, fmt.Errorf("fieldcrypt: keyring %s: %w", path, err)
+	}
+	if err := k.validate(); err != nil {
+		return nil, fmt.Errorf("fieldcrypt: keyring %s: %w", path, err)
+	}
+	return &k, nil
+}
+
+// Save writes the keyring to path, creating it readable by its owner only.
+// The file is replaced atomically, so a crash leaves either the old or the
+// new keyring.
+func (k *Keyring) Save(path string) error {
+	data, err := json.MarshalIndent(k, "", "  ")
+	if err != nil {
+		return fmt.Errorf("fieldcrypt: %w", err)
+	}
+	data = append(data, '\n')
+
+	if err := fileutil.WriteFilePerm(path, data, 0o600); err != nil {
+		return fmt.Errorf("fieldcrypt: %w", err)
+	}
+	return nil
+}
+
+// Rotate adds a fresh key and makes it the active one.
+func (k *Keyring) Rotate() (*Key, error) {
+	id := make([]byte, 4)
+	secret := make([]byte, KeySize)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("fieldcrypt: %w", err)
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("fieldcrypt: %w", err)
+	}
+
+	key := Key{ID: hex.EncodeToString(id), Secret: secret, Created: time.Now().UTC().Truncate(time.Second)}
+	if _, ok := k.key(key.ID); ok {
+		// Four random bytes collide rarely enough to simply try again.
+		return k.Rotate()
+	}
+	k.Keys = append(k.Keys, key)
+	k.Active = key.ID
+	return &k.Keys[len(k.Keys)-1], nil
+}
+
+// key returns the key with the given ID.
+func (k *Keyring) key(id string) (*Key, bool) {
+	for i := range k.Keys {
+		if k.Keys[i].ID == id {
+			return &k.Keys[i], true
+		}
+	}
+	return nil, false
+}
+
+// validate checks the fields, the key sizes and that the active key exists.
+func (k *Keyring) validate() error {
+	for field, e := range k.Fields {
+		if !encryptable(field) {
+			return fmt.Errorf("field %q cannot be encrypted, want one of %v", field, userstore.EncryptableFields)
+		}
+		if e != userstore.Plaintext && e != userstore.Randomized && e != userstore.Deterministic {
+			return fmt.Errorf("field %s: unknown encryption %q, want randomized or deterministic", field, e)
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, key := range k.Keys {
+		if key.ID == "" || len(key.ID) > 255 {
+			return errors.New("key IDs must be 1 to 255 bytes long")
+		}
+		if seen[key.ID] {
+			return fmt.Errorf("key %s appears twice", key.ID)
+		}
+		seen[key.ID] = true
+		if len(key.Secret) != KeySize {
+			return fmt.Errorf("key %s is %d bytes long, want %d", key.ID, len(key.Secret), KeySize)
+		}
+	}
+	if _, ok := k.key(k.Active); !ok {
+		return fmt.Errorf("active key %q is not in the keyring", k.Active)
+	}
+	return nil
+}
+
+// encryptable reports whether field is one of userstore.EncryptableFields.
+func encryptable(field string) bool {
+	for _, f := range userstore.EncryptableFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package fieldcrypt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/userstore"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Progress counts what Reencrypt did.
+type Progress struct {
+	Scanned   int64 `json:"scanned"`
+	Rewritten int64 `json:"rewritten"`
+
+	// Changed counts the documents that were written by someone else between
+	// being read and being rewritten. Running Reencrypt again picks them up.
+	Changed int64 `json:"changed"`
+}
+
+// Reencrypt brings every document of the named collection of db in line
+// with the keyring: plaintext values of encrypted fields are encrypted,
+// values encrypted with an older key, in another mode or in the older format
+// are encrypted again with the active key, and encrypted values of fields
+// that are no longer encrypted are decrypted. Run it after creating a
+// keyring for an existing collection, after every rotation and after an
+// upgrade from values of version 1; until then equality filters on
+// deterministic fields miss the values encrypted with older keys.
+//
+// Each document is rewritten only if its fields still hold what was read, so
+// Reencrypt can run while the collection is in use. Entries of the audit log
+// keep the encryption they were written with, so keys are never dropped from
+// the keyring.
+func (e *Encrypter) Reencrypt(ctx context.Context, db *mongo.Database, name string) (*Progress, error) {
+	// The collection without the encrypting codecs, to see what is stored.
+	coll := db.Collection(name)
+	progress := &Progress{}
+
+	cursor, err := coll.Find(ctx, bson.D{})
+	if err != nil {
+		return progress, fmt.Errorf("fieldcrypt: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var errs []error
+	for cursor.Next(ctx) {
+		progress.Scanned++
+		id := cursor.Current.Lookup("_id")
+		oid, _ := id.ObjectIDOK()
+
+		filter := bson.D{{Key: "_id", Value: id}}
+		set := bson.D{}
+		for field, key := range fieldKeys {
+			old, err := cursor.Current.LookupErr(key)
+			if err != nil {
+				continue
+			}
+			updated, changed, err := e.rewrite(field, oid, old)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%v: %w", id, err))
+				continue
+			}
+			if changed {
+				filter = append(filter, bson.E{Key: key, Value: old})
+				set = append(set, bson.E{Key: key, Value: updated})
+			}
+		}
+		if len(set) == 0 {
+			continue
+		}
+
+		res, err := coll.UpdateOne(ctx, filter, bson.D{{Key: "$set", Value: set}})
+		if err != nil {
+			return progress, fmt.Errorf("fieldcrypt: rewrite %v: %w", id, err)
+		}
+		if res.MatchedCount == 0 {
+			progress.Changed++
+		} else {
+			progress.Rewritten++
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return progress, fmt.Errorf("fieldcrypt: %w", err)
+	}
+	if len(errs) > 0 {
+		return progress, fmt.Errorf("fieldcrypt: %d value(s) could not be rewritten: %w", len(errs), errors.Join(errs...))
+	}
+	return progress, nil
+}
+
+// rewrite returns the value field should hold instead of old in the user
+// with the given ID, and whether it differs.
+func (e *Encrypter) rewrite(field string, id primitive.ObjectID, old bson.RawValue) (bson.RawValue, bool, error) {
+	t, value := old.Type, old.Value
+	if subtype, data, ok := old.BinaryOK(); ok && subtype == Subtype {
+		if e.Mode(field) != userstore.Plaintext && e.current(field, data) {
+			return old, false, nil
+		}
+		var err error
+		if t, value, err = e.Decrypt(field, id, data); err != nil {
+			return old, false, err
+		}
+	} else if e.Mode(field) == userstore.Plaintext {
+		return old, false, nil
+	}
+
+	if e.Mode(field) == userstore.Plaintext {
+		return bson.RawValue{Type: t, Value: value}, true, nil
+	}
+	ciphertext, err := e.Encrypt(field, id, t, value)
+	if err != nil {
+		return old, false, err
+	}
+	_, encoded, err := bson.MarshalValue(primitive.Binary{Subtype: Subtype, Data: ciphertext})
+	if err != nil {
+		return old, false, err
+	}
+	return bson.RawValue{Type: bsontype.Binary, Value: encoded}, true, nil
+}
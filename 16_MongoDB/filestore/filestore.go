@@ -0,0 +1,568 @@
+// Package filestore is a small embedded document store for machines without
+// a MongoDB server.
+//
+// A DB is a directory. Each collection is kept in memory and saved to two
+// files in that directory:
+//
+//	<name>.json  the documents as a JSON array of MongoDB Extended JSON,
+//	             rewritten atomically at every checkpoint
+//	<name>.wal   a write-ahead log of the changes made since, one JSON line
+//	             per change, synced to disk before the change is applied
+//
+// Opening a collection loads the snapshot and replays the log, so no
+// acknowledged write is lost when the process dies. A change torn in half by
+// a crash is at the end of the log and is dropped.
+//
+// Queries take a MongoDB filter document and support the subset of operators
+// the repositories in this module use; see Match. A DB must be opened by one
+// process at a time, which Open enforces with a lock file.
+package filestore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/saurabhkk55/Go/11_file_os/fileutil"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ErrNoDocuments is returned by FindOne when no document matches the filter.
+var ErrNoDocuments = errors.New("filestore: no documents in result")
+
+// ErrDuplicateKey is returned when a document is inserted with an _id that is already taken.
+var ErrDuplicateKey = errors.New("filestore: duplicate _id")
+
+// ErrClosed is returned by the operations on a closed DB.
+var ErrClosed = errors.New("filestore: database is closed")
+
+// ErrLocked is returned by Open when another process has the database open.
+var ErrLocked = errors.New("filestore: database is open in another process")
+
+// lockName is the file of a database directory that Open locks.
+const lockName = ".lock"
+
+// checkpointEvery is the number of logged changes after which a collection
+// rewrites its snapshot and empties its log.
+const checkpointEvery = 1000
+
+// DB is a directory of collections.
+type DB struct {
+	dir  string
+	lock *os.File
+
+	mu     sync.Mutex
+	colls  map[string]*Collection
+	closed bool
+}
+
+// Open opens the database kept in dir, creating the directory when needed.
+// The database stays locked until Close, and Open fails with ErrLocked while
+// another process holds it.
+func Open(dir string) (*DB, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("filestore: %w", err)
+	}
+	lock, err := lockFile(filepath.Join(dir, lockName))
+	if err != nil {
+		return nil, err
+	}
+	return &DB{dir: dir, lock: lock, colls: map[string]*Collection{}}, nil
+}
+
+// Dir returns the directory of the database.
+func (db *DB) Dir() string {
+	return db.dir
+}
+
+// Collection opens the named collection, loading it from disk the first time.
+// A collection that does not exist yet is created empty.
+func (db *DB) Collection(name string) (*Collection, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return nil, fmt.Errorf("filestore: invalid collection name %q", name)
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return nil, ErrClosed
+	}
+	if c, ok := db.colls[name]; ok {
+		return c, nil
+	}
+
+	c, err := openCollection(db.dir, name)
+	if err != nil {
+		return nil, err
+	}
+	db.colls[name] = c
+	return c, nil
+}
+
+// Close checkpoints and closes every open collection.
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return nil
+	}
+	db.closed = true
+
+	var errs []error
+	for _, c := range db.colls {
+		errs = append(errs, c.close())
+	}
+	errs = append(errs, unlockFile(db.lock))
+	return errors.Join(errs...)
+}
+
+// Collection is a set of documents identified by their _id.
+// It is safe for concurrent use.
+type Collection struct {
+	name     string
+	snapshot string
+	walPath  string
+
+	mu      sync.RWMutex
+	docs    map[string]bson.Raw
+	wal     *os.File
+	walSize int64 // length of the complete records in the log
+	pending int   // changes logged since the last checkpoint
+	closed  bool
+
+	// broken is set when a torn record could not be cut off the log, which
+	// would make the records logged after it unreadable; writes then fail.
+	broken error
+}
+
+// logRecord is one line of the write-ahead log. A "put" record stores Doc,
+// replacing any document with the same _id; a "delete" record removes IDs.
+type logRecord struct {
+	Op  string          `bson:"op"`
+	Doc bson.Raw        `bson:"doc,omitempty"`
+	IDs []bson.RawValue `bson:"ids,omitempty"`
+}
+
+// openCollection loads the snapshot, replays the log and opens the log for appending.
+func openCollection(dir, name string) (*Collection, error) {
+	c := &Collection{
+		name:     name,
+		snapshot: filepath.Join(dir, name+".json"),
+		walPath:  filepath.Join(dir, name+".wal"),
+		docs:     map[string]bson.Raw{},
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	replayed, err := c.replay()
+	if err != nil {
+		return nil, err
+	}
+
+	c.wal, err = os.OpenFile(c.walPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("filestore: %w", err)
+	}
+
+	// Fold the replayed changes into the snapshot, which also drops a torn last record.
+	if replayed {
+		if err := c.checkpoint(); err != nil {
+			c.wal.Close()
+			return nil, err
+		}
+	}
+	info, err := c.wal.Stat()
+	if err != nil {
+		c.wal.Close()
+		return nil, fmt.Errorf("filestore: %w", err)
+	}
+	c.walSize = info.Size()
+	return c, nil
+}
+
+// load reads the snapshot, if there is one.
+func (c *Collection) load() error {
+	data, err := os.ReadFile(c.snapshot)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("filestore: %w", err)
+	}
+
+	var docs []json.RawMessage
+	if err := json.Unmarshal(data, &docs); err != nil {
+		return fmt.Errorf("filestore: %s: %w", c.snapshot, err)
+	}
+	for i, ext := range docs {
+		var doc bson.Raw
+		if err := bson.UnmarshalExtJSON(ext, true, &doc); err != nil {
+			return fmt.Errorf("filestore: %s: document %d: %w", c.snapshot, i, err)
+		}
+		if err := c.put(doc); err != nil {
+			return fmt.Errorf("filestore: %s: document %d: %w", c.snapshot, i, err)
+		}
+	}
+	return nil
+}
+
+// replay applies the records of the log and reports whether there were any.
+// Only the last line may be unreadable, as a write torn by a crash.
+func (c *Collection) replay() (bool, error) {
+	f, err := os.Open(c.walPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("filestore: %w", err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	replayed := false
+	for line := 1; ; line++ {
+		data, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// A last line without its newline was never acknowledged.
+			return replayed || len(data) > 0, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("filestore: %w", err)
+		}
+
+		var rec logRecord
+		if err := bson.UnmarshalExtJSON(data, true, &rec); err != nil {
+			if _, peekErr := r.Peek(1); peekErr == io.EOF {
+				return true, nil
+			}
+			return false, fmt.Errorf("filestore: %s: line %d: %w", c.walPath, line, err)
+		}
+		if err := c.apply(rec); err != nil {
+			return false, fmt.Errorf("filestore: %s: line %d: %w", c.walPath, line, err)
+		}
+		replayed = true
+	}
+}
+
+// apply performs a logged change in memory.
+func (c *Collection) apply(rec logRecord) error {
+	switch rec.Op {
+	case "put":
+		return c.put(rec.Doc)
+	case "delete":
+		for _, id := range rec.IDs {
+			delete(c.docs, idKey(id))
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown operation %q", rec.Op)
+}
+
+// put stores doc under its _id.
+func (c *Collection) put(doc bson.Raw) error {
+	id, err := doc.LookupErr("_id")
+	if err != nil {
+		return errors.New("document has no _id")
+	}
+	c.docs[idKey(id)] = doc
+	return nil
+}
+
+// write logs rec durably, then applies it. A record that could not be
+// logged is cut off the log again, so that it never ends up in front of the
+// next one. The caller must hold c.mu.
+func (c *Collection) write(rec logRecord) error {
+	if c.closed {
+		return ErrClosed
+	}
+	if c.broken != nil {
+		return c.broken
+	}
+
+	line, err := bson.MarshalExtJSON(rec, true, false)
+	if err != nil {
+		return fmt.Errorf("filestore: %w", err)
+	}
+	line = append(line, '\n')
+	if _, err := c.wal.Write(line); err != nil {
+		return c.rollback(fmt.Errorf("filestore: write log: %w", err))
+	}
+	if err := c.wal.Sync(); err != nil {
+		return c.rollback(fmt.Errorf("filestore: sync log: %w", err))
+	}
+	c.walSize += int64(len(line))
+	if err := c.apply(rec); err != nil {
+		return fmt.Errorf("filestore: %w", err)
+	}
+
+	// The change is durable from here on, so a failed checkpoint is only retried later.
+	c.pending++
+	if c.pending >= checkpointEvery {
+		c.checkpoint()
+	}
+	return nil
+}
+
+// rollback truncates the log back to its last complete record after err
+// failed a write, and returns err. When it cannot, the collection refuses
+// further writes until it is reopened, which drops the torn record.
+func (c *Collection) rollback(err error) error {
+	truncErr := c.wal.Truncate(c.walSize)
+	if truncErr == nil {
+		truncErr = c.wal.Sync()
+	}
+	if truncErr != nil {
+		c.broken = fmt.Errorf("filestore: %s: log holds a torn record: %w", c.name, truncErr)
+		return errors.Join(err, c.broken)
+	}
+	return err
+}
+
+// Checkpoint rewrites the snapshot with every document and empties the log.
+func (c *Collection) Checkpoint() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+	return c.checkpoint()
+}
+
+// checkpoint writes the snapshot atomically, then truncates the log.
+// Records replayed onto a newer snapshot are harmless, so a crash in between loses nothing.
+// The caller must hold c.mu.
+func (c *Collection) checkpoint() error {
+	var buf bytes.Buffer
+	buf.WriteString("[")
+	for i, key := range c.sortedKeys() {
+		ext, err := bson.MarshalExtJSON(c.docs[key], true, false)
+		if err != nil {
+			return fmt.Errorf("filestore: %w", err)
+		}
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString("\n")
+		buf.Write(ext)
+	}
+	buf.WriteString("\n]\n")
+
+	if err := fileutil.WriteFile(c.snapshot, buf.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("filestore: checkpoint %s: %w", c.name, err)
+	}
+	if err := c.wal.Truncate(0); err != nil {
+		return fmt.Errorf("filestore: checkpoint %s: %w", c.name, err)
+	}
+	c.walSize = 0
+	c.broken = nil
+	if err := c.wal.Sync(); err != nil {
+		return fmt.Errorf("filestore: checkpoint %s: %w", c.name, err)
+	}
+	c.pending = 0
+	return nil
+}
+
+// close checkpoints the collection when it has changed and closes its log.
+func (c *Collection) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	var err error
+	if c.pending > 0 {
+		err = c.checkpoint()
+	}
+	c.closed = true
+	return errors.Join(err, c.wal.Close())
+}
+
+// Name returns the name of the collection.
+func (c *Collection) Name() string {
+	return c.name
+}
+
+// InsertOne stores doc, which must have an _id that no other document has.
+// doc is anything bson.Marshal accepts.
+func (c *Collection) InsertOne(doc any) error {
+	raw, id, err := marshalDocument(doc)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.docs[idKey(id)]; ok {
+		return ErrDuplicateKey
+	}
+	return c.write(logRecord{Op: "put", Doc: raw})
+}
+
+// Find returns the documents matching filter in _id order.
+func (c *Collection) Find(filter any) ([]bson.Raw, error) {
+	match, err := Compile(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var docs []bson.Raw
+	for _, key := range c.sortedKeys() {
+		if doc := c.docs[key]; match(doc) {
+			docs = append(docs, clone(doc))
+		}
+	}
+	return docs, nil
+}
+
+// FindOne returns the first document matching filter in _id order, or ErrNoDocuments.
+func (c *Collection) FindOne(filter any) (bson.Raw, error) {
+	docs, err := c.Find(filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, ErrNoDocuments
+	}
+	return docs[0], nil
+}
+
+// Count returns the number of documents matching filter.
+func (c *Collection) Count(filter any) (int64, error) {
+	match, err := Compile(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var n int64
+	for _, doc := range c.docs {
+		if match(doc) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// ReplaceOne replaces the first document matching filter by doc and reports
+// whether one matched. doc keeps the _id of the replaced document when it has
+// none, and must not change it otherwise.
+func (c *Collection) ReplaceOne(filter, doc any) (bool, error) {
+	match, err := Compile(filter)
+	if err != nil {
+		return false, err
+	}
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return false, fmt.Errorf("filestore: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var old bson.Raw
+	for _, key := range c.sortedKeys() {
+		if match(c.docs[key]) {
+			old = c.docs[key]
+			break
+		}
+	}
+	if old == nil {
+		return false, nil
+	}
+
+	oldID := old.Lookup("_id")
+	if id, err := bson.Raw(raw).LookupErr("_id"); err != nil {
+		raw = withID(raw, oldID)
+	} else if idKey(id) != idKey(oldID) {
+		return false, fmt.Errorf("filestore: replacement changes the _id from %s to %s", oldID, id)
+	}
+	return true, c.write(logRecord{Op: "put", Doc: raw})
+}
+
+// DeleteMany removes the documents matching filter and returns how many were removed.
+func (c *Collection) DeleteMany(filter any) (int64, error) {
+	match, err := Compile(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var ids []bson.RawValue
+	for _, doc := range c.docs {
+		if match(doc) {
+			ids = append(ids, doc.Lookup("_id"))
+		}
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	if err := c.write(logRecord{Op: "delete", IDs: ids}); err != nil {
+		return 0, err
+	}
+	return int64(len(ids)), nil
+}
+
+// sortedKeys returns the keys of the documents in order. ObjectIDs sort by creation time.
+// The caller must hold c.mu.
+func (c *Collection) sortedKeys() []string {
+	keys := make([]string, 0, len(c.docs))
+	for key := range c.docs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// marshalDocument encodes doc and returns it with its _id.
+func marshalDocument(doc any) (bson.Raw, bson.RawValue, error) {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, bson.RawValue{}, fmt.Errorf("filestore: %w", err)
+	}
+	id, err := bson.Raw(raw).LookupErr("_id")
+	if err != nil {
+		return nil, bson.RawValue{}, errors.New("filestore: document has no _id")
+	}
+	return raw, id, nil
+}
+
+// withID returns doc with id prepended as its _id.
+func withID(doc bson.Raw, id bson.RawValue) bson.Raw {
+	elems, _ := doc.Elements()
+	d := bson.D{{Key: "_id", Value: id}}
+	for _, e := range elems {
+		d = append(d, bson.E{Key: e.Key(), Value: e.Value()})
+	}
+	raw, _ := bson.Marshal(d)
+	return raw
+}
+
+// idKey turns an _id into a map key. Values of different types never collide.
+func idKey(id bson.RawValue) string {
+	return string(rune(id.Type)) + string(id.Value)
+}
+
+// clone returns a copy of doc that the caller may keep.
+func clone(doc bson.Raw) bson.Raw {
+	return append(bson.Raw(nil), doc...)
+}
@@ -0,0 +1,163 @@
+package filestore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// crash abandons db as a process that died would: the logs are closed and
+// the lock released without a checkpoint.
+func crash(t *testing.T, db *DB) {
+	t.Helper()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, c := range db.colls {
+		c.wal.Close()
+	}
+	db.closed = true
+	if err := unlockFile(db.lock); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// ids returns the _id of every document of coll, in order.
+func ids(t *testing.T, coll *Collection) []int32 {
+	t.Helper()
+	docs, err := coll.Find(bson.D{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := []int32{}
+	for _, doc := range docs {
+		out = append(out, doc.Lookup("_id").Int32())
+	}
+	return out
+}
+
+func TestRecovery(t *testing.T) {
+	insert := func(ids ...int32) func(*Collection) error {
+		return func(c *Collection) error {
+			for _, id := range ids {
+				if err := c.InsertOne(bson.D{{Key: "_id", Value: id}}); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	remove := func(id int32) func(*Collection) error {
+		return func(c *Collection) error {
+			_, err := c.DeleteMany(bson.D{{Key: "_id", Value: id}})
+			return err
+		}
+	}
+	checkpoint := func(c *Collection) error { return c.Checkpoint() }
+
+	tests := []struct {
+		name   string
+		writes []func(*Collection) error
+		tail   string // appended to the log after the crash
+
+		want    []int32
+		wantErr bool
+	}{
+		{name: "log only", writes: []func(*Collection) error{insert(1, 2, 3)}, want: []int32{1, 2, 3}},
+		{name: "snapshot and log", writes: []func(*Collection) error{insert(1, 2), checkpoint, insert(3), remove(1)}, want: []int32{2, 3}},
+		{name: "snapshot only", writes: []func(*Collection) error{insert(1), remove(1), insert(2), checkpoint}, want: []int32{2}},
+		{name: "torn last record", writes: []func(*Collection) error{insert(1, 2)}, tail: `{"op":"put","doc":{"_id":{"$numberIn`, want: []int32{1, 2}},
+		{name: "unreadable last line", writes: []func(*Collection) error{insert(1)}, tail: "not json\n", want: []int32{1}},
+		{name: "unreadable line in the middle", writes: []func(*Collection) error{insert(1)}, tail: "not json\n" + `{"op":"delete","ids":[{"$numberInt":"1"}]}` + "\n", wantErr: true},
+		{name: "unknown operation", writes: []func(*Collection) error{insert(1)}, tail: `{"op":"drop"}` + "\n", wantErr: true},
+		{name: "put without _id", writes: []func(*Collection) error{insert(1)}, tail: `{"op":"put","doc":{"a":{"$numberInt":"1"}}}` + "\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			db, err := Open(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			coll, err := db.Collection("users")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, write := range tt.writes {
+				if err := write(coll); err != nil {
+					t.Fatal(err)
+				}
+			}
+			crash(t, db)
+
+			if tt.tail != "" {
+				f, err := os.OpenFile(filepath.Join(dir, "users.wal"), os.O_WRONLY|os.O_APPEND, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				f.WriteString(tt.tail)
+				f.Close()
+			}
+
+			db, err = Open(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			coll, err = db.Collection("users")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("opened with documents %v, want an error", ids(t, coll))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ids(t, coll); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("documents %v, want %v", got, tt.want)
+			}
+
+			// Recovery folds the log into the snapshot, dropping a torn
+			// record, so the writes made after it survive the next crash.
+			if err := coll.InsertOne(bson.D{{Key: "_id", Value: int32(99)}}); err != nil {
+				t.Fatal(err)
+			}
+			crash(t, db)
+			db, err = Open(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			if coll, err = db.Collection("users"); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := ids(t, coll), append(tt.want, 99); !reflect.DeepEqual(got, want) {
+				t.Errorf("after a second crash: documents %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestOpenLocked(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(dir); !errors.Is(err, ErrLocked) {
+		t.Errorf("second Open: err = %v, want ErrLocked", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(dir)
+	if err != nil {
+		t.Fatalf("Open after Close: %v", err)
+	}
+	db.Close()
+}
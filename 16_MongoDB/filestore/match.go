@@ -0,0 +1,419 @@
+package filestore
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// Predicate reports whether a document matches a compiled filter.
+type Predicate func(doc bson.Raw) bool
+
+// Match reports whether doc matches the MongoDB filter document filter.
+func Match(doc bson.Raw, filter any) (bool, error) {
+	match, err := Compile(filter)
+	if err != nil {
+		return false, err
+	}
+	return match(doc), nil
+}
+
+// Compile turns a MongoDB filter document into a Predicate. A nil filter matches
+// every document. The supported subset is:
+//
+//	{field: value}                         equality, with dotted paths into embedded documents
+//	{field: {$eq|$ne|$gt|$gte|$lt|$lte: value}}
+//	{field: {$in|$nin: [values]}}
+//	{field: {$exists: bool}}
+//	{field: {$regex: pattern, $options: "ims"}}  in RE2 syntax, on string values
+//	{$and|$or|$nor: [filters]}
+//
+// Values compare as MongoDB does within a type: numbers of any BSON type with
+// each other, and strings, dates, ObjectIDs and booleans with their own kind.
+// A field holding an array matches when the array or any of its elements does.
+// Any other operator is an error.
+func Compile(filter any) (Predicate, error) {
+	if filter == nil {
+		return func(bson.Raw) bool { return true }, nil
+	}
+	raw, err := bson.Marshal(filter)
+	if err != nil {
+		return nil, fmt.Errorf("filestore: filter: %w", err)
+	}
+	match, err := compileDocument(raw)
+	if err != nil {
+		return nil, fmt.Errorf("filestore: filter: %w", err)
+	}
+	return match, nil
+}
+
+// compileDocument compiles a filter document, whose conditions must all hold.
+func compileDocument(filter bson.Raw) (Predicate, error) {
+	elems, err := filter.Elements()
+	if err != nil {
+		return nil, err
+	}
+
+	var preds []Predicate
+	for _, e := range elems {
+		var p Predicate
+		if key := e.Key(); strings.HasPrefix(key, "$") {
+			p, err = compileLogical(key, e.Value())
+		} else {
+			p, err = compileField(key, e.Value())
+		}
+		if err != nil {
+			return nil, err
+		}
+		preds = append(preds, p)
+	}
+	return allOf(preds), nil
+}
+
+// compileLogical compiles $and, $or or $nor over an array of filter documents.
+func compileLogical(op string, operand bson.RawValue) (Predicate, error) {
+	arr, ok := operand.ArrayOK()
+	if !ok {
+		return nil, fmt.Errorf("%s needs an array", op)
+	}
+	values, err := arr.Values()
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("%s needs a non-empty array", op)
+	}
+
+	preds := make([]Predicate, len(values))
+	for i, v := range values {
+		doc, ok := v.DocumentOK()
+		if !ok {
+			return nil, fmt.Errorf("%s entries must be documents", op)
+		}
+		if preds[i], err = compileDocument(doc); err != nil {
+			return nil, err
+		}
+	}
+
+	switch op {
+	case "$and":
+		return allOf(preds), nil
+	case "$or":
+		return anyOf(preds), nil
+	case "$nor":
+		or := anyOf(preds)
+		return func(doc bson.Raw) bool { return !or(doc) }, nil
+	}
+	return nil, fmt.Errorf("unsupported operator %s", op)
+}
+
+// compileField compiles the condition on one field: a value to equal, or a
+// document of operators.
+func compileField(path string, cond bson.RawValue) (Predicate, error) {
+	ops, ok := cond.DocumentOK()
+	if !ok || !isOperatorDocument(ops) {
+		return fieldPredicate(path, eq(cond)), nil
+	}
+
+	elems, err := ops.Elements()
+	if err != nil {
+		return nil, err
+	}
+	var preds []Predicate
+	for _, e := range elems {
+		if e.Key() == "$options" {
+			continue // read by $regex
+		}
+		if e.Key() == "$regex" {
+			p, err := compileRegex(path, e.Value(), ops.Lookup("$options"))
+			if err != nil {
+				return nil, err
+			}
+			preds = append(preds, p)
+			continue
+		}
+		p, err := compileOperator(path, e.Key(), e.Value())
+		if err != nil {
+			return nil, err
+		}
+		preds = append(preds, p)
+	}
+	return allOf(preds), nil
+}
+
+// compileOperator compiles one operator applied to the field at path.
+func compileOperator(path, op string, operand bson.RawValue) (Predicate, error) {
+	switch op {
+	case "$eq":
+		return fieldPredicate(path, eq(operand)), nil
+	case "$ne":
+		p := fieldPredicate(path, eq(operand))
+		return func(doc bson.Raw) bool { return !p(doc) }, nil
+	case "$gt", "$gte", "$lt", "$lte":
+		return fieldPredicate(path, ordered(op, operand)), nil
+	case "$in", "$nin":
+		arr, ok := operand.ArrayOK()
+		if !ok {
+			return nil, fmt.Errorf("%s needs an array", op)
+		}
+		values, err := arr.Values()
+		if err != nil {
+			return nil, err
+		}
+		p := fieldPredicate(path, in(values))
+		if op == "$nin" {
+			return func(doc bson.Raw) bool { return !p(doc) }, nil
+		}
+		return p, nil
+	case "$exists":
+		want := truthy(operand)
+		return func(doc bson.Raw) bool {
+			_, err := doc.LookupErr(strings.Split(path, ".")...)
+			return (err == nil) == want
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported operator %s", op)
+}
+
+// compileRegex compiles $regex, with the flags of $options if present, on
+// the field at path. Patterns are compiled as RE2, which agrees with
+// MongoDB's PCRE for everyday patterns.
+func compileRegex(path string, pattern, options bson.RawValue) (Predicate, error) {
+	var expr, flags string
+	if re, opts, ok := pattern.RegexOK(); ok {
+		expr, flags = re, opts
+	} else if expr, ok = pattern.StringValueOK(); !ok {
+		return nil, fmt.Errorf("$regex needs a string")
+	}
+	if opts, ok := options.StringValueOK(); ok {
+		flags = opts
+	}
+	for _, f := range flags {
+		if !strings.ContainsRune("ims", f) {
+			return nil, fmt.Errorf("unsupported $regex option %q", f)
+		}
+	}
+	if flags != "" {
+		expr = "(?" + flags + ")" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("$regex: %w", err)
+	}
+	return fieldPredicate(path, func(v bson.RawValue, missing bool) bool {
+		s, ok := v.StringValueOK()
+		return !missing && ok && re.MatchString(s)
+	}), nil
+}
+
+// valueTest tests a field value. missing is true, with a zero v, when the document has no such field.
+type valueTest func(v bson.RawValue, missing bool) bool
+
+// fieldPredicate applies test to the field at path. When the field holds an
+// array, test is applied to the whole array and to each element.
+func fieldPredicate(path string, test valueTest) Predicate {
+	keys := strings.Split(path, ".")
+	return func(doc bson.Raw) bool {
+		v, err := doc.LookupErr(keys...)
+		if err != nil {
+			return test(bson.RawValue{}, true)
+		}
+		if test(v, false) {
+			return true
+		}
+		if arr, ok := v.ArrayOK(); ok {
+			values, _ := arr.Values()
+			for _, elem := range values {
+				if test(elem, false) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
+
+// eq tests for equality with operand. A null operand also matches a missing field.
+func eq(operand bson.RawValue) valueTest {
+	return func(v bson.RawValue, missing bool) bool {
+		if missing {
+			return operand.Type == bsontype.Null
+		}
+		return equal(v, operand)
+	}
+}
+
+// in tests for equality with any of values.
+func in(values []bson.RawValue) valueTest {
+	tests := make([]valueTest, len(values))
+	for i, v := range values {
+		tests[i] = eq(v)
+	}
+	return func(v bson.RawValue, missing bool) bool {
+		for _, test := range tests {
+			if test(v, missing) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// ordered tests a field against operand with $gt, $gte, $lt or $lte.
+// Missing fields and values of another type never match.
+func ordered(op string, operand bson.RawValue) valueTest {
+	return func(v bson.RawValue, missing bool) bool {
+		if missing {
+			return false
+		}
+		c, ok := compare(v, operand)
+		if !ok {
+			return false
+		}
+		switch op {
+		case "$gt":
+			return c > 0
+		case "$gte":
+			return c >= 0
+		case "$lt":
+			return c < 0
+		}
+		return c <= 0
+	}
+}
+
+// equal reports whether two values are equal. Numbers are equal across BSON types.
+func equal(a, b bson.RawValue) bool {
+	if c, ok := compareNumbers(a, b); ok {
+		return c == 0
+	}
+	return a.Type == b.Type && bytes.Equal(a.Value, b.Value)
+}
+
+// compare orders two values of the same kind. ok is false when they cannot be compared.
+func compare(a, b bson.RawValue) (c int, ok bool) {
+	if c, ok := compareNumbers(a, b); ok {
+		return c, true
+	}
+	if a.Type != b.Type {
+		return 0, false
+	}
+
+	switch a.Type {
+	case bsontype.String:
+		return strings.Compare(a.StringValue(), b.StringValue()), true
+	case bsontype.DateTime:
+		return compareTimes(a.Time(), b.Time()), true
+	case bsontype.ObjectID:
+		x, y := a.ObjectID(), b.ObjectID()
+		return bytes.Compare(x[:], y[:]), true
+	case bsontype.Boolean:
+		x, y := a.Boolean(), b.Boolean()
+		switch {
+		case x == y:
+			return 0, true
+		case y:
+			return -1, true
+		}
+		return 1, true
+	}
+	return 0, false
+}
+
+// compareNumbers orders two numbers of any numeric BSON type. Integers are
+// compared exactly; a double against anything is compared as a float.
+func compareNumbers(a, b bson.RawValue) (int, bool) {
+	x, xInt, xOK := number(a)
+	y, yInt, yOK := number(b)
+	if !xOK || !yOK {
+		return 0, false
+	}
+	if xInt && yInt {
+		i, j := a.AsInt64(), b.AsInt64()
+		switch {
+		case i < j:
+			return -1, true
+		case i > j:
+			return 1, true
+		}
+		return 0, true
+	}
+	switch {
+	case x < y:
+		return -1, true
+	case x > y:
+		return 1, true
+	}
+	return 0, true
+}
+
+// number returns a numeric value as a float and whether it is an integer type.
+func number(v bson.RawValue) (f float64, isInt, ok bool) {
+	switch v.Type {
+	case bsontype.Int32:
+		return float64(v.Int32()), true, true
+	case bsontype.Int64:
+		return float64(v.Int64()), true, true
+	case bsontype.Double:
+		return v.Double(), false, true
+	}
+	return 0, false, false
+}
+
+// compareTimes orders two times.
+func compareTimes(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	}
+	return 0
+}
+
+// truthy reports whether an $exists operand means true: true or a non-zero number.
+func truthy(v bson.RawValue) bool {
+	if b, ok := v.BooleanOK(); ok {
+		return b
+	}
+	if f, _, ok := number(v); ok {
+		return f != 0
+	}
+	return v.Type != bsontype.Null
+}
+
+// isOperatorDocument reports whether doc is a document of operators, such as
+// {$gt: 1}, rather than an embedded document to compare with.
+func isOperatorDocument(doc bson.Raw) bool {
+	elems, err := doc.Elements()
+	return err == nil && len(elems) > 0 && strings.HasPrefix(elems[0].Key(), "$")
+}
+
+// allOf returns a Predicate that holds when every one of preds holds.
+func allOf(preds []Predicate) Predicate {
+	return func(doc bson.Raw) bool {
+		for _, p := range preds {
+			if !p(doc) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// anyOf returns a Predicate that holds when at least one of preds holds.
+func anyOf(preds []Predicate) Predicate {
+	return func(doc bson.Raw) bool {
+		for _, p := range preds {
+			if p(doc) {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,541 @@
+// Package httpapi exposes a userstore.Repository as a JSON REST service.
+//
+//	POST   /users       create a user
+//	GET    /users       list users (?limit=&offset=&cursor=&sort=-age,name&fields=name,age
+//	                    &name=&gender=&minAge=&maxAge=&deleted=exclude|only|include
+//	                    &where=age >= 18 AND name ~ "^Sau", see package query)
+//	GET    /users/{id}  fetch one user
+//	PUT    /users/{id}  replace a user
+//	PATCH  /users/{id}  change some fields of a user ($set, $unset and $inc)
+//	DELETE /users/{id}  move a user to the trash (?hard=true deletes it permanently)
+//	POST   /users/{id}/restore  take a user out of the trash
+//
+//	GET    /users/by-name/{name}  fetch the user with that name
+//	PUT    /users/by-name/{name}  replace the user with that name, or create it
+//
+//	GET    /healthz     liveness probe: the process is serving requests
+//	GET    /readyz      readiness probe: the store is reachable (see SetReadinessCheck)
+//
+// PUT and PATCH respond with the matched and modified counts and the stored user.
+// Store operations run under the request context, so a client that goes
+// away aborts them; one the store does not finish in time is answered with
+// 504 Gateway Timeout.
+// A user with an expiresAt is removed for good once that time has passed;
+// PATCH can move it with $set or clear it with $unset.
+//
+// Every response carrying a single user has an ETag holding the user's
+// version. Sending it back in If-Match makes PUT, PATCH, DELETE and restore
+// fail with 412 Precondition Failed, and the current ETag, when someone else
+// changed the user in the meantime.
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/query"
+	"github.com/saurabhkk55/Go/16_MongoDB/userstore"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxBodyBytes caps the size of request bodies the handler will decode.
+const maxBodyBytes = 1 << 20
+
+// Page sizes used by GET /users.
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// Handler serves the /users endpoints.
+type Handler struct {
+	repo  userstore.Repository
+	mux   *http.ServeMux
+	ready func(ctx context.Context) error
+}
+
+// NewHandler returns a Handler that manages the users stored in repo.
+func NewHandler(repo userstore.Repository) *Handler {
+	h := &Handler{repo: repo, mux: http.NewServeMux()}
+
+	// "/users" matches the collection itself, "/users/" everything below it.
+	h.mux.HandleFunc("/users", h.users)
+	h.mux.HandleFunc("/users/", h.user)
+	h.mux.HandleFunc("/healthz", h.healthz)
+	h.mux.HandleFunc("/readyz", h.readyz)
+
+	return h
+}
+
+// SetReadinessCheck makes GET /readyz answer 503 while check returns an error.
+// Without a check the handler is always ready, which suits the memory store.
+func (h *Handler) SetReadinessCheck(check func(ctx context.Context) error) {
+	h.ready = check
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// healthz reports that the process is up.
+func (h *Handler) healthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, "GET, HEAD")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readyz reports whether the store can serve requests.
+func (h *Handler) readyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, "GET, HEAD")
+		return
+	}
+	if h.ready != nil {
+		if err := h.ready(r.Context()); err != nil {
+			log.Printf("httpapi: not ready: %v", err)
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "unavailable"})
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// users handles requests for the collection.
+func (h *Handler) users(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		h.createUser(w, r)
+	case http.MethodGet:
+		h.listUsers(w, r)
+	default:
+		methodNotAllowed(w, "GET, POST")
+	}
+}
+
+// user handles requests for a single user.
+func (h *Handler) user(w http.ResponseWriter, r *http.Request) {
+	if name, ok := strings.CutPrefix(r.URL.Path, "/users/by-name/"); ok {
+		h.userByName(w, r, name)
+		return
+	}
+
+	rawID := strings.TrimPrefix(r.URL.Path, "/users/")
+	if rawID, ok := strings.CutSuffix(rawID, "/restore"); ok {
+		h.restoreUser(w, r, rawID)
+		return
+	}
+	if rawID == "" || strings.Contains(rawID, "/") {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	id, err := userstore.ParseID(rawID)
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		h.getUser(w, r, id)
+	case http.MethodPut:
+		h.replaceUser(w, r, id)
+	case http.MethodPatch:
+		h.patchUser(w, r, id)
+	case http.MethodDelete:
+		h.deleteUser(w, r, id)
+	default:
+		methodNotAllowed(w, "GET, PUT, PATCH, DELETE")
+	}
+}
+
+// userByName handles requests addressed by the user's name.
+func (h *Handler) userByName(w http.ResponseWriter, r *http.Request, name string) {
+	if name == "" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		h.getUserByName(w, r, name)
+	case http.MethodPut:
+		h.upsertUser(w, r, name)
+	default:
+		methodNotAllowed(w, "GET, PUT")
+	}
+}
+
+// userInput is the body accepted by POST and PUT.
+type userInput struct {
+	Name       string            `json:"name"`
+	Age        userstore.Age     `json:"age"`
+	Gender     userstore.Gender  `json:"gender"`
+	Attributes map[string]string `json:"attributes"`
+	ExpiresAt  *time.Time        `json:"expiresAt"`
+}
+
+// user converts the input into a User with the given ID.
+func (in *userInput) user(id primitive.ObjectID) *userstore.User {
+	return &userstore.User{ID: id, Name: in.Name, Age: in.Age, Gender: in.Gender, Attributes: in.Attributes, ExpiresAt: in.ExpiresAt}
+}
+
+// updateResponse is the body returned by PUT and PATCH.
+type updateResponse struct {
+	*userstore.UpdateResult
+	User *userstore.User `json:"user"`
+}
+
+func (h *Handler) createUser(w http.ResponseWriter, r *http.Request) {
+	var in userInput
+	if !decodeBody(w, r, &in) {
+		return
+	}
+
+	u := in.user(primitive.NilObjectID)
+	if err := h.repo.Create(r.Context(), u); err != nil {
+		writeRepoError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", "/users/"+u.ID.Hex())
+	setETag(w, u)
+	writeJSON(w, http.StatusCreated, u)
+}
+
+func (h *Handler) listUsers(w http.ResponseWriter, r *http.Request) {
+	opts, err := listOptions(r.URL.Query())
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+
+	page, err := h.repo.List(r.Context(), opts)
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+
+	// Render only the requested fields when a projection was asked for.
+	users := make([]map[string]any, len(page.Users))
+	for i := range page.Users {
+		users[i] = userstore.Project(&page.Users[i], opts.Fields)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"users":      users,
+		"total":      page.Total,
+		"nextCursor": page.NextCursor,
+	})
+}
+
+func (h *Handler) getUser(w http.ResponseWriter, r *http.Request, id primitive.ObjectID) {
+	u, err := h.repo.Get(r.Context(), id)
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+	setETag(w, u)
+	writeJSON(w, http.StatusOK, u)
+}
+
+func (h *Handler) getUserByName(w http.ResponseWriter, r *http.Request, name string) {
+	u, err := h.repo.FindByName(r.Context(), name)
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+	setETag(w, u)
+	writeJSON(w, http.StatusOK, u)
+}
+
+func (h *Handler) replaceUser(w http.ResponseWriter, r *http.Request, id primitive.ObjectID) {
+	expect, err := ifMatch(r)
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+	var in userInput
+	if !decodeBody(w, r, &in) {
+		return
+	}
+
+	u := in.user(id)
+	result, err := h.repo.Replace(r.Context(), u, expect)
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+	setETag(w, u)
+	writeJSON(w, http.StatusOK, updateResponse{UpdateResult: result, User: u})
+}
+
+func (h *Handler) patchUser(w http.ResponseWriter, r *http.Request, id primitive.ObjectID) {
+	expect, err := ifMatch(r)
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+	var upd userstore.Update
+	if !decodeBody(w, r, &upd) {
+		return
+	}
+
+	result, err := h.repo.Update(r.Context(), id, upd, expect)
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+
+	// Return the stored user so the client sees the effect of $inc and $unset.
+	u, err := h.repo.Get(r.Context(), id)
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+	setETag(w, u)
+	writeJSON(w, http.StatusOK, updateResponse{UpdateResult: result, User: u})
+}
+
+func (h *Handler) upsertUser(w http.ResponseWriter, r *http.Request, name string) {
+	var in userInput
+	if !decodeBody(w, r, &in) {
+		return
+	}
+
+	// The name in the path wins; a different name in the body would be a rename.
+	if in.Name != "" && in.Name != name {
+		writeError(w, http.StatusBadRequest, "name in body does not match the path")
+		return
+	}
+	in.Name = name
+
+	u := in.user(primitive.NilObjectID)
+	result, err := h.repo.Upsert(r.Context(), u)
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+
+	status := http.StatusOK
+	if result.Upserted() {
+		status = http.StatusCreated
+		w.Header().Set("Location", "/users/"+u.ID.Hex())
+	}
+	setETag(w, u)
+	writeJSON(w, status, updateResponse{UpdateResult: result, User: u})
+}
+
+func (h *Handler) deleteUser(w http.ResponseWriter, r *http.Request, id primitive.ObjectID) {
+	expect, err := ifMatch(r)
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+
+	hard := false
+	if v := r.URL.Query().Get("hard"); v != "" {
+		if hard, err = strconv.ParseBool(v); err != nil {
+			writeRepoError(w, &userstore.ValidationError{Field: "hard", Reason: "must be true or false"})
+			return
+		}
+	}
+
+	if hard {
+		_, err = h.repo.HardDelete(r.Context(), id, expect)
+	} else {
+		err = h.repo.Delete(r.Context(), id, expect)
+	}
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// restoreUser handles POST /users/{id}/restore.
+func (h *Handler) restoreUser(w http.ResponseWriter, r *http.Request, rawID string) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, "POST")
+		return
+	}
+	id, err := userstore.ParseID(rawID)
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+	expect, err := ifMatch(r)
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+
+	u, err := h.repo.Restore(r.Context(), id, expect)
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+	setETag(w, u)
+	writeJSON(w, http.StatusOK, u)
+}
+
+// listOptions reads the pagination, sort and projection query parameters.
+func listOptions(q url.Values) (userstore.ListOptions, error) {
+	opts := userstore.ListOptions{Limit: defaultPageSize, After: q.Get("cursor")}
+
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageSize {
+			return opts, &userstore.ValidationError{Field: "limit", Reason: "must be between 1 and " + strconv.Itoa(maxPageSize)}
+		}
+		opts.Limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return opts, &userstore.ValidationError{Field: "offset", Reason: "must be a whole number"}
+		}
+		opts.Offset = n
+	}
+
+	if v := q.Get("name"); v != "" {
+		opts.Filter.Name = v
+	}
+	if v := q.Get("deleted"); v != "" {
+		deleted, err := userstore.ParseDeletedFilter(v)
+		if err != nil {
+			return opts, err
+		}
+		opts.Filter.Deleted = deleted
+	}
+	if v := q.Get("gender"); v != "" {
+		g, err := userstore.ParseGender(v)
+		if err != nil {
+			return opts, err
+		}
+		opts.Filter.Gender = g
+	}
+	if v := q.Get("where"); v != "" {
+		where, err := query.Parse(v)
+		if err != nil {
+			return opts, &userstore.ValidationError{Field: "where", Reason: strings.TrimPrefix(err.Error(), "query: ")}
+		}
+		opts.Filter.Where = where
+	}
+	for param, dst := range map[string]**userstore.Age{"minAge": &opts.Filter.MinAge, "maxAge": &opts.Filter.MaxAge} {
+		if v := q.Get(param); v != "" {
+			age, err := userstore.ParseAge(v)
+			if err != nil {
+				return opts, err
+			}
+			*dst = &age
+		}
+	}
+
+	var err error
+	if opts.Sort, err = userstore.ParseSort(q.Get("sort")); err != nil {
+		return opts, err
+	}
+	if opts.Fields, err = userstore.ParseFields(q.Get("fields")); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// ifMatch returns the version the If-Match header requires. Without the
+// header, or with "*", any version matches.
+func ifMatch(r *http.Request) (userstore.Expect, error) {
+	tag := strings.TrimSpace(r.Header.Get("If-Match"))
+	if tag == "" || tag == "*" {
+		return userstore.AnyVersion, nil
+	}
+
+	// Versions are strong validators, so weak tags and lists are not accepted.
+	version, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(tag, `"`), `"`), 10, 64)
+	if err != nil || len(tag) < 3 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return userstore.AnyVersion, &userstore.ValidationError{Field: "If-Match", Reason: `must be a single ETag such as "3"`}
+	}
+	return userstore.AtVersion(version), nil
+}
+
+// setETag sets the ETag header to the version of u.
+func setETag(w http.ResponseWriter, u *userstore.User) {
+	w.Header().Set("ETag", etag(u.Version))
+}
+
+// etag formats a version as a strong entity tag.
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// decodeBody decodes the JSON request body into v.
+// It writes a 400 response and returns false when the body is not valid.
+func decodeBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// writeRepoError maps repository errors onto HTTP status codes.
+func writeRepoError(w http.ResponseWriter, err error) {
+	var (
+		invalid  *userstore.ValidationError
+		conflict *userstore.ConflictError
+	)
+
+	switch {
+	case errors.As(err, &invalid):
+		writeError(w, http.StatusBadRequest, invalid.Field+" "+invalid.Reason)
+	case errors.As(err, &conflict):
+		w.Header().Set("ETag", etag(conflict.Actual))
+		writeError(w, http.StatusPreconditionFailed, "user was changed by someone else")
+	case errors.Is(err, userstore.ErrNotFound):
+		writeError(w, http.StatusNotFound, "user not found")
+	case errors.Is(err, userstore.ErrDuplicate):
+		writeError(w, http.StatusConflict, "user already exists")
+	case errors.Is(err, userstore.ErrTimeout):
+		log.Printf("httpapi: %v", err)
+		writeError(w, http.StatusGatewayTimeout, "the store did not answer in time")
+	case errors.Is(err, context.Canceled):
+		// The client went away, which aborted the operation; nobody reads this.
+		writeError(w, http.StatusServiceUnavailable, "request cancelled")
+	default:
+		log.Printf("httpapi: %v", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+	}
+}
+
+// methodNotAllowed writes a 405 response listing the allowed methods.
+func methodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
+
+// writeError writes a JSON error body with the given status code.
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// writeJSON writes v as a JSON body with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("httpapi: encode response: %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/userstore"
+)
+
+// newTestServer returns a server over a memory repository holding alice, at
+// version 1, and bob in the trash, and the path of each.
+func newTestServer(t *testing.T) (*httptest.Server, map[string]string) {
+	t.Helper()
+	repo := userstore.NewMemoryRepository()
+	ctx := context.Background()
+
+	alice := &userstore.User{Name: "alice", Age: 30, Gender: "F"}
+	bob := &userstore.User{Name: "bob", Age: 40, Gender: "M"}
+	for _, u := range []*userstore.User{alice, bob} {
+		if err := repo.Create(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repo.Delete(ctx, bob.ID, userstore.AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(NewHandler(repo))
+	t.Cleanup(srv.Close)
+	return srv, map[string]string{
+		"{alice}": "/users/" + alice.ID.Hex(),
+		"{bob}":   "/users/" + bob.ID.Hex(),
+	}
+}
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		ifMatch string
+		body    string
+
+		wantStatus int
+		wantETag   string
+		wantBody   string // a substring of the response body
+	}{
+		{"create", "POST", "/users", "", `{"name":"carol","age":25,"gender":"female"}`, 201, `"1"`, `"gender":"F"`},
+		{"create invalid", "POST", "/users", "", `{"name":"carol","age":-1,"gender":"F"}`, 400, "", `age`},
+		{"create duplicate", "POST", "/users", "", `{"name":"alice","age":30,"gender":"F"}`, 409, "", ""},
+		{"create bad body", "POST", "/users", "", `{"name":`, 400, "", "invalid request body"},
+		{"create unknown field", "POST", "/users", "", `{"name":"carol","age":25,"gender":"F","nick":"c"}`, 400, "", "invalid request body"},
+
+		{"get", "GET", "{alice}", "", "", 200, `"1"`, `"name":"alice"`},
+		{"get in trash", "GET", "{bob}", "", "", 404, "", ""},
+		{"get unknown", "GET", "/users/0123456789abcdef01234567", "", "", 404, "", ""},
+		{"get bad id", "GET", "/users/nope", "", "", 400, "", ""},
+		{"get by name", "GET", "/users/by-name/alice", "", "", 200, `"1"`, `"age":30`},
+		{"nested path", "GET", "{alice}/extra", "", "", 404, "", ""},
+		{"wrong method", "POST", "{alice}", "", "", 405, "", ""},
+
+		{"list", "GET", "/users", "", "", 200, "", `"total":1`},
+		{"list including trash", "GET", "/users?deleted=include", "", "", 200, "", `"total":2`},
+		{"list where", "GET", "/users?deleted=include&where=" + url.QueryEscape(`age >= 35`), "", "", 200, "", `"name":"bob"`},
+		{"list bad where", "GET", "/users?where=" + url.QueryEscape(`age >=`), "", "", 400, "", "where"},
+		{"list bad limit", "GET", "/users?limit=1000", "", "", 400, "", "limit"},
+		{"list fields", "GET", "/users?fields=name", "", "", 200, "", `"users":[{"id":`},
+
+		{"replace", "PUT", "{alice}", `"1"`, `{"name":"alice","age":31,"gender":"F"}`, 200, `"2"`, `"age":31`},
+		{"replace stale", "PUT", "{alice}", `"7"`, `{"name":"alice","age":31,"gender":"F"}`, 412, "", ""},
+		{"replace weak etag", "PUT", "{alice}", `W/"1"`, `{"name":"alice","age":31,"gender":"F"}`, 400, "", "If-Match"},
+		{"patch", "PATCH", "{alice}", "*", `{"$inc":{"age":2}}`, 200, `"2"`, `"age":32`},
+		{"patch stale", "PATCH", "{alice}", `"2"`, `{"$inc":{"age":2}}`, 412, "", ""},
+
+		{"upsert existing", "PUT", "/users/by-name/alice", "", `{"age":50,"gender":"F"}`, 200, `"2"`, `"age":50`},
+		{"upsert new", "PUT", "/users/by-name/dave", "", `{"age":50,"gender":"M"}`, 201, `"1"`, `"name":"dave"`},
+		{"upsert rename", "PUT", "/users/by-name/alice", "", `{"name":"eve","age":50,"gender":"F"}`, 400, "", "does not match"},
+
+		{"delete", "DELETE", "{alice}", `"1"`, "", 204, "", ""},
+		{"delete stale", "DELETE", "{alice}", `"2"`, "", 412, "", ""},
+		{"delete bad hard", "DELETE", "{alice}?hard=maybe", "", "", 400, "", "hard"},
+		{"restore", "POST", "{bob}/restore", "", "", 200, `"3"`, `"name":"bob"`},
+		{"restore not deleted", "POST", "{alice}/restore", "", "", 404, "", ""},
+		{"restore wrong method", "GET", "{bob}/restore", "", "", 405, "", ""},
+
+		{"healthz", "GET", "/healthz", "", "", 200, "", `"ok"`},
+		{"readyz", "GET", "/readyz", "", "", 200, "", `"ok"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, paths := newTestServer(t)
+			path := tt.path
+			for placeholder, p := range paths {
+				path = strings.Replace(path, placeholder, p, 1)
+			}
+
+			req, err := http.NewRequest(tt.method, srv.URL+path, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			var body json.RawMessage
+			if resp.StatusCode != http.StatusNoContent {
+				if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+					t.Fatalf("decode body: %v", err)
+				}
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d; body %s", resp.StatusCode, tt.wantStatus, body)
+			}
+			if got := resp.Header.Get("ETag"); tt.wantETag != "" && got != tt.wantETag {
+				t.Errorf("ETag = %s, want %s", got, tt.wantETag)
+			}
+			if !strings.Contains(string(body), tt.wantBody) {
+				t.Errorf("body %s does not contain %s", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestReadyzUnavailable(t *testing.T) {
+	h := NewHandler(userstore.NewMemoryRepository())
+	h.SetReadinessCheck(func(ctx context.Context) error { return context.DeadlineExceeded })
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,266 @@
+// Package migrate applies versioned schema and index migrations to a MongoDB database.
+//
+// Every applied version is recorded in the "migrations" collection of the
+// database, or in another collection given to NewRunnerIn, so running the same migrations again only applies the new ones.
+// Migrations can be rolled back to an earlier version with their Down step.
+// Documents a data migration cannot convert are moved to a quarantine
+// collection instead of failing the whole run.
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CollectionName is the collection that records the applied migrations.
+const CollectionName = "migrations"
+
+// lockID is the _id of the document that keeps two runners from migrating at once.
+const lockID = "lock"
+
+// lockTimeout is how long a lock is honoured before it is assumed to be left
+// over from a crashed runner.
+const lockTimeout = 10 * time.Minute
+
+var (
+	// ErrLocked is returned when another runner is migrating the same database.
+	ErrLocked = errors.New("migrate: another migration is in progress")
+
+	// ErrIrreversible is returned when rolling back a migration that has no Down step.
+	ErrIrreversible = errors.New("migrate: migration cannot be rolled back")
+)
+
+// Step changes the database in one direction.
+type Step func(ctx context.Context, db *mongo.Database) error
+
+// Migration is a single versioned change to the database.
+type Migration struct {
+	Version     int
+	Description string
+	Up          Step
+
+	// Down undoes Up. A nil Down makes the migration irreversible.
+	Down Step
+}
+
+// Status describes one migration and whether it has been applied.
+type Status struct {
+	Version     int       `json:"version"`
+	Description string    `json:"description"`
+	Applied     bool      `json:"applied"`
+	AppliedAt   time.Time `json:"appliedAt,omitempty"`
+}
+
+// record is the document stored in the migrations collection for each applied version.
+type record struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"appliedAt"`
+}
+
+// Runner applies a fixed list of migrations to a database.
+type Runner struct {
+	db         *mongo.Database
+	records    *mongo.Collection
+	migrations []Migration
+}
+
+// NewRunner returns a Runner for the given migrations, which must have
+// distinct positive versions and an Up step.
+func NewRunner(db *mongo.Database, migrations []Migration) (*Runner, error) {
+	return NewRunnerIn(db, CollectionName, migrations)
+}
+
+// NewRunnerIn is like NewRunner but records the applied versions in the
+// named collection, so that several sets of collections in one database can
+// be migrated independently.
+func NewRunnerIn(db *mongo.Database, records string, migrations []Migration) (*Runner, error) {
+	sorted := append([]Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+
+	for i, m := range sorted {
+		if m.Version <= 0 {
+			return nil, fmt.Errorf("migrate: version %d must be positive", m.Version)
+		}
+		if i > 0 && sorted[i-1].Version == m.Version {
+			return nil, fmt.Errorf("migrate: version %d is used twice", m.Version)
+		}
+		if m.Up == nil {
+			return nil, fmt.Errorf("migrate: version %d has no Up step", m.Version)
+		}
+	}
+
+	return &Runner{db: db, records: db.Collection(records), migrations: sorted}, nil
+}
+
+// Latest returns the highest known version, or 0 when there are no migrations.
+func (r *Runner) Latest() int {
+	if len(r.migrations) == 0 {
+		return 0
+	}
+	return r.migrations[len(r.migrations)-1].Version
+}
+
+// Status lists every known migration and whether it has been applied.
+func (r *Runner) Status(ctx context.Context) ([]Status, error) {
+	applied, err := r.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, len(r.migrations))
+	for i, m := range r.migrations {
+		statuses[i] = Status{Version: m.Version, Description: m.Description}
+		if rec, ok := applied[m.Version]; ok {
+			statuses[i].Applied = true
+			statuses[i].AppliedAt = rec.AppliedAt
+		}
+	}
+	return statuses, nil
+}
+
+// Current returns the highest applied version, or 0 when nothing has been applied.
+func (r *Runner) Current(ctx context.Context) (int, error) {
+	applied, err := r.applied(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	current := 0
+	for v := range applied {
+		current = max(current, v)
+	}
+	return current, nil
+}
+
+// Migrate applies or rolls back migrations until target is the highest applied version.
+// It returns the migrations it ran, in the order it ran them.
+func (r *Runner) Migrate(ctx context.Context, target int) ([]Migration, error) {
+	if target < 0 || (target > 0 && r.find(target) == nil) {
+		return nil, fmt.Errorf("migrate: unknown target version %d", target)
+	}
+
+	if err := r.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer r.unlock(context.Background())
+
+	applied, err := r.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var ran []Migration
+
+	// Apply missing versions up to the target, oldest first.
+	for _, m := range r.migrations {
+		if m.Version > target {
+			break
+		}
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if err := m.Up(ctx, r.db); err != nil {
+			return ran, fmt.Errorf("migrate: version %d (%s): %w", m.Version, m.Description, err)
+		}
+		rec := record{Version: m.Version, Description: m.Description, AppliedAt: time.Now().UTC()}
+		if _, err := r.records.InsertOne(ctx, rec); err != nil {
+			return ran, fmt.Errorf("migrate: record version %d: %w", m.Version, err)
+		}
+		ran = append(ran, m)
+	}
+
+	// Roll back applied versions above the target, newest first.
+	for i := len(r.migrations) - 1; i >= 0; i-- {
+		m := r.migrations[i]
+		if m.Version <= target {
+			break
+		}
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if m.Down == nil {
+			return ran, fmt.Errorf("%w: version %d (%s)", ErrIrreversible, m.Version, m.Description)
+		}
+		if err := m.Down(ctx, r.db); err != nil {
+			return ran, fmt.Errorf("migrate: roll back version %d (%s): %w", m.Version, m.Description, err)
+		}
+		if _, err := r.records.DeleteOne(ctx, bson.D{{Key: "_id", Value: m.Version}}); err != nil {
+			return ran, fmt.Errorf("migrate: unrecord version %d: %w", m.Version, err)
+		}
+		ran = append(ran, m)
+	}
+	return ran, nil
+}
+
+// find returns the migration with the given version, or nil.
+func (r *Runner) find(version int) *Migration {
+	for i := range r.migrations {
+		if r.migrations[i].Version == version {
+			return &r.migrations[i]
+		}
+	}
+	return nil
+}
+
+// applied loads the recorded versions.
+func (r *Runner) applied(ctx context.Context) (map[int]record, error) {
+	// The lock document has a string _id, so only numeric ids are versions.
+	filter := bson.D{{Key: "_id", Value: bson.D{{Key: "$type", Value: "number"}}}}
+	cursor, err := r.records.Find(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("migrate: load applied versions: %w", err)
+	}
+
+	var records []record
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, fmt.Errorf("migrate: load applied versions: %w", err)
+	}
+
+	applied := make(map[int]record, len(records))
+	for _, rec := range records {
+		applied[rec.Version] = rec
+	}
+	return applied, nil
+}
+
+// lock takes the migration lock, or takes over one older than lockTimeout.
+func (r *Runner) lock(ctx context.Context) error {
+	now := time.Now().UTC()
+	filter := bson.D{
+		{Key: "_id", Value: lockID},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "locked", Value: false}},
+			bson.D{{Key: "lockedAt", Value: bson.D{{Key: "$lt", Value: now.Add(-lockTimeout)}}}},
+		}},
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "locked", Value: true},
+		{Key: "lockedAt", Value: now},
+	}}}
+
+	// When the lock is held the filter matches nothing, so the upsert tries to
+	// insert a second document with the same _id and fails with a duplicate key.
+	_, err := r.records.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrLocked
+	}
+	if err != nil {
+		return fmt.Errorf("migrate: take lock: %w", err)
+	}
+	return nil
+}
+
+// unlock releases the migration lock.
+func (r *Runner) unlock(ctx context.Context) error {
+	_, err := r.records.UpdateOne(ctx, bson.D{{Key: "_id", Value: lockID}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "locked", Value: false}}}})
+	return err
+}
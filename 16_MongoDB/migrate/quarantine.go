@@ -0,0 +1,111 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// QuarantineSuffix ends the name of the collection where Transform and Dedup
+// move the documents they cannot migrate, next to the collection they came from.
+const QuarantineSuffix = "_quarantine"
+
+// QuarantineCollection returns the name of the quarantine collection of collection.
+func QuarantineCollection(collection string) string {
+	return collection + QuarantineSuffix
+}
+
+// Quarantined is a document moved out of its collection by a migration. It
+// keeps the _id of the document, which can be fixed and inserted again.
+type Quarantined struct {
+	ID            bson.RawValue `bson:"_id"`
+	Reason        string        `bson:"reason"`
+	QuarantinedAt time.Time     `bson:"quarantinedAt"`
+	Document      bson.Raw      `bson:"document"`
+}
+
+// CountQuarantined returns the number of documents of collection that
+// migrations have moved to its quarantine collection.
+func CountQuarantined(ctx context.Context, db *mongo.Database, collection string) (int64, error) {
+	return db.Collection(QuarantineCollection(collection)).CountDocuments(ctx, bson.D{})
+}
+
+// quarantine copies doc to the quarantine collection of collection, then
+// deletes it from collection. A crash in between leaves the document in both.
+func quarantine(ctx context.Context, db *mongo.Database, collection string, doc bson.Raw, reason string) error {
+	id := doc.Lookup("_id")
+	q := Quarantined{ID: id, Reason: reason, QuarantinedAt: time.Now().UTC(), Document: doc}
+	_, err := db.Collection(QuarantineCollection(collection)).ReplaceOne(ctx,
+		bson.D{{Key: "_id", Value: id}}, q, options.Replace().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("quarantine %s: %w", id, err)
+	}
+	if _, err := db.Collection(collection).DeleteOne(ctx, bson.D{{Key: "_id", Value: id}}); err != nil {
+		return fmt.Errorf("quarantine %s: %w", id, err)
+	}
+	return nil
+}
+
+// Dedup returns a step that makes field unique across collection, as a
+// unique index on it requires: of the documents sharing a value, the one with
+// the lowest _id stays and the others are quarantined. Documents without the
+// field count as sharing the value null, as they do for the index.
+func Dedup(collection, field string) Step {
+	return func(ctx context.Context, db *mongo.Database) error {
+		pipeline := mongo.Pipeline{
+			{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+			{{Key: "$group", Value: bson.D{
+				{Key: "_id", Value: "$" + field},
+				{Key: "ids", Value: bson.D{{Key: "$push", Value: "$_id"}}},
+				{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+			}}},
+			{{Key: "$match", Value: bson.D{{Key: "count", Value: bson.D{{Key: "$gt", Value: 1}}}}}},
+		}
+		coll := db.Collection(collection)
+		cursor, err := coll.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+		if err != nil {
+			return err
+		}
+		var groups []struct {
+			IDs []bson.RawValue `bson:"ids"`
+		}
+		if err := cursor.All(ctx, &groups); err != nil {
+			return err
+		}
+
+		for _, g := range groups {
+			kept := g.IDs[0]
+			for _, id := range g.IDs[1:] {
+				doc, err := coll.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Raw()
+				if errors.Is(err, mongo.ErrNoDocuments) {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				reason := fmt.Sprintf("duplicate %s of %s", field, kept)
+				if err := quarantine(ctx, db, collection, doc, reason); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// Chain returns a step that runs steps in order and stops at the first error.
+func Chain(steps ...Step) Step {
+	return func(ctx context.Context, db *mongo.Database) error {
+		for _, step := range steps {
+			if err := step(ctx, db); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
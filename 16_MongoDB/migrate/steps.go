@@ -0,0 +1,122 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CreateCollection returns steps that create a collection and drop it again.
+// Up does nothing when the collection already exists; Down refuses to drop a
+// collection that still holds documents.
+func CreateCollection(name string) (up, down Step) {
+	up = func(ctx context.Context, db *mongo.Database) error {
+		exists, err := collectionExists(ctx, db, name)
+		if err != nil || exists {
+			return err
+		}
+		return db.CreateCollection(ctx, name)
+	}
+	down = func(ctx context.Context, db *mongo.Database) error {
+		n, err := db.Collection(name).EstimatedDocumentCount(ctx)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return fmt.Errorf("collection %s still holds %d documents", name, n)
+		}
+		return db.Collection(name).Drop(ctx)
+	}
+	return up, down
+}
+
+// SetValidator returns steps that install a document validator on a collection
+// and remove it again. Validation is "moderate": documents that were already
+// invalid can still be updated, so legacy data does not block writes.
+func SetValidator(collection string, validator bson.D) (up, down Step) {
+	up = func(ctx context.Context, db *mongo.Database) error {
+		return collMod(ctx, db, collection, validator, "moderate")
+	}
+	down = func(ctx context.Context, db *mongo.Database) error {
+		return collMod(ctx, db, collection, bson.D{}, "off")
+	}
+	return up, down
+}
+
+// CreateIndex returns steps that create an index and drop it again.
+// The index must be named so that Down can find it.
+func CreateIndex(collection string, model mongo.IndexModel) (up, down Step) {
+	name := ""
+	if model.Options != nil && model.Options.Name != nil {
+		name = *model.Options.Name
+	}
+
+	up = func(ctx context.Context, db *mongo.Database) error {
+		if name == "" {
+			return fmt.Errorf("index on %s has no name", collection)
+		}
+		_, err := db.Collection(collection).Indexes().CreateOne(ctx, model)
+		return err
+	}
+	down = func(ctx context.Context, db *mongo.Database) error {
+		_, err := db.Collection(collection).Indexes().DropOne(ctx, name)
+		return err
+	}
+	return up, down
+}
+
+// Transform returns a step that rewrites every document matching filter.
+// fn receives each document and returns the update to apply to it, or nil to
+// leave it alone. A document fn fails on is quarantined with the error as the
+// reason, and the others are still migrated; see CountQuarantined.
+func Transform(collection string, filter bson.D, fn func(doc bson.Raw) (bson.D, error)) Step {
+	return func(ctx context.Context, db *mongo.Database) error {
+		coll := db.Collection(collection)
+		cursor, err := coll.Find(ctx, filter)
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+
+		for cursor.Next(ctx) {
+			id := cursor.Current.Lookup("_id")
+			update, err := fn(cursor.Current)
+			if err != nil {
+				if err := quarantine(ctx, db, collection, cursor.Current, err.Error()); err != nil {
+					return err
+				}
+				continue
+			}
+			if update == nil {
+				continue
+			}
+			if _, err := coll.UpdateOne(ctx, bson.D{{Key: "_id", Value: id}}, update); err != nil {
+				return fmt.Errorf("update %s: %w", id, err)
+			}
+		}
+		return cursor.Err()
+	}
+}
+
+// collectionExists reports whether db has a collection called name.
+func collectionExists(ctx context.Context, db *mongo.Database, name string) (bool, error) {
+	names, err := db.ListCollectionNames(ctx, bson.D{{Key: "name", Value: name}},
+		options.ListCollections().SetNameOnly(true))
+	if err != nil {
+		return false, err
+	}
+	return len(names) > 0, nil
+}
+
+// collMod changes the validator of a collection.
+func collMod(ctx context.Context, db *mongo.Database, collection string, validator bson.D, level string) error {
+	cmd := bson.D{
+		{Key: "collMod", Value: collection},
+		{Key: "validator", Value: validator},
+		{Key: "validationLevel", Value: level},
+	}
+	return db.RunCommand(ctx, cmd).Err()
+}
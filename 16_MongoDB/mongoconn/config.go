@@ -0,0 +1,239 @@
+// Package mongoconn opens MongoDB connections from configuration, retrying
+// while the server is starting up, and checks that they stay healthy.
+//
+// Settings are read from defaults, then an optional JSON config file, then
+// environment variables, each overriding the one before:
+//
+//	MONGODB_CONFIG                    path of the JSON config file
+//	MONGODB_URI                       connection URI
+//	MONGODB_APP_NAME                  application name reported to the server
+//	MONGODB_MAX_POOL_SIZE             maximum connections in the pool
+//	MONGODB_MIN_POOL_SIZE             connections kept open when idle
+//	MONGODB_CONNECT_TIMEOUT           timeout for opening one connection, e.g. "10s"
+//	MONGODB_SERVER_SELECTION_TIMEOUT  how long an operation waits for a usable server
+//	MONGODB_SOCKET_TIMEOUT            timeout for a single read or write, 0 for none
+//	MONGODB_OPERATION_TIMEOUT         timeout for a whole repository operation, 0 for none
+//	MONGODB_CONNECT_ATTEMPTS          connection attempts at startup
+//	MONGODB_RETRY_DELAY               delay before the first retry
+//	MONGODB_RETRY_MAX_DELAY           upper bound of the delay between retries
+package mongoconn
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Config holds the connection settings.
+type Config struct {
+	URI     string
+	AppName string
+
+	MaxPoolSize uint64
+	MinPoolSize uint64
+
+	ConnectTimeout         time.Duration
+	ServerSelectionTimeout time.Duration
+	SocketTimeout          time.Duration
+
+	// OperationTimeout bounds each operation of the repositories opened on
+	// the client, when the caller sets no earlier deadline; see
+	// userstore.MongoRepository.SetTimeout.
+	OperationTimeout time.Duration
+
+	// ConnectAttempts is the number of times Connect tries to reach the server.
+	ConnectAttempts int
+
+	// RetryDelay is the delay before the first retry. It doubles after every
+	// failed attempt up to RetryMaxDelay, and each delay is randomized.
+	RetryDelay    time.Duration
+	RetryMaxDelay time.Duration
+}
+
+// DefaultConfig returns the settings used when nothing is configured: the
+// local server that 1_connection.go has always used.
+func DefaultConfig() Config {
+	return Config{
+		URI:                    "mongodb://localhost:27017",
+		AppName:                "go-userstore",
+		MaxPoolSize:            100,
+		ConnectTimeout:         10 * time.Second,
+		ServerSelectionTimeout: 5 * time.Second,
+		OperationTimeout:       10 * time.Second,
+		ConnectAttempts:        5,
+		RetryDelay:             500 * time.Millisecond,
+		RetryMaxDelay:          10 * time.Second,
+	}
+}
+
+// fileConfig is the JSON layout of the config file. Durations are strings such as "5s".
+// Pointers tell missing settings apart from zero ones.
+type fileConfig struct {
+	URI                    *string `json:"uri"`
+	AppName                *string `json:"appName"`
+	MaxPoolSize            *uint64 `json:"maxPoolSize"`
+	MinPoolSize            *uint64 `json:"minPoolSize"`
+	ConnectTimeout         *string `json:"connectTimeout"`
+	ServerSelectionTimeout *string `json:"serverSelectionTimeout"`
+	SocketTimeout          *string `json:"socketTimeout"`
+	OperationTimeout       *string `json:"operationTimeout"`
+	ConnectAttempts        *int    `json:"connectAttempts"`
+	RetryDelay             *string `json:"retryDelay"`
+	RetryMaxDelay          *string `json:"retryMaxDelay"`
+}
+
+// Load returns the default settings overridden by the config file at path, or
+// the one named by MONGODB_CONFIG when path is empty, and then by the environment.
+func Load(path string) (Config, error) {
+	cfg := DefaultConfig()
+
+	if path == "" {
+		path = os.Getenv("MONGODB_CONFIG")
+	}
+	if path != "" {
+		if err := cfg.LoadFile(path); err != nil {
+			return Config{}, err
+		}
+	}
+	if err := cfg.LoadEnv(); err != nil {
+		return Config{}, err
+	}
+	return cfg, cfg.Validate()
+}
+
+// LoadFile overrides the settings present in the JSON config file at path.
+func (c *Config) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return &ConfigError{Source: path, Err: err}
+	}
+
+	var fc fileConfig
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&fc); err != nil {
+		return &ConfigError{Source: path, Err: err}
+	}
+
+	set := func(dst *string, src *string) {
+		if src != nil {
+			*dst = *src
+		}
+	}
+	set(&c.URI, fc.URI)
+	set(&c.AppName, fc.AppName)
+	if fc.MaxPoolSize != nil {
+		c.MaxPoolSize = *fc.MaxPoolSize
+	}
+	if fc.MinPoolSize != nil {
+		c.MinPoolSize = *fc.MinPoolSize
+	}
+	if fc.ConnectAttempts != nil {
+		c.ConnectAttempts = *fc.ConnectAttempts
+	}
+
+	durations := []struct {
+		key string
+		src *string
+		dst *time.Duration
+	}{
+		{"connectTimeout", fc.ConnectTimeout, &c.ConnectTimeout},
+		{"serverSelectionTimeout", fc.ServerSelectionTimeout, &c.ServerSelectionTimeout},
+		{"socketTimeout", fc.SocketTimeout, &c.SocketTimeout},
+		{"operationTimeout", fc.OperationTimeout, &c.OperationTimeout},
+		{"retryDelay", fc.RetryDelay, &c.RetryDelay},
+		{"retryMaxDelay", fc.RetryMaxDelay, &c.RetryMaxDelay},
+	}
+	for _, d := range durations {
+		if d.src == nil {
+			continue
+		}
+		v, err := time.ParseDuration(*d.src)
+		if err != nil {
+			return &ConfigError{Source: path, Key: d.key, Err: err}
+		}
+		*d.dst = v
+	}
+	return nil
+}
+
+// LoadEnv overrides the settings whose environment variables are set.
+func (c *Config) LoadEnv() error {
+	if v, ok := os.LookupEnv("MONGODB_URI"); ok {
+		c.URI = v
+	}
+	if v, ok := os.LookupEnv("MONGODB_APP_NAME"); ok {
+		c.AppName = v
+	}
+
+	uints := []struct {
+		key string
+		dst *uint64
+	}{
+		{"MONGODB_MAX_POOL_SIZE", &c.MaxPoolSize},
+		{"MONGODB_MIN_POOL_SIZE", &c.MinPoolSize},
+	}
+	for _, u := range uints {
+		if v, ok := os.LookupEnv(u.key); ok {
+			n, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				return &ConfigError{Source: "environment", Key: u.key, Err: err}
+			}
+			*u.dst = n
+		}
+	}
+
+	if v, ok := os.LookupEnv("MONGODB_CONNECT_ATTEMPTS"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return &ConfigError{Source: "environment", Key: "MONGODB_CONNECT_ATTEMPTS", Err: err}
+		}
+		c.ConnectAttempts = n
+	}
+
+	durations := []struct {
+		key string
+		dst *time.Duration
+	}{
+		{"MONGODB_CONNECT_TIMEOUT", &c.ConnectTimeout},
+		{"MONGODB_SERVER_SELECTION_TIMEOUT", &c.ServerSelectionTimeout},
+		{"MONGODB_SOCKET_TIMEOUT", &c.SocketTimeout},
+		{"MONGODB_OPERATION_TIMEOUT", &c.OperationTimeout},
+		{"MONGODB_RETRY_DELAY", &c.RetryDelay},
+		{"MONGODB_RETRY_MAX_DELAY", &c.RetryMaxDelay},
+	}
+	for _, d := range durations {
+		if v, ok := os.LookupEnv(d.key); ok {
+			dur, err := time.ParseDuration(v)
+			if err != nil {
+				return &ConfigError{Source: "environment", Key: d.key, Err: err}
+			}
+			*d.dst = dur
+		}
+	}
+	return nil
+}
+
+// Validate checks that the settings can be used to connect.
+func (c *Config) Validate() error {
+	invalid := func(key, reason string) error {
+		return &ConfigError{Key: key, Err: fmt.Errorf("%s", reason)}
+	}
+
+	switch {
+	case c.URI == "":
+		return invalid("uri", "is required")
+	case c.MaxPoolSize != 0 && c.MinPoolSize > c.MaxPoolSize:
+		return invalid("minPoolSize", "must not be greater than maxPoolSize")
+	case c.ConnectAttempts < 1:
+		return invalid("connectAttempts", "must be at least 1")
+	case c.ConnectTimeout < 0 || c.ServerSelectionTimeout < 0 || c.SocketTimeout < 0 || c.OperationTimeout < 0:
+		return invalid("timeouts", "must not be negative")
+	case c.RetryDelay < 0 || c.RetryMaxDelay < c.RetryDelay:
+		return invalid("retryMaxDelay", "must not be less than retryDelay")
+	}
+	return nil
+}
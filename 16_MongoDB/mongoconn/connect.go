@@ -0,0 +1,140 @@
+package mongoconn
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// checkTimeout bounds a health check when neither the caller nor the config does.
+const checkTimeout = 2 * time.Second
+
+// Client is a connected MongoDB client that knows its configuration.
+type Client struct {
+	*mongo.Client
+	cfg Config
+}
+
+// Connect opens a client with cfg and waits until the server answers a ping,
+// retrying with exponential backoff and jitter up to cfg.ConnectAttempts times.
+// logf, when not nil, is told about every failed attempt.
+//
+// A bad configuration is reported as a *ConfigError straight away; a server
+// that never answers as a *ConnectError.
+func Connect(ctx context.Context, cfg Config, logf func(format string, args ...any)) (*Client, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	client, err := mongo.Connect(ctx, cfg.clientOptions())
+	if err != nil {
+		return nil, &ConfigError{Key: "uri", Err: err}
+	}
+
+	fail := func(attempts int, err error) (*Client, error) {
+		client.Disconnect(context.Background())
+		return nil, &ConnectError{URI: redact(cfg.URI), Attempts: attempts, Err: err}
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := client.Ping(ctx, readpref.Primary())
+		if err == nil {
+			return &Client{Client: client, cfg: cfg}, nil
+		}
+		if attempt >= cfg.ConnectAttempts || ctx.Err() != nil {
+			return fail(attempt, err)
+		}
+
+		delay := cfg.backoff(attempt)
+		if logf != nil {
+			logf("MongoDB at %s is not reachable (attempt %d of %d), retrying in %s: %v",
+				redact(cfg.URI), attempt, cfg.ConnectAttempts, delay.Round(time.Millisecond), err)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fail(attempt, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// Check pings the primary and returns an error wrapping ErrUnhealthy when it
+// does not answer. It is cheap enough to back an HTTP readiness probe.
+func (c *Client) Check(ctx context.Context) error {
+	timeout := c.cfg.ServerSelectionTimeout
+	if timeout <= 0 {
+		timeout = checkTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if err := c.Ping(ctx, readpref.Primary()); err != nil {
+		return fmt.Errorf("%w: %v", ErrUnhealthy, err)
+	}
+	return nil
+}
+
+// Config returns the settings the client was opened with.
+func (c *Client) Config() Config {
+	return c.cfg
+}
+
+// Close disconnects the client, waiting for in-use connections until ctx is done.
+func (c *Client) Close(ctx context.Context) error {
+	return c.Disconnect(ctx)
+}
+
+// clientOptions translates the config into driver options.
+func (c *Config) clientOptions() *options.ClientOptions {
+	opts := options.Client().
+		ApplyURI(c.URI).
+		SetAppName(c.AppName).
+		SetMaxPoolSize(c.MaxPoolSize).
+		SetMinPoolSize(c.MinPoolSize)
+	if c.ConnectTimeout > 0 {
+		opts.SetConnectTimeout(c.ConnectTimeout)
+	}
+	if c.ServerSelectionTimeout > 0 {
+		opts.SetServerSelectionTimeout(c.ServerSelectionTimeout)
+	}
+	if c.SocketTimeout > 0 {
+		opts.SetSocketTimeout(c.SocketTimeout)
+	}
+	return opts
+}
+
+// backoff returns the delay after the given failed attempt: RetryDelay doubled
+// per attempt and capped at RetryMaxDelay, then randomized between half and
+// all of it so that several instances restarting together do not retry in step.
+func (c *Config) backoff(attempt int) time.Duration {
+	d := c.RetryDelay
+	for i := 1; i < attempt && d < c.RetryMaxDelay; i++ {
+		d *= 2
+	}
+	d = min(d, c.RetryMaxDelay)
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// redact removes the password from a connection URI so that it can be logged.
+func redact(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "(unparsable URI)"
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+	return u.String()
+}
@@ -0,0 +1,44 @@
+package mongoconn
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnhealthy is wrapped by the errors Check returns.
+var ErrUnhealthy = errors.New("mongoconn: server is not reachable")
+
+// ConfigError reports a setting that could not be read or is not usable.
+// Retrying does not help; the configuration has to be fixed.
+type ConfigError struct {
+	// Source is the config file or "environment"; empty for validation errors.
+	Source string
+	Key    string
+	Err    error
+}
+
+func (e *ConfigError) Error() string {
+	msg := "mongoconn: config"
+	if e.Source != "" {
+		msg += " " + e.Source
+	}
+	if e.Key != "" {
+		msg += ": " + e.Key
+	}
+	return msg + ": " + e.Err.Error()
+}
+
+func (e *ConfigError) Unwrap() error { return e.Err }
+
+// ConnectError reports that the server could not be reached after every attempt.
+type ConnectError struct {
+	URI      string
+	Attempts int
+	Err      error
+}
+
+func (e *ConnectError) Error() string {
+	return fmt.Sprintf("mongoconn: cannot reach %s after %d attempt(s): %v", e.URI, e.Attempts, e.Err)
+}
+
+func (e *ConnectError) Unwrap() error { return e.Err }
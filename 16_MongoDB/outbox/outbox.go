@@ -0,0 +1,278 @@
+// Package outbox keeps the user writes that could not reach MongoDB and
+// replays them once it is back.
+//
+// Repository wraps a userstore.Repository. A write that fails because the
+// server cannot be reached is stored in an Outbox and reported as queued
+// (ErrQueued) instead of being lost. The Outbox is a collection of the
+// embedded file store, so a queued write survives the process dying. Relay
+// replays the queued writes in the order they were made, backing off while
+// the server stays unreachable.
+//
+// Each entry has an idempotency key. A write queued under the key of the last
+// entry still pending for the same user is not queued again, so an operator
+// retyping the same user does not create it twice; retyping the name with
+// other fields is refused with a *PendingError. A write matching an earlier
+// entry, with other writes of the user queued since, is a new write, such as
+// a second delete after a restore, and is queued under its own key. Creates are replayed with the ID they were
+// given when they were queued, so a create that did reach the server before
+// the connection dropped is recognised instead of repeated. Updates and
+// replaces are replayed only while the user is at the version it had before
+// the write, so one that did reach the server is not applied twice; when the
+// user has changed since, the entry is marked Failed for an operator to check.
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/audit"
+	"github.com/saurabhkk55/Go/16_MongoDB/filestore"
+	"github.com/saurabhkk55/Go/16_MongoDB/mongoconn"
+	"github.com/saurabhkk55/Go/16_MongoDB/tenant"
+	"github.com/saurabhkk55/Go/16_MongoDB/userstore"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// CollectionName is the file store collection an Outbox is usually kept in.
+const CollectionName = "outbox"
+
+// ErrQueued is wrapped by the errors of writes that were queued in the outbox.
+var ErrQueued = errors.New("outbox: write queued for retry")
+
+// QueuedError reports a write that failed because the server could not be
+// reached and was queued in the outbox. It matches ErrQueued and the error
+// of the failed write.
+type QueuedError struct {
+	Key string
+
+	// Duplicate is true when an entry with the same key was already pending,
+	// so nothing new was queued.
+	Duplicate bool
+
+	Err error
+}
+
+func (e *QueuedError) Error() string {
+	if e.Duplicate {
+		return fmt.Sprintf("outbox: write already queued as %s: %v", e.Key, e.Err)
+	}
+	return fmt.Sprintf("outbox: write queued as %s: %v", e.Key, e.Err)
+}
+
+// Unwrap lets errors.Is match ErrQueued and the error of the write.
+func (e *QueuedError) Unwrap() []error {
+	return []error{ErrQueued, e.Err}
+}
+
+// PendingError reports a create that could not reach the server while a
+// create of the same name with other fields was already queued. Nothing was
+// queued: the queued create must be dropped, or replayed, before the name is
+// created again. It matches userstore.ErrDuplicate and the error of the write.
+type PendingError struct {
+	Key string
+	Err error
+}
+
+func (e *PendingError) Error() string {
+	return fmt.Sprintf("outbox: a create with other fields is already queued as %s: %v", e.Key, e.Err)
+}
+
+// Unwrap lets errors.Is match userstore.ErrDuplicate and the error of the write.
+func (e *PendingError) Unwrap() []error {
+	return []error{userstore.ErrDuplicate, e.Err}
+}
+
+// Unavailable reports whether err means that the server could not be
+// reached, so that the same write may succeed later.
+func Unavailable(err error) bool {
+	return mongo.IsNetworkError(err) || mongo.IsTimeout(err) ||
+		errors.Is(err, mongo.ErrClientDisconnected) || errors.Is(err, mongoconn.ErrUnhealthy)
+}
+
+// Entry is a queued write. Op says which fields are used: User for creates,
+// replaces and upserts, Update for updates and UserID for the rest.
+type Entry struct {
+	Key    string             `bson:"_id" json:"key"`
+	Seq    int64              `bson:"seq" json:"seq"`
+	Op     audit.Op           `bson:"op" json:"op"`
+	UserID primitive.ObjectID `bson:"userId,omitempty" json:"userId,omitempty"`
+	User   *userstore.User    `bson:"user,omitempty" json:"user,omitempty"`
+	Update *userstore.Update  `bson:"update,omitempty" json:"update,omitempty"`
+
+	// The context of the write, restored when it is replayed.
+	Actor   string `bson:"actor,omitempty" json:"actor,omitempty"`
+	Tenant  string `bson:"tenant,omitempty" json:"tenant,omitempty"`
+	Version *int64 `bson:"version,omitempty" json:"version,omitempty"`
+
+	Queued    time.Time `bson:"queued" json:"queued"`
+	Attempts  int       `bson:"attempts" json:"attempts"`
+	LastError string    `bson:"lastError,omitempty" json:"lastError,omitempty"`
+
+	// Failed is set when a replay was refused for a reason retrying cannot
+	// fix, such as a duplicate name. Failed entries are kept for inspection
+	// and are not replayed again; drop them once dealt with.
+	Failed bool `bson:"failed" json:"failed"`
+}
+
+// Outbox is the durable queue of writes waiting to be replayed.
+// It is safe for concurrent use by one process.
+type Outbox struct {
+	coll *filestore.Collection
+	mu   sync.Mutex // numbers the entries one at a time
+}
+
+// New returns an Outbox kept in coll.
+func New(coll *filestore.Collection) *Outbox {
+	return &Outbox{coll: coll}
+}
+
+// Add queues e, numbering it after the entries already queued. It returns
+// false, and queues nothing, when an entry with the same key is queued.
+func (o *Outbox) Add(e *Entry) (bool, error) {
+	return o.add(e, false)
+}
+
+// add is Add. With derived set, the key of e was derived from the write, so
+// an entry with that key is only a repeat of e while it is the last entry
+// pending for the same user; e is then left out and its Key set to that of
+// the entry. Otherwise e is queued under the key followed by "#" and its
+// number.
+func (o *Outbox) add(e *Entry, derived bool) (bool, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	entries, err := o.List()
+	if err != nil {
+		return false, err
+	}
+	var last *Entry // the last entry pending for the user of e
+	taken := false
+	for i, old := range entries {
+		e.Seq = max(e.Seq, old.Seq)
+		taken = taken || old.Key == e.Key
+		if !old.Failed && old.sameUser(e) {
+			last = &entries[i]
+		}
+	}
+	e.Seq++
+	if derived {
+		if last != nil && (last.Key == e.Key || strings.HasPrefix(last.Key, e.Key+"#")) {
+			e.Key = last.Key
+			return false, nil
+		}
+		if taken {
+			e.Key += "#" + strconv.FormatInt(e.Seq, 10)
+		}
+	}
+	if e.Queued.IsZero() {
+		e.Queued = time.Now().UTC()
+	}
+
+	err = o.coll.InsertOne(e)
+	if errors.Is(err, filestore.ErrDuplicateKey) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("outbox: %w", err)
+	}
+	return true, nil
+}
+
+// Get returns the entry with the given key, or nil when there is none.
+func (o *Outbox) Get(key string) (*Entry, error) {
+	doc, err := o.coll.FindOne(bson.D{{Key: "_id", Value: key}})
+	if errors.Is(err, filestore.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("outbox: %w", err)
+	}
+	var e Entry
+	if err := bson.Unmarshal(doc, &e); err != nil {
+		return nil, fmt.Errorf("outbox: entry %s: %w", key, err)
+	}
+	return &e, nil
+}
+
+// List returns the queued entries, failed ones included, oldest first.
+func (o *Outbox) List() ([]Entry, error) {
+	docs, err := o.coll.Find(nil)
+	if err != nil {
+		return nil, fmt.Errorf("outbox: %w", err)
+	}
+	entries := make([]Entry, len(docs))
+	for i, doc := range docs {
+		if err := bson.Unmarshal(doc, &entries[i]); err != nil {
+			return nil, fmt.Errorf("outbox: entry %s: %w", doc.Lookup("_id"), err)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Seq < entries[j].Seq })
+	return entries, nil
+}
+
+// Len returns the number of queued entries that are still to be replayed.
+func (o *Outbox) Len() (int64, error) {
+	n, err := o.coll.Count(bson.D{{Key: "failed", Value: false}})
+	if err != nil {
+		return 0, fmt.Errorf("outbox: %w", err)
+	}
+	return n, nil
+}
+
+// Drop removes the entries with the given keys, or every entry when no key
+// is given, and returns how many it removed.
+func (o *Outbox) Drop(keys ...string) (int64, error) {
+	var filter any
+	if len(keys) > 0 {
+		filter = bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: keys}}}}
+	}
+	n, err := o.coll.DeleteMany(filter)
+	if err != nil {
+		return 0, fmt.Errorf("outbox: %w", err)
+	}
+	return n, nil
+}
+
+// save stores the changed attempt count, error and state of e.
+func (o *Outbox) save(e *Entry) error {
+	if _, err := o.coll.ReplaceOne(bson.D{{Key: "_id", Value: e.Key}}, e); err != nil {
+		return fmt.Errorf("outbox: %w", err)
+	}
+	return nil
+}
+
+// sameUser reports whether e and other write the same user, going by the ID
+// or, for creates and upserts, the name.
+func (e *Entry) sameUser(other *Entry) bool {
+	if !e.UserID.IsZero() && e.UserID == other.UserID {
+		return true
+	}
+	return e.User != nil && other.User != nil && e.User.Name == other.User.Name
+}
+
+// context returns ctx carrying the actor and tenant e was queued with.
+func (e *Entry) context(ctx context.Context) context.Context {
+	if e.Actor != "" {
+		ctx = audit.WithActor(ctx, e.Actor)
+	}
+	if e.Tenant != "" {
+		ctx = tenant.WithID(ctx, e.Tenant)
+	}
+	return ctx
+}
+
+// expect returns the version of the user the write of e requires.
+func (e *Entry) expect() userstore.Expect {
+	if e.Version != nil {
+		return userstore.AtVersion(*e.Version)
+	}
+	return userstore.AnyVersion
+}
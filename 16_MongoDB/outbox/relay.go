@@ -0,0 +1,158 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/audit"
+	"github.com/saurabhkk55/Go/16_MongoDB/userstore"
+)
+
+// MaxBackoff caps the wait between replays while the server is unreachable.
+const MaxBackoff = 5 * time.Minute
+
+// Result counts what one Replay did.
+type Result struct {
+	Applied int `json:"applied"`
+	Failed  int `json:"failed"`
+
+	// Pending counts the entries left to replay, because the server became
+	// unreachable again.
+	Pending int `json:"pending"`
+}
+
+// Replay replays the pending entries of o through repo, oldest first, and
+// removes those that were applied. Entries refused for good are marked
+// Failed. Replay stops at the first entry that fails because the server
+// cannot be reached, so that later writes do not overtake it, and returns
+// that error.
+//
+// repo must not queue writes itself, so pass the repository wrapped by
+// Repository rather than the Repository.
+func Replay(ctx context.Context, o *Outbox, repo userstore.Repository) (*Result, error) {
+	entries, err := o.List()
+	if err != nil {
+		return nil, err
+	}
+
+	res := &Result{}
+	for i := range entries {
+		e := &entries[i]
+		if e.Failed {
+			continue
+		}
+
+		err := apply(e.context(ctx), repo, e)
+		switch {
+		case err == nil:
+			if _, err := o.Drop(e.Key); err != nil {
+				return res, err
+			}
+			res.Applied++
+			continue
+		case Unavailable(err) || ctx.Err() != nil:
+			for _, rest := range entries[i:] {
+				if !rest.Failed {
+					res.Pending++
+				}
+			}
+		default:
+			e.Failed = true
+			res.Failed++
+		}
+
+		e.Attempts++
+		e.LastError = err.Error()
+		if saveErr := o.save(e); saveErr != nil {
+			return res, saveErr
+		}
+		if !e.Failed {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// apply makes the write of e through repo. A write that turns out to have
+// reached the server before it was queued counts as applied.
+func apply(ctx context.Context, repo userstore.Repository, e *Entry) error {
+	switch e.Op {
+	case audit.OpCreate:
+		err := repo.Create(ctx, e.User)
+		if errors.Is(err, userstore.ErrDuplicate) {
+			// The create went through when it was first made if the ID is taken.
+			if _, getErr := repo.Get(ctx, e.User.ID); getErr == nil {
+				return nil
+			}
+		}
+		return err
+	case audit.OpUpdate:
+		_, err := repo.Update(ctx, e.UserID, *e.Update, e.expect())
+		return changed(err)
+	case audit.OpReplace:
+		_, err := repo.Replace(ctx, e.User, e.expect())
+		return changed(err)
+	case audit.OpUpsert:
+		_, err := repo.Upsert(ctx, e.User)
+		return err
+	case audit.OpDelete:
+		err := repo.Delete(ctx, e.UserID, e.expect())
+		if errors.Is(err, userstore.ErrNotFound) {
+			// Deleted already, by this write or another.
+			return nil
+		}
+		return err
+	case audit.OpRestore:
+		_, err := repo.Restore(ctx, e.UserID, e.expect())
+		return err
+	case audit.OpPurge:
+		_, err := repo.HardDelete(ctx, e.UserID, e.expect())
+		if errors.Is(err, userstore.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	return errors.New("outbox: unknown operation " + string(e.Op))
+}
+
+// changed explains a *userstore.ConflictError of a replayed write: the user
+// changed since the write was queued, possibly by the write itself.
+func changed(err error) error {
+	var conflict *userstore.ConflictError
+	if errors.As(err, &conflict) {
+		return fmt.Errorf("outbox: the user changed since the write was queued, maybe by this write reaching the server: %w", err)
+	}
+	return err
+}
+
+// RunRelay replays the entries of o through repo every interval until ctx is
+// done. While the server is unreachable it waits twice as long after every
+// attempt, up to MaxBackoff. Entries applied or refused for good are passed
+// to logf, and so are other failures.
+func RunRelay(ctx context.Context, o *Outbox, repo userstore.Repository, interval time.Duration, logf func(format string, args ...any)) {
+	wait := interval
+	for {
+		res, err := Replay(ctx, o, repo)
+		switch {
+		case err != nil && Unavailable(err):
+			wait = min(2*wait, MaxBackoff)
+		case err != nil && ctx.Err() == nil:
+			logf("outbox: replay: %v", err)
+		default:
+			wait = interval
+		}
+		if res != nil && (res.Applied > 0 || res.Failed > 0) {
+			logf("outbox: replayed %d queued write(s), %d refused, %d pending", res.Applied, res.Failed, res.Pending)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,398 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/filestore"
+	"github.com/saurabhkk55/Go/16_MongoDB/mongoconn"
+	"github.com/saurabhkk55/Go/16_MongoDB/userstore"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// How the writes of a flakyRepository fail.
+const (
+	up   = ""     // they do not
+	down = "down" // before reaching the server, reads included
+	lost = "lost" // after the server applied them, as a timeout would
+)
+
+// flakyRepository is a repository whose server can be made unreachable.
+type flakyRepository struct {
+	userstore.Repository
+	mode string
+}
+
+var errUnreachable = errors.Join(mongoconn.ErrUnhealthy, errors.New("no server"))
+
+// write makes the write of fn, or not, as the mode says.
+func (r *flakyRepository) write(fn func() error) error {
+	switch r.mode {
+	case down:
+		return errUnreachable
+	case lost:
+		fn()
+		return errUnreachable
+	}
+	return fn()
+}
+
+func (r *flakyRepository) Get(ctx context.Context, id primitive.ObjectID) (*userstore.User, error) {
+	if r.mode == down {
+		return nil, errUnreachable
+	}
+	return r.Repository.Get(ctx, id)
+}
+
+func (r *flakyRepository) Create(ctx context.Context, u *userstore.User) error {
+	return r.write(func() error { return r.Repository.Create(ctx, u) })
+}
+
+func (r *flakyRepository) Update(ctx context.Context, id primitive.ObjectID, upd userstore.Update, expect userstore.Expect) (*userstore.UpdateResult, error) {
+	var res *userstore.UpdateResult
+	return res, r.write(func() (err error) {
+		res, err = r.Repository.Update(ctx, id, upd, expect)
+		return err
+	})
+}
+
+func (r *flakyRepository) Delete(ctx context.Context, id primitive.ObjectID, expect userstore.Expect) error {
+	return r.write(func() error { return r.Repository.Delete(ctx, id, expect) })
+}
+
+func (r *flakyRepository) Restore(ctx context.Context, id primitive.ObjectID, expect userstore.Expect) (*userstore.User, error) {
+	var u *userstore.User
+	return u, r.write(func() (err error) {
+		u, err = r.Repository.Restore(ctx, id, expect)
+		return err
+	})
+}
+
+func (r *flakyRepository) HardDelete(ctx context.Context, id primitive.ObjectID, expect userstore.Expect) (*userstore.User, error) {
+	var u *userstore.User
+	return u, r.write(func() (err error) {
+		u, err = r.Repository.HardDelete(ctx, id, expect)
+		return err
+	})
+}
+
+// newTestOutbox returns an empty outbox in a temporary file store.
+func newTestOutbox(t *testing.T) *Outbox {
+	t.Helper()
+	db, err := filestore.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	coll, err := db.Collection(CollectionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(coll)
+}
+
+func TestReplay(t *testing.T) {
+	inc := userstore.Update{Inc: map[string]int{"age": 1}}
+	carol := func() *userstore.User { return &userstore.User{Name: "carol", Age: 20, Gender: "F"} }
+
+	tests := []struct {
+		name  string
+		mode  string
+		write func(ctx context.Context, r *Repository, alice *userstore.User) error
+
+		// between, when set, changes the store before the replay.
+		between func(ctx context.Context, repo userstore.Repository, alice *userstore.User) error
+		replay  string // the mode of the server during the replay
+
+		want      Result
+		wantAge   userstore.Age // of alice afterwards; 0 when alice is gone
+		wantCarol bool
+	}{
+		{
+			name:  "create",
+			mode:  down,
+			write: func(ctx context.Context, r *Repository, _ *userstore.User) error { return r.Create(ctx, carol()) },
+			want:  Result{Applied: 1}, wantAge: 30, wantCarol: true,
+		},
+		{
+			name:  "create that reached the server",
+			mode:  lost,
+			write: func(ctx context.Context, r *Repository, _ *userstore.User) error { return r.Create(ctx, carol()) },
+			want:  Result{Applied: 1}, wantAge: 30, wantCarol: true,
+		},
+		{
+			name: "update",
+			mode: down,
+			write: func(ctx context.Context, r *Repository, alice *userstore.User) error {
+				_, err := r.Update(ctx, alice.ID, inc, userstore.AtVersion(1))
+				return err
+			},
+			want: Result{Applied: 1}, wantAge: 31,
+		},
+		{
+			// The version read before the write pins the replay, so the
+			// increment is not applied twice.
+			name: "update that reached the server",
+			mode: lost,
+			write: func(ctx context.Context, r *Repository, alice *userstore.User) error {
+				_, err := r.Update(ctx, alice.ID, inc, userstore.AnyVersion)
+				return err
+			},
+			want: Result{Failed: 1}, wantAge: 31,
+		},
+		{
+			name: "update of a user changed since",
+			mode: down,
+			write: func(ctx context.Context, r *Repository, alice *userstore.User) error {
+				_, err := r.Update(ctx, alice.ID, inc, userstore.AtVersion(1))
+				return err
+			},
+			between: func(ctx context.Context, repo userstore.Repository, alice *userstore.User) error {
+				_, err := repo.Update(ctx, alice.ID, userstore.Update{Inc: map[string]int{"age": 10}}, userstore.AnyVersion)
+				return err
+			},
+			want: Result{Failed: 1}, wantAge: 40,
+		},
+		{
+			name: "delete that reached the server",
+			mode: lost,
+			write: func(ctx context.Context, r *Repository, alice *userstore.User) error {
+				return r.Delete(ctx, alice.ID, userstore.AnyVersion)
+			},
+			want: Result{Applied: 1},
+		},
+		{
+			name: "hard delete",
+			mode: down,
+			write: func(ctx context.Context, r *Repository, alice *userstore.User) error {
+				_, err := r.HardDelete(ctx, alice.ID, userstore.AnyVersion)
+				return err
+			},
+			want: Result{Applied: 1},
+		},
+		{
+			name: "writes in order",
+			mode: down,
+			write: func(ctx context.Context, r *Repository, alice *userstore.User) error {
+				if _, err := r.Update(ctx, alice.ID, inc, userstore.AtVersion(1)); !errors.Is(err, ErrQueued) {
+					return err
+				}
+				_, err := r.Update(ctx, alice.ID, userstore.Update{Inc: map[string]int{"age": 2}}, userstore.AtVersion(2))
+				return err
+			},
+			want: Result{Applied: 2}, wantAge: 33,
+		},
+		{
+			// A write matching an earlier entry is queued again when the
+			// user was written in between.
+			name: "delete, restore and delete again",
+			mode: down,
+			write: func(ctx context.Context, r *Repository, alice *userstore.User) error {
+				if err := r.Delete(ctx, alice.ID, userstore.AnyVersion); !errors.Is(err, ErrQueued) {
+					return err
+				}
+				if _, err := r.Restore(ctx, alice.ID, userstore.AnyVersion); !errors.Is(err, ErrQueued) {
+					return err
+				}
+				return r.Delete(ctx, alice.ID, userstore.AnyVersion)
+			},
+			want: Result{Applied: 3},
+		},
+		{
+			name: "increment undone and redone",
+			mode: down,
+			write: func(ctx context.Context, r *Repository, alice *userstore.User) error {
+				dec := userstore.Update{Inc: map[string]int{"age": -1}}
+				for i, upd := range []userstore.Update{inc, dec} {
+					if _, err := r.Update(ctx, alice.ID, upd, userstore.AtVersion(int64(i+1))); !errors.Is(err, ErrQueued) {
+						return err
+					}
+				}
+				_, err := r.Update(ctx, alice.ID, inc, userstore.AtVersion(3))
+				return err
+			},
+			want: Result{Applied: 3}, wantAge: 31,
+		},
+		{
+			name: "server still down",
+			mode: down,
+			write: func(ctx context.Context, r *Repository, alice *userstore.User) error {
+				if err := r.Create(ctx, carol()); !errors.Is(err, ErrQueued) {
+					return err
+				}
+				_, err := r.Update(ctx, alice.ID, inc, userstore.AtVersion(1))
+				return err
+			},
+			replay: down,
+			want:   Result{Pending: 2}, wantAge: 30,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mem := userstore.NewMemoryRepository()
+			alice := &userstore.User{Name: "alice", Age: 30, Gender: "F"}
+			if err := mem.Create(ctx, alice); err != nil {
+				t.Fatal(err)
+			}
+
+			box := newTestOutbox(t)
+			flaky := &flakyRepository{Repository: mem, mode: tt.mode}
+			if err := tt.write(ctx, NewRepository(flaky, box), alice); !errors.Is(err, ErrQueued) {
+				t.Fatalf("write: err = %v, want ErrQueued", err)
+			}
+			if tt.between != nil {
+				if err := tt.between(ctx, mem, alice); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			flaky.mode = tt.replay
+			res, err := Replay(ctx, box, flaky)
+			if tt.replay == down {
+				if !Unavailable(err) {
+					t.Errorf("replay: err = %v, want the server to be unreachable", err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if *res != tt.want {
+				t.Errorf("replay: %+v, want %+v", *res, tt.want)
+			}
+
+			var age userstore.Age
+			if u, err := mem.Get(ctx, alice.ID); err == nil {
+				age = u.Age
+			}
+			if age != tt.wantAge {
+				t.Errorf("alice is %d, want %d", age, tt.wantAge)
+			}
+			if _, err := mem.FindByName(ctx, "carol"); (err == nil) != tt.wantCarol {
+				t.Errorf("carol found: %v, want %v", err == nil, tt.wantCarol)
+			}
+
+			// Applied entries are dropped; the others stay queued.
+			n, err := box.Len()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != int64(tt.want.Pending) {
+				t.Errorf("%d entries left to replay, want %d", n, tt.want.Pending)
+			}
+		})
+	}
+}
+
+func TestQueueCreate(t *testing.T) {
+	tests := []struct {
+		name        string
+		second      userstore.User
+		wantPending bool
+	}{
+		{name: "same fields", second: userstore.User{Name: "carol", Age: 20, Gender: "F"}},
+		{name: "other fields", second: userstore.User{Name: "carol", Age: 21, Gender: "F"}, wantPending: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			r := NewRepository(&flakyRepository{Repository: userstore.NewMemoryRepository(), mode: down}, newTestOutbox(t))
+			if err := r.Create(ctx, &userstore.User{Name: "carol", Age: 20, Gender: "F"}); !errors.Is(err, ErrQueued) {
+				t.Fatalf("first create: err = %v, want ErrQueued", err)
+			}
+
+			err := r.Create(ctx, &tt.second)
+			var pending *PendingError
+			var queued *QueuedError
+			switch {
+			case tt.wantPending:
+				if !errors.As(err, &pending) || !errors.Is(err, userstore.ErrDuplicate) {
+					t.Errorf("second create: err = %v, want a *PendingError", err)
+				}
+			case !errors.As(err, &queued) || !queued.Duplicate:
+				t.Errorf("second create: err = %v, want a duplicate *QueuedError", err)
+			}
+		})
+	}
+}
+
+func TestQueueRepeat(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []func(ctx context.Context, r *Repository, id primitive.ObjectID) error
+
+		wantDuplicate bool // of the last write
+		wantQueued    int64
+	}{
+		{
+			name: "same delete twice",
+			writes: []func(ctx context.Context, r *Repository, id primitive.ObjectID) error{
+				func(ctx context.Context, r *Repository, id primitive.ObjectID) error {
+					return r.Delete(ctx, id, userstore.AnyVersion)
+				},
+				func(ctx context.Context, r *Repository, id primitive.ObjectID) error {
+					return r.Delete(ctx, id, userstore.AnyVersion)
+				},
+			},
+			wantDuplicate: true, wantQueued: 1,
+		},
+		{
+			name: "delete after a restore",
+			writes: []func(ctx context.Context, r *Repository, id primitive.ObjectID) error{
+				func(ctx context.Context, r *Repository, id primitive.ObjectID) error {
+					return r.Delete(ctx, id, userstore.AnyVersion)
+				},
+				func(ctx context.Context, r *Repository, id primitive.ObjectID) error {
+					_, err := r.Restore(ctx, id, userstore.AnyVersion)
+					return err
+				},
+				func(ctx context.Context, r *Repository, id primitive.ObjectID) error {
+					return r.Delete(ctx, id, userstore.AnyVersion)
+				},
+				// A repeat of the last delete, queued under another key.
+				func(ctx context.Context, r *Repository, id primitive.ObjectID) error {
+					return r.Delete(ctx, id, userstore.AnyVersion)
+				},
+			},
+			wantDuplicate: true, wantQueued: 3,
+		},
+		{
+			name: "delete after a write of another user",
+			writes: []func(ctx context.Context, r *Repository, id primitive.ObjectID) error{
+				func(ctx context.Context, r *Repository, id primitive.ObjectID) error {
+					return r.Delete(ctx, id, userstore.AnyVersion)
+				},
+				func(ctx context.Context, r *Repository, id primitive.ObjectID) error {
+					return r.Delete(ctx, primitive.NewObjectID(), userstore.AnyVersion)
+				},
+				func(ctx context.Context, r *Repository, id primitive.ObjectID) error {
+					return r.Delete(ctx, id, userstore.AnyVersion)
+				},
+			},
+			wantDuplicate: true, wantQueued: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			box := newTestOutbox(t)
+			r := NewRepository(&flakyRepository{Repository: userstore.NewMemoryRepository(), mode: down}, box)
+			id := primitive.NewObjectID()
+
+			var err error
+			for _, write := range tt.writes {
+				err = write(ctx, r, id)
+			}
+			var queued *QueuedError
+			if !errors.As(err, &queued) || queued.Duplicate != tt.wantDuplicate {
+				t.Errorf("last write: err = %v, want a *QueuedError with Duplicate %v", err, tt.wantDuplicate)
+			}
+			if n, err := box.Len(); err != nil || n != tt.wantQueued {
+				t.Errorf("%d entries queued (%v), want %d", n, err, tt.wantQueued)
+			}
+		})
+	}
+}
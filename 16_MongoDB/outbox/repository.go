@@ -0,0 +1,182 @@
+package outbox
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/audit"
+	"github.com/saurabhkk55/Go/16_MongoDB/tenant"
+	"github.com/saurabhkk55/Go/16_MongoDB/userstore"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type keyKey struct{}
+
+// WithKey returns a context whose write is queued under the idempotency key
+// key instead of the one derived from the write.
+func WithKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, keyKey{}, key)
+}
+
+// Repository is a userstore.Repository that queues the writes failing
+// because the server cannot be reached, and returns a *QueuedError for
+// them. Reads, CreateMany, Purge and Expire are not queued.
+//
+// Without WithKey, a write is keyed by what it does: a create by the name
+// of the user, a delete or restore by the ID, and an update, replace or
+// upsert by the ID or name and a hash of the change. Such a key is only
+// taken as a repeat while its entry is the last one pending for the user;
+// a delete after a queued delete and restore is queued again. A create of
+// a name already queued with other fields is not queued; it fails with a
+// *PendingError instead.
+//
+// A write that failed this way may still have been applied, such as one that
+// timed out after the server took it. Updates and replaces therefore record
+// the version the user was at before the write, reading it first when the
+// caller allows any version, and are only replayed while the user is still
+// at that version.
+type Repository struct {
+	userstore.Repository
+	box *Outbox
+}
+
+var _ userstore.Repository = (*Repository)(nil)
+
+// NewRepository returns a Repository that queues the failed writes of repo in box.
+func NewRepository(repo userstore.Repository, box *Outbox) *Repository {
+	return &Repository{Repository: repo, box: box}
+}
+
+// Create stores u. The ID is assigned before the first attempt, so a replay
+// creates the same user.
+func (r *Repository) Create(ctx context.Context, u *userstore.User) error {
+	if u.ID.IsZero() {
+		u.ID = primitive.NewObjectID()
+	}
+	err := r.Repository.Create(ctx, u)
+	return r.queue(ctx, err, &Entry{Op: audit.OpCreate, UserID: u.ID, User: u}, "create:"+u.Name, userstore.AnyVersion)
+}
+
+// Update applies upd to the user with the given ID.
+func (r *Repository) Update(ctx context.Context, id primitive.ObjectID, upd userstore.Update, expect userstore.Expect) (*userstore.UpdateResult, error) {
+	e := &Entry{Op: audit.OpUpdate, UserID: id, Update: &upd}
+	key := "update:" + id.Hex() + ":" + hash(upd)
+	before, err := r.pin(ctx, id, expect)
+	if err != nil {
+		// Nothing was sent, so the update cannot have been applied.
+		return nil, r.queue(ctx, err, e, key, expect)
+	}
+	res, err := r.Repository.Update(ctx, id, upd, expect)
+	return res, r.queue(ctx, err, e, key, before)
+}
+
+// Replace replaces the user with the ID of u.
+func (r *Repository) Replace(ctx context.Context, u *userstore.User, expect userstore.Expect) (*userstore.UpdateResult, error) {
+	e := &Entry{Op: audit.OpReplace, UserID: u.ID, User: u}
+	key := "replace:" + u.ID.Hex() + ":" + hash(u)
+	before, err := r.pin(ctx, u.ID, expect)
+	if err != nil {
+		return nil, r.queue(ctx, err, e, key, expect)
+	}
+	res, err := r.Repository.Replace(ctx, u, expect)
+	return res, r.queue(ctx, err, e, key, before)
+}
+
+// Upsert replaces or inserts the user with the name of u.
+func (r *Repository) Upsert(ctx context.Context, u *userstore.User) (*userstore.UpdateResult, error) {
+	res, err := r.Repository.Upsert(ctx, u)
+	return res, r.queue(ctx, err, &Entry{Op: audit.OpUpsert, User: u}, "upsert:"+u.Name+":"+hash(u), userstore.AnyVersion)
+}
+
+// Delete moves the user with the given ID to the trash.
+func (r *Repository) Delete(ctx context.Context, id primitive.ObjectID, expect userstore.Expect) error {
+	err := r.Repository.Delete(ctx, id, expect)
+	return r.queue(ctx, err, &Entry{Op: audit.OpDelete, UserID: id}, "delete:"+id.Hex(), expect)
+}
+
+// Restore takes the user with the given ID out of the trash.
+func (r *Repository) Restore(ctx context.Context, id primitive.ObjectID, expect userstore.Expect) (*userstore.User, error) {
+	u, err := r.Repository.Restore(ctx, id, expect)
+	return u, r.queue(ctx, err, &Entry{Op: audit.OpRestore, UserID: id}, "restore:"+id.Hex(), expect)
+}
+
+// HardDelete permanently removes the user with the given ID. A queued hard
+// delete returns no user.
+func (r *Repository) HardDelete(ctx context.Context, id primitive.ObjectID, expect userstore.Expect) (*userstore.User, error) {
+	u, err := r.Repository.HardDelete(ctx, id, expect)
+	return u, r.queue(ctx, err, &Entry{Op: audit.OpPurge, UserID: id}, "purge:"+id.Hex(), expect)
+}
+
+// pin returns expect, or, when expect allows any version, the version the
+// user with the given ID is at now. A user that cannot be found is left to
+// the write to report.
+func (r *Repository) pin(ctx context.Context, id primitive.ObjectID, expect userstore.Expect) (userstore.Expect, error) {
+	if _, ok := expect.Version(); ok {
+		return expect, nil
+	}
+	u, err := r.Repository.Get(ctx, id)
+	if errors.Is(err, userstore.ErrNotFound) {
+		return expect, nil
+	}
+	if err != nil {
+		return expect, err
+	}
+	return userstore.AtVersion(u.Version), nil
+}
+
+// queue returns err, after queuing e when err says the server could not be
+// reached. The entry keeps the version expect requires, for the replay.
+func (r *Repository) queue(ctx context.Context, err error, e *Entry, key string, expect userstore.Expect) error {
+	if err == nil || !Unavailable(err) {
+		return err
+	}
+
+	e.Key = key
+	explicit := false
+	if k, ok := ctx.Value(keyKey{}).(string); ok && k != "" {
+		e.Key, explicit = k, true
+	}
+	e.Actor = audit.ActorFrom(ctx)
+	e.Tenant, _ = tenant.FromContext(ctx)
+	if v, ok := expect.Version(); ok {
+		e.Version = &v
+	}
+	e.Attempts, e.LastError = 1, err.Error()
+
+	added, qerr := r.box.add(e, !explicit)
+	if qerr != nil {
+		return fmt.Errorf("%w; queuing it for retry failed too: %v", err, qerr)
+	}
+	if !added && e.Op == audit.OpCreate {
+		// The key holds only the name, so the queued create may carry other fields.
+		pending, qerr := r.box.Get(e.Key)
+		if qerr != nil {
+			return fmt.Errorf("%w; queuing it for retry failed too: %v", err, qerr)
+		}
+		if pending != nil && pending.User != nil && content(pending.User) != content(e.User) {
+			return &PendingError{Key: e.Key, Err: err}
+		}
+	}
+	return &QueuedError{Key: e.Key, Duplicate: !added, Err: err}
+}
+
+// content returns a digest of the fields of u a caller sets, leaving out
+// those the repository assigns.
+func content(u *userstore.User) string {
+	c := u.Clone()
+	c.ID, c.Version = primitive.NilObjectID, 0
+	c.CreatedAt, c.UpdatedAt = time.Time{}, time.Time{}
+	return hash(c)
+}
+
+// hash returns a short digest of the JSON form of v, to key writes by content.
+func hash(v any) string {
+	data, _ := json.Marshal(v)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:6])
+}
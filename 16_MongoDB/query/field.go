@@ -0,0 +1,325 @@
+package query
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/userstore"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// kind is the type of the values of a field.
+type kind int
+
+const (
+	kindString kind = iota
+	kindID
+	kindAge
+	kindGender
+	kindInt
+	kindTime
+)
+
+// fields maps the lower-cased JSON names of the fields that can be queried
+// onto their JSON names, BSON keys and kinds. Attributes are handled apart.
+var fields = map[string]Field{
+	"id":        {Name: "id", key: "_id", kind: kindID},
+	"name":      {Name: "name", key: "Name", kind: kindString},
+	"age":       {Name: "age", key: "Age", kind: kindAge},
+	"gender":    {Name: "gender", key: "Gender", kind: kindGender},
+	"createdat": {Name: "createdAt", key: "CreatedAt", kind: kindTime},
+	"updatedat": {Name: "updatedAt", key: "UpdatedAt", kind: kindTime},
+	"deletedat": {Name: "deletedAt", key: "DeletedAt", kind: kindTime},
+	"expiresat": {Name: "expiresAt", key: "ExpiresAt", kind: kindTime},
+	"version":   {Name: "version", key: "Version", kind: kindInt},
+}
+
+// Field is a field of a user named in a query.
+type Field struct {
+	Name    string // JSON name, such as "age" or "attributes.city"
+	NamePos int
+
+	key  string // BSON key
+	kind kind
+}
+
+// lookupField returns the field called name, ignoring case except in attribute keys.
+func lookupField(name string) (Field, bool) {
+	if prefix, key, ok := strings.Cut(name, "."); ok {
+		if !strings.EqualFold(prefix, "attributes") || key == "" || strings.Contains(key, ".") {
+			return Field{}, false
+		}
+		return Field{Name: "attributes." + key, key: "Attributes." + key, kind: kindString}, true
+	}
+	f, ok := fields[strings.ToLower(name)]
+	return f, ok
+}
+
+// value returns the value of the field in u, and false when u has none.
+func (f Field) value(u *userstore.User) (any, bool) {
+	switch f.kind {
+	case kindID:
+		return u.ID, true
+	case kindAge:
+		return u.Age, true
+	case kindGender:
+		return u.Gender, true
+	case kindInt:
+		return u.Version, true
+	case kindTime:
+		var t time.Time
+		switch f.key {
+		case "CreatedAt":
+			t = u.CreatedAt
+		case "UpdatedAt":
+			t = u.UpdatedAt
+		case "DeletedAt":
+			if u.DeletedAt != nil {
+				t = *u.DeletedAt
+			}
+		case "ExpiresAt":
+			if u.ExpiresAt != nil {
+				t = *u.ExpiresAt
+			}
+		}
+		// Zero times are left out of the stored documents.
+		return t, !t.IsZero()
+	}
+	if f.key == "Name" {
+		return u.Name, true
+	}
+	v, ok := u.Attributes[strings.TrimPrefix(f.key, "Attributes.")]
+	return v, ok
+}
+
+// literal converts the token of a value into the Go value the field holds.
+func (f Field) literal(src string, t token) (any, error) {
+	switch f.kind {
+	case kindAge, kindInt:
+		if t.kind != tokNumber {
+			return nil, errorAt(src, t.pos, f.Name+" needs a number, not "+t.describe())
+		}
+		n, err := strconv.ParseInt(t.text, 10, 64)
+		if err != nil {
+			return nil, errorAt(src, t.pos, "number is out of range")
+		}
+		if f.kind == kindAge {
+			return userstore.Age(n), nil
+		}
+		return n, nil
+	}
+
+	if t.kind != tokString {
+		return nil, errorAt(src, t.pos, f.Name+" needs a string, not "+t.describe())
+	}
+	switch f.kind {
+	case kindID:
+		id, err := primitive.ObjectIDFromHex(t.text)
+		if err != nil {
+			return nil, errorAt(src, t.pos, "id must be a 24-digit hex ObjectID")
+		}
+		return id, nil
+	case kindGender:
+		g, err := userstore.ParseGender(t.text)
+		if err != nil {
+			return nil, errorAt(src, t.pos, `gender must be one of "M", "F" or "O"`)
+		}
+		return g, nil
+	case kindTime:
+		for _, layout := range []string{time.RFC3339Nano, time.DateOnly} {
+			if tm, err := time.Parse(layout, t.text); err == nil {
+				return tm.UTC(), nil
+			}
+		}
+		return nil, errorAt(src, t.pos, f.Name+` must be an RFC 3339 time or a date such as "2024-01-31"`)
+	}
+	return t.text, nil
+}
+
+// format returns v in the query syntax.
+func format(v any) string {
+	switch v := v.(type) {
+	case string:
+		return strconv.Quote(v)
+	case userstore.Gender:
+		return strconv.Quote(string(v))
+	case primitive.ObjectID:
+		return strconv.Quote(v.Hex())
+	case time.Time:
+		return strconv.Quote(v.Format(time.RFC3339Nano))
+	case userstore.Age:
+		return strconv.Itoa(int(v))
+	case int64:
+		return strconv.FormatInt(v, 10)
+	}
+	return "?"
+}
+
+// compare orders two values of the same field.
+func compare(a, b any) int {
+	switch a := a.(type) {
+	case string:
+		return strings.Compare(a, b.(string))
+	case userstore.Gender:
+		return strings.Compare(string(a), string(b.(userstore.Gender)))
+	case primitive.ObjectID:
+		b := b.(primitive.ObjectID)
+		return bytes.Compare(a[:], b[:])
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	case userstore.Age:
+		return cmpInt(int64(a), int64(b.(userstore.Age)))
+	case int64:
+		return cmpInt(a, b.(int64))
+	}
+	return 0
+}
+
+func cmpInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// mongoOps maps the comparison operators onto MongoDB query operators.
+var mongoOps = map[string]string{
+	"=":  "$eq",
+	"!=": "$ne",
+	"<":  "$lt",
+	"<=": "$lte",
+	">":  "$gt",
+	">=": "$gte",
+}
+
+// Comparison compares a field with a value.
+type Comparison struct {
+	Field Field
+	Op    string // =, !=, <, <=, > or >=
+	Value any
+}
+
+func (e *Comparison) Pos() int { return e.Field.NamePos }
+
+func (e *Comparison) String() string {
+	return e.Field.Name + " " + e.Op + " " + format(e.Value)
+}
+
+// MongoFilter translates the comparison into a MongoDB query document.
+func (e *Comparison) MongoFilter() bson.D {
+	if e.Op == "=" {
+		return bson.D{{Key: e.Field.key, Value: e.Value}}
+	}
+	return bson.D{{Key: e.Field.key, Value: bson.D{{Key: mongoOps[e.Op], Value: e.Value}}}}
+}
+
+// Match reports whether u satisfies the comparison.
+func (e *Comparison) Match(u *userstore.User) bool {
+	v, ok := e.Field.value(u)
+	if !ok {
+		return e.Op == "!="
+	}
+	c := compare(v, e.Value)
+	switch e.Op {
+	case "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	}
+	return c >= 0
+}
+
+// Compared returns the field, compared by range unless the operator is = or !=.
+func (e *Comparison) Compared() map[string]bool {
+	return map[string]bool{e.Field.Name: e.Op != "=" && e.Op != "!="}
+}
+
+// In tests a field for being, or with Negated not being, one of a list of values.
+type In struct {
+	Field   Field
+	Negated bool
+	Values  []any
+}
+
+func (e *In) Pos() int { return e.Field.NamePos }
+
+func (e *In) String() string {
+	values := make([]string, len(e.Values))
+	for i, v := range e.Values {
+		values[i] = format(v)
+	}
+	op := " IN ("
+	if e.Negated {
+		op = " NOT IN ("
+	}
+	return e.Field.Name + op + strings.Join(values, ", ") + ")"
+}
+
+// MongoFilter translates the test into a MongoDB query document.
+func (e *In) MongoFilter() bson.D {
+	op := "$in"
+	if e.Negated {
+		op = "$nin"
+	}
+	return bson.D{{Key: e.Field.key, Value: bson.D{{Key: op, Value: bson.A(e.Values)}}}}
+}
+
+// Match reports whether u satisfies the test.
+func (e *In) Match(u *userstore.User) bool {
+	v, ok := e.Field.value(u)
+	if !ok {
+		return e.Negated
+	}
+	for _, want := range e.Values {
+		if compare(v, want) == 0 {
+			return !e.Negated
+		}
+	}
+	return e.Negated
+}
+
+// Compared returns the field, compared by equality.
+func (e *In) Compared() map[string]bool {
+	return map[string]bool{e.Field.Name: false}
+}
+
+// Regexp matches a string field against a regular expression.
+type Regexp struct {
+	Field   Field
+	Pattern *regexp.Regexp
+}
+
+func (e *Regexp) Pos() int { return e.Field.NamePos }
+
+func (e *Regexp) String() string {
+	return e.Field.Name + " ~ " + strconv.Quote(e.Pattern.String())
+}
+
+// MongoFilter translates the match into a MongoDB query document.
+func (e *Regexp) MongoFilter() bson.D {
+	return bson.D{{Key: e.Field.key, Value: bson.D{{Key: "$regex", Value: e.Pattern.String()}}}}
+}
+
+// Match reports whether the field of u matches the pattern.
+func (e *Regexp) Match(u *userstore.User) bool {
+	v, ok := e.Field.value(u)
+	return ok && e.Pattern.MatchString(v.(string))
+}
+
+// Compared returns the field, which the server cannot match by equality.
+func (e *Regexp) Compared() map[string]bool {
+	return map[string]bool{e.Field.Name: true}
+}
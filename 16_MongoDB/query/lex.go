@@ -0,0 +1,162 @@
+package query
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// tokenKind is the kind of a lexical token.
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp // = != < <= > >= ~
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+// token is one lexical token of a query. pos is the byte offset of its first
+// character; text is the source text, except for strings, where it is the
+// unquoted value.
+type token struct {
+	kind tokenKind
+	pos  int
+	text string
+}
+
+// describe names the token for error messages.
+func (t token) describe() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of query"
+	case tokString:
+		return "string " + strconv.Quote(t.text)
+	}
+	return strconv.Quote(t.text)
+}
+
+// keyword reports whether t is the keyword kw, in any case.
+func (t token) keyword(kw string) bool {
+	return t.kind == tokIdent && strings.EqualFold(t.text, kw)
+}
+
+// lexer splits a query into tokens.
+type lexer struct {
+	src string
+	pos int
+}
+
+// next returns the next token, or a *SyntaxError.
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.src) {
+		r, size := utf8.DecodeRuneInString(l.src[l.pos:])
+		if !unicode.IsSpace(r) {
+			break
+		}
+		l.pos += size
+	}
+	start := l.pos
+	if start == len(l.src) {
+		return token{kind: tokEOF, pos: start}, nil
+	}
+
+	c := l.src[start]
+	switch {
+	case c == '(':
+		l.pos++
+		return token{kind: tokLParen, pos: start, text: "("}, nil
+	case c == ')':
+		l.pos++
+		return token{kind: tokRParen, pos: start, text: ")"}, nil
+	case c == ',':
+		l.pos++
+		return token{kind: tokComma, pos: start, text: ","}, nil
+	case c == '=' || c == '~':
+		l.pos++
+		if c == '=' && l.peekByte('=') {
+			l.pos++ // "==" is accepted for "="
+		}
+		return token{kind: tokOp, pos: start, text: string(c)}, nil
+	case c == '<' || c == '>' || c == '!':
+		l.pos++
+		if l.peekByte('=') {
+			l.pos++
+		} else if c == '!' {
+			return token{}, errorAt(l.src, start, `expected "!="`)
+		}
+		return token{kind: tokOp, pos: start, text: l.src[start:l.pos]}, nil
+	case c == '"':
+		return l.string()
+	case c == '-' || isDigit(c):
+		return l.number()
+	case isIdentStart(c):
+		for l.pos < len(l.src) && isIdentPart(l.src[l.pos]) {
+			l.pos++
+		}
+		return token{kind: tokIdent, pos: start, text: l.src[start:l.pos]}, nil
+	}
+	r, _ := utf8.DecodeRuneInString(l.src[start:])
+	return token{}, errorAt(l.src, start, "unexpected character "+strconv.QuoteRune(r))
+}
+
+// string lexes a double-quoted string, with the escapes of Go strings.
+func (l *lexer) string() (token, error) {
+	start := l.pos
+	l.pos++
+	for l.pos < len(l.src) {
+		switch l.src[l.pos] {
+		case '\\':
+			l.pos += 2
+			continue
+		case '"':
+			l.pos++
+			s, err := strconv.Unquote(l.src[start:l.pos])
+			if err != nil {
+				return token{}, errorAt(l.src, start, "invalid escape in string")
+			}
+			return token{kind: tokString, pos: start, text: s}, nil
+		}
+		l.pos++
+	}
+	return token{}, errorAt(l.src, start, "string is not terminated")
+}
+
+// number lexes a whole number with an optional minus sign.
+func (l *lexer) number() (token, error) {
+	start := l.pos
+	if l.src[l.pos] == '-' {
+		l.pos++
+	}
+	digits := l.pos
+	for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+		l.pos++
+	}
+	if l.pos == digits || (l.pos < len(l.src) && isIdentPart(l.src[l.pos])) {
+		return token{}, errorAt(l.src, start, "malformed number")
+	}
+	return token{kind: tokNumber, pos: start, text: l.src[start:l.pos]}, nil
+}
+
+func (l *lexer) peekByte(c byte) bool {
+	return l.pos < len(l.src) && l.src[l.pos] == c
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '_'
+}
+
+// isIdentPart reports whether c can continue an identifier. Dots join the
+// parts of a path such as attributes.city.
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || isDigit(c) || c == '.'
+}
@@ -0,0 +1,309 @@
+package query
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxDepth caps the nesting of parentheses and NOTs, so that a hostile query
+// cannot exhaust the stack.
+const maxDepth = 100
+
+// Parse parses a filter expression. Errors are *SyntaxErrors.
+func Parse(src string) (Expr, error) {
+	p := &parser{lex: lexer{src: src}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if p.tok.kind == tokEOF {
+		return nil, p.errorf("query is empty")
+	}
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("expected AND, OR or end of query, found " + p.tok.describe())
+	}
+	return e, nil
+}
+
+// parser is a recursive descent parser over the tokens of a query, with
+// one token of lookahead.
+type parser struct {
+	lex   lexer
+	tok   token
+	depth int
+}
+
+// advance moves on to the next token.
+func (p *parser) advance() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+// errorf returns a *SyntaxError at the current token.
+func (p *parser) errorf(msg string) error {
+	return errorAt(p.lex.src, p.tok.pos, msg)
+}
+
+// or parses and { "OR" and }.
+func (p *parser) or() (Expr, error) {
+	x, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	operands := []Expr{x}
+	for p.tok.keyword("OR") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		y, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, y)
+	}
+	if len(operands) == 1 {
+		return x, nil
+	}
+	return &Or{Operands: operands}, nil
+}
+
+// and parses unary { "AND" unary }.
+func (p *parser) and() (Expr, error) {
+	x, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	operands := []Expr{x}
+	for p.tok.keyword("AND") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		y, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, y)
+	}
+	if len(operands) == 1 {
+		return x, nil
+	}
+	return &And{Operands: operands}, nil
+}
+
+// unary parses "NOT" unary, a parenthesized expression or a comparison.
+func (p *parser) unary() (Expr, error) {
+	if p.depth++; p.depth > maxDepth {
+		return nil, p.errorf("query is nested too deeply")
+	}
+	defer func() { p.depth-- }()
+
+	switch {
+	case p.tok.keyword("NOT"):
+		pos := p.tok.pos
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &Not{X: x, NotPos: pos}, nil
+	case p.tok.kind == tokLParen:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		x, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokRParen {
+			return nil, p.errorf(`expected ")", found ` + p.tok.describe())
+		}
+		return x, p.advance()
+	}
+	return p.comparison()
+}
+
+// comparison parses a field followed by an operator and its operand.
+func (p *parser) comparison() (Expr, error) {
+	if p.tok.kind != tokIdent || isKeyword(p.tok.text) {
+		return nil, p.errorf("expected a field name, found " + p.tok.describe())
+	}
+	f, ok := lookupField(p.tok.text)
+	if !ok {
+		return nil, p.errorf("unknown field " + p.tok.describe())
+	}
+	f.NamePos = p.tok.pos
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case p.tok.keyword("IN"):
+		return p.in(f, false)
+	case p.tok.keyword("NOT"):
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if !p.tok.keyword("IN") {
+			return nil, p.errorf("expected IN after NOT, found " + p.tok.describe())
+		}
+		return p.in(f, true)
+	case p.tok.kind == tokOp && p.tok.text == "~":
+		return p.regexp(f)
+	case p.tok.kind == tokOp:
+		op := p.tok.text
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		v, err := p.value(f)
+		if err != nil {
+			return nil, err
+		}
+		return &Comparison{Field: f, Op: op, Value: v}, nil
+	}
+	return nil, p.errorf("expected an operator after " + f.Name + ", found " + p.tok.describe())
+}
+
+// in parses the list of values after IN.
+func (p *parser) in(f Field, negated bool) (Expr, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokLParen {
+		return nil, p.errorf(`expected "(" after IN, found ` + p.tok.describe())
+	}
+	e := &In{Field: f, Negated: negated}
+	for {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		v, err := p.value(f)
+		if err != nil {
+			return nil, err
+		}
+		e.Values = append(e.Values, v)
+
+		switch p.tok.kind {
+		case tokComma:
+			continue
+		case tokRParen:
+			return e, p.advance()
+		}
+		return nil, p.errorf(`expected "," or ")", found ` + p.tok.describe())
+	}
+}
+
+// regexp parses the pattern after "~".
+func (p *parser) regexp(f Field) (Expr, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if f.kind != kindString {
+		return nil, errorAt(p.lex.src, f.NamePos, "~ only applies to name and attributes")
+	}
+	if p.tok.kind != tokString {
+		return nil, p.errorf("expected a pattern string, found " + p.tok.describe())
+	}
+	re, err := regexp.Compile(p.tok.text)
+	if err != nil {
+		return nil, p.errorf("invalid pattern: " + strings.TrimPrefix(err.Error(), "error parsing regexp: "))
+	}
+	if msg := portable(p.tok.text); msg != "" {
+		return nil, p.errorf("pattern not supported: " + msg)
+	}
+	return &Regexp{Field: f, Pattern: re}, p.advance()
+}
+
+// portable returns why pattern, which compiles as RE2, could match
+// differently as the PCRE pattern MongoDB runs, or "" when it cannot.
+func portable(pattern string) string {
+	for i := 0; i < len(pattern)-1; i++ {
+		if pattern[i] != '\\' {
+			continue
+		}
+		i++
+		switch c := pattern[i]; {
+		case c == 'Q':
+			// Everything up to \E is literal.
+			end := strings.Index(pattern[i:], `\E`)
+			if end < 0 {
+				return ""
+			}
+			i += end + 1
+		case c == 's' || c == 'S':
+			return `\s differs in MongoDB, which counts \v as a space; use [ \t\n\f\r]`
+		case c >= '0' && c <= '7':
+			return "octal escapes may be read as back-references by MongoDB; use \\x{...}"
+		}
+	}
+
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return ""
+	}
+	return portableNode(re)
+}
+
+// portableNode checks the parsed pattern for constructs the two engines treat
+// differently.
+func portableNode(re *syntax.Regexp) string {
+	switch re.Op {
+	case syntax.OpEndText:
+		if re.Flags&syntax.WasDollar != 0 {
+			return `$ also matches before a final newline in MongoDB; use \z`
+		}
+	case syntax.OpBeginLine, syntax.OpEndLine:
+		return "multi-line mode (?m) is not supported"
+	case syntax.OpLiteral, syntax.OpCharClass:
+		if re.Flags&syntax.FoldCase == 0 {
+			break
+		}
+		// A class holds its folded runes, which for ASCII letters include
+		// the long s and the Kelvin sign.
+		for i := 0; i < len(re.Rune); i++ {
+			r := re.Rune[i]
+			if r < utf8.RuneSelf || (re.Op == syntax.OpCharClass && r == re.Rune[i^1] && (r == '\u017f' || r == '\u212a')) {
+				continue
+			}
+			return "case-insensitive matching (?i) only applies to ASCII letters"
+		}
+	}
+	for _, sub := range re.Sub {
+		if msg := portableNode(sub); msg != "" {
+			return msg
+		}
+	}
+	return ""
+}
+
+// value parses a value of the kind of f.
+func (p *parser) value(f Field) (any, error) {
+	if p.tok.kind != tokString && p.tok.kind != tokNumber {
+		return nil, p.errorf("expected a value, found " + p.tok.describe())
+	}
+	v, err := f.literal(p.lex.src, p.tok)
+	if err != nil {
+		return nil, err
+	}
+	return v, p.advance()
+}
+
+// isKeyword reports whether s is one of the keywords, in any case.
+func isKeyword(s string) bool {
+	for _, kw := range []string{"AND", "OR", "NOT", "IN"} {
+		if strings.EqualFold(s, kw) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,183 @@
+// Package query parses filter expressions over users, such as
+//
+//	Age >= 18 AND Gender IN ("M", "F") OR Name ~ "^Sau"
+//
+// into an Expr, which can be turned into a MongoDB filter document or
+// evaluated against a userstore.User, with the same results. An Expr is a
+// userstore.Condition, so it can be set as the Where of a userstore.Filter.
+//
+// The grammar, with keywords in any case:
+//
+//	expr       = and { "OR" and }
+//	and        = unary { "AND" unary }
+//	unary      = "NOT" unary | "(" expr ")" | comparison
+//	comparison = field ( "=" | "!=" | "<" | "<=" | ">" | ">=" ) value
+//	           | field [ "NOT" ] "IN" "(" value { "," value } ")"
+//	           | field "~" string
+//
+// Fields are named as in the JSON form of a user, in any case: id, name, age,
+// gender, createdAt, updatedAt, deletedAt, expiresAt, version, and
+// attributes.<key>. Values are double-quoted strings, with Go escapes, or
+// whole numbers. Ages and versions take numbers; the other fields take
+// strings, which for the timestamps are RFC 3339 times or dates such as
+// "2024-01-31". "~" matches a regular expression, in RE2 syntax, against a
+// name or attribute.
+//
+// MongoDB runs patterns with PCRE, so only the part of RE2 on which the two
+// agree is accepted: "$" is refused, as PCRE also matches it before a final
+// newline, in favour of \z; so are \s, which in PCRE includes \v, octal
+// escapes, multi-line mode and case-insensitive matching of non-ASCII letters.
+//
+// Comparisons follow MongoDB: a missing attribute, deletedAt or expiresAt
+// satisfies only "!=" and "NOT IN".
+package query
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/userstore"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Expr is a parsed filter expression: an *And, *Or, *Not, *Comparison,
+// *In or *Regexp.
+type Expr interface {
+	userstore.Condition
+
+	// Pos returns the byte offset of the expression in the query it was parsed from.
+	Pos() int
+
+	// String returns the expression in the query syntax, fully parenthesized.
+	String() string
+}
+
+// SyntaxError reports a query that cannot be parsed, or that compares a
+// field with a value of the wrong kind, at the offending position.
+type SyntaxError struct {
+	Query  string
+	Offset int // byte offset into Query
+	Msg    string
+}
+
+// Column returns the 1-based column, in characters, of the offending position.
+func (e *SyntaxError) Column() int {
+	return utf8.RuneCountInString(e.Query[:e.Offset]) + 1
+}
+
+// Error implements the error interface.
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("query: column %d: %s", e.Column(), e.Msg)
+}
+
+// Caret returns the query with a caret on the line below, under the
+// offending position.
+func (e *SyntaxError) Caret() string {
+	return e.Query + "\n" + strings.Repeat(" ", e.Column()-1) + "^"
+}
+
+// errorAt returns a *SyntaxError at offset pos of query.
+func errorAt(query string, pos int, msg string) *SyntaxError {
+	return &SyntaxError{Query: query, Offset: pos, Msg: msg}
+}
+
+// And holds when all of its operands hold.
+type And struct {
+	Operands []Expr
+}
+
+// Or holds when any of its operands holds.
+type Or struct {
+	Operands []Expr
+}
+
+// Not holds when its operand does not.
+type Not struct {
+	X      Expr
+	NotPos int
+}
+
+func (e *And) Pos() int { return e.Operands[0].Pos() }
+func (e *Or) Pos() int  { return e.Operands[0].Pos() }
+func (e *Not) Pos() int { return e.NotPos }
+
+func (e *And) String() string { return join(e.Operands, " AND ") }
+func (e *Or) String() string  { return join(e.Operands, " OR ") }
+func (e *Not) String() string { return "NOT " + e.X.String() }
+
+// join returns the operands separated by sep, in parentheses.
+func join(operands []Expr, sep string) string {
+	parts := make([]string, len(operands))
+	for i, x := range operands {
+		parts[i] = x.String()
+	}
+	return "(" + strings.Join(parts, sep) + ")"
+}
+
+// MongoFilter translates the expression into a MongoDB query document.
+func (e *And) MongoFilter() bson.D {
+	return bson.D{{Key: "$and", Value: filters(e.Operands)}}
+}
+
+// MongoFilter translates the expression into a MongoDB query document.
+func (e *Or) MongoFilter() bson.D {
+	return bson.D{{Key: "$or", Value: filters(e.Operands)}}
+}
+
+// MongoFilter translates the expression into a MongoDB query document.
+func (e *Not) MongoFilter() bson.D {
+	return bson.D{{Key: "$nor", Value: bson.A{e.X.MongoFilter()}}}
+}
+
+func filters(operands []Expr) bson.A {
+	docs := make(bson.A, len(operands))
+	for i, x := range operands {
+		docs[i] = x.MongoFilter()
+	}
+	return docs
+}
+
+// Match reports whether u satisfies the expression.
+func (e *And) Match(u *userstore.User) bool {
+	for _, x := range e.Operands {
+		if !x.Match(u) {
+			return false
+		}
+	}
+	return true
+}
+
+// Match reports whether u satisfies the expression.
+func (e *Or) Match(u *userstore.User) bool {
+	for _, x := range e.Operands {
+		if x.Match(u) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match reports whether u satisfies the expression.
+func (e *Not) Match(u *userstore.User) bool {
+	return !e.X.Match(u)
+}
+
+// Compared returns the fields compared by the operands.
+func (e *And) Compared() map[string]bool { return compared(e.Operands) }
+
+// Compared returns the fields compared by the operands.
+func (e *Or) Compared() map[string]bool { return compared(e.Operands) }
+
+// Compared returns the fields compared by the operand.
+func (e *Not) Compared() map[string]bool { return e.X.Compared() }
+
+func compared(operands []Expr) map[string]bool {
+	fields := map[string]bool{}
+	for _, x := range operands {
+		for f, ranged := range x.Compared() {
+			fields[f] = fields[f] || ranged
+		}
+	}
+	return fields
+}
@@ -0,0 +1,150 @@
+package query
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/userstore"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		query      string
+		wantString string
+		wantFilter string // canonical extended JSON
+	}{
+		{`age >= 18`, `age >= 18`, `{"Age":{"$gte":18}}`},
+		{
+			`Age >= 18 AND Gender IN ("M", "F") OR Name ~ "^Sau"`,
+			`((age >= 18 AND gender IN ("M", "F")) OR name ~ "^Sau")`,
+			`{"$or":[{"$and":[{"Age":{"$gte":18}},{"Gender":{"$in":["M","F"]}}]},{"Name":{"$regex":"^Sau"}}]}`,
+		},
+		{`NOT (age < 18)`, `NOT age < 18`, `{"$nor":[{"Age":{"$lt":18}}]}`},
+		{`gender not in ("m")`, `gender NOT IN ("M")`, `{"Gender":{"$nin":["M"]}}`},
+		{`attributes.team = "core"`, `attributes.team = "core"`, `{"Attributes.team":"core"}`},
+		{`createdAt < "2024-01-31"`, `createdAt < "2024-01-31T00:00:00Z"`, `{"CreatedAt":{"$lt":{"$date":"2024-01-31T00:00:00Z"}}}`},
+		{`version = 2`, `version = 2`, `{"Version":2}`},
+		{`id = "0123456789abcdef01234567"`, `id = "0123456789abcdef01234567"`, `{"_id":{"$oid":"0123456789abcdef01234567"}}`},
+		{`name ~ "a\\z"`, `name ~ "a\\z"`, `{"Name":{"$regex":"a\\z"}}`},
+		{`name ~ "\\Q$\\E"`, `name ~ "\\Q$\\E"`, `{"Name":{"$regex":"\\Q$\\E"}}`},
+		{`name ~ "(?i)[k]"`, `name ~ "(?i)[k]"`, `{"Name":{"$regex":"(?i)[k]"}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			e, err := Parse(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := e.String(); got != tt.wantString {
+				t.Errorf("String() = %s, want %s", got, tt.wantString)
+			}
+			filter, err := bson.MarshalExtJSON(e.MongoFilter(), false, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(filter) != tt.wantFilter {
+				t.Errorf("MongoFilter() = %s, want %s", filter, tt.wantFilter)
+			}
+
+			// The string form parses back to the same expression.
+			again, err := Parse(e.String())
+			if err != nil {
+				t.Fatalf("reparse %s: %v", e.String(), err)
+			}
+			if again.String() != e.String() {
+				t.Errorf("reparsed as %s", again.String())
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		query      string
+		wantColumn int
+		wantMsg    string // a substring of the message
+	}{
+		{`age >=`, 7, "expected a value, found end of query"},
+		{`age = "x"`, 7, `age needs a number, not string "x"`},
+		{`name = 1`, 8, "name needs a string"},
+		{`nope = 1`, 1, `unknown field "nope"`},
+		{`(age = 1`, 9, `expected ")"`},
+		{`age = 1 garbage`, 9, `found "garbage"`},
+		{`age IN ()`, 9, "expected a value"},
+		{`name ~ "("`, 8, "invalid pattern"},
+
+		// Patterns on which RE2 and MongoDB's PCRE disagree.
+		{`name ~ "a$"`, 8, "use \\z"},
+		{`name ~ "\\s"`, 8, `\s differs in MongoDB`},
+		{`name ~ "\\101"`, 8, "octal escapes"},
+		{`name ~ "(?m)^a"`, 8, "multi-line mode"},
+		{`name ~ "(?i)é"`, 8, "only applies to ASCII letters"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			_, err := Parse(tt.query)
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("err = %v, want a *SyntaxError", err)
+			}
+			if syntaxErr.Column() != tt.wantColumn {
+				t.Errorf("column = %d, want %d", syntaxErr.Column(), tt.wantColumn)
+			}
+			if !strings.Contains(syntaxErr.Msg, tt.wantMsg) {
+				t.Errorf("message %q does not contain %q", syntaxErr.Msg, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	deleted := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	users := []*userstore.User{
+		{ID: primitive.NewObjectID(), Name: "Saurabh", Age: 30, Gender: "M", Attributes: map[string]string{"team": "core"}, Version: 1},
+		{ID: primitive.NewObjectID(), Name: "asha", Age: 17, Gender: "F", Version: 3},
+		{ID: primitive.NewObjectID(), Name: "Kiran\n", Age: 45, Gender: "O", DeletedAt: &deleted, Version: 2},
+	}
+
+	tests := []struct {
+		query string
+		want  string // names of the matching users, comma-separated
+	}{
+		{`age >= 18`, "Saurabh,Kiran\n"},
+		{`NOT age >= 18`, "asha"},
+		{`age >= 18 AND gender = "m"`, "Saurabh"},
+		{`gender IN ("F", "O") OR version = 1`, "Saurabh,asha,Kiran\n"},
+		{`gender NOT IN ("M")`, "asha,Kiran\n"},
+		{`name ~ "^[A-Z]"`, "Saurabh,Kiran\n"},
+		{`name ~ "(?i)^s"`, "Saurabh"},
+		{`name ~ "n\\z"`, ""},
+		{`name ~ "n\n\\z"`, "Kiran\n"},
+		{`attributes.team = "core"`, "Saurabh"},
+		{`attributes.team != "core"`, "asha,Kiran\n"},
+		{`deletedAt < "2025-01-01"`, "Kiran\n"},
+		{`deletedAt != "2024-03-01"`, "Saurabh,asha"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			e, err := Parse(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var matched []string
+			for _, u := range users {
+				if e.Match(u) {
+					matched = append(matched, u.Name)
+				}
+			}
+			if got := strings.Join(matched, ","); got != tt.want {
+				t.Errorf("matched %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
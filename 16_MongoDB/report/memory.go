@@ -0,0 +1,115 @@
+package report
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/userstore"
+)
+
+// MemoryReporter computes reports in memory from the users a repository streams.
+// It works with any repository but reads every matching user, so it is meant
+// for the memory store and for testing.
+type MemoryReporter struct {
+	repo userstore.Repository
+}
+
+// NewMemoryReporter returns a Reporter over the users stored in repo.
+func NewMemoryReporter(repo userstore.Repository) *MemoryReporter {
+	return &MemoryReporter{repo: repo}
+}
+
+// GroupCount counts users per value of field.
+func (r *MemoryReporter) GroupCount(ctx context.Context, field string, filter userstore.Filter) ([]Group, error) {
+	if _, err := groupKey(field); err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int64{}
+	err := r.each(ctx, filter, func(u *userstore.User) {
+		var key string
+		switch field {
+		case "gender":
+			key = string(u.Gender)
+		case "age":
+			key = strconv.Itoa(int(u.Age))
+		default:
+			key = u.Attributes[strings.TrimPrefix(field, "attributes.")]
+		}
+		counts[key]++
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([]Group, 0, len(counts))
+	for key, n := range counts {
+		groups = append(groups, Group{Key: key, Count: n})
+	}
+	sortGroups(groups, field)
+	return groups, nil
+}
+
+// AgeHistogram counts users per age bucket. Ages below the first bound are not counted.
+func (r *MemoryReporter) AgeHistogram(ctx context.Context, buckets Buckets, filter userstore.Filter) ([]Bucket, error) {
+	if err := buckets.validate(); err != nil {
+		return nil, err
+	}
+
+	result := buckets.empty()
+	err := r.each(ctx, filter, func(u *userstore.User) {
+		if i := buckets.index(u.Age); i >= 0 {
+			result[i].Count++
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// AgeStats returns the count and the minimum, maximum, mean and median age.
+func (r *MemoryReporter) AgeStats(ctx context.Context, filter userstore.Filter) (*Stats, error) {
+	var ages []int
+	err := r.each(ctx, filter, func(u *userstore.User) {
+		ages = append(ages, int(u.Age))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &Stats{Count: int64(len(ages))}
+	if len(ages) == 0 {
+		return stats, nil
+	}
+	sort.Ints(ages)
+
+	sum := 0
+	for _, age := range ages {
+		sum += age
+	}
+	stats.Min = userstore.Age(ages[0])
+	stats.Max = userstore.Age(ages[len(ages)-1])
+	stats.Mean = float64(sum) / float64(len(ages))
+	stats.Median = median(ages)
+	return stats, nil
+}
+
+// each calls fn for every user matching filter.
+func (r *MemoryReporter) each(ctx context.Context, filter userstore.Filter, fn func(*userstore.User)) error {
+	return r.repo.Stream(ctx, userstore.ListOptions{Filter: filter}, func(u *userstore.User) error {
+		fn(u)
+		return nil
+	})
+}
+
+// median returns the middle value of sorted ages, or the mean of the two middle values.
+func median(sorted []int) float64 {
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 1 {
+		return float64(sorted[mid])
+	}
+	return float64(sorted[mid-1]+sorted[mid]) / 2
+}
@@ -0,0 +1,170 @@
+package report
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/userstore"
+)
+
+// newTestReporter returns a reporter over four users and one in the trash.
+func newTestReporter(t *testing.T) *MemoryReporter {
+	t.Helper()
+	repo := userstore.NewMemoryRepository()
+	ctx := context.Background()
+
+	users := []*userstore.User{
+		{Name: "a", Age: 9, Gender: "F", Attributes: map[string]string{"team": "core"}},
+		{Name: "b", Age: 10, Gender: "M", Attributes: map[string]string{"team": "core"}},
+		{Name: "c", Age: 10, Gender: "F"},
+		{Name: "d", Age: 25, Gender: "O", Attributes: map[string]string{"team": "ops"}},
+		{Name: "e", Age: 40, Gender: "M"},
+	}
+	for _, u := range users {
+		if err := repo.Create(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repo.Delete(ctx, users[4].ID, userstore.AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	return NewMemoryReporter(repo)
+}
+
+func age(a userstore.Age) *userstore.Age { return &a }
+
+func TestMemoryGroupCount(t *testing.T) {
+	tests := []struct {
+		field   string
+		filter  userstore.Filter
+		want    []Group
+		wantErr bool
+	}{
+		{field: "gender", want: []Group{{"F", 2}, {"M", 1}, {"O", 1}}},
+		{field: "gender", filter: userstore.Filter{Deleted: userstore.IncludeDeleted}, want: []Group{{"F", 2}, {"M", 2}, {"O", 1}}},
+		{field: "gender", filter: userstore.Filter{MinAge: age(10)}, want: []Group{{"F", 1}, {"M", 1}, {"O", 1}}},
+		// Ages sort as numbers among equal counts.
+		{field: "age", want: []Group{{"10", 2}, {"9", 1}, {"25", 1}}},
+		// Users without the attribute are counted under the empty key.
+		{field: "attributes.team", want: []Group{{"core", 2}, {"", 1}, {"ops", 1}}},
+		{field: "name", wantErr: true},
+		{field: "attributes.", wantErr: true},
+		{field: "attributes.a.b", wantErr: true},
+	}
+
+	r := newTestReporter(t)
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			got, err := r.GroupCount(context.Background(), tt.field, tt.filter)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryAgeHistogram(t *testing.T) {
+	tests := []struct {
+		name    string
+		buckets Buckets
+		filter  userstore.Filter
+		want    []Bucket
+		wantErr bool
+	}{
+		{name: "all ages", buckets: Buckets{0, 10, 30}, want: []Bucket{{0, 9, 1}, {10, 29, 3}, {30, 150, 0}}},
+		{name: "including trash", buckets: Buckets{0, 10, 30}, filter: userstore.Filter{Deleted: userstore.IncludeDeleted}, want: []Bucket{{0, 9, 1}, {10, 29, 3}, {30, 150, 1}}},
+		{name: "below the first bound", buckets: Buckets{10, 30}, want: []Bucket{{10, 29, 3}, {30, 150, 0}}},
+		{name: "single ages", buckets: Buckets{9, 10, 11}, want: []Bucket{{9, 9, 1}, {10, 10, 2}, {11, 150, 1}}},
+		{name: "decreasing", buckets: Buckets{30, 10}, wantErr: true},
+		{name: "out of range", buckets: Buckets{200}, wantErr: true},
+		{name: "none", buckets: Buckets{}, wantErr: true},
+	}
+
+	r := newTestReporter(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.AgeHistogram(context.Background(), tt.buckets, tt.filter)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryAgeStats(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter userstore.Filter
+		want   Stats
+	}{
+		{name: "even count", want: Stats{Count: 4, Min: 9, Max: 25, Mean: 13.5, Median: 10}},
+		{name: "odd count", filter: userstore.Filter{Deleted: userstore.IncludeDeleted}, want: Stats{Count: 5, Min: 9, Max: 40, Mean: 18.8, Median: 10}},
+		{name: "minimum age", filter: userstore.Filter{MinAge: age(10)}, want: Stats{Count: 3, Min: 10, Max: 25, Mean: 15, Median: 10}},
+		{name: "no users", filter: userstore.Filter{Name: "nobody"}, want: Stats{}},
+	}
+
+	r := newTestReporter(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.AgeStats(context.Background(), tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != tt.want {
+				t.Errorf("got %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseBuckets(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Buckets
+		wantErr bool
+	}{
+		{in: "0,18,30,65", want: Buckets{0, 18, 30, 65}},
+		{in: "18", want: Buckets{18}},
+		{in: "0,x", wantErr: true},
+		{in: "30,18", wantErr: true},
+		{in: "18,18", wantErr: true},
+		{in: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseBuckets(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package report
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/userstore"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MongoReporter runs reports as aggregation pipelines on a user collection.
+type MongoReporter struct {
+	coll *mongo.Collection
+}
+
+// NewMongoReporter returns a Reporter over the users stored in coll.
+func NewMongoReporter(coll *mongo.Collection) *MongoReporter {
+	return &MongoReporter{coll: coll}
+}
+
+// GroupCount counts users per value of field with a $group stage.
+func (r *MongoReporter) GroupCount(ctx context.Context, field string, filter userstore.Filter) ([]Group, error) {
+	key, err := groupKey(field)
+	if err != nil {
+		return nil, err
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$" + key},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+		}}},
+	}
+	var rows []struct {
+		Key   bson.RawValue `bson:"_id"`
+		Count int64         `bson:"count"`
+	}
+	if err := r.aggregate(ctx, filter, pipeline, &rows); err != nil {
+		return nil, err
+	}
+
+	// Legacy documents may hold a value with a different BSON type, such as
+	// the age "42" next to 42, so merge groups that render the same.
+	counts := map[string]int64{}
+	for _, row := range rows {
+		counts[keyString(row.Key)] += row.Count
+	}
+	groups := make([]Group, 0, len(counts))
+	for key, n := range counts {
+		groups = append(groups, Group{Key: key, Count: n})
+	}
+	sortGroups(groups, field)
+	return groups, nil
+}
+
+// AgeHistogram counts users per age bucket with a $bucket stage.
+// Ages below the first bound are not counted.
+func (r *MongoReporter) AgeHistogram(ctx context.Context, buckets Buckets, filter userstore.Filter) ([]Bucket, error) {
+	if err := buckets.validate(); err != nil {
+		return nil, err
+	}
+
+	boundaries := bson.A{}
+	for _, b := range buckets {
+		boundaries = append(boundaries, int(b))
+	}
+	boundaries = append(boundaries, userstore.MaxAge+1)
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$bucket", Value: bson.D{
+			{Key: "groupBy", Value: "$Age"},
+			{Key: "boundaries", Value: boundaries},
+			{Key: "default", Value: "other"},
+			{Key: "output", Value: bson.D{{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}}}},
+		}}},
+	}
+	var rows []struct {
+		Min   bson.RawValue `bson:"_id"`
+		Count int64         `bson:"count"`
+	}
+	if err := r.aggregate(ctx, filter, pipeline, &rows); err != nil {
+		return nil, err
+	}
+
+	// Each bucket is identified by its lower bound; the "other" bucket is dropped.
+	result := buckets.empty()
+	for _, row := range rows {
+		min, ok := row.Min.AsInt64OK()
+		if !ok {
+			continue
+		}
+		if i := buckets.index(userstore.Age(min)); i >= 0 {
+			result[i].Count += row.Count
+		}
+	}
+	return result, nil
+}
+
+// AgeStats returns the count and the minimum, maximum, mean and median age.
+// The median is read by sorting on Age and skipping to the middle, which works
+// on servers older than the $median operator.
+func (r *MongoReporter) AgeStats(ctx context.Context, filter userstore.Filter) (*Stats, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: nil},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+			{Key: "min", Value: bson.D{{Key: "$min", Value: "$Age"}}},
+			{Key: "max", Value: bson.D{{Key: "$max", Value: "$Age"}}},
+			{Key: "mean", Value: bson.D{{Key: "$avg", Value: "$Age"}}},
+		}}},
+	}
+	var rows []Stats
+	if err := r.aggregate(ctx, filter, pipeline, &rows); err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return &Stats{}, nil
+	}
+	stats := rows[0]
+
+	middle := mongo.Pipeline{
+		{{Key: "$sort", Value: bson.D{{Key: "Age", Value: 1}}}},
+		{{Key: "$skip", Value: (stats.Count - 1) / 2}},
+		{{Key: "$limit", Value: 2 - stats.Count%2}},
+		{{Key: "$project", Value: bson.D{{Key: "_id", Value: 0}, {Key: "Age", Value: 1}}}},
+	}
+	var mid []struct {
+		Age userstore.Age `bson:"Age"`
+	}
+	if err := r.aggregate(ctx, filter, middle, &mid); err != nil {
+		return nil, err
+	}
+	ages := make([]int, len(mid))
+	for i, m := range mid {
+		ages[i] = int(m.Age)
+	}
+	if len(ages) > 0 {
+		stats.Median = median(ages)
+	}
+	return &stats, nil
+}
+
+// aggregate runs pipeline on the users matching filter and decodes every result into rows.
+func (r *MongoReporter) aggregate(ctx context.Context, filter userstore.Filter, pipeline mongo.Pipeline, rows any) error {
+	if err := filter.Validate(); err != nil {
+		return err
+	}
+
+	stages := append(mongo.Pipeline{{{Key: "$match", Value: filter.MongoFilter()}}}, pipeline...)
+	cursor, err := r.coll.Aggregate(ctx, stages)
+	if err != nil {
+		return fmt.Errorf("report: aggregate: %w", err)
+	}
+	if err := cursor.All(ctx, rows); err != nil {
+		return fmt.Errorf("report: aggregate: %w", err)
+	}
+	return nil
+}
+
+// keyString renders a grouped value as a Group key. Missing values become "".
+func keyString(v bson.RawValue) string {
+	switch v.Type {
+	case bsontype.String:
+		return v.StringValue()
+	case bsontype.Null, bsontype.Undefined, 0:
+		return ""
+	}
+	if n, ok := v.AsInt64OK(); ok {
+		return fmt.Sprint(n)
+	}
+	return v.String()
+}
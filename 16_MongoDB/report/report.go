@@ -0,0 +1,164 @@
+// Package report answers summary questions about the user collection:
+// how many users there are per gender or attribute value, how ages are
+// distributed, and the minimum, maximum, mean and median age.
+//
+// MongoReporter runs the reports as aggregation pipelines on the server.
+// MemoryReporter computes the same results from any userstore.Repository,
+// so reports can be checked offline against a MemoryRepository.
+// Results are turned into a Table for printing as text, CSV or JSON.
+package report
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/userstore"
+)
+
+// Reporter computes reports over the users matching a filter.
+type Reporter interface {
+	// GroupCount counts users per value of field: "gender", "age" or "attributes.<key>".
+	// Users without the field are counted under the empty key.
+	GroupCount(ctx context.Context, field string, filter userstore.Filter) ([]Group, error)
+
+	// AgeHistogram counts users per age bucket. See ParseBuckets.
+	AgeHistogram(ctx context.Context, buckets Buckets, filter userstore.Filter) ([]Bucket, error)
+
+	// AgeStats returns the count and the minimum, maximum, mean and median age.
+	AgeStats(ctx context.Context, filter userstore.Filter) (*Stats, error)
+}
+
+// Group is the number of users sharing one value of the grouped field.
+type Group struct {
+	Key   string `json:"key"`
+	Count int64  `json:"count"`
+}
+
+// Bucket is the number of users whose age lies within Min and Max, inclusive.
+type Bucket struct {
+	Min   userstore.Age `json:"min"`
+	Max   userstore.Age `json:"max"`
+	Count int64         `json:"count"`
+}
+
+// Label returns the bucket's range, such as "18-29".
+func (b Bucket) Label() string {
+	if b.Min == b.Max {
+		return strconv.Itoa(int(b.Min))
+	}
+	return fmt.Sprintf("%d-%d", b.Min, b.Max)
+}
+
+// Stats summarizes the ages of a set of users. Min, Max, Mean and Median are
+// zero when Count is zero.
+type Stats struct {
+	Count  int64         `json:"count"`
+	Min    userstore.Age `json:"min"`
+	Max    userstore.Age `json:"max"`
+	Mean   float64       `json:"mean"`
+	Median float64       `json:"median"`
+}
+
+// Buckets holds the lower bounds of consecutive age buckets in increasing order.
+// Each bucket reaches up to the next bound, and the last one up to userstore.MaxAge.
+type Buckets []userstore.Age
+
+// ParseBuckets parses lower bounds such as "0,18,30,65".
+func ParseBuckets(s string) (Buckets, error) {
+	var b Buckets
+	for _, part := range strings.Split(s, ",") {
+		age, err := userstore.ParseAge(part)
+		if err != nil {
+			return nil, fmt.Errorf("report: bucket bound %q is not a whole number", part)
+		}
+		b = append(b, age)
+	}
+	return b, b.validate()
+}
+
+// UniformBuckets returns buckets of the given width covering every valid age.
+func UniformBuckets(width int) (Buckets, error) {
+	if width < 1 {
+		return nil, fmt.Errorf("report: bucket width must be at least 1")
+	}
+	var b Buckets
+	for age := userstore.MinAge; age <= userstore.MaxAge; age += width {
+		b = append(b, userstore.Age(age))
+	}
+	return b, nil
+}
+
+// validate checks that the bounds are valid ages in increasing order.
+func (b Buckets) validate() error {
+	if len(b) == 0 {
+		return fmt.Errorf("report: at least one bucket is required")
+	}
+	for i, age := range b {
+		if age < userstore.MinAge || age > userstore.MaxAge {
+			return fmt.Errorf("report: bucket bound %d is not between %d and %d", age, userstore.MinAge, userstore.MaxAge)
+		}
+		if i > 0 && age <= b[i-1] {
+			return fmt.Errorf("report: bucket bounds must increase, %d follows %d", age, b[i-1])
+		}
+	}
+	return nil
+}
+
+// empty returns the buckets with zero counts, in order.
+func (b Buckets) empty() []Bucket {
+	buckets := make([]Bucket, len(b))
+	for i, min := range b {
+		max := userstore.Age(userstore.MaxAge)
+		if i+1 < len(b) {
+			max = b[i+1] - 1
+		}
+		buckets[i] = Bucket{Min: min, Max: max}
+	}
+	return buckets
+}
+
+// index returns the bucket holding age, or -1 when age is below the first bound.
+func (b Buckets) index(age userstore.Age) int {
+	return sort.Search(len(b), func(i int) bool { return b[i] > age }) - 1
+}
+
+// groupKey returns the BSON path of a groupable field.
+func groupKey(field string) (string, error) {
+	switch field {
+	case "gender":
+		return "Gender", nil
+	case "age":
+		return "Age", nil
+	}
+	if key, ok := strings.CutPrefix(field, "attributes."); ok && key != "" && !strings.ContainsAny(key, ".$") {
+		return "Attributes." + key, nil
+	}
+	return "", fmt.Errorf(`report: cannot group by %q, want "gender", "age" or "attributes.<key>"`, field)
+}
+
+// sortGroups orders groups by descending count, then by key.
+// Ages are compared as numbers so that "9" comes before "10".
+func sortGroups(groups []Group, field string) {
+	sort.Slice(groups, func(i, j int) bool {
+		a, b := groups[i], groups[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		if field == "age" {
+			x, errX := strconv.Atoi(a.Key)
+			y, errY := strconv.Atoi(b.Key)
+			if errX == nil && errY == nil {
+				return x < y
+			}
+		}
+		return a.Key < b.Key
+	})
+}
+
+var (
+	_ Reporter = (*MongoReporter)(nil)
+	_ Reporter = (*MemoryReporter)(nil)
+)
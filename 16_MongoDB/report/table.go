@@ -0,0 +1,137 @@
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Format is an output format for a Table.
+type Format string
+
+// The supported formats.
+const (
+	Text Format = "table"
+	CSV  Format = "csv"
+	JSON Format = "json"
+)
+
+// ParseFormat parses "table", "csv" or "json".
+func ParseFormat(s string) (Format, error) {
+	switch Format(strings.ToLower(s)) {
+	case Text, "text":
+		return Text, nil
+	case CSV:
+		return CSV, nil
+	case JSON:
+		return JSON, nil
+	}
+	return "", fmt.Errorf("report: unknown format %q, want table, csv or json", s)
+}
+
+// Table is a report result ready to be written in any Format.
+type Table struct {
+	Columns []string
+	Rows    [][]any
+}
+
+// GroupTable turns group counts into a table with the grouped field and a share of the total.
+func GroupTable(field string, groups []Group) *Table {
+	var total int64
+	for _, g := range groups {
+		total += g.Count
+	}
+
+	t := &Table{Columns: []string{field, "count", "percent"}}
+	for _, g := range groups {
+		key := g.Key
+		if key == "" {
+			key = "(none)"
+		}
+		t.Rows = append(t.Rows, []any{key, g.Count, percent(g.Count, total)})
+	}
+	return t
+}
+
+// HistogramTable turns age buckets into a table with one row per bucket.
+func HistogramTable(buckets []Bucket) *Table {
+	t := &Table{Columns: []string{"age", "count"}}
+	for _, b := range buckets {
+		t.Rows = append(t.Rows, []any{b.Label(), b.Count})
+	}
+	return t
+}
+
+// StatsTable turns age statistics into a single-row table.
+func StatsTable(s *Stats) *Table {
+	return &Table{
+		Columns: []string{"count", "min", "max", "mean", "median"},
+		Rows:    [][]any{{s.Count, int(s.Min), int(s.Max), round2(s.Mean), round2(s.Median)}},
+	}
+}
+
+// Write renders the table to w.
+func (t *Table) Write(w io.Writer, format Format) error {
+	switch format {
+	case Text:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(tw, strings.Join(t.Columns, "\t")+"\t")
+		for _, row := range t.Rows {
+			fmt.Fprintln(tw, strings.Join(t.strings(row), "\t")+"\t")
+		}
+		return tw.Flush()
+
+	case CSV:
+		cw := csv.NewWriter(w)
+		cw.Write(t.Columns)
+		for _, row := range t.Rows {
+			cw.Write(t.strings(row))
+		}
+		cw.Flush()
+		return cw.Error()
+
+	case JSON:
+		// Rows become objects keyed by column, keeping numbers as numbers.
+		objects := make([]map[string]any, len(t.Rows))
+		for i, row := range t.Rows {
+			objects[i] = make(map[string]any, len(t.Columns))
+			for j, col := range t.Columns {
+				objects[i][col] = row[j]
+			}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(objects)
+	}
+	return fmt.Errorf("report: unknown format %q", format)
+}
+
+// strings formats the cells of a row.
+func (t *Table) strings(row []any) []string {
+	cells := make([]string, len(row))
+	for i, v := range row {
+		if f, ok := v.(float64); ok {
+			cells[i] = strconv.FormatFloat(f, 'f', -1, 64)
+			continue
+		}
+		cells[i] = fmt.Sprint(v)
+	}
+	return cells
+}
+
+// percent returns n as a percentage of total, rounded to two decimals.
+func percent(n, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return round2(float64(n) * 100 / float64(total))
+}
+
+// round2 rounds f to two decimals.
+func round2(f float64) float64 {
+	return float64(int64(f*100+0.5)) / 100
+}
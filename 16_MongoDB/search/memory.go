@@ -0,0 +1,39 @@
+package search
+
+import (
+	"context"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/userstore"
+)
+
+// MemorySearcher searches the users a repository streams. It works with any
+// repository but reads every user the filter selects, so it is meant for the
+// memory and file stores and for testing.
+type MemorySearcher struct {
+	repo userstore.Repository
+}
+
+// NewMemorySearcher returns a Searcher over the users stored in repo.
+func NewMemorySearcher(repo userstore.Repository) *MemorySearcher {
+	return &MemorySearcher{repo: repo}
+}
+
+// Search scores every user selected by q.Filter.
+func (s *MemorySearcher) Search(ctx context.Context, q Query) ([]Result, error) {
+	m, err := q.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	var results []Result
+	err = s.repo.Stream(ctx, userstore.ListOptions{Filter: q.Filter}, func(u *userstore.User) error {
+		if score, ok := m.score(u); ok {
+			results = append(results, Result{User: *u, Score: score})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rank(results, m.limit), nil
+}
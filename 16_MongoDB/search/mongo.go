@@ -0,0 +1,62 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/userstore"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MongoSearcher searches a user collection on the server.
+//
+// Exact searches use the collection's text index, which userstore.Migrations
+// creates, to read only the users holding at least one of the words; the
+// index folds case and diacritics as Fold does. A text index only knows whole
+// words, so the other modes read every user the filter selects. In both cases
+// the users are scored and ranked as MemorySearcher does.
+type MongoSearcher struct {
+	coll *mongo.Collection
+}
+
+// NewMongoSearcher returns a Searcher over the users stored in coll.
+func NewMongoSearcher(coll *mongo.Collection) *MongoSearcher {
+	return &MongoSearcher{coll: coll}
+}
+
+// Search finds the candidates on the server and scores them.
+func (s *MongoSearcher) Search(ctx context.Context, q Query) ([]Result, error) {
+	m, err := q.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	filter := q.Filter.MongoFilter()
+	if m.mode == Exact {
+		// $text matches documents holding any of the words; score keeps
+		// only those holding all of them.
+		filter = append(filter, bson.E{Key: "$text", Value: bson.D{{Key: "$search", Value: strings.Join(m.terms, " ")}}})
+	}
+	cursor, err := s.coll.Find(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("search: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var results []Result
+	for cursor.Next(ctx) {
+		var u userstore.User
+		if err := cursor.Decode(&u); err != nil {
+			return nil, fmt.Errorf("search: decode: %w", err)
+		}
+		if score, ok := m.score(&u); ok {
+			results = append(results, Result{User: u, Score: score})
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("search: %w", err)
+	}
+	return rank(results, m.limit), nil
+}
@@ -0,0 +1,219 @@
+// Package search finds users by the words in their name and attribute values.
+//
+// Text is compared after folding case and diacritics, so "jose" finds "José"
+// and "STRASSE" finds "Straße". Each word of the query must match a word of
+// the user, exactly, as a prefix, as a substring or within an edit distance,
+// depending on the Mode. Results are ranked by how well and where the words
+// matched: a match in the name counts twice as much as one in an attribute.
+//
+// MongoSearcher looks exact words up in the collection's text index and scans
+// the collection for the other modes. MemorySearcher searches the users of any
+// userstore.Repository; both rank the same users the same way.
+package search
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/userstore"
+)
+
+// Searcher finds the users matching a query.
+type Searcher interface {
+	// Search returns the best matches for q, best first.
+	Search(ctx context.Context, q Query) ([]Result, error)
+}
+
+// Mode is how a word of the query may match a word of a user. Each mode also
+// accepts the matches of the modes before it.
+type Mode int
+
+// The search modes.
+const (
+	Exact     Mode = iota // the whole word
+	Prefix                // the beginning of a word
+	Substring             // any part of a word
+	Fuzzy                 // any part of a word, or a word within an edit distance
+)
+
+var modeNames = []string{"exact", "prefix", "substring", "fuzzy"}
+
+func (m Mode) String() string {
+	if m < 0 || int(m) >= len(modeNames) {
+		return fmt.Sprintf("Mode(%d)", int(m))
+	}
+	return modeNames[m]
+}
+
+// ParseMode parses "exact", "prefix", "substring" or "fuzzy".
+func ParseMode(s string) (Mode, error) {
+	for i, name := range modeNames {
+		if s == name {
+			return Mode(i), nil
+		}
+	}
+	return 0, &userstore.ValidationError{Field: "mode", Reason: "must be exact, prefix, substring or fuzzy"}
+}
+
+// DefaultLimit is the number of results returned when Query.Limit is zero.
+const DefaultLimit = 20
+
+// Query describes a search.
+type Query struct {
+	// Text holds the words to look for.
+	Text string
+
+	Mode Mode
+
+	// MaxDistance is the largest edit distance a Fuzzy match may have. Zero
+	// picks it from the length of each word: 0 up to 2 letters, 1 up to 5
+	// and 2 beyond.
+	MaxDistance int
+
+	// Limit caps the number of results. Zero means DefaultLimit.
+	Limit int
+
+	// Filter restricts the search to the users it selects.
+	Filter userstore.Filter
+}
+
+// Result is a user matching a query.
+type Result struct {
+	User  userstore.User `json:"user"`
+	Score float64        `json:"score"`
+}
+
+// Field weights.
+const (
+	nameWeight      = 2
+	attributeWeight = 1
+)
+
+// matcher scores users against the words of a query.
+type matcher struct {
+	terms       []string
+	mode        Mode
+	maxDistance int
+	limit       int
+}
+
+// compile checks q and prepares its words.
+func (q *Query) compile() (*matcher, error) {
+	if err := q.Filter.Validate(); err != nil {
+		return nil, err
+	}
+	if q.Mode < Exact || q.Mode > Fuzzy {
+		return nil, &userstore.ValidationError{Field: "mode", Reason: "is unknown"}
+	}
+	if q.MaxDistance < 0 {
+		return nil, &userstore.ValidationError{Field: "distance", Reason: "must not be negative"}
+	}
+	if q.Limit < 0 {
+		return nil, &userstore.ValidationError{Field: "limit", Reason: "must not be negative"}
+	}
+
+	m := &matcher{terms: Words(q.Text), mode: q.Mode, maxDistance: q.MaxDistance, limit: q.Limit}
+	if len(m.terms) == 0 {
+		return nil, &userstore.ValidationError{Field: "query", Reason: "has no words to search for"}
+	}
+	if m.limit == 0 {
+		m.limit = DefaultLimit
+	}
+	return m, nil
+}
+
+// score returns how well u matches. ok is false unless every term matches.
+func (m *matcher) score(u *userstore.User) (score float64, ok bool) {
+	name := Words(u.Name)
+	var attrs []string
+	for _, v := range u.Attributes {
+		attrs = append(attrs, Words(v)...)
+	}
+
+	for _, t := range m.terms {
+		best := nameWeight * m.bestMatch(t, name)
+		if s := attributeWeight * m.bestMatch(t, attrs); s > best {
+			best = s
+		}
+		if best == 0 {
+			return 0, false
+		}
+		score += best
+	}
+	// Rounding keeps the printed scores short and equal scores equal.
+	return math.Round(score*1000) / 1000, true
+}
+
+// bestMatch returns the score of the best match of term among words, or 0.
+//
+// An exact match scores 1. Prefix and substring matches score less, and more
+// the more of the word they cover. A fuzzy match scores less the more edits
+// it needs.
+func (m *matcher) bestMatch(term string, words []string) float64 {
+	var best float64
+	for _, w := range words {
+		if s := m.match(term, w); s > best {
+			best = s
+		}
+	}
+	return best
+}
+
+func (m *matcher) match(term, word string) float64 {
+	if term == word {
+		return 1
+	}
+	coverage := float64(runeCount(term)) / float64(runeCount(word))
+	switch {
+	case m.mode >= Prefix && strings.HasPrefix(word, term):
+		return 0.5 + 0.4*coverage
+	case m.mode >= Substring && strings.Contains(word, term):
+		return 0.3 + 0.4*coverage
+	case m.mode >= Fuzzy:
+		limit := m.maxDistance
+		if limit == 0 {
+			limit = autoDistance(term)
+		}
+		if d := distance(term, word, limit); d <= limit && limit > 0 {
+			return 0.3 * (1 - float64(d)/float64(runeCount(term)+1))
+		}
+	}
+	return 0
+}
+
+// autoDistance is the edit distance allowed for term when none is given.
+func autoDistance(term string) int {
+	switch n := runeCount(term); {
+	case n <= 2:
+		return 0
+	case n <= 5:
+		return 1
+	}
+	return 2
+}
+
+// rank sorts results best first, then by name, and keeps the first limit.
+func rank(results []Result, limit int) []Result {
+	sort.Slice(results, func(i, j int) bool {
+		a, b := &results[i], &results[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.User.Name != b.User.Name {
+			return a.User.Name < b.User.Name
+		}
+		return a.User.ID.Hex() < b.User.ID.Hex()
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+var (
+	_ Searcher = (*MongoSearcher)(nil)
+	_ Searcher = (*MemorySearcher)(nil)
+)
@@ -0,0 +1,244 @@
+// Package seed generates realistic synthetic users and loads them into a
+// repository, to seed development databases and to load test them.
+//
+// A Generator draws names from built-in lists, ages from a configurable
+// distribution and genders from a configurable mix. It is driven by a seeded
+// random source, so the same seed and options always produce the same users,
+// in the same order. Load inserts them through Repository.CreateMany in
+// batches written by parallel workers, at a target rate if one is set, and
+// reports the throughput and the latency percentiles of the batches.
+package seed
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/userstore"
+)
+
+// Defaults used when Options leaves a field empty.
+const (
+	DefaultAges    = "normal:38,15"
+	DefaultGenders = "M=49,F=49,O=2"
+)
+
+// Options controls what a Generator produces.
+type Options struct {
+	// Seed selects the sequence of users. The same seed gives the same users.
+	Seed int64
+
+	// Ages is the age distribution; see ParseAges. The zero value means DefaultAges.
+	Ages Ages
+
+	// Genders is the gender mix; see ParseGenders. Nil means DefaultGenders.
+	Genders Genders
+}
+
+// Ages is a distribution of ages.
+type Ages struct {
+	// Kind is "uniform", drawing whole years between A and B, or "normal",
+	// with mean A and standard deviation B, redrawn until within the valid
+	// ages.
+	Kind string
+	A, B float64
+}
+
+// ParseAges parses an age distribution such as "uniform:18,80" or "normal:38,15".
+func ParseAges(s string) (Ages, error) {
+	kind, params, ok := strings.Cut(s, ":")
+	if !ok {
+		return Ages{}, fmt.Errorf("seed: age distribution %q needs a kind and two numbers, such as %q", s, DefaultAges)
+	}
+	x, y, ok := strings.Cut(params, ",")
+	if !ok {
+		return Ages{}, fmt.Errorf("seed: age distribution %q needs two numbers", s)
+	}
+	a, errA := strconv.ParseFloat(strings.TrimSpace(x), 64)
+	b, errB := strconv.ParseFloat(strings.TrimSpace(y), 64)
+	if errA != nil || errB != nil {
+		return Ages{}, fmt.Errorf("seed: age distribution %q needs two numbers", s)
+	}
+
+	ages := Ages{Kind: strings.ToLower(kind), A: a, B: b}
+	return ages, ages.validate()
+}
+
+func (d Ages) validate() error {
+	switch d.Kind {
+	case "uniform":
+		if d.A > d.B || d.A < userstore.MinAge || d.B > userstore.MaxAge {
+			return fmt.Errorf("seed: uniform ages need %d <= min <= max <= %d", userstore.MinAge, userstore.MaxAge)
+		}
+	case "normal":
+		if d.B <= 0 || d.A < userstore.MinAge || d.A > userstore.MaxAge {
+			return fmt.Errorf("seed: normal ages need a mean between %d and %d and a positive deviation", userstore.MinAge, userstore.MaxAge)
+		}
+	default:
+		return fmt.Errorf("seed: unknown age distribution %q, want uniform or normal", d.Kind)
+	}
+	return nil
+}
+
+// String returns the distribution in the form ParseAges reads.
+func (d Ages) String() string {
+	return fmt.Sprintf("%s:%g,%g", d.Kind, d.A, d.B)
+}
+
+// Genders is a gender mix: the relative weight of each gender.
+type Genders map[userstore.Gender]float64
+
+// ParseGenders parses a gender mix such as "M=49,F=49,O=2". The weights are
+// relative and need not add up to 100; genders left out are not generated.
+func ParseGenders(s string) (Genders, error) {
+	mix := Genders{}
+	for _, part := range strings.Split(s, ",") {
+		name, weight, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("seed: gender mix entry %q is not GENDER=WEIGHT", part)
+		}
+		g, err := userstore.ParseGender(name)
+		if err != nil {
+			return nil, err
+		}
+		w, err := strconv.ParseFloat(weight, 64)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("seed: gender weight %q is not a non-negative number", weight)
+		}
+		mix[g] += w
+	}
+
+	var total float64
+	for _, w := range mix {
+		total += w
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("seed: gender mix %q has no positive weight", s)
+	}
+	return mix, nil
+}
+
+// Generator produces synthetic users. It is not safe for concurrent use.
+type Generator struct {
+	rng     *rand.Rand
+	ages    Ages
+	genders []userstore.Gender // in a fixed order, so the seed decides alone
+	weights []float64          // cumulative, ending at 1
+
+	// seen counts the names handed out, to number the repeats.
+	seen map[string]int
+}
+
+// NewGenerator returns a Generator for opts.
+func NewGenerator(opts Options) (*Generator, error) {
+	if opts.Ages == (Ages{}) {
+		opts.Ages, _ = ParseAges(DefaultAges)
+	} else if err := opts.Ages.validate(); err != nil {
+		return nil, err
+	}
+	if opts.Genders == nil {
+		opts.Genders, _ = ParseGenders(DefaultGenders)
+	}
+
+	g := &Generator{
+		rng:  rand.New(rand.NewSource(opts.Seed)),
+		ages: opts.Ages,
+		seen: map[string]int{},
+	}
+	var total float64
+	for gender, w := range opts.Genders {
+		if w > 0 {
+			g.genders = append(g.genders, gender)
+			total += w
+		}
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("seed: gender mix has no positive weight")
+	}
+	sort.Slice(g.genders, func(i, j int) bool { return g.genders[i] < g.genders[j] })
+	var sum float64
+	for _, gender := range g.genders {
+		sum += opts.Genders[gender] / total
+		g.weights = append(g.weights, sum)
+	}
+	g.weights[len(g.weights)-1] = 1
+	return g, nil
+}
+
+// Next returns a new user, without an ID. Names are unique within the
+// generator: a repeated name is numbered, as in "Priya Shah 2".
+func (g *Generator) Next() *userstore.User {
+	first := firstNames[g.rng.Intn(len(firstNames))]
+	last := lastNames[g.rng.Intn(len(lastNames))]
+	name := first + " " + last
+	g.seen[name]++
+	n := g.seen[name]
+	if n > 1 {
+		name += " " + strconv.Itoa(n)
+	}
+
+	email := strings.ToLower(first + "." + last)
+	if n > 1 {
+		email += strconv.Itoa(n)
+	}
+	return &userstore.User{
+		Name:   name,
+		Age:    g.age(),
+		Gender: g.gender(),
+		Attributes: map[string]string{
+			"city":  cities[g.rng.Intn(len(cities))],
+			"email": email + "@example.com",
+		},
+	}
+}
+
+// age draws an age from the distribution.
+func (g *Generator) age() userstore.Age {
+	if g.ages.Kind == "uniform" {
+		lo, hi := int(math.Ceil(g.ages.A)), int(math.Floor(g.ages.B))
+		return userstore.Age(lo + g.rng.Intn(hi-lo+1))
+	}
+	for {
+		age := math.Round(g.rng.NormFloat64()*g.ages.B + g.ages.A)
+		if age >= userstore.MinAge && age <= userstore.MaxAge {
+			return userstore.Age(age)
+		}
+	}
+}
+
+// gender draws a gender from the mix.
+func (g *Generator) gender() userstore.Gender {
+	x := g.rng.Float64()
+	for i, w := range g.weights {
+		if x < w {
+			return g.genders[i]
+		}
+	}
+	return g.genders[len(g.genders)-1]
+}
+
+var firstNames = []string{
+	"Aarav", "Aditi", "Amelia", "Ana", "Ananya", "Arjun", "Ava", "Carlos", "Chen", "Chloe",
+	"Daniel", "David", "Diya", "Elena", "Emma", "Ethan", "Fatima", "Gabriel", "Hana", "Hiro",
+	"Ibrahim", "Isabella", "Ishaan", "Ivan", "James", "Kabir", "Kavya", "Lakshmi", "Liam", "Lucas",
+	"Maria", "Mateo", "Mei", "Meera", "Mohammed", "Noah", "Nour", "Olivia", "Omar", "Priya",
+	"Rahul", "Riya", "Rohan", "Sakura", "Samuel", "Sara", "Saurabh", "Sofia", "Sophie", "Tanvi",
+	"Thomas", "Vihaan", "Wei", "Yara", "Yusuf", "Zara", "Zoe",
+}
+
+var lastNames = []string{
+	"Ali", "Bauer", "Brown", "Chen", "Costa", "Das", "Dubois", "Fernandes", "Garcia", "Gupta",
+	"Hassan", "Iyer", "Ivanov", "Jain", "Johnson", "Kapoor", "Khan", "Kim", "Kowalski", "Kumar",
+	"Lee", "Lopez", "Martin", "Mehta", "Mishra", "Moreau", "Muller", "Nair", "Nguyen", "Novak",
+	"Okafor", "Patel", "Pereira", "Rao", "Reddy", "Rossi", "Sato", "Schmidt", "Shah", "Sharma",
+	"Silva", "Singh", "Smith", "Suzuki", "Tanaka", "Verma", "Wang", "Williams", "Yadav", "Zhang",
+}
+
+var cities = []string{
+	"Amsterdam", "Bengaluru", "Berlin", "Chennai", "Delhi", "Dubai", "Hyderabad", "Istanbul",
+	"Jaipur", "Kolkata", "Lagos", "Lisbon", "London", "Madrid", "Mexico City", "Mumbai",
+	"Nairobi", "New York", "Paris", "Pune", "Seoul", "Singapore", "Sydney", "Tokyo", "Toronto",
+}
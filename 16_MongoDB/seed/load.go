@@ -0,0 +1,229 @@
+package seed
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/userstore"
+)
+
+// Defaults used when LoadOptions leaves a field zero.
+const (
+	DefaultBatchSize = 100
+	DefaultWorkers   = 4
+)
+
+// maxErrors is the number of distinct failure messages a Report keeps.
+const maxErrors = 10
+
+// LoadOptions controls Load.
+type LoadOptions struct {
+	// Count is the number of users to generate.
+	Count int
+
+	// BatchSize is the number of users written per CreateMany. Zero means DefaultBatchSize.
+	BatchSize int
+
+	// Workers is the number of batches written at the same time. Zero means DefaultWorkers.
+	Workers int
+
+	// Rate is the target number of users written per second, across all the
+	// workers. Zero means as fast as the repository takes them.
+	Rate float64
+}
+
+// Latency holds percentiles of the time taken to write one batch.
+type Latency struct {
+	P50, P90, P99, Max time.Duration
+}
+
+// Report summarizes a load.
+type Report struct {
+	Requested int
+	Inserted  int
+
+	// Duplicates counts the users whose name was already taken, as happens
+	// when the same seed is loaded twice. They are not failures.
+	Duplicates int
+
+	Failed  int
+	Batches int
+	Elapsed time.Duration
+
+	// Throughput is the number of users inserted per second.
+	Throughput float64
+
+	Latency Latency
+
+	// Errors holds the first distinct failure messages.
+	Errors []string
+}
+
+// MarshalJSON writes the durations in milliseconds.
+func (r *Report) MarshalJSON() ([]byte, error) {
+	ms := func(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+	return json.Marshal(struct {
+		Requested  int                `json:"requested"`
+		Inserted   int                `json:"inserted"`
+		Duplicates int                `json:"duplicates"`
+		Failed     int                `json:"failed"`
+		Batches    int                `json:"batches"`
+		ElapsedMS  float64            `json:"elapsedMs"`
+		Throughput float64            `json:"usersPerSecond"`
+		LatencyMS  map[string]float64 `json:"batchLatencyMs"`
+		Errors     []string           `json:"errors,omitempty"`
+	}{
+		Requested:  r.Requested,
+		Inserted:   r.Inserted,
+		Duplicates: r.Duplicates,
+		Failed:     r.Failed,
+		Batches:    r.Batches,
+		ElapsedMS:  ms(r.Elapsed),
+		Throughput: r.Throughput,
+		LatencyMS: map[string]float64{
+			"p50": ms(r.Latency.P50),
+			"p90": ms(r.Latency.P90),
+			"p99": ms(r.Latency.P99),
+			"max": ms(r.Latency.Max),
+		},
+		Errors: r.Errors,
+	})
+}
+
+// result is the outcome of writing one batch.
+type result struct {
+	size     int
+	errs     map[int]error
+	err      error
+	duration time.Duration
+}
+
+// Load generates opts.Count users with gen and writes them to repo in
+// batches, from opts.Workers goroutines, pacing the batches to opts.Rate.
+// The batches are generated in order on one goroutine, so the users written
+// depend only on the generator, whatever the number of workers.
+//
+// Users that fail are counted in the report and do not stop the load. Load
+// returns an error only when ctx is done first, along with the report so far.
+func Load(ctx context.Context, repo userstore.Repository, gen *Generator, opts LoadOptions) (*Report, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = DefaultWorkers
+	}
+
+	batches := make(chan []*userstore.User, opts.Workers)
+	results := make(chan result, opts.Workers)
+	start := time.Now()
+
+	go func() {
+		defer close(batches)
+		for sent := 0; sent < opts.Count; {
+			n := min(opts.BatchSize, opts.Count-sent)
+			if opts.Rate > 0 {
+				// Each batch is due when the users before it have had their time
+				// at the target rate, which keeps the pace from drifting.
+				due := start.Add(time.Duration(float64(sent) / opts.Rate * float64(time.Second)))
+				if !sleepUntil(ctx, due) {
+					return
+				}
+			}
+			batch := make([]*userstore.User, n)
+			for i := range batch {
+				batch[i] = gen.Next()
+			}
+			select {
+			case batches <- batch:
+			case <-ctx.Done():
+				return
+			}
+			sent += n
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				began := time.Now()
+				errs, err := repo.CreateMany(ctx, batch)
+				results <- result{size: len(batch), errs: errs, err: err, duration: time.Since(began)}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	report := &Report{Requested: opts.Count}
+	seen := map[string]bool{}
+	fail := func(err error) {
+		if msg := err.Error(); !seen[msg] && len(report.Errors) < maxErrors {
+			seen[msg] = true
+			report.Errors = append(report.Errors, msg)
+		}
+	}
+	var durations []time.Duration
+	for r := range results {
+		report.Batches++
+		durations = append(durations, r.duration)
+		if r.err != nil {
+			report.Failed += r.size
+			fail(r.err)
+			continue
+		}
+		for _, err := range r.errs {
+			if errors.Is(err, userstore.ErrDuplicate) {
+				report.Duplicates++
+			} else {
+				report.Failed++
+				fail(err)
+			}
+		}
+		report.Inserted += r.size - len(r.errs)
+	}
+
+	report.Elapsed = time.Since(start)
+	if secs := report.Elapsed.Seconds(); secs > 0 {
+		report.Throughput = float64(report.Inserted) / secs
+	}
+	report.Latency = percentiles(durations)
+	return report, ctx.Err()
+}
+
+// sleepUntil waits until t and reports whether ctx was still live by then.
+func sleepUntil(ctx context.Context, t time.Time) bool {
+	d := time.Until(t)
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// percentiles returns the nearest-rank percentiles of durations.
+func percentiles(durations []time.Duration) Latency {
+	if len(durations) == 0 {
+		return Latency{}
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	rank := func(p int) time.Duration {
+		i := (p*len(durations) + 99) / 100
+		return durations[max(i, 1)-1]
+	}
+	return Latency{P50: rank(50), P90: rank(90), P99: rank(99), Max: durations[len(durations)-1]}
+}
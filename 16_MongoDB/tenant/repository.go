@@ -0,0 +1,251 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/userstore"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// recheckInterval is how long Repository trusts that a tenant it opened is
+// still provisioned before looking it up again.
+const recheckInterval = time.Minute
+
+// OpenFunc returns the repository of tenant t, whose collections live at loc.
+type OpenFunc func(t *Tenant, loc Location) (userstore.Repository, error)
+
+// Repository is a userstore.Repository that sends every call to the
+// repository of the tenant named by the call's context. Calls without a
+// tenant fail with ErrNoTenant, and calls for a tenant that is not
+// provisioned fail with a *userstore.ValidationError.
+//
+// The repository of a tenant is opened on its first call and kept. Tenants
+// decommissioned by another process are noticed within a minute.
+type Repository struct {
+	router *Router
+	open   OpenFunc
+
+	mu    sync.Mutex
+	repos map[string]*opened
+}
+
+// opened is the repository of one tenant.
+type opened struct {
+	repo    userstore.Repository
+	checked time.Time
+}
+
+var _ userstore.Repository = (*Repository)(nil)
+
+// NewRepository returns a Repository over the tenants of router whose
+// repositories are built by open. Wrap each tenant's repository in open,
+// rather than wrapping the Repository, so that caches and audit logs stay
+// per tenant too.
+func NewRepository(router *Router, open OpenFunc) *Repository {
+	return &Repository{router: router, open: open, repos: map[string]*opened{}}
+}
+
+// repo returns the repository of the tenant of ctx.
+func (r *Repository) repo(ctx context.Context) (userstore.Repository, error) {
+	id, ok := FromContext(ctx)
+	if !ok {
+		return nil, ErrNoTenant
+	}
+
+	r.mu.Lock()
+	o, ok := r.repos[id]
+	r.mu.Unlock()
+	if ok && time.Since(o.checked) < recheckInterval {
+		return o.repo, nil
+	}
+
+	t, err := r.router.Lookup(ctx, id)
+	if errors.Is(err, ErrUnknown) {
+		r.mu.Lock()
+		delete(r.repos, id)
+		r.mu.Unlock()
+		return nil, &userstore.ValidationError{Field: "tenant", Reason: fmt.Sprintf("%q is not provisioned", id)}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if o, ok := r.repos[id]; ok {
+		o.checked = time.Now()
+		return o.repo, nil
+	}
+	repo, err := r.open(t, r.router.Locate(t))
+	if err != nil {
+		return nil, fmt.Errorf("tenant: open %s: %w", id, err)
+	}
+	r.repos[id] = &opened{repo: repo, checked: time.Now()}
+	return repo, nil
+}
+
+// AllTenants returns a Purger that purges the trash of every provisioned
+// tenant, for userstore.RunPurger.
+func (r *Repository) AllTenants() userstore.Purger {
+	return allTenants{r}
+}
+
+type allTenants struct{ r *Repository }
+
+func (a allTenants) Purge(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	tenants, err := a.r.router.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var (
+		total int64
+		errs  []error
+	)
+	for _, t := range tenants {
+		n, err := a.r.Purge(WithID(ctx, t.ID), deletedBefore)
+		total += n
+		if err != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", t.ID, err))
+		}
+	}
+	return total, errors.Join(errs...)
+}
+
+// Create stores u for the tenant of ctx.
+func (r *Repository) Create(ctx context.Context, u *userstore.User) error {
+	repo, err := r.repo(ctx)
+	if err != nil {
+		return err
+	}
+	return repo.Create(ctx, u)
+}
+
+// CreateMany stores users for the tenant of ctx.
+func (r *Repository) CreateMany(ctx context.Context, users []*userstore.User) (map[int]error, error) {
+	repo, err := r.repo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.CreateMany(ctx, users)
+}
+
+// Get returns the user of the tenant of ctx with the given ID.
+func (r *Repository) Get(ctx context.Context, id primitive.ObjectID) (*userstore.User, error) {
+	repo, err := r.repo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.Get(ctx, id)
+}
+
+// FindByName returns the user of the tenant of ctx with the given name.
+func (r *Repository) FindByName(ctx context.Context, name string) (*userstore.User, error) {
+	repo, err := r.repo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.FindByName(ctx, name)
+}
+
+// List returns one page of the users of the tenant of ctx.
+func (r *Repository) List(ctx context.Context, opts userstore.ListOptions) (*userstore.Page, error) {
+	repo, err := r.repo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.List(ctx, opts)
+}
+
+// Stream calls fn for the selected users of the tenant of ctx.
+func (r *Repository) Stream(ctx context.Context, opts userstore.ListOptions, fn func(*userstore.User) error) error {
+	repo, err := r.repo(ctx)
+	if err != nil {
+		return err
+	}
+	return repo.Stream(ctx, opts, fn)
+}
+
+// Count returns the number of users of the tenant of ctx.
+func (r *Repository) Count(ctx context.Context) (int64, error) {
+	repo, err := r.repo(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return repo.Count(ctx)
+}
+
+// Update updates a user of the tenant of ctx.
+func (r *Repository) Update(ctx context.Context, id primitive.ObjectID, upd userstore.Update, expect userstore.Expect) (*userstore.UpdateResult, error) {
+	repo, err := r.repo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.Update(ctx, id, upd, expect)
+}
+
+// Replace replaces a user of the tenant of ctx.
+func (r *Repository) Replace(ctx context.Context, u *userstore.User, expect userstore.Expect) (*userstore.UpdateResult, error) {
+	repo, err := r.repo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.Replace(ctx, u, expect)
+}
+
+// Upsert replaces or inserts a user of the tenant of ctx.
+func (r *Repository) Upsert(ctx context.Context, u *userstore.User) (*userstore.UpdateResult, error) {
+	repo, err := r.repo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.Upsert(ctx, u)
+}
+
+// Delete moves a user of the tenant of ctx to the trash.
+func (r *Repository) Delete(ctx context.Context, id primitive.ObjectID, expect userstore.Expect) error {
+	repo, err := r.repo(ctx)
+	if err != nil {
+		return err
+	}
+	return repo.Delete(ctx, id, expect)
+}
+
+// Restore takes a user of the tenant of ctx out of the trash.
+func (r *Repository) Restore(ctx context.Context, id primitive.ObjectID, expect userstore.Expect) (*userstore.User, error) {
+	repo, err := r.repo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.Restore(ctx, id, expect)
+}
+
+// HardDelete permanently removes a user of the tenant of ctx.
+func (r *Repository) HardDelete(ctx context.Context, id primitive.ObjectID, expect userstore.Expect) (*userstore.User, error) {
+	repo, err := r.repo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.HardDelete(ctx, id, expect)
+}
+
+// Purge empties the trash of the tenant of ctx. To purge every tenant, use AllTenants.
+func (r *Repository) Purge(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	repo, err := r.repo(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return repo.Purge(ctx, deletedBefore)
+}
+
+// Expire removes the expired users of the tenant of ctx.
+func (r *Repository) Expire(ctx context.Context, now time.Time) (int64, error) {
+	repo, err := r.repo(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return repo.Expire(ctx, now)
+}
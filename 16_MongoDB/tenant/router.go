@@ -0,0 +1,221 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/audit"
+	"github.com/saurabhkk55/Go/16_MongoDB/blobstore"
+	"github.com/saurabhkk55/Go/16_MongoDB/migrate"
+	"github.com/saurabhkk55/Go/16_MongoDB/userstore"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RegistryCollection is the collection of the shared database that lists the tenants.
+const RegistryCollection = "tenants"
+
+// maxDatabaseName is the longest database name MongoDB accepts.
+const maxDatabaseName = 63
+
+// Location is where the collections of a tenant live.
+type Location struct {
+	DB *mongo.Database
+
+	// Prefix is put in front of every collection name. It is empty for
+	// tenants with a database of their own.
+	Prefix string
+}
+
+// Name returns the name the tenant's copy of collection has in DB.
+func (l Location) Name(collection string) string {
+	return l.Prefix + collection
+}
+
+// Router finds the storage of the tenants of a shared database and
+// provisions and decommissions them.
+type Router struct {
+	client          *mongo.Client
+	shared          *mongo.Database
+	registry        *mongo.Collection
+	collection      string
+	auditCollection string
+}
+
+// NewRouter returns a Router for the tenants of the database dbName. Each
+// tenant gets its own copy of the user collection and of the audit
+// collection.
+func NewRouter(client *mongo.Client, dbName, collection, auditCollection string) *Router {
+	shared := client.Database(dbName)
+	return &Router{
+		client:          client,
+		shared:          shared,
+		registry:        shared.Collection(RegistryCollection),
+		collection:      collection,
+		auditCollection: auditCollection,
+	}
+}
+
+// Locate returns where the collections of t live.
+func (r *Router) Locate(t *Tenant) Location {
+	if t.Mode == PerCollection {
+		return Location{DB: r.shared, Prefix: t.ID + "_"}
+	}
+	return Location{DB: r.client.Database(r.shared.Name() + "_" + t.ID)}
+}
+
+// Collections returns the names of the user and audit collections of t.
+func (r *Router) Collections(t *Tenant) (users, auditLog string) {
+	loc := r.Locate(t)
+	return loc.Name(r.collection), loc.Name(r.auditCollection)
+}
+
+// Migrator returns a migration runner for the user collection of t, which
+// records the applied versions apart from those of other tenants.
+func (r *Router) Migrator(t *Tenant) (*migrate.Runner, error) {
+	loc := r.Locate(t)
+	return migrate.NewRunnerIn(loc.DB, loc.Name(migrate.CollectionName), userstore.Migrations(loc.Name(r.collection)))
+}
+
+// Lookup returns the tenant with the given ID. It fails with ErrUnknown when
+// there is none or it is being decommissioned.
+func (r *Router) Lookup(ctx context.Context, id string) (*Tenant, error) {
+	if err := ValidateID(id); err != nil {
+		return nil, err
+	}
+	filter := bson.D{{Key: "_id", Value: id}, {Key: "decommissioning", Value: bson.D{{Key: "$ne", Value: true}}}}
+	var t Tenant
+	err := r.registry.FindOne(ctx, filter).Decode(&t)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("%w %q", ErrUnknown, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("tenant: look up %s: %w", id, err)
+	}
+	return &t, nil
+}
+
+// List returns the provisioned tenants ordered by ID.
+func (r *Router) List(ctx context.Context) ([]Tenant, error) {
+	filter := bson.D{{Key: "decommissioning", Value: bson.D{{Key: "$ne", Value: true}}}}
+	cursor, err := r.registry.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("tenant: list: %w", err)
+	}
+	var tenants []Tenant
+	if err := cursor.All(ctx, &tenants); err != nil {
+		return nil, fmt.Errorf("tenant: list: %w", err)
+	}
+	return tenants, nil
+}
+
+// Provision creates the collections of a new tenant, migrates them to the
+// latest schema and registers the tenant. Provisioning a tenant that failed
+// halfway can be retried.
+func (r *Router) Provision(ctx context.Context, id string, mode Mode) (*Tenant, error) {
+	if err := ValidateID(id); err != nil {
+		return nil, err
+	}
+	if _, err := ParseMode(string(mode)); err != nil {
+		return nil, err
+	}
+	t := &Tenant{ID: id, Mode: mode, CreatedAt: time.Now().UTC().Truncate(time.Millisecond)}
+	if name := r.Locate(t).DB.Name(); len(name) > maxDatabaseName {
+		return nil, &userstore.ValidationError{Field: "tenant", Reason: fmt.Sprintf("makes the database name %s too long", name)}
+	}
+
+	n, err := r.registry.CountDocuments(ctx, bson.D{{Key: "_id", Value: id}})
+	if err != nil {
+		return nil, fmt.Errorf("tenant: provision %s: %w", id, err)
+	}
+	if n > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrExists, id)
+	}
+
+	migrator, err := r.Migrator(t)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := migrator.Migrate(ctx, migrator.Latest()); err != nil {
+		return nil, fmt.Errorf("tenant: provision %s: %w", id, err)
+	}
+	_, auditLog := r.Collections(t)
+	if err := audit.NewMongoLog(r.Locate(t).DB.Collection(auditLog)).EnsureIndexes(ctx); err != nil {
+		return nil, fmt.Errorf("tenant: provision %s: %w", id, err)
+	}
+
+	// The tenant becomes visible only once its collections are ready.
+	_, err = r.registry.InsertOne(ctx, t)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, fmt.Errorf("%w: %s", ErrExists, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("tenant: provision %s: %w", id, err)
+	}
+	return t, nil
+}
+
+// Decommission drops the collections of a tenant, or its database, and
+// unregisters it. The tenant is marked first, so Lookup stops finding it
+// before any data is gone; a decommission that failed halfway can be
+// retried.
+func (r *Router) Decommission(ctx context.Context, id string) (*Tenant, error) {
+	if err := ValidateID(id); err != nil {
+		return nil, err
+	}
+	var t Tenant
+	err := r.registry.FindOneAndUpdate(ctx, bson.D{{Key: "_id", Value: id}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "decommissioning", Value: true}}}}).Decode(&t)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("%w %q", ErrUnknown, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("tenant: decommission %s: %w", id, err)
+	}
+
+	if loc := r.Locate(&t); loc.Prefix == "" {
+		err = loc.DB.Drop(ctx)
+	} else {
+		err = r.dropOwned(ctx, &t)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("tenant: decommission %s: %w", id, err)
+	}
+
+	if _, err := r.registry.DeleteOne(ctx, bson.D{{Key: "_id", Value: id}}); err != nil {
+		return nil, fmt.Errorf("tenant: decommission %s: %w", id, err)
+	}
+	return &t, nil
+}
+
+// Owned returns the names of every collection t has in its database: the
+// user and audit collections, the migration records, the quarantine of the
+// user collection and the collections of the blob bucket.
+func (r *Router) Owned(t *Tenant) []string {
+	loc := r.Locate(t)
+	bucket := loc.Name(blobstore.DefaultBucket)
+	return []string{
+		loc.Name(r.collection),
+		loc.Name(r.auditCollection),
+		loc.Name(migrate.CollectionName),
+		migrate.QuarantineCollection(loc.Name(r.collection)),
+		bucket + ".files",
+		bucket + ".chunks",
+	}
+}
+
+// dropOwned drops the collections of t in a shared database. Only the
+// collections the router names are dropped: another tenant's ID, or a
+// collection of the shared database, may start with the same prefix.
+func (r *Router) dropOwned(ctx context.Context, t *Tenant) error {
+	db := r.Locate(t).DB
+	for _, name := range r.Owned(t) {
+		if err := db.Collection(name).Drop(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
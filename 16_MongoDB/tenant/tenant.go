@@ -0,0 +1,98 @@
+// Package tenant keeps the users of each tenant apart.
+//
+// A tenant is named by an ID such as "acme" and has storage of its own,
+// chosen when it is provisioned: a database of its own ("<db>_<tenant>"), or
+// collections of the shared database whose names start with the tenant
+// ("<tenant>_<collection>"). The shared database lists the provisioned
+// tenants in its "tenants" collection. A Router provisions, finds and
+// decommissions tenants and tells where their collections are.
+//
+// The tenant of a request travels in its context; see WithID. Repository
+// sends every call to the repository of that tenant and refuses calls
+// without one, so a request only ever reaches the users of its own tenant.
+// IDs and names of other tenants' users are simply not found.
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/userstore"
+)
+
+// MaxIDLength is the longest tenant ID. Database names are limited to 64
+// bytes, and the ID is appended to the name of the shared database.
+const MaxIDLength = 32
+
+// validID matches tenant IDs. They cannot hold "_", which separates the ID
+// from the collection name, so no tenant's prefix is a prefix of another's.
+var validID = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+var (
+	// ErrNoTenant is returned by Repository for calls whose context names no
+	// tenant. It is a *userstore.ValidationError.
+	ErrNoTenant error = &userstore.ValidationError{Field: "tenant", Reason: "is not set"}
+
+	// ErrUnknown is returned for tenants that are not provisioned.
+	ErrUnknown = errors.New("tenant: unknown tenant")
+
+	// ErrExists is returned when provisioning a tenant that already exists.
+	ErrExists = errors.New("tenant: tenant already exists")
+)
+
+// ValidateID checks that id can name a tenant: 1 to MaxIDLength lowercase
+// letters, digits and dashes, starting with a letter or digit.
+func ValidateID(id string) error {
+	if len(id) == 0 || len(id) > MaxIDLength || !validID.MatchString(id) {
+		return &userstore.ValidationError{
+			Field:  "tenant",
+			Reason: fmt.Sprintf("must be 1 to %d lowercase letters, digits and dashes, not %q", MaxIDLength, id),
+		}
+	}
+	return nil
+}
+
+type idKey struct{}
+
+// WithID returns a context for the calls made on behalf of tenant id.
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, idKey{}, id)
+}
+
+// FromContext returns the tenant stored by WithID.
+func FromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(idKey{}).(string)
+	return id, ok && id != ""
+}
+
+// Mode is where the collections of a tenant live.
+type Mode string
+
+// The storage modes.
+const (
+	// PerDatabase gives the tenant a database of its own.
+	PerDatabase Mode = "database"
+
+	// PerCollection keeps the tenant in the shared database, in collections
+	// prefixed with its ID.
+	PerCollection Mode = "collection"
+)
+
+// ParseMode parses "database" or "collection".
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(s); m {
+	case PerDatabase, PerCollection:
+		return m, nil
+	}
+	return "", &userstore.ValidationError{Field: "mode", Reason: "must be database or collection"}
+}
+
+// Tenant is a provisioned tenant.
+type Tenant struct {
+	ID        string    `bson:"_id" json:"id"`
+	Mode      Mode      `bson:"mode" json:"mode"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
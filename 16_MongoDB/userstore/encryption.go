@@ -0,0 +1,61 @@
+package userstore
+
+// Encryption is how a collection stores the values of a field. The values are
+// encrypted and decrypted by the codecs of the collection, as package
+// fieldcrypt sets them up; a MongoRepository only needs to know which queries
+// the server can still answer.
+type Encryption string
+
+// The ways a field can be stored.
+const (
+	// Plaintext values can be filtered and sorted on.
+	Plaintext Encryption = ""
+
+	// Randomized values encrypt the same value differently every time, so the
+	// server can neither filter nor sort on them.
+	Randomized Encryption = "randomized"
+
+	// Deterministic values encrypt the same value the same way under one key,
+	// so the server can still filter on equality, but not on ranges or sort.
+	Deterministic Encryption = "deterministic"
+)
+
+// EncryptableFields are the fields that can be stored encrypted, by JSON name.
+var EncryptableFields = []string{"age", "gender"}
+
+// SetEncryption records how the collection stores field, "age" or "gender".
+// Queries the server cannot answer on the encrypted values are then refused
+// with a ValidationError instead of silently comparing ciphertexts.
+func (r *MongoRepository) SetEncryption(field string, e Encryption) {
+	if r.encrypted == nil {
+		r.encrypted = map[string]Encryption{}
+	}
+	r.encrypted[field] = e
+}
+
+// checkEncrypted refuses the filters and sort keys of opts that need to
+// compare encrypted values.
+func (r *MongoRepository) checkEncrypted(opts *ListOptions) error {
+	if r.encrypted["age"] != Plaintext && (opts.Filter.MinAge != nil || opts.Filter.MaxAge != nil) {
+		return &ValidationError{Field: "age", Reason: "is encrypted and cannot be filtered by range"}
+	}
+	if r.encrypted["gender"] == Randomized && opts.Filter.Gender != "" {
+		return &ValidationError{Field: "gender", Reason: "is encrypted and cannot be filtered on"}
+	}
+	if opts.Filter.Where != nil {
+		for field, ranged := range opts.Filter.Where.Compared() {
+			switch e := r.encrypted[field]; {
+			case e == Randomized:
+				return &ValidationError{Field: field, Reason: "is encrypted and cannot be filtered on"}
+			case e == Deterministic && ranged:
+				return &ValidationError{Field: field, Reason: "is encrypted and can only be compared for equality"}
+			}
+		}
+	}
+	for _, k := range opts.Sort {
+		if r.encrypted[k.Field] != Plaintext {
+			return &ValidationError{Field: "sort", Reason: k.Field + " is encrypted and cannot be sorted on"}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package userstore
+
+import (
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	// ErrNotFound is returned when no user matches the given ID or filter.
+	ErrNotFound = errors.New("userstore: user not found")
+
+	// ErrDuplicate is returned when a write would break a unique key.
+	ErrDuplicate = errors.New("userstore: duplicate key")
+
+	// ErrConflict is returned when a write expected a version of the user
+	// that is no longer current. The error is a *ConflictError.
+	ErrConflict = errors.New("userstore: version conflict")
+
+	// ErrTimeout is returned when an operation did not finish within the
+	// timeout of the repository or the deadline of its context. The error
+	// wraps the cause too, such as context.DeadlineExceeded.
+	ErrTimeout = errors.New("userstore: operation timed out")
+)
+
+// ValidationError reports a user field that failed validation.
+type ValidationError struct {
+	Field  string
+	Reason string
+}
+
+// Error implements the error interface.
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("userstore: %s %s", e.Field, e.Reason)
+}
+
+// ConflictError reports a write that expected another version of a user;
+// someone else changed the user in between. See Expect.
+type ConflictError struct {
+	ID       primitive.ObjectID
+	Expected int64
+	Actual   int64
+}
+
+// Error implements the error interface.
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("userstore: user %s is at version %d, not %d", e.ID.Hex(), e.Actual, e.Expected)
+}
+
+// Unwrap lets errors.Is match ErrConflict.
+func (e *ConflictError) Unwrap() error {
+	return ErrConflict
+}
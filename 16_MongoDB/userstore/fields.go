@@ -0,0 +1,145 @@
+package userstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// Age is a user's age in whole years.
+//
+// Documents written by the old getUserInput stored the age as a string, so Age
+// also decodes numeric strings from BSON and JSON. It is always written back
+// as an integer.
+type Age int
+
+// ParseAge parses an age typed by a person, such as "42".
+func ParseAge(s string) (Age, error) {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return 0, &ValidationError{Field: "age", Reason: "must be a whole number"}
+	}
+	return Age(n), nil
+}
+
+// UnmarshalBSONValue decodes integer, whole double and legacy string ages.
+func (a *Age) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	raw := bson.RawValue{Type: t, Value: data}
+
+	switch t {
+	case bsontype.Int32:
+		*a = Age(raw.Int32())
+	case bsontype.Int64:
+		*a = Age(raw.Int64())
+	case bsontype.Double:
+		f := raw.Double()
+		if f != math.Trunc(f) {
+			return fmt.Errorf("userstore: age %v is not a whole number", f)
+		}
+		*a = Age(f)
+	case bsontype.String:
+		age, err := ParseAge(raw.StringValue())
+		if err != nil {
+			return fmt.Errorf("userstore: legacy age %q is not a whole number", raw.StringValue())
+		}
+		*a = age
+	case bsontype.Null, bsontype.Undefined:
+		*a = 0
+	default:
+		return fmt.Errorf("userstore: cannot decode age from BSON %s", t)
+	}
+	return nil
+}
+
+// UnmarshalJSON accepts both 42 and "42".
+func (a *Age) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		age, err := ParseAge(s)
+		if err != nil {
+			return err
+		}
+		*a = age
+		return nil
+	}
+
+	var n int
+	if err := json.Unmarshal(data, &n); err != nil {
+		return &ValidationError{Field: "age", Reason: "must be a whole number"}
+	}
+	*a = Age(n)
+	return nil
+}
+
+// Gender is one of the values accepted for the Gender field.
+type Gender string
+
+// The accepted genders.
+const (
+	GenderMale   Gender = "M"
+	GenderFemale Gender = "F"
+	GenderOther  Gender = "O"
+)
+
+// genderSpellings maps the spellings people used to type at the prompt onto Gender values.
+var genderSpellings = map[string]Gender{
+	"m":      GenderMale,
+	"male":   GenderMale,
+	"f":      GenderFemale,
+	"female": GenderFemale,
+	"o":      GenderOther,
+	"other":  GenderOther,
+}
+
+// ParseGender parses a gender such as "M", "f" or "female".
+func ParseGender(s string) (Gender, error) {
+	g, ok := genderSpellings[strings.ToLower(strings.TrimSpace(s))]
+	if !ok {
+		return "", &ValidationError{Field: "gender", Reason: `must be one of "M", "F" or "O"`}
+	}
+	return g, nil
+}
+
+// normalizeGender returns the Gender for s, or s unchanged when it is not a known spelling.
+// Unknown values are kept so that Validate can report them.
+func normalizeGender(s string) Gender {
+	if g, err := ParseGender(s); err == nil {
+		return g
+	}
+	return Gender(s)
+}
+
+// Valid reports whether g is one of the accepted genders.
+func (g Gender) Valid() bool {
+	return g == GenderMale || g == GenderFemale || g == GenderOther
+}
+
+// UnmarshalBSONValue normalizes legacy spellings such as "male".
+func (g *Gender) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	raw := bson.RawValue{Type: t, Value: data}
+
+	switch t {
+	case bsontype.String:
+		*g = normalizeGender(raw.StringValue())
+	case bsontype.Null, bsontype.Undefined:
+		*g = ""
+	default:
+		return fmt.Errorf("userstore: cannot decode gender from BSON %s", t)
+	}
+	return nil
+}
+
+// UnmarshalJSON normalizes spellings such as "male".
+func (g *Gender) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return &ValidationError{Field: "gender", Reason: "must be a string"}
+	}
+	*g = normalizeGender(s)
+	return nil
+}
@@ -0,0 +1,71 @@
+package userstore
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/filestore"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// FileRepository is a Repository backed by a collection of the embedded
+// filestore, so users survive restarts without a MongoDB server.
+//
+// It is a MemoryRepository loaded from the collection, which writes every
+// change to the collection before making it in memory, so the two stores run
+// the same repository code and only differ in durability.
+type FileRepository struct {
+	*MemoryRepository
+	coll *filestore.Collection
+}
+
+// NewFileRepository returns a Repository that stores users in coll, loading
+// the users coll already holds.
+func NewFileRepository(coll *filestore.Collection) (*FileRepository, error) {
+	docs, err := coll.Find(bson.D{})
+	if err != nil {
+		return nil, fileError("load", err)
+	}
+	users := make(map[primitive.ObjectID]User, len(docs))
+	for _, doc := range docs {
+		var u User
+		if err := bson.Unmarshal(doc, &u); err != nil {
+			return nil, fileError("decode", err)
+		}
+		users[u.ID] = u
+	}
+
+	r := &FileRepository{coll: coll}
+	r.MemoryRepository = &MemoryRepository{users: users, persist: r}
+	return r, nil
+}
+
+// put writes u to the collection, in place of the user with the same ID.
+func (r *FileRepository) put(u *User) error {
+	matched, err := r.coll.ReplaceOne(bson.D{{Key: "_id", Value: u.ID}}, u)
+	if err == nil && !matched {
+		err = r.coll.InsertOne(u)
+	}
+	return fileError("write", err)
+}
+
+// remove deletes the users with the given IDs from the collection.
+func (r *FileRepository) remove(ids []primitive.ObjectID) error {
+	_, err := r.coll.DeleteMany(bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}})
+	return fileError("delete", err)
+}
+
+// fileError translates filestore errors into the package's sentinel errors
+// and wraps the rest with the failed operation.
+func fileError(op string, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, filestore.ErrNoDocuments):
+		return ErrNotFound
+	case errors.Is(err, filestore.ErrDuplicateKey):
+		return ErrDuplicate
+	}
+	return fmt.Errorf("userstore: %s: %w", op, err)
+}
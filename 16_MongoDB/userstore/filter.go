@@ -0,0 +1,170 @@
+package userstore
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Filter selects users by field values. Zero fields match everything,
+// and the fields that are set must all match.
+type Filter struct {
+	Name   string
+	Gender Gender
+	MinAge *Age
+	MaxAge *Age
+
+	// Deleted selects users by whether they are in the trash.
+	// The zero value leaves them out.
+	Deleted DeletedFilter
+
+	// Where is a further condition the users must satisfy, such as an
+	// expression parsed by package query.
+	Where Condition
+}
+
+// Condition is a condition on users that both the server and the in-memory
+// stores can evaluate.
+type Condition interface {
+	// MongoFilter translates the condition into a MongoDB query document.
+	MongoFilter() bson.D
+
+	// Match reports whether u satisfies the condition, as MongoFilter would on the server.
+	Match(u *User) bool
+
+	// Compared returns the JSON names of the fields the condition looks at,
+	// each mapped to whether it is compared by more than equality.
+	Compared() map[string]bool
+}
+
+// DeletedFilter selects live users, users in the trash, or both.
+type DeletedFilter int
+
+// The values of Filter.Deleted.
+const (
+	ExcludeDeleted DeletedFilter = iota
+	OnlyDeleted
+	IncludeDeleted
+)
+
+// ParseDeletedFilter parses "exclude", "only" or "include". The empty string means "exclude".
+func ParseDeletedFilter(s string) (DeletedFilter, error) {
+	switch s {
+	case "", "exclude":
+		return ExcludeDeleted, nil
+	case "only":
+		return OnlyDeleted, nil
+	case "include":
+		return IncludeDeleted, nil
+	}
+	return 0, &ValidationError{Field: "deleted", Reason: `must be "exclude", "only" or "include"`}
+}
+
+// IsZero reports whether the filter matches every user outside the trash.
+func (f *Filter) IsZero() bool {
+	return f.Name == "" && f.Gender == "" && f.MinAge == nil && f.MaxAge == nil && f.Deleted == ExcludeDeleted && f.Where == nil
+}
+
+// Validate checks the values the filter compares against.
+func (f *Filter) Validate() error {
+	if f.Gender != "" {
+		if err := validateGender(f.Gender); err != nil {
+			return err
+		}
+	}
+	if f.Deleted < ExcludeDeleted || f.Deleted > IncludeDeleted {
+		return &ValidationError{Field: "deleted", Reason: "is not a known trash filter"}
+	}
+	if f.MinAge != nil && f.MaxAge != nil && *f.MinAge > *f.MaxAge {
+		return &ValidationError{Field: "minAge", Reason: "must not be greater than maxAge"}
+	}
+	return nil
+}
+
+// MongoFilter translates the filter into a MongoDB query document.
+func (f *Filter) MongoFilter() bson.D {
+	filter := bson.D{}
+	if f.Name != "" {
+		filter = append(filter, bson.E{Key: "Name", Value: f.Name})
+	}
+	if f.Gender != "" {
+		filter = append(filter, bson.E{Key: "Gender", Value: f.Gender})
+	}
+
+	age := bson.D{}
+	if f.MinAge != nil {
+		age = append(age, bson.E{Key: "$gte", Value: *f.MinAge})
+	}
+	if f.MaxAge != nil {
+		age = append(age, bson.E{Key: "$lte", Value: *f.MaxAge})
+	}
+	if len(age) > 0 {
+		filter = append(filter, bson.E{Key: "Age", Value: age})
+	}
+	if f.Where != nil {
+		// Nested under $and, so that its keys cannot clash with those above.
+		filter = append(filter, bson.E{Key: "$and", Value: bson.A{f.Where.MongoFilter()}})
+	}
+
+	switch f.Deleted {
+	case ExcludeDeleted:
+		filter = append(filter, notDeleted)
+	case OnlyDeleted:
+		filter = append(filter, bson.E{Key: "DeletedAt", Value: bson.D{{Key: "$exists", Value: true}}})
+	}
+	return filter
+}
+
+// notDeleted is the query condition that leaves out users in the trash.
+var notDeleted = bson.E{Key: "DeletedAt", Value: bson.D{{Key: "$exists", Value: false}}}
+
+// expiredBy is the query condition selecting the users whose ExpiresAt is not after now.
+func expiredBy(now time.Time) bson.D {
+	return bson.D{{Key: "ExpiresAt", Value: bson.D{{Key: "$lte", Value: now}}}}
+}
+
+// ExpiredBy returns the condition selecting the users whose ExpiresAt is not after now.
+func ExpiredBy(now time.Time) Condition {
+	return expiredCondition(now)
+}
+
+// expiredCondition is the Condition returned by ExpiredBy.
+type expiredCondition time.Time
+
+func (c expiredCondition) MongoFilter() bson.D {
+	return expiredBy(time.Time(c))
+}
+
+func (c expiredCondition) Match(u *User) bool {
+	return u.ExpiresAt != nil && !u.ExpiresAt.After(time.Time(c))
+}
+
+func (c expiredCondition) Compared() map[string]bool {
+	return map[string]bool{"expiresAt": true}
+}
+
+// match reports whether u satisfies the filter, as MongoFilter would on the server.
+func (f *Filter) match(u *User) bool {
+	if f.Name != "" && u.Name != f.Name {
+		return false
+	}
+	if f.Gender != "" && u.Gender != f.Gender {
+		return false
+	}
+	if f.MinAge != nil && u.Age < *f.MinAge {
+		return false
+	}
+	if f.MaxAge != nil && u.Age > *f.MaxAge {
+		return false
+	}
+	if f.Where != nil && !f.Where.Match(u) {
+		return false
+	}
+	switch f.Deleted {
+	case ExcludeDeleted:
+		return u.DeletedAt == nil
+	case OnlyDeleted:
+		return u.DeletedAt != nil
+	}
+	return true
+}
@@ -0,0 +1,320 @@
+package userstore
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fieldKeys maps the JSON names of User fields onto their BSON keys.
+// Only these fields can be used for projection.
+var fieldKeys = map[string]string{
+	"id":         "_id",
+	"name":       "Name",
+	"age":        "Age",
+	"gender":     "Gender",
+	"attributes": "Attributes",
+	"createdAt":  "CreatedAt",
+	"updatedAt":  "UpdatedAt",
+	"deletedAt":  "DeletedAt",
+	"expiresAt":  "ExpiresAt",
+	"version":    "Version",
+}
+
+// sortable reports whether a field can be used for sorting.
+// DeletedAt and ExpiresAt are missing from most users, so they cannot be keyset sort keys.
+func sortable(field string) bool {
+	return fieldKeys[field] != "" && field != "attributes" && field != "deletedAt" && field != "expiresAt"
+}
+
+// SortField is one key of a multi-field sort.
+type SortField struct {
+	Field string // JSON name of the field, such as "age"
+	Desc  bool
+}
+
+// ListOptions controls which users List returns and in what order.
+// The zero value lists every user in ID order.
+type ListOptions struct {
+	// Filter selects the users to list.
+	Filter Filter
+
+	// Sort orders the users. The ID is always used as the final tie-breaker.
+	Sort []SortField
+
+	// Limit caps the number of users returned. Zero means no limit.
+	Limit int
+
+	// Offset skips that many users. It cannot be combined with After.
+	Offset int
+
+	// After is the NextCursor of a previous page with the same Sort.
+	After string
+
+	// Fields restricts the fields that are loaded. The ID is always loaded.
+	Fields []string
+}
+
+// Page is one page of users returned by List.
+type Page struct {
+	Users []User `json:"users"`
+
+	// Total is the number of users matching the query, ignoring pagination.
+	Total int64 `json:"total"`
+
+	// NextCursor can be passed as ListOptions.After to fetch the next page.
+	// It is empty on the last page.
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// ParseSort parses a sort such as "-age,name", where a leading "-" means descending.
+func ParseSort(s string) ([]SortField, error) {
+	var fields []SortField
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		f := SortField{Field: strings.TrimPrefix(part, "-"), Desc: strings.HasPrefix(part, "-")}
+		if !sortable(f.Field) {
+			return nil, &ValidationError{Field: "sort", Reason: "has unknown field " + f.Field}
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// ParseFields parses a projection such as "name,age".
+func ParseFields(s string) ([]string, error) {
+	var fields []string
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if _, ok := fieldKeys[f]; !ok {
+			return nil, &ValidationError{Field: "fields", Reason: "has unknown field " + f}
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// Project returns the JSON form of u restricted to the given fields plus the ID.
+// With no fields it returns every field.
+func Project(u *User, fields []string) map[string]any {
+	var all map[string]any
+	data, _ := json.Marshal(u)
+	json.Unmarshal(data, &all)
+
+	if len(fields) == 0 {
+		return all
+	}
+
+	projected := map[string]any{"id": all["id"]}
+	for _, f := range fields {
+		projected[f] = all[f]
+	}
+	return projected
+}
+
+// validate checks the options before any query runs.
+func (o *ListOptions) validate() error {
+	if err := o.Filter.Validate(); err != nil {
+		return err
+	}
+	if o.Limit < 0 {
+		return &ValidationError{Field: "limit", Reason: "must not be negative"}
+	}
+	if o.Offset < 0 {
+		return &ValidationError{Field: "offset", Reason: "must not be negative"}
+	}
+	if o.Offset > 0 && o.After != "" {
+		return &ValidationError{Field: "offset", Reason: "cannot be combined with a cursor"}
+	}
+	for _, f := range o.Sort {
+		if !sortable(f.Field) {
+			return &ValidationError{Field: "sort", Reason: "has unknown field " + f.Field}
+		}
+	}
+	for _, f := range o.Fields {
+		if _, ok := fieldKeys[f]; !ok {
+			return &ValidationError{Field: "fields", Reason: "has unknown field " + f}
+		}
+	}
+	return nil
+}
+
+// sortKeys returns the requested sort with the ID appended as a tie-breaker,
+// which makes the order total and cursors unambiguous.
+func (o *ListOptions) sortKeys() []SortField {
+	keys := make([]SortField, 0, len(o.Sort)+1)
+	for _, f := range o.Sort {
+		if f.Field == "id" {
+			return append(keys, f)
+		}
+		keys = append(keys, f)
+	}
+	return append(keys, SortField{Field: "id"})
+}
+
+// sortValue returns the value of a sortable field in the form stored in cursors.
+func (u *User) sortValue(field string) any {
+	switch field {
+	case "name":
+		return u.Name
+	case "age":
+		return int64(u.Age)
+	case "gender":
+		return string(u.Gender)
+	case "createdAt":
+		return primitive.NewDateTimeFromTime(u.CreatedAt)
+	case "updatedAt":
+		return primitive.NewDateTimeFromTime(u.UpdatedAt)
+	default:
+		return u.ID
+	}
+}
+
+// compareSortValues orders two values returned by sortValue.
+func compareSortValues(a, b any) int {
+	switch a := a.(type) {
+	case string:
+		return strings.Compare(a, b.(string))
+	case int64:
+		return compareOrdered(a, b.(int64))
+	case primitive.DateTime:
+		return compareOrdered(a, b.(primitive.DateTime))
+	case primitive.ObjectID:
+		bid := b.(primitive.ObjectID)
+		return bytes.Compare(a[:], bid[:])
+	}
+	return 0
+}
+
+// compareOrdered returns -1, 0 or 1 as a is less than, equal to or greater than b.
+func compareOrdered[T int64 | primitive.DateTime](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// pageCursor is the decoded form of Page.NextCursor.
+type pageCursor struct {
+	Sort   string `bson:"s"`
+	Values bson.A `bson:"v"`
+}
+
+// sortSpec renders sort keys back into the "-age,name,id" form.
+func sortSpec(keys []SortField) string {
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k.Field
+		if k.Desc {
+			parts[i] = "-" + k.Field
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// encodeCursor builds the cursor that resumes listing after u.
+// BSON keeps the value types intact, which JSON would not do for dates and IDs.
+func encodeCursor(keys []SortField, u *User) string {
+	c := pageCursor{Sort: sortSpec(keys)}
+	for _, k := range keys {
+		c.Values = append(c.Values, u.sortValue(k.Field))
+	}
+
+	data, err := bson.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor parses a cursor and checks that it was made for the same sort.
+func decodeCursor(keys []SortField, s string) (bson.A, error) {
+	invalid := &ValidationError{Field: "cursor", Reason: "is not valid for this query"}
+
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, invalid
+	}
+
+	var c pageCursor
+	if err := bson.Unmarshal(data, &c); err != nil {
+		return nil, invalid
+	}
+	if c.Sort != sortSpec(keys) || len(c.Values) != len(keys) {
+		return nil, invalid
+	}
+
+	// Check every value has the type sortValue produces, so comparisons cannot panic.
+	for i, k := range keys {
+		var zero User
+		want := zero.sortValue(k.Field)
+		if !sameType(want, c.Values[i]) {
+			return nil, invalid
+		}
+	}
+	return c.Values, nil
+}
+
+// sameType reports whether a and b have the same dynamic type.
+func sameType(a, b any) bool {
+	return reflect.TypeOf(a) == reflect.TypeOf(b)
+}
+
+// afterCursor reports whether u sorts strictly after the cursor values.
+func afterCursor(keys []SortField, u *User, values bson.A) bool {
+	for i, k := range keys {
+		c := compareSortValues(u.sortValue(k.Field), values[i])
+		if k.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c > 0
+		}
+	}
+	return false
+}
+
+// keysetFilter builds the MongoDB filter selecting documents after the cursor values:
+// (k1 > v1) OR (k1 = v1 AND k2 > v2) OR ..., with < for descending keys.
+func keysetFilter(keys []SortField, values bson.A) bson.D {
+	var branches bson.A
+	for i, k := range keys {
+		branch := bson.D{}
+		for j := 0; j < i; j++ {
+			branch = append(branch, bson.E{Key: fieldKeys[keys[j].Field], Value: values[j]})
+		}
+
+		op := "$gt"
+		if k.Desc {
+			op = "$lt"
+		}
+		branch = append(branch, bson.E{Key: fieldKeys[k.Field], Value: bson.D{{Key: op, Value: values[i]}}})
+		branches = append(branches, branch)
+	}
+	return bson.D{{Key: "$or", Value: branches}}
+}
+
+// newPage trims users, which may hold one user more than limit, into a Page.
+func newPage(users []User, total int64, keys []SortField, limit int) *Page {
+	page := &Page{Users: users, Total: total}
+	if limit > 0 && len(users) > limit {
+		page.Users = users[:limit]
+		page.NextCursor = encodeCursor(keys, &page.Users[limit-1])
+	}
+	return page
+}
@@ -0,0 +1,500 @@
+package userstore
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryRepository is a Repository that keeps users in a map.
+// It needs no MongoDB server, so it is the stand-in used for tests.
+// Names are kept unique, mirroring the unique index the user collection is meant to have.
+type MemoryRepository struct {
+	mu    sync.RWMutex
+	users map[primitive.ObjectID]User
+
+	// persist, when set, is given every change before it is made; see FileRepository.
+	persist persister
+}
+
+// persister keeps the users of a MemoryRepository somewhere durable.
+type persister interface {
+	put(u *User) error
+	remove(ids []primitive.ObjectID) error
+}
+
+// NewMemoryRepository returns an empty in-memory Repository.
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{users: make(map[primitive.ObjectID]User)}
+}
+
+// Create stores a copy of u.
+func (r *MemoryRepository) Create(ctx context.Context, u *User) error {
+	if err := u.Validate(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if u.ID.IsZero() {
+		u.ID = primitive.NewObjectID()
+	}
+	if _, ok := r.users[u.ID]; ok {
+		return ErrDuplicate
+	}
+	if r.nameTaken(u.Name, u.ID) {
+		return ErrDuplicate
+	}
+
+	u.touch(time.Now())
+	u.Version = 1
+	return r.save(u.Clone())
+}
+
+// CreateMany stores each user as Create would. Failures are returned by index.
+func (r *MemoryRepository) CreateMany(ctx context.Context, users []*User) (map[int]error, error) {
+	failed := map[int]error{}
+	for i, u := range users {
+		if err := r.Create(ctx, u); err != nil {
+			failed[i] = err
+		}
+	}
+	return failed, nil
+}
+
+// Get returns a copy of the user with the given ID.
+func (r *MemoryRepository) Get(ctx context.Context, id primitive.ObjectID) (*User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	u, ok := r.users[id]
+	if !ok || u.DeletedAt != nil {
+		return nil, ErrNotFound
+	}
+	u = u.Clone()
+	return &u, nil
+}
+
+// FindByName returns a copy of the user with the given name.
+func (r *MemoryRepository) FindByName(ctx context.Context, name string) (*User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, u := range r.users {
+		if u.Name == name && u.DeletedAt == nil {
+			u = u.Clone()
+			return &u, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// List returns one page of users.
+func (r *MemoryRepository) List(ctx context.Context, opts ListOptions) (*Page, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	keys := opts.sortKeys()
+
+	var after bson.A
+	if opts.After != "" {
+		values, err := decodeCursor(keys, opts.After)
+		if err != nil {
+			return nil, err
+		}
+		after = values
+	}
+
+	return listPage(r.selectUsers(opts, keys), opts, keys, after), nil
+}
+
+// listPage cuts the page that opts asks for out of users, which hold every
+// matching user sorted by keys. after holds the decoded opts.After cursor.
+func listPage(users []User, opts ListOptions, keys []SortField, after bson.A) *Page {
+	total := int64(len(users))
+
+	// Drop everything up to and including the cursor position, then apply the offset.
+	if after != nil {
+		i := sort.Search(len(users), func(i int) bool { return afterCursor(keys, &users[i], after) })
+		users = users[i:]
+	}
+	users = users[min(opts.Offset, len(users)):]
+	if opts.Limit > 0 && len(users) > opts.Limit+1 {
+		users = users[:opts.Limit+1]
+	}
+
+	// Mirror the MongoDB projection by clearing fields that were not asked for.
+	if len(opts.Fields) > 0 {
+		for i := range users {
+			users[i] = projectUser(&users[i], opts.Fields, keys)
+		}
+	}
+	return newPage(users, total, keys, opts.Limit)
+}
+
+// Stream calls fn for every user matching opts.Filter, in opts.Sort order.
+// Pagination options are ignored.
+func (r *MemoryRepository) Stream(ctx context.Context, opts ListOptions, fn func(*User) error) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	keys := opts.sortKeys()
+
+	for _, u := range r.selectUsers(opts, keys) {
+		if len(opts.Fields) > 0 {
+			u = projectUser(&u, opts.Fields, keys)
+		}
+		if err := fn(&u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selectUsers returns copies of the users matching opts.Filter, sorted by keys.
+func (r *MemoryRepository) selectUsers(opts ListOptions, keys []SortField) []User {
+	r.mu.RLock()
+	users := make([]User, 0, len(r.users))
+	for _, u := range r.users {
+		if opts.Filter.match(&u) {
+			users = append(users, u.Clone())
+		}
+	}
+	r.mu.RUnlock()
+
+	sortUsers(users, keys)
+	return users
+}
+
+// Count returns the number of stored users that are not in the trash.
+func (r *MemoryRepository) Count(ctx context.Context) (int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var n int64
+	for _, u := range r.users {
+		if u.DeletedAt == nil {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Update applies a partial update to the user with the given ID.
+func (r *MemoryRepository) Update(ctx context.Context, id primitive.ObjectID, upd Update, expect Expect) (*UpdateResult, error) {
+	if err := upd.validate(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old, ok := r.users[id]
+	if !ok || old.DeletedAt != nil {
+		return nil, ErrNotFound
+	}
+	if err := expect.check(&old); err != nil {
+		return nil, err
+	}
+
+	// Validate the result too, which catches an increment that leaves the range.
+	updated := upd.apply(old)
+	if err := updated.Validate(); err != nil {
+		return nil, err
+	}
+	return r.store(old, updated)
+}
+
+// Replace overwrites every field of the stored user that has the same ID as u.
+// CreatedAt is kept from the stored user and copied back into u.
+func (r *MemoryRepository) Replace(ctx context.Context, u *User, expect Expect) (*UpdateResult, error) {
+	if err := u.Validate(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old, ok := r.users[u.ID]
+	if !ok || old.DeletedAt != nil {
+		return nil, ErrNotFound
+	}
+	if err := expect.check(&old); err != nil {
+		return nil, err
+	}
+
+	result, err := r.store(old, replaced(old, u))
+	if err != nil {
+		return nil, err
+	}
+	*u = r.users[u.ID].Clone()
+	return result, nil
+}
+
+// Upsert replaces the user with the same name as u, or inserts u when there is none.
+func (r *MemoryRepository) Upsert(ctx context.Context, u *User) (*UpdateResult, error) {
+	if err := u.Validate(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, old := range r.users {
+		if old.Name == u.Name && old.DeletedAt == nil {
+			result, err := r.store(old, replaced(old, u))
+			if err != nil {
+				return nil, err
+			}
+			*u = r.users[old.ID].Clone()
+			return result, nil
+		}
+	}
+
+	if u.ID.IsZero() {
+		u.ID = primitive.NewObjectID()
+	}
+	if _, ok := r.users[u.ID]; ok {
+		return nil, ErrDuplicate
+	}
+
+	// A user in the trash still holds the name.
+	if r.nameTaken(u.Name, u.ID) {
+		return nil, ErrDuplicate
+	}
+	u.CreatedAt = time.Time{}
+	u.touch(time.Now())
+	u.Version = 1
+	if err := r.save(u.Clone()); err != nil {
+		return nil, err
+	}
+	return &UpdateResult{UpsertedID: &u.ID}, nil
+}
+
+// replaced returns old with every field except the ID and timestamps taken from u.
+// The expiry of old is kept when u has none; Update can unset it.
+func replaced(old User, u *User) User {
+	updated := old
+	updated.Name = u.Name
+	updated.Age = u.Age
+	updated.Gender = u.Gender
+	updated.Attributes = u.Attributes
+	if u.ExpiresAt != nil {
+		updated.ExpiresAt = u.ExpiresAt
+	}
+	return updated.Clone()
+}
+
+// store saves updated in place of old, bumping UpdatedAt and the version only when something changed.
+// The caller must hold r.mu and must have validated updated.
+func (r *MemoryRepository) store(old, updated User) (*UpdateResult, error) {
+	if sameContent(&old, &updated) {
+		return &UpdateResult{Matched: 1}, nil
+	}
+	if r.nameTaken(updated.Name, updated.ID) {
+		return nil, ErrDuplicate
+	}
+
+	updated.touch(time.Now())
+	updated.Version = old.Version + 1
+	if err := r.save(updated); err != nil {
+		return nil, err
+	}
+	return &UpdateResult{Matched: 1, Modified: 1}, nil
+}
+
+// Delete moves the user with the given ID to the trash.
+func (r *MemoryRepository) Delete(ctx context.Context, id primitive.ObjectID, expect Expect) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[id]
+	if !ok || u.DeletedAt != nil {
+		return ErrNotFound
+	}
+	if err := expect.check(&u); err != nil {
+		return err
+	}
+
+	u.touch(time.Now())
+	u.Version++
+	deletedAt := u.UpdatedAt
+	u.DeletedAt = &deletedAt
+	return r.save(u)
+}
+
+// Restore takes the user with the given ID out of the trash.
+func (r *MemoryRepository) Restore(ctx context.Context, id primitive.ObjectID, expect Expect) (*User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[id]
+	if !ok || u.DeletedAt == nil {
+		return nil, ErrNotFound
+	}
+	if err := expect.check(&u); err != nil {
+		return nil, err
+	}
+
+	u.DeletedAt = nil
+	u.touch(time.Now())
+	u.Version++
+	if err := r.save(u); err != nil {
+		return nil, err
+	}
+	u = u.Clone()
+	return &u, nil
+}
+
+// HardDelete permanently removes the user with the given ID.
+func (r *MemoryRepository) HardDelete(ctx context.Context, id primitive.ObjectID, expect Expect) (*User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if err := expect.check(&u); err != nil {
+		return nil, err
+	}
+	if err := r.drop([]primitive.ObjectID{id}); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// Purge permanently removes the users moved to the trash before deletedBefore.
+func (r *MemoryRepository) Purge(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var ids []primitive.ObjectID
+	for id, u := range r.users {
+		if u.DeletedAt != nil && u.DeletedAt.Before(deletedBefore) {
+			ids = append(ids, id)
+		}
+	}
+	if err := r.drop(ids); err != nil {
+		return 0, err
+	}
+	return int64(len(ids)), nil
+}
+
+// Expire permanently removes the users whose ExpiresAt is not after now,
+// in the trash or not, and returns how many were removed.
+func (r *MemoryRepository) Expire(ctx context.Context, now time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var ids []primitive.ObjectID
+	for id, u := range r.users {
+		if u.ExpiresAt != nil && !u.ExpiresAt.After(now) {
+			ids = append(ids, id)
+		}
+	}
+	if err := r.drop(ids); err != nil {
+		return 0, err
+	}
+	return int64(len(ids)), nil
+}
+
+// save stores u under its ID, once persisted. The caller must hold r.mu.
+func (r *MemoryRepository) save(u User) error {
+	if r.persist != nil {
+		if err := r.persist.put(&u); err != nil {
+			return err
+		}
+	}
+	r.users[u.ID] = u
+	return nil
+}
+
+// drop removes the users with the given IDs, once persisted. The caller must hold r.mu.
+func (r *MemoryRepository) drop(ids []primitive.ObjectID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if r.persist != nil {
+		if err := r.persist.remove(ids); err != nil {
+			return err
+		}
+	}
+	for _, id := range ids {
+		delete(r.users, id)
+	}
+	return nil
+}
+
+// nameTaken reports whether a user other than except already has the given name.
+// The caller must hold r.mu.
+func (r *MemoryRepository) nameTaken(name string, except primitive.ObjectID) bool {
+	for id, u := range r.users {
+		if id != except && u.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// sortUsers orders users by the given sort keys.
+func sortUsers(users []User, keys []SortField) {
+	sort.Slice(users, func(i, j int) bool {
+		for _, k := range keys {
+			c := compareSortValues(users[i].sortValue(k.Field), users[j].sortValue(k.Field))
+			if k.Desc {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+}
+
+// projectUser returns a copy of u holding only the ID, the given fields and the sort keys.
+func projectUser(u *User, fields []string, keys []SortField) User {
+	keep := map[string]bool{"id": true}
+	for _, f := range fields {
+		keep[f] = true
+	}
+	for _, k := range keys {
+		keep[k.Field] = true
+	}
+
+	p := User{ID: u.ID}
+	if keep["name"] {
+		p.Name = u.Name
+	}
+	if keep["age"] {
+		p.Age = u.Age
+	}
+	if keep["gender"] {
+		p.Gender = u.Gender
+	}
+	if keep["attributes"] {
+		p.Attributes = u.Attributes
+	}
+	if keep["createdAt"] {
+		p.CreatedAt = u.CreatedAt
+	}
+	if keep["updatedAt"] {
+		p.UpdatedAt = u.UpdatedAt
+	}
+	if keep["deletedAt"] {
+		p.DeletedAt = u.DeletedAt
+	}
+	if keep["expiresAt"] {
+		p.ExpiresAt = u.ExpiresAt
+	}
+	if keep["version"] {
+		p.Version = u.Version
+	}
+	return p
+}
@@ -0,0 +1,207 @@
+package userstore
+
+import (
+	"context"
+
+	"github.com/saurabhkk55/Go/16_MongoDB/migrate"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Migrations returns the schema history of the user collection with the given name.
+// Run them with a migrate.Runner before using a MongoRepository on that collection.
+// Legacy documents they cannot migrate are moved to the quarantine collection;
+// see migrate.CountQuarantined.
+func Migrations(collection string) []migrate.Migration {
+	createUp, createDown := migrate.CreateCollection(collection)
+	validatorUp, validatorDown := migrate.SetValidator(collection, userSchema(false))
+	nameUp, nameDown := migrate.CreateIndex(collection, mongo.IndexModel{
+		Keys:    bson.D{{Key: "Name", Value: 1}},
+		Options: options.Index().SetName("Name_unique").SetUnique(true),
+	})
+	ageUp, ageDown := migrate.CreateIndex(collection, mongo.IndexModel{
+		Keys:    bson.D{{Key: "Age", Value: 1}, {Key: "_id", Value: 1}},
+		Options: options.Index().SetName("Age_id"),
+	})
+	deletedUp, deletedDown := migrate.CreateIndex(collection, mongo.IndexModel{
+		Keys: bson.D{{Key: "DeletedAt", Value: 1}},
+		Options: options.Index().SetName("DeletedAt_trash").
+			SetPartialFilterExpression(bson.D{{Key: "DeletedAt", Value: bson.D{{Key: "$exists", Value: true}}}}),
+	})
+	versionUp, versionDown := initialVersions(collection)
+	// A TTL index with no delay removes each user once its ExpiresAt has
+	// passed. Users without one are not in the index and never expire.
+	expiryUp, expiryDown := migrate.CreateIndex(collection, mongo.IndexModel{
+		Keys:    bson.D{{Key: "ExpiresAt", Value: 1}},
+		Options: options.Index().SetName("ExpiresAt_ttl").SetExpireAfterSeconds(0),
+	})
+	encryptableUp, _ := migrate.SetValidator(collection, userSchema(true))
+	plaintextUp, _ := migrate.SetValidator(collection, userSchema(false))
+	// A wildcard text index covers the values of every attribute; the name
+	// weighs more. Version 3 folds case and diacritics, and the language
+	// "none" keeps words unstemmed.
+	textUp, textDown := migrate.CreateIndex(collection, mongo.IndexModel{
+		Keys: bson.D{{Key: "$**", Value: "text"}},
+		Options: options.Index().SetName("search_text").
+			SetWeights(bson.D{{Key: "Name", Value: 2}}).
+			SetDefaultLanguage("none").
+			SetTextVersion(3),
+	})
+
+	return []migrate.Migration{
+		{
+			Version:     1,
+			Description: "create the " + collection + " collection",
+			Up:          createUp,
+			Down:        createDown,
+		},
+		{
+			Version:     2,
+			Description: "convert legacy string ages, gender spellings and missing timestamps",
+			// Documents that cannot be converted, such as an age of "twenty",
+			// are quarantined rather than failing the migration.
+			Up: migrate.Transform(collection, legacyFilter(), upgradeLegacy),
+			// Every reader accepts both forms, so the converted values can stay.
+			Down: func(context.Context, *mongo.Database) error { return nil },
+		},
+		{
+			Version:     3,
+			Description: "validate user documents with a JSON schema",
+			Up:          validatorUp,
+			Down:        validatorDown,
+		},
+		{
+			Version:     4,
+			Description: "unique index on Name",
+			// The old insertDocument let names repeat; all but the first user of
+			// each name are quarantined so that the index can be built.
+			Up:   migrate.Chain(migrate.Dedup(collection, "Name"), nameUp),
+			Down: nameDown,
+		},
+		{
+			Version:     5,
+			Description: "index on Age for age filters and sorting",
+			Up:          ageUp,
+			Down:        ageDown,
+		},
+		{
+			Version:     6,
+			Description: "index on DeletedAt for the trash and the purger",
+			Up:          deletedUp,
+			Down:        deletedDown,
+		},
+		{
+			Version:     7,
+			Description: "start every user at version 1 for optimistic concurrency",
+			Up:          versionUp,
+			Down:        versionDown,
+		},
+		{
+			Version:     8,
+			Description: "text index on names and attribute values for search",
+			Up:          textUp,
+			Down:        textDown,
+		},
+		{
+			Version:     9,
+			Description: "let Age and Gender hold encrypted values",
+			Up:          encryptableUp,
+			Down:        plaintextUp,
+		},
+		{
+			Version:     10,
+			Description: "TTL index on ExpiresAt to remove expired users",
+			Up:          expiryUp,
+			Down:        expiryDown,
+		},
+	}
+}
+
+// initialVersions returns steps that give every user without a Version
+// version 1, and remove the field again.
+func initialVersions(collection string) (up, down migrate.Step) {
+	up = func(ctx context.Context, db *mongo.Database) error {
+		_, err := db.Collection(collection).UpdateMany(ctx,
+			bson.D{{Key: "Version", Value: bson.D{{Key: "$exists", Value: false}}}},
+			bson.D{{Key: "$set", Value: bson.D{{Key: "Version", Value: int64(1)}}}})
+		return err
+	}
+	down = func(ctx context.Context, db *mongo.Database) error {
+		_, err := db.Collection(collection).UpdateMany(ctx, bson.D{},
+			bson.D{{Key: "$unset", Value: bson.D{{Key: "Version", Value: ""}}}})
+		return err
+	}
+	return up, down
+}
+
+// userSchema is the $jsonSchema validator matching User.Validate. An
+// encryptable schema also accepts the binary values fieldcrypt stores in
+// place of an encrypted Age or Gender.
+func userSchema(encryptable bool) bson.D {
+	age := bson.D{
+		{Key: "bsonType", Value: bson.A{"int", "long"}},
+		{Key: "minimum", Value: MinAge},
+		{Key: "maximum", Value: MaxAge},
+	}
+	gender := bson.D{
+		{Key: "enum", Value: bson.A{GenderMale, GenderFemale, GenderOther}},
+	}
+	if encryptable {
+		encrypted := bson.D{{Key: "bsonType", Value: "binData"}}
+		age = bson.D{{Key: "anyOf", Value: bson.A{age, encrypted}}}
+		gender = bson.D{{Key: "anyOf", Value: bson.A{gender, encrypted}}}
+	}
+
+	properties := bson.D{
+		{Key: "Name", Value: bson.D{
+			{Key: "bsonType", Value: "string"},
+			{Key: "minLength", Value: 1},
+			{Key: "maxLength", Value: MaxNameLength},
+		}},
+		{Key: "Age", Value: age},
+		{Key: "Gender", Value: gender},
+		{Key: "Attributes", Value: bson.D{
+			{Key: "bsonType", Value: "object"},
+			{Key: "maxProperties", Value: MaxAttributes},
+			{Key: "additionalProperties", Value: bson.D{{Key: "bsonType", Value: "string"}}},
+		}},
+		{Key: "CreatedAt", Value: bson.D{{Key: "bsonType", Value: "date"}}},
+		{Key: "UpdatedAt", Value: bson.D{{Key: "bsonType", Value: "date"}}},
+	}
+
+	return bson.D{{Key: "$jsonSchema", Value: bson.D{
+		{Key: "bsonType", Value: "object"},
+		{Key: "required", Value: bson.A{"Name", "Age", "Gender"}},
+		{Key: "properties", Value: properties},
+	}}}
+}
+
+// legacyFilter selects the documents written by the old getUserInput.
+func legacyFilter() bson.D {
+	return bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "Age", Value: bson.D{{Key: "$type", Value: "string"}}}},
+		bson.D{{Key: "Gender", Value: bson.D{{Key: "$nin", Value: bson.A{GenderMale, GenderFemale, GenderOther}}}}},
+		bson.D{{Key: "CreatedAt", Value: bson.D{{Key: "$exists", Value: false}}}},
+	}}}
+}
+
+// upgradeLegacy decodes a legacy document through User, which already
+// understands the old encodings, and writes the fields back in their typed form.
+func upgradeLegacy(doc bson.Raw) (bson.D, error) {
+	var u User
+	if err := bson.Unmarshal(doc, &u); err != nil {
+		return nil, err
+	}
+	if err := u.Validate(); err != nil {
+		return nil, err
+	}
+	u.fillLegacyTimestamps()
+
+	return bson.D{{Key: "$set", Value: bson.D{
+		{Key: "Age", Value: u.Age},
+		{Key: "Gender", Value: u.Gender},
+		{Key: "CreatedAt", Value: u.CreatedAt},
+		{Key: "UpdatedAt", Value: u.UpdatedAt},
+	}}}, nil
+}
@@ -0,0 +1,527 @@
+package userstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultTimeout is how long an operation of a MongoRepository may run when
+// nothing else is configured; see SetTimeout.
+const DefaultTimeout = 10 * time.Second
+
+// MongoRepository is a Repository backed by a MongoDB collection.
+//
+// Every operation is bounded by the timeout of the repository, or by the
+// deadline of its context when that comes first, so a server that stops
+// answering does not block the caller forever. An operation that runs out
+// of time fails with an error wrapping ErrTimeout; one whose context is
+// cancelled fails with an error wrapping context.Canceled.
+type MongoRepository struct {
+	coll    *mongo.Collection
+	timeout time.Duration
+
+	// encrypted holds the fields stored encrypted; see SetEncryption.
+	encrypted map[string]Encryption
+}
+
+// NewMongoRepository returns a Repository that stores users in coll.
+func NewMongoRepository(coll *mongo.Collection) *MongoRepository {
+	return &MongoRepository{coll: coll, timeout: DefaultTimeout}
+}
+
+// SetTimeout sets how long each operation may run. Zero leaves operations
+// bounded by their context alone. Stream applies it to the query and to each
+// wait for the next batch of users, not to the whole iteration.
+func (r *MongoRepository) SetTimeout(d time.Duration) {
+	r.timeout = d
+}
+
+// withTimeout bounds ctx by the timeout of the repository.
+func (r *MongoRepository) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, r.timeout)
+}
+
+// Create inserts u into the collection.
+func (r *MongoRepository) Create(ctx context.Context, u *User) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	if err := u.Validate(); err != nil {
+		return err
+	}
+
+	// Assign the ID here so the caller gets it back without a type assertion on InsertedID.
+	if u.ID.IsZero() {
+		u.ID = primitive.NewObjectID()
+	}
+	u.touch(time.Now())
+	u.Version = 1
+
+	_, err := r.coll.InsertOne(ctx, u)
+	return mongoError("insert", err)
+}
+
+// CreateMany inserts users in a single unordered InsertMany, so one bad user
+// does not stop the others. Failures are returned by index.
+func (r *MongoRepository) CreateMany(ctx context.Context, users []*User) (map[int]error, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	failed := map[int]error{}
+	docs := make([]any, 0, len(users))
+	indexes := make([]int, 0, len(users))
+
+	// Validate up front; only valid users are sent to the server.
+	now := time.Now()
+	for i, u := range users {
+		if err := u.Validate(); err != nil {
+			failed[i] = err
+			continue
+		}
+		if u.ID.IsZero() {
+			u.ID = primitive.NewObjectID()
+		}
+		u.touch(now)
+		u.Version = 1
+		docs = append(docs, u)
+		indexes = append(indexes, i)
+	}
+	if len(docs) == 0 {
+		return failed, nil
+	}
+
+	_, err := r.coll.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+
+	// A BulkWriteException lists the documents that failed by their position in docs.
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) && bulkErr.WriteConcernError == nil {
+		for _, we := range bulkErr.WriteErrors {
+			failed[indexes[we.Index]] = mongoError("insert", we)
+		}
+		return failed, nil
+	}
+	if err != nil {
+		return nil, mongoError("insert", err)
+	}
+	return failed, nil
+}
+
+// Get finds the user with the given ID.
+func (r *MongoRepository) Get(ctx context.Context, id primitive.ObjectID) (*User, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	return r.findOne(ctx, bson.D{{Key: "_id", Value: id}, notDeleted})
+}
+
+// FindByName finds the user with the given name.
+func (r *MongoRepository) FindByName(ctx context.Context, name string) (*User, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	return r.findOne(ctx, bson.D{{Key: "Name", Value: name}, notDeleted})
+}
+
+// List returns one page of users.
+func (r *MongoRepository) List(ctx context.Context, opts ListOptions) (*Page, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	if err := r.checkEncrypted(&opts); err != nil {
+		return nil, err
+	}
+	keys := opts.sortKeys()
+
+	// The total ignores pagination, so count before the cursor narrows the filter.
+	filter := opts.Filter.MongoFilter()
+	total, err := r.coll.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, mongoError("count", err)
+	}
+
+	if opts.After != "" {
+		values, err := decodeCursor(keys, opts.After)
+		if err != nil {
+			return nil, err
+		}
+		filter = append(filter, keysetFilter(keys, values)...)
+	}
+
+	findOpts := findOptions(opts, keys).SetSkip(int64(opts.Offset))
+
+	// Fetch one extra document to learn whether another page follows.
+	if opts.Limit > 0 {
+		findOpts.SetLimit(int64(opts.Limit) + 1)
+	}
+
+	cursor, err := r.coll.Find(ctx, filter, findOpts)
+	if err != nil {
+		return nil, mongoError("find", err)
+	}
+
+	// All decodes every remaining document and closes the cursor.
+	users := []User{}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, mongoError("decode", err)
+	}
+	for i := range users {
+		users[i].fillLegacyTimestamps()
+	}
+	return newPage(users, total, keys, opts.Limit), nil
+}
+
+// Stream calls fn for every user matching opts.Filter, in opts.Sort order,
+// decoding one document at a time. Pagination options are ignored.
+func (r *MongoRepository) Stream(ctx context.Context, opts ListOptions, fn func(*User) error) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	if err := r.checkEncrypted(&opts); err != nil {
+		return err
+	}
+
+	findCtx, cancel := r.withTimeout(ctx)
+	cursor, err := r.coll.Find(findCtx, opts.Filter.MongoFilter(), findOptions(opts, opts.sortKeys()))
+	cancel()
+	if err != nil {
+		return mongoError("find", err)
+	}
+	defer cursor.Close(ctx)
+
+	for r.next(ctx, cursor) {
+		var u User
+		if err := cursor.Decode(&u); err != nil {
+			return mongoError("decode", err)
+		}
+		u.fillLegacyTimestamps()
+
+		if err := fn(&u); err != nil {
+			return err
+		}
+	}
+	return mongoError("find", cursor.Err())
+}
+
+// next advances cursor, giving each wait for the next batch of users the
+// timeout of the repository, so that a slow fn does not use it up.
+func (r *MongoRepository) next(ctx context.Context, cursor *mongo.Cursor) bool {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	return cursor.Next(ctx)
+}
+
+// findOptions builds the sort and projection shared by List and Stream.
+func findOptions(opts ListOptions, keys []SortField) *options.FindOptions {
+	sort := bson.D{}
+	for _, k := range keys {
+		dir := 1
+		if k.Desc {
+			dir = -1
+		}
+		sort = append(sort, bson.E{Key: fieldKeys[k.Field], Value: dir})
+	}
+	findOpts := options.Find().SetSort(sort)
+
+	if len(opts.Fields) > 0 {
+		// The sort keys are projected too because the next cursor is built from them.
+		projection := bson.D{}
+		seen := map[string]bool{}
+		fields := append([]string{}, opts.Fields...)
+		for _, k := range keys {
+			fields = append(fields, k.Field)
+		}
+		for _, f := range fields {
+			if !seen[f] {
+				seen[f] = true
+				projection = append(projection, bson.E{Key: fieldKeys[f], Value: 1})
+			}
+		}
+		findOpts.SetProjection(projection)
+	}
+	return findOpts
+}
+
+// Count returns the number of documents in the collection that are not in the trash.
+func (r *MongoRepository) Count(ctx context.Context) (int64, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	n, err := r.coll.CountDocuments(ctx, bson.D{notDeleted})
+	if err != nil {
+		return 0, mongoError("count", err)
+	}
+	return n, nil
+}
+
+// Update applies a partial update to the user with the given ID.
+func (r *MongoRepository) Update(ctx context.Context, id primitive.ObjectID, upd Update, expect Expect) (*UpdateResult, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	if err := upd.validate(); err != nil {
+		return nil, err
+	}
+
+	result, _, err := r.swap(ctx, bson.D{{Key: "_id", Value: id}, notDeleted}, func(old User) (User, error) {
+		if err := expect.check(&old); err != nil {
+			return old, err
+		}
+		// Validate the result too, which catches an increment that leaves the range.
+		updated := upd.apply(old)
+		return updated, updated.Validate()
+	})
+	return result, err
+}
+
+// Replace overwrites every field of the stored user that has the same ID as u.
+// Attributes missing from u are removed; CreatedAt, and ExpiresAt unless u
+// sets it, are kept and copied back into u.
+func (r *MongoRepository) Replace(ctx context.Context, u *User, expect Expect) (*UpdateResult, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	if err := u.Validate(); err != nil {
+		return nil, err
+	}
+
+	result, stored, err := r.swap(ctx, bson.D{{Key: "_id", Value: u.ID}, notDeleted}, func(old User) (User, error) {
+		if err := expect.check(&old); err != nil {
+			return old, err
+		}
+		return replaced(old, u), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	*u = *stored
+	return result, nil
+}
+
+// Upsert replaces the user with the same name as u, or inserts u when there is none.
+func (r *MongoRepository) Upsert(ctx context.Context, u *User) (*UpdateResult, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	if err := u.Validate(); err != nil {
+		return nil, err
+	}
+	if u.ID.IsZero() {
+		u.ID = primitive.NewObjectID()
+	}
+
+	for {
+		// The name matched an existing user, whose ID wins over the one generated above.
+		result, stored, err := r.swap(ctx, bson.D{{Key: "Name", Value: u.Name}, notDeleted}, func(old User) (User, error) {
+			return replaced(old, u), nil
+		})
+		if err == nil {
+			*u = *stored
+			return result, nil
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+
+		inserted := u.Clone()
+		inserted.CreatedAt = time.Time{}
+		inserted.touch(time.Now())
+		inserted.Version = 1
+		_, err = r.coll.InsertOne(ctx, &inserted)
+		if err == nil {
+			*u = inserted
+			return &UpdateResult{UpsertedID: &u.ID}, nil
+		}
+
+		// Another writer may have inserted the name since it was looked up, in
+		// which case replace that user. A user in the trash still holds the
+		// name, so then the insert fails as a duplicate.
+		if !mongo.IsDuplicateKeyError(err) {
+			return nil, mongoError("upsert", err)
+		}
+		if _, findErr := r.FindByName(ctx, u.Name); findErr != nil {
+			return nil, mongoError("upsert", err)
+		}
+	}
+}
+
+// swap rewrites the user matching filter with what change makes of it, as a
+// compare-and-swap on the version: the write only lands if nobody wrote the
+// user since it was read, and is retried on a fresh copy otherwise. Callers
+// check the version they expect inside change, so a retry reports the
+// conflict. A change that leaves the content alone writes nothing.
+func (r *MongoRepository) swap(ctx context.Context, filter bson.D, change func(old User) (User, error)) (*UpdateResult, *User, error) {
+	for {
+		old, err := r.findOne(ctx, filter)
+		if err != nil {
+			return nil, nil, err
+		}
+		updated, err := change(old.Clone())
+		if err != nil {
+			return nil, nil, err
+		}
+		if sameContent(old, &updated) {
+			return &UpdateResult{Matched: 1}, old, nil
+		}
+
+		updated.touch(time.Now())
+		updated.Version = old.Version + 1
+		// The whole user is written, so that a codec encrypting its fields
+		// sees its _id; updated keeps the _id and CreatedAt of old.
+		result, err := r.coll.ReplaceOne(ctx, bson.D{{Key: "_id", Value: old.ID}, versionIs(old.Version)}, &updated)
+		if err != nil {
+			return nil, nil, mongoError("update", err)
+		}
+		if result.MatchedCount > 0 {
+			return &UpdateResult{Matched: 1, Modified: 1}, &updated, nil
+		}
+	}
+}
+
+// Delete moves the user with the given ID to the trash.
+func (r *MongoRepository) Delete(ctx context.Context, id primitive.ObjectID, expect Expect) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "DeletedAt", Value: now},
+			{Key: "UpdatedAt", Value: now},
+		}},
+		{Key: "$inc", Value: bson.D{{Key: "Version", Value: 1}}},
+	}
+
+	filter := bson.D{{Key: "_id", Value: id}, notDeleted}
+	result, err := r.coll.UpdateOne(ctx, expect.filter(filter), update)
+	if err != nil {
+		return mongoError("delete", err)
+	}
+	if result.MatchedCount == 0 {
+		return r.missed(ctx, filter, expect)
+	}
+	return nil
+}
+
+// Restore takes the user with the given ID out of the trash.
+func (r *MongoRepository) Restore(ctx context.Context, id primitive.ObjectID, expect Expect) (*User, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	filter := bson.D{{Key: "_id", Value: id}, {Key: "DeletedAt", Value: bson.D{{Key: "$exists", Value: true}}}}
+	update := bson.D{
+		{Key: "$unset", Value: bson.D{{Key: "DeletedAt", Value: ""}}},
+		{Key: "$set", Value: bson.D{{Key: "UpdatedAt", Value: now}}},
+		{Key: "$inc", Value: bson.D{{Key: "Version", Value: 1}}},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var u User
+	err := r.coll.FindOneAndUpdate(ctx, expect.filter(filter), update, opts).Decode(&u)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, r.missed(ctx, filter, expect)
+	}
+	if err != nil {
+		return nil, mongoError("restore", err)
+	}
+	u.fillLegacyTimestamps()
+	return &u, nil
+}
+
+// HardDelete permanently removes the user with the given ID.
+func (r *MongoRepository) HardDelete(ctx context.Context, id primitive.ObjectID, expect Expect) (*User, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	filter := bson.D{{Key: "_id", Value: id}}
+
+	var u User
+	err := r.coll.FindOneAndDelete(ctx, expect.filter(filter)).Decode(&u)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, r.missed(ctx, filter, expect)
+	}
+	if err != nil {
+		return nil, mongoError("delete", err)
+	}
+	u.fillLegacyTimestamps()
+	return &u, nil
+}
+
+// missed explains why a write narrowed by expect matched nothing: either no
+// user matches filter, or the user is at another version.
+func (r *MongoRepository) missed(ctx context.Context, filter bson.D, expect Expect) error {
+	u, err := r.findOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if err := expect.check(u); err != nil {
+		return err
+	}
+	// The user appeared or changed back in between; report what the write saw.
+	return ErrNotFound
+}
+
+// Purge permanently removes the users moved to the trash before deletedBefore.
+func (r *MongoRepository) Purge(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	filter := bson.D{{Key: "DeletedAt", Value: bson.D{{Key: "$lt", Value: deletedBefore}}}}
+	result, err := r.coll.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, mongoError("purge", err)
+	}
+	return result.DeletedCount, nil
+}
+
+// Expire permanently removes the users whose ExpiresAt is not after now,
+// in the trash or not, and returns how many were removed. The TTL index
+// created by the migrations does the same on the server about once a
+// minute; Expire is for when they must go straight away.
+func (r *MongoRepository) Expire(ctx context.Context, now time.Time) (int64, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	result, err := r.coll.DeleteMany(ctx, expiredBy(now))
+	if err != nil {
+		return 0, mongoError("expire", err)
+	}
+	return result.DeletedCount, nil
+}
+
+// findOne decodes the first user matching filter.
+func (r *MongoRepository) findOne(ctx context.Context, filter bson.D) (*User, error) {
+	var u User
+	if err := r.coll.FindOne(ctx, filter).Decode(&u); err != nil {
+		return nil, mongoError("find", err)
+	}
+	u.fillLegacyTimestamps()
+	return &u, nil
+}
+
+// mongoError maps driver errors onto the package's sentinel errors.
+func mongoError(op string, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return ErrNotFound
+	case mongo.IsDuplicateKeyError(err):
+		return fmt.Errorf("%w: %v", ErrDuplicate, err)
+	case mongo.IsTimeout(err):
+		return fmt.Errorf("%w: %s: %w", ErrTimeout, op, err)
+	default:
+		return fmt.Errorf("userstore: %s: %w", op, err)
+	}
+}
@@ -0,0 +1,39 @@
+package userstore
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultRetention is how long deleted users stay in the trash when nothing else is configured.
+const DefaultRetention = 30 * 24 * time.Hour
+
+// Purger permanently removes the users moved to the trash before a time.
+// Every Repository is a Purger.
+type Purger interface {
+	Purge(ctx context.Context, deletedBefore time.Time) (int64, error)
+}
+
+// RunPurger permanently removes the users that have been in the trash for
+// longer than retention, once straight away and then every interval, until
+// ctx is done. Failures are passed to logf and retried on the next run.
+func RunPurger(ctx context.Context, repo Purger, retention, interval time.Duration, logf func(format string, args ...any)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := repo.Purge(ctx, time.Now().Add(-retention))
+		switch {
+		case err != nil && ctx.Err() == nil:
+			logf("userstore: purge trash: %v", err)
+		case n > 0:
+			logf("userstore: purged %d user(s) deleted more than %s ago", n, retention)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
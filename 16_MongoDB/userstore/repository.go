@@ -0,0 +1,78 @@
+package userstore
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Repository is the set of operations the HTTP and CLI layers use to manage users.
+// MongoRepository, MemoryRepository and FileRepository implement it.
+type Repository interface {
+	// Create validates u, assigns it a new ID when it has none and stores it.
+	Create(ctx context.Context, u *User) error
+
+	// CreateMany validates and stores users in one batch. Users that cannot be
+	// stored are reported by their index; the others are stored regardless.
+	CreateMany(ctx context.Context, users []*User) (map[int]error, error)
+
+	// Get returns the user with the given ID.
+	// Like every other read, it does not see users in the trash.
+	Get(ctx context.Context, id primitive.ObjectID) (*User, error)
+
+	// FindByName returns the user with the given name.
+	FindByName(ctx context.Context, name string) (*User, error)
+
+	// List returns one page of users selected, ordered and projected by opts.
+	List(ctx context.Context, opts ListOptions) (*Page, error)
+
+	// Stream calls fn for each user selected by opts.Filter in opts.Sort order,
+	// without loading them all at once. Pagination options are ignored.
+	Stream(ctx context.Context, opts ListOptions, fn func(*User) error) error
+
+	// Count returns the number of stored users that are not in the trash.
+	Count(ctx context.Context) (int64, error)
+
+	// Update applies a partial update to the user with the given ID.
+	// This and the other writes below that take an Expect fail with a
+	// *ConflictError when the user is not at the version it requires.
+	Update(ctx context.Context, id primitive.ObjectID, upd Update, expect Expect) (*UpdateResult, error)
+
+	// Replace validates u and replaces the whole stored user with the same ID.
+	// The stored CreatedAt, and ExpiresAt unless u sets it, are kept and
+	// copied back into u.
+	Replace(ctx context.Context, u *User, expect Expect) (*UpdateResult, error)
+
+	// Upsert replaces the user with the same name as u, or inserts u when there is none.
+	// On return u holds the ID of the stored user.
+	Upsert(ctx context.Context, u *User) (*UpdateResult, error)
+
+	// Delete moves the user with the given ID to the trash by setting DeletedAt.
+	// A user in the trash keeps its name, which cannot be reused until the
+	// user is restored or purged.
+	Delete(ctx context.Context, id primitive.ObjectID, expect Expect) error
+
+	// Restore takes the user with the given ID out of the trash and returns it.
+	Restore(ctx context.Context, id primitive.ObjectID, expect Expect) (*User, error)
+
+	// HardDelete permanently removes the user with the given ID, whether it is
+	// in the trash or not, and returns what was removed.
+	HardDelete(ctx context.Context, id primitive.ObjectID, expect Expect) (*User, error)
+
+	// Purge permanently removes the users that were moved to the trash before
+	// the given time and returns how many were removed.
+	Purge(ctx context.Context, deletedBefore time.Time) (int64, error)
+
+	// Expire permanently removes the users whose ExpiresAt is not after now,
+	// whether they are in the trash or not, and returns how many were removed.
+	// MongoDB also removes them by itself, through the TTL index.
+	Expire(ctx context.Context, now time.Time) (int64, error)
+}
+
+// Every implementation must satisfy Repository.
+var (
+	_ Repository = (*MongoRepository)(nil)
+	_ Repository = (*MemoryRepository)(nil)
+	_ Repository = (*FileRepository)(nil)
+)
@@ -0,0 +1,186 @@
+package userstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Update is a partial update with MongoDB $set, $unset and $inc semantics.
+//
+// In JSON it is written either with operators,
+//
+//	{"$set": {"gender": "F"}, "$unset": ["attributes.city"], "$inc": {"age": 1}}
+//
+// or as a plain object of fields, which is the same as putting them under "$set".
+type Update struct {
+	// Set overwrites the given fields. Attributes are set key by key.
+	Set UserPatch `bson:"set" json:"$set"`
+
+	// Unset removes optional fields: "attributes", "attributes.<key>" or "expiresAt".
+	Unset []string `bson:"unset,omitempty" json:"$unset"`
+
+	// Inc atomically adds to numeric fields. Only "age" is numeric.
+	Inc map[string]int `bson:"inc,omitempty" json:"$inc"`
+}
+
+// UpdateResult reports what an update, replace or upsert did.
+type UpdateResult struct {
+	// Matched is the number of users the filter selected.
+	Matched int64 `json:"matched"`
+
+	// Modified is the number of users whose content actually changed.
+	Modified int64 `json:"modified"`
+
+	// UpsertedID is set when an upsert inserted a new user.
+	UpsertedID *primitive.ObjectID `json:"upsertedId,omitempty"`
+}
+
+// Upserted reports whether the write inserted a new user.
+func (r *UpdateResult) Upserted() bool {
+	return r.UpsertedID != nil
+}
+
+// UnmarshalJSON accepts both the operator form and a plain object of fields.
+func (u *Update) UnmarshalJSON(data []byte) error {
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+
+	operators := false
+	for k := range keys {
+		if strings.HasPrefix(k, "$") {
+			operators = true
+		}
+	}
+
+	// Decode strictly so that a misspelt field or operator is reported instead of ignored.
+	if !operators {
+		*u = Update{}
+		return decodeStrict(data, &u.Set)
+	}
+
+	type plain Update
+	var p plain
+	if err := decodeStrict(data, &p); err != nil {
+		return err
+	}
+	*u = Update(p)
+	return nil
+}
+
+// decodeStrict decodes JSON into v, rejecting unknown fields.
+func decodeStrict(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// IsEmpty reports whether the update changes nothing.
+func (u *Update) IsEmpty() bool {
+	s := u.Set
+	return s.Name == nil && s.Age == nil && s.Gender == nil && len(s.Attributes) == 0 && s.ExpiresAt == nil &&
+		len(u.Unset) == 0 && len(u.Inc) == 0
+}
+
+// validate checks the values being written and that no field is used twice.
+func (u *Update) validate() error {
+	if u.IsEmpty() {
+		return &ValidationError{Field: "update", Reason: "is empty"}
+	}
+
+	if u.Set.Name != nil {
+		if err := validateName(*u.Set.Name); err != nil {
+			return err
+		}
+	}
+	if u.Set.Age != nil {
+		if err := validateAge(*u.Set.Age); err != nil {
+			return err
+		}
+	}
+	if u.Set.Gender != nil {
+		if err := validateGender(*u.Set.Gender); err != nil {
+			return err
+		}
+	}
+	if err := validateAttributes(u.Set.Attributes); err != nil {
+		return err
+	}
+
+	for _, path := range u.Unset {
+		switch {
+		case path == "attributes":
+			if len(u.Set.Attributes) > 0 {
+				return &ValidationError{Field: "attributes", Reason: "cannot be both set and unset"}
+			}
+		case strings.HasPrefix(path, "attributes."):
+			key := strings.TrimPrefix(path, "attributes.")
+			if err := validateAttributeKey(key); err != nil {
+				return err
+			}
+			if _, ok := u.Set.Attributes[key]; ok {
+				return &ValidationError{Field: path, Reason: "cannot be both set and unset"}
+			}
+		case path == "expiresAt":
+			if u.Set.ExpiresAt != nil {
+				return &ValidationError{Field: path, Reason: "cannot be both set and unset"}
+			}
+		case path == "deletedAt":
+			return &ValidationError{Field: path, Reason: "can only be cleared by Restore"}
+		case fieldKeys[path] != "":
+			return &ValidationError{Field: path, Reason: "is required and cannot be unset"}
+		default:
+			return &ValidationError{Field: path, Reason: "is not a known field"}
+		}
+	}
+
+	for field := range u.Inc {
+		if field != "age" {
+			return &ValidationError{Field: field, Reason: "is not a numeric field"}
+		}
+		if u.Set.Age != nil {
+			return &ValidationError{Field: "age", Reason: "cannot be both set and incremented"}
+		}
+	}
+	return nil
+}
+
+// apply performs the update on a copy of the user, as MongoDB would.
+func (u *Update) apply(user User) User {
+	u.Set.Apply(&user)
+
+	for _, path := range u.Unset {
+		switch path {
+		case "attributes":
+			user.Attributes = nil
+			continue
+		case "expiresAt":
+			user.ExpiresAt = nil
+			continue
+		}
+
+		// Copy before deleting so the caller's map is never changed.
+		key := strings.TrimPrefix(path, "attributes.")
+		if _, ok := user.Attributes[key]; ok {
+			attrs := make(map[string]string, len(user.Attributes))
+			for k, v := range user.Attributes {
+				if k != key {
+					attrs[k] = v
+				}
+			}
+			if len(attrs) == 0 {
+				attrs = nil
+			}
+			user.Attributes = attrs
+		}
+	}
+
+	if n, ok := u.Inc["age"]; ok {
+		user.Age += Age(n)
+	}
+	return user
+}
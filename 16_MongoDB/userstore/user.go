@@ -0,0 +1,235 @@
+// Package userstore stores the user documents that 1_connection.go works with.
+// The same Repository interface is implemented on top of MongoDB and by an
+// in-memory stand-in that needs no server, which is handy for tests.
+package userstore
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Limits enforced by Validate.
+const (
+	MaxNameLength      = 100
+	MinAge             = 0
+	MaxAge             = 150
+	MaxAttributes      = 20
+	MaxAttributeLength = 200
+)
+
+// User is a single document in the user collection.
+// The bson keys match the documents written by insertDocument.
+type User struct {
+	ID     primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name   string             `bson:"Name" json:"name"`
+	Age    Age                `bson:"Age" json:"age"`
+	Gender Gender             `bson:"Gender" json:"gender"`
+
+	// Attributes holds optional free-form details such as a nickname or city.
+	Attributes map[string]string `bson:"Attributes,omitempty" json:"attributes,omitempty"`
+
+	CreatedAt time.Time `bson:"CreatedAt,omitempty" json:"createdAt"`
+	UpdatedAt time.Time `bson:"UpdatedAt,omitempty" json:"updatedAt"`
+
+	// DeletedAt is set while the user is in the trash; see Repository.Delete.
+	DeletedAt *time.Time `bson:"DeletedAt,omitempty" json:"deletedAt,omitempty"`
+
+	// ExpiresAt, when set, is when the user is removed for good, whether it
+	// is in the trash or not. MongoDB removes expired users with a TTL index
+	// and the other stores with Expire; until then they are still visible.
+	ExpiresAt *time.Time `bson:"ExpiresAt,omitempty" json:"expiresAt,omitempty"`
+
+	// Version is 1 when the user is created and goes up by one with every
+	// write that changes it. It is set by the repository; see Expect.
+	Version int64 `bson:"Version,omitempty" json:"version"`
+}
+
+// Validate checks every field against the rules of the user collection.
+func (u *User) Validate() error {
+	if err := validateName(u.Name); err != nil {
+		return err
+	}
+	if err := validateAge(u.Age); err != nil {
+		return err
+	}
+	if err := validateGender(u.Gender); err != nil {
+		return err
+	}
+	if u.DeletedAt != nil {
+		return &ValidationError{Field: "deletedAt", Reason: "is set by Delete and cannot be written"}
+	}
+	return validateAttributes(u.Attributes)
+}
+
+// validateName checks that a name is present, trimmed and not too long.
+func validateName(name string) error {
+	trimmed := strings.TrimSpace(name)
+	if trimmed == "" {
+		return &ValidationError{Field: "name", Reason: "is required"}
+	}
+	if trimmed != name {
+		return &ValidationError{Field: "name", Reason: "must not start or end with spaces"}
+	}
+	if utf8.RuneCountInString(name) > MaxNameLength {
+		return &ValidationError{Field: "name", Reason: "is too long"}
+	}
+	return nil
+}
+
+// validateAge checks that an age is within the sane range.
+func validateAge(age Age) error {
+	if age < MinAge || age > MaxAge {
+		return &ValidationError{Field: "age", Reason: "must be between 0 and 150"}
+	}
+	return nil
+}
+
+// validateGender checks that a gender is one of the enum values.
+func validateGender(g Gender) error {
+	if !g.Valid() {
+		return &ValidationError{Field: "gender", Reason: `must be one of "M", "F" or "O"`}
+	}
+	return nil
+}
+
+// validateAttributes checks attribute keys and values.
+// Keys become part of MongoDB field paths, so they may not contain dots or start with "$".
+func validateAttributes(attrs map[string]string) error {
+	if len(attrs) > MaxAttributes {
+		return &ValidationError{Field: "attributes", Reason: "has too many entries"}
+	}
+	for k, v := range attrs {
+		if err := validateAttributeKey(k); err != nil {
+			return err
+		}
+		if utf8.RuneCountInString(v) > MaxAttributeLength {
+			return &ValidationError{Field: "attributes." + k, Reason: "is too long"}
+		}
+	}
+	return nil
+}
+
+// validateAttributeKey checks a single attribute key.
+func validateAttributeKey(k string) error {
+	if k == "" || strings.Contains(k, ".") || strings.HasPrefix(k, "$") {
+		return &ValidationError{Field: "attributes", Reason: fmt.Sprintf("has invalid key %q", k)}
+	}
+	return nil
+}
+
+// touch sets the timestamps for a write happening now.
+// CreatedAt is only set the first time a user is stored.
+func (u *User) touch(now time.Time) {
+	// MongoDB stores times with millisecond precision, so keep the in-memory copy identical.
+	now = now.UTC().Truncate(time.Millisecond)
+
+	if u.CreatedAt.IsZero() {
+		u.CreatedAt = now
+	}
+	u.UpdatedAt = now
+	if u.ExpiresAt != nil {
+		expiresAt := u.ExpiresAt.UTC().Truncate(time.Millisecond)
+		u.ExpiresAt = &expiresAt
+	}
+}
+
+// UserPatch holds the fields of a partial update. Nil fields are left unchanged.
+// Attributes are merged key by key into the existing ones.
+type UserPatch struct {
+	Name       *string           `bson:"name,omitempty" json:"name"`
+	Age        *Age              `bson:"age,omitempty" json:"age"`
+	Gender     *Gender           `bson:"gender,omitempty" json:"gender"`
+	Attributes map[string]string `bson:"attributes,omitempty" json:"attributes"`
+	ExpiresAt  *time.Time        `bson:"expiresAt,omitempty" json:"expiresAt"`
+}
+
+// Apply copies the fields set in p onto u.
+func (p *UserPatch) Apply(u *User) {
+	if p.Name != nil {
+		u.Name = *p.Name
+	}
+	if p.Age != nil {
+		u.Age = *p.Age
+	}
+	if p.Gender != nil {
+		u.Gender = *p.Gender
+	}
+	if p.ExpiresAt != nil {
+		expiresAt := *p.ExpiresAt
+		u.ExpiresAt = &expiresAt
+	}
+	if len(p.Attributes) > 0 {
+		attrs := make(map[string]string, len(u.Attributes)+len(p.Attributes))
+		for k, v := range u.Attributes {
+			attrs[k] = v
+		}
+		for k, v := range p.Attributes {
+			attrs[k] = v
+		}
+		u.Attributes = attrs
+	}
+}
+
+// ParseID parses the hex form of a user ID.
+func ParseID(s string) (primitive.ObjectID, error) {
+	id, err := primitive.ObjectIDFromHex(s)
+	if err != nil {
+		return primitive.NilObjectID, &ValidationError{Field: "id", Reason: "is not a valid ObjectID"}
+	}
+	return id, nil
+}
+
+// fillLegacyTimestamps gives documents written before timestamps existed
+// sensible values, using the creation time encoded in the ObjectID.
+func (u *User) fillLegacyTimestamps() {
+	if u.CreatedAt.IsZero() && !u.ID.IsZero() {
+		u.CreatedAt = u.ID.Timestamp().UTC()
+	}
+	if u.UpdatedAt.IsZero() {
+		u.UpdatedAt = u.CreatedAt
+	}
+}
+
+// Clone returns a deep copy of u, which shares no map or pointer with it.
+func (u User) Clone() User {
+	if u.Attributes != nil {
+		attrs := make(map[string]string, len(u.Attributes))
+		for k, v := range u.Attributes {
+			attrs[k] = v
+		}
+		u.Attributes = attrs
+	}
+	if u.DeletedAt != nil {
+		deletedAt := *u.DeletedAt
+		u.DeletedAt = &deletedAt
+	}
+	if u.ExpiresAt != nil {
+		expiresAt := *u.ExpiresAt
+		u.ExpiresAt = &expiresAt
+	}
+	return u
+}
+
+// sameContent reports whether a and b hold the same user data, ignoring timestamps.
+// Nil and empty attribute maps are treated alike, as MongoDB omits both.
+func sameContent(a, b *User) bool {
+	if a.ID != b.ID || a.Name != b.Name || a.Age != b.Age || a.Gender != b.Gender {
+		return false
+	}
+	if (a.ExpiresAt == nil) != (b.ExpiresAt == nil) || a.ExpiresAt != nil && !a.ExpiresAt.Equal(*b.ExpiresAt) {
+		return false
+	}
+	if len(a.Attributes) != len(b.Attributes) {
+		return false
+	}
+	for k, v := range a.Attributes {
+		if bv, ok := b.Attributes[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,55 @@
+package userstore
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Expect is the version of the user that Update, Replace, Delete, Restore
+// and HardDelete require. With AtVersion they only write the user when it is
+// at that version, and fail with a *ConflictError otherwise. This is how a
+// client that read a user makes sure nobody changed it before its own write
+// lands. With AnyVersion they apply to whatever version is current.
+//
+// Upsert addresses users by name and never checks the version.
+type Expect struct {
+	version int64
+	set     bool
+}
+
+// AnyVersion lets a write apply to any version of the user.
+var AnyVersion = Expect{}
+
+// AtVersion makes a write apply only to version v of the user.
+func AtVersion(v int64) Expect {
+	return Expect{version: v, set: true}
+}
+
+// Version returns the version required by e, or false for AnyVersion.
+func (e Expect) Version() (int64, bool) {
+	return e.version, e.set
+}
+
+// check returns a *ConflictError when u is not at the version e requires.
+func (e Expect) check(u *User) error {
+	if e.set && u.Version != e.version {
+		return &ConflictError{ID: u.ID, Expected: e.version, Actual: u.Version}
+	}
+	return nil
+}
+
+// filter narrows filter to the version e requires, if any.
+func (e Expect) filter(filter bson.D) bson.D {
+	if e.set {
+		return append(filter, versionIs(e.version))
+	}
+	return filter
+}
+
+// versionIs is the query condition that selects a user at version v.
+// Users written before versions existed have no Version field and count as version 0.
+func versionIs(v int64) bson.E {
+	if v == 0 {
+		return bson.E{Key: "Version", Value: bson.D{{Key: "$in", Value: bson.A{0, nil}}}}
+	}
+	return bson.E{Key: "Version", Value: v}
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+
+	"github.com/saurabhkk55/Go/11_file_os/fileutil"
 )
 
 // Define a struct named `Student` to store student information
@@ -15,7 +17,7 @@ type Student struct {
 func main() {
 	var input string
 
-	// Open a file for student records
+	// Get the file for student records
 	file := play_with_file()
 	fmt.Print("\n----------------------------------\n\n")
 
@@ -29,31 +31,35 @@ func main() {
 		fmt.Print("Do you want to enter information for another student? (y/n): ")
 		fmt.Scan(&input)
 
-		// Close the file when not needed anymore
 		if input != "y" {
-			defer file.Close()
 			break
 		}
 	}
 }
 
-// store_struct_to_file encodes a Student struct to JSON and stores it in a file.
-func store_struct_to_file(student_info Student, file_for_student_records *os.File) {
-	// Create a JSON encoder
-	encoder := json.NewEncoder(file_for_student_records)
-
-	// Encode the studentInfo struct to JSON and write it to the file
-	err := encoder.Encode(student_info)
+// store_struct_to_file encodes a Student struct to JSON and appends it to the file.
+func store_struct_to_file(student_info Student, file_for_student_records string) {
+	// Encode the studentInfo struct to JSON, one record per line
+	record, err := json.Marshal(student_info)
 	if err != nil {
 		fmt.Println("Error encoding struct:", err)
 		return
 	}
+	record = append(record, '\n')
+
+	// Append the record to the file
+	err = fileutil.AppendFile(file_for_student_records, record, 0644)
+	if err != nil {
+		fmt.Println("Error writing file:", err)
+		return
+	}
 
 	fmt.Println("Struct successfully written to file.")
 }
 
-// play_with_file creates or opens a file for student records.
-func play_with_file() *os.File {
+// play_with_file returns the path of the file for student records, which is
+// created by the first record stored.
+func play_with_file() string {
 	// Get the current working directory
 	current_working_directory, err := os.Getwd()
 	if err != nil {
@@ -66,15 +72,8 @@ func play_with_file() *os.File {
 	newfile := current_working_directory + "/hello.txt"
 	fmt.Println("newfile: ", newfile)
 
-	// Open or create the file for writing and appending
-	file, err := os.OpenFile(newfile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
-	if err != nil {
-		panic(err)
-	}
-
 	fmt.Println("File is ready.")
-	return file
-
+	return newfile
 }
 
 // encode_decode prompts the user to enter student information and returns a Student struct.